@@ -0,0 +1,384 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/util"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+)
+
+// Config holds the bench run parameters (parsed from CLI flags)
+type Config struct {
+	Target      string
+	Scenario    string
+	Concurrency int
+	Requests    int
+	Email       string
+	Password    string
+	Timeout     time.Duration
+}
+
+// Result summarizes a bench run
+type Result struct {
+	Scenario  string
+	Total     int
+	Failed    int64
+	Elapsed   time.Duration
+	Latencies []time.Duration
+}
+
+// Run parses the bench subcommand flags and drives the selected scenario
+// Usage: mein-idaas bench -target http://localhost:4000 -scenario login -c 20 -n 2000 -email a@b.c -password secret
+// The verify scenario runs in-process with the instance's signing keys (.env) and needs no running instance;
+// the sign scenario runs in-process too, with throwaway keys.
+func Run(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	cfg := Config{}
+	fs.StringVar(&cfg.Target, "target", "http://localhost:4000", "base URL of the instance under test")
+	fs.StringVar(&cfg.Scenario, "scenario", "login", "register | login | refresh | verify | sign")
+	fs.IntVar(&cfg.Concurrency, "c", 10, "number of concurrent workers")
+	fs.IntVar(&cfg.Requests, "n", 500, "total number of requests")
+	fs.StringVar(&cfg.Email, "email", "", "email of a verified account (login/refresh scenarios)")
+	fs.StringVar(&cfg.Password, "password", "", "password of the account (login/refresh scenarios)")
+	fs.DurationVar(&cfg.Timeout, "timeout", 10*time.Second, "per-request timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cfg.Concurrency <= 0 || cfg.Requests <= 0 {
+		return errors.New("-c and -n must be positive")
+	}
+	if (cfg.Scenario == "login" || cfg.Scenario == "refresh") && (cfg.Email == "" || cfg.Password == "") {
+		return errors.New("-email and -password are required for the login and refresh scenarios")
+	}
+
+	if cfg.Scenario == "verify" {
+		return runVerify(cfg)
+	}
+	if cfg.Scenario == "sign" {
+		return runSign(cfg)
+	}
+
+	res, err := Execute(cfg)
+	if err != nil {
+		return err
+	}
+	res.Print()
+	return nil
+}
+
+// runVerify measures access token validation (the work of the auth middleware) without and with
+// the validated token cache and prints both results and the throughput gain
+func runVerify(cfg Config) error {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("warning: failed to load .env file: %v (using system environment variables)", err)
+	}
+	if err := util.InitSigningKeys(); err != nil {
+		return err
+	}
+	size := util.GetAccessTokenCacheSize()
+	if size <= 0 {
+		size = 10000
+	}
+
+	// Every verified token is logged; keep the output readable
+	logOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+
+	util.SetAccessTokenCacheSize(0)
+	uncached, err := Execute(cfg)
+	if err != nil {
+		return err
+	}
+	util.SetAccessTokenCacheSize(size)
+	cached, err := Execute(cfg)
+	if err != nil {
+		return err
+	}
+
+	uncached.Scenario, cached.Scenario = "verify (cache off)", "verify (cache on)"
+	uncached.Print()
+	cached.Print()
+	fmt.Printf("[BENCH] token cache: %.1fx throughput\n", uncached.Elapsed.Seconds()/cached.Elapsed.Seconds())
+	return nil
+}
+
+// runSign measures access token signing (the CPU cost of every login and refresh) with RS256 and ES256,
+// each with the signing worker pool (JWT_SIGNING_WORKERS) and without it, and prints the ES256 gain.
+// The keys are generated for the run (RSA-2048 and P-256), so no key material needs to be configured.
+func runSign(cfg Config) error {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("warning: failed to load .env file: %v (using system environment variables)", err)
+	}
+
+	logOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+
+	var pooled []*Result
+	for _, alg := range []string{"RS256", "ES256"} {
+		if err := useThrowawayKey(alg); err != nil {
+			return err
+		}
+		withPool, err := Execute(cfg)
+		if err != nil {
+			return err
+		}
+		util.SetSigningWorkers(0)
+		withoutPool, err := Execute(cfg)
+		if err != nil {
+			return err
+		}
+
+		withPool.Scenario = "sign " + alg + " (pool on)"
+		withoutPool.Scenario = "sign " + alg + " (pool off)"
+		withPool.Print()
+		withoutPool.Print()
+		pooled = append(pooled, withPool)
+	}
+	fmt.Printf("[BENCH] ES256 vs RS256: %.1fx throughput\n", pooled[0].Elapsed.Seconds()/pooled[1].Elapsed.Seconds())
+	return nil
+}
+
+// useThrowawayKey generates a key pair for alg and loads it through util.InitSigningKeys, like a key from .env
+func useThrowawayKey(alg string) error {
+	var signer crypto.Signer
+	var err error
+	prefix := "RSA"
+	if alg == "ES256" {
+		prefix = "EC"
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return err
+	}
+
+	priv, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return err
+	}
+	for name, value := range map[string]string{
+		"JWT_SIGNING_ALG":                  alg,
+		"JWT_SIGNER":                       "local",
+		prefix + "_PRIVATE_KEY":            string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv})),
+		prefix + "_PUBLIC_KEY":             string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+		prefix + "_PRIVATE_KEY_PASSPHRASE": "",
+	} {
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return util.InitSigningKeys()
+}
+
+// Execute runs the scenario with the given config and collects latencies
+func Execute(cfg Config) (*Result, error) {
+	client := &http.Client{Timeout: cfg.Timeout}
+	base := strings.TrimRight(cfg.Target, "/") + "/api/v1/auth"
+
+	var step func(w *worker) error
+	switch cfg.Scenario {
+	case "register":
+		step = func(w *worker) error { return w.register(base) }
+	case "login":
+		step = func(w *worker) error { return w.login(base, cfg.Email, cfg.Password) }
+	case "refresh":
+		step = func(w *worker) error { return w.refresh(base, cfg.Email, cfg.Password) }
+	case "verify":
+		// Every request carries the same access token, like a client calling the API repeatedly
+		pair, err := util.GenerateTokens(context.Background(), uuid.New(), []string{"user"}, "", "", "",
+			time.Now().Add(time.Hour), dto.NewAuthentication(dto.AMRPassword), 0)
+		if err != nil {
+			return nil, err
+		}
+		step = func(w *worker) error {
+			_, err := util.ParseAccessToken(pair.AccessToken)
+			return err
+		}
+	case "sign":
+		// One access token per request, as issued by a login or refresh
+		userID := uuid.New()
+		authn := dto.NewAuthentication(dto.AMRPassword)
+		step = func(w *worker) error {
+			_, err := util.GenerateAccessTokenOnly(context.Background(), userID, []string{"user"}, "", "", "", authn, 0)
+			return err
+		}
+	default:
+		return nil, fmt.Errorf("unknown scenario %q (expected register, login, refresh, verify or sign)", cfg.Scenario)
+	}
+
+	res := &Result{Scenario: cfg.Scenario, Total: cfg.Requests}
+	latencies := make([]time.Duration, cfg.Requests)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := &worker{client: client}
+			for {
+				idx := next.Add(1) - 1
+				if idx >= int64(cfg.Requests) {
+					return
+				}
+				t := time.Now()
+				if err := step(w); err != nil {
+					atomic.AddInt64(&res.Failed, 1)
+				}
+				latencies[idx] = time.Since(t)
+			}
+		}()
+	}
+	wg.Wait()
+
+	res.Elapsed = time.Since(start)
+	res.Latencies = latencies
+	return res, nil
+}
+
+// Percentile returns the p-th percentile latency (0 < p <= 100)
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(r.Latencies))
+	copy(sorted, r.Latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(float64(len(sorted))*p/100+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// Print writes a human-readable summary to stdout
+func (r *Result) Print() {
+	rps := float64(r.Total) / r.Elapsed.Seconds()
+	fmt.Printf("[BENCH] scenario=%s requests=%d failed=%d elapsed=%v throughput=%.1f req/s\n",
+		r.Scenario, r.Total, r.Failed, r.Elapsed.Round(time.Millisecond), rps)
+	fmt.Printf("[BENCH] latency p50=%v p90=%v p95=%v p99=%v max=%v\n",
+		r.Percentile(50).Round(time.Microsecond),
+		r.Percentile(90).Round(time.Microsecond),
+		r.Percentile(95).Round(time.Microsecond),
+		r.Percentile(99).Round(time.Microsecond),
+		r.Percentile(100).Round(time.Microsecond))
+}
+
+// worker keeps per-goroutine state (the refresh scenario needs its own token chain)
+type worker struct {
+	client       *http.Client
+	refreshToken string
+}
+
+func (w *worker) register(base string) error {
+	payload := map[string]string{
+		"name":     "bench",
+		"email":    "bench-" + uuid.NewString() + "@bench.local",
+		"password": "bench-password-" + uuid.NewString()[:8],
+	}
+	_, err := w.postJSON(base+"/register", payload, "", http.StatusCreated)
+	return err
+}
+
+func (w *worker) login(base, email, password string) error {
+	resp, err := w.postJSON(base+"/login", map[string]string{"email": email, "password": password}, "", http.StatusOK)
+	if err != nil {
+		return err
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name == "refresh_token" {
+			w.refreshToken = c.Value
+		}
+	}
+	return nil
+}
+
+func (w *worker) refresh(base, email, password string) error {
+	// Each worker logs in once, then keeps rotating its own refresh token
+	if w.refreshToken == "" {
+		if err := w.login(base, email, password); err != nil {
+			return err
+		}
+	}
+
+	resp, err := w.postJSON(base+"/refresh", nil, w.refreshToken, http.StatusOK)
+	if err != nil {
+		w.refreshToken = ""
+		return err
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name == "refresh_token" {
+			w.refreshToken = c.Value
+		}
+	}
+	return nil
+}
+
+func (w *worker) postJSON(url string, payload interface{}, refreshCookie string, expected int) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if refreshCookie != "" {
+		req.AddCookie(&http.Cookie{Name: "refresh_token", Value: refreshCookie})
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != expected {
+		return resp, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return resp, nil
+}
@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"mein-idaas/dto"
+	"mein-idaas/service"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIKeyController provides handlers for API keys of the current user and, for admins, of any user (service accounts)
+type APIKeyController struct {
+	svc *service.APIKeyService
+}
+
+func NewAPIKeyController(s *service.APIKeyService) *APIKeyController {
+	return &APIKeyController{svc: s}
+}
+
+// apiKeyErrorStatus maps API key errors to HTTP status codes
+func apiKeyErrorStatus(err error) int {
+	switch err.Error() {
+	case "invalid user ID format":
+		return fiber.StatusBadRequest
+	case "user not found", "API key not found":
+		return fiber.StatusNotFound
+	}
+	return fiber.StatusInternalServerError
+}
+
+// CreateAPIKey godoc
+// @Summary      Create an API key
+// @Description  Issues an API key for the current user. Send it as X-API-Key instead of a Bearer token; it acts with the user's roles, limited to the given scopes. The key is only returned in this response. Keys can't be created with an API key.
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.CreateAPIKeyRequest true "Key name, scopes and lifetime"
+// @Success      201  {object}  dto.CreateAPIKeyResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/api-keys [post]
+func (kc *APIKeyController) CreateAPIKey(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	return kc.create(c, userID)
+}
+
+// ListAPIKeys godoc
+// @Summary      List API keys
+// @Description  Returns the current user's API keys that are not revoked, without their secrets.
+// @Tags         api-keys
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {array}   dto.APIKeyResponse
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/api-keys [get]
+func (kc *APIKeyController) ListAPIKeys(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	return kc.list(c, userID)
+}
+
+// RevokeAPIKey godoc
+// @Summary      Revoke an API key
+// @Description  Revokes one of the current user's API keys; requests with it are rejected right away.
+// @Tags         api-keys
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        id path string true "API key ID"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/api-keys/{id} [delete]
+func (kc *APIKeyController) RevokeAPIKey(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	return kc.revoke(c, userID, c.Params("id"))
+}
+
+// CreateUserAPIKey godoc
+// @Summary      Create an API key for a user (admin)
+// @Description  Issues an API key for any user, e.g. a service account. Requires the users:write permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        id path string true "User ID"
+// @Param        payload body dto.CreateAPIKeyRequest true "Key name, scopes and lifetime"
+// @Success      201  {object}  dto.CreateAPIKeyResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /admin/users/{id}/api-keys [post]
+func (kc *APIKeyController) CreateUserAPIKey(c *fiber.Ctx) error {
+	return kc.create(c, c.Params("id"))
+}
+
+// ListUserAPIKeys godoc
+// @Summary      List API keys of a user (admin)
+// @Description  Returns the user's API keys that are not revoked. Requires the users:read permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        id path string true "User ID"
+// @Success      200  {array}   dto.APIKeyResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /admin/users/{id}/api-keys [get]
+func (kc *APIKeyController) ListUserAPIKeys(c *fiber.Ctx) error {
+	return kc.list(c, c.Params("id"))
+}
+
+// RevokeUserAPIKey godoc
+// @Summary      Revoke an API key of a user (admin)
+// @Description  Revokes one API key of the user. Requires the users:write permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        id path string true "User ID"
+// @Param        key_id path string true "API key ID"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /admin/users/{id}/api-keys/{key_id} [delete]
+func (kc *APIKeyController) RevokeUserAPIKey(c *fiber.Ctx) error {
+	return kc.revoke(c, c.Params("id"), c.Params("key_id"))
+}
+
+func (kc *APIKeyController) create(c *fiber.Ctx, userID string) error {
+	// A leaked key must not be able to mint more keys
+	if keyID, _ := c.Locals("api_key_id").(string); keyID != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "API keys can't create API keys"})
+	}
+
+	var req dto.CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := kc.svc.Create(c.UserContext(), userID, &req)
+	if err != nil {
+		return c.Status(apiKeyErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+func (kc *APIKeyController) list(c *fiber.Ctx, userID string) error {
+	keys, err := kc.svc.List(c.UserContext(), userID)
+	if err != nil {
+		return c.Status(apiKeyErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(keys)
+}
+
+func (kc *APIKeyController) revoke(c *fiber.Ctx, userID string, keyID string) error {
+	if err := kc.svc.Revoke(c.UserContext(), userID, keyID); err != nil {
+		return c.Status(apiKeyErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "API key revoked"})
+}
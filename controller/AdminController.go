@@ -0,0 +1,637 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/service"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportTimeout bounds a full user export
+const exportTimeout = 30 * time.Minute
+
+// AdminController provides handlers for administrative operations
+// Each route is mounted behind middleware.RequirePermission (see setupRoutes)
+type AdminController struct {
+	svc *service.AdminService
+}
+
+func NewAdminController(s *service.AdminService) *AdminController {
+	return &AdminController{svc: s}
+}
+
+// ExportUsers godoc
+// @Summary      Export all users (streamed)
+// @Description  Streams every user as newline-delimited JSON (one dto.UserExportRecord per line). Users are read in batches, so memory use is constant regardless of user count. Requires the users:export permission.
+// @Tags         admin
+// @Produce      application/x-ndjson
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  dto.UserExportRecord
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/users/export [get]
+func (ac *AdminController) ExportUsers(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="users.ndjson"`)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// The stream outlives the handler (and its request timeout), so it gets its own deadline
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+
+		// Errors are logged by the service; the status line is already sent at this point
+		_ = ac.svc.ExportUsers(ctx, w)
+	})
+	return nil
+}
+
+// SearchUsers godoc
+// @Summary      Search users
+// @Description  Returns one page of the users whose name or email contains every word of q (case-insensitive), exact email matches first, then names or emails starting with the first word, then the newest users. Requires the users:read permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        q query string true "Search words, 2-100 characters"
+// @Param        page query int false "Page, starting at 1 (default 1)"
+// @Param        size query int false "Users per page, 1-100 (default 20)"
+// @Success      200  {object}  dto.UserListResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/users/search [get]
+func (ac *AdminController) SearchUsers(c *fiber.Ctx) error {
+	var req dto.SearchUsersRequest
+	if err := c.QueryParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid query parameters"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := ac.svc.SearchUsers(c.UserContext(), &req)
+	if err != nil {
+		if err.Error() == "search query is empty" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// ListUsers godoc
+// @Summary      List users (paginated)
+// @Description  Returns one page of users with the total number of matching users. Filters: email (contains, case-insensitive), verified, role (code), created_from/created_to (YYYY-MM-DD, inclusive). Sorted by created_at (default), email or name. Requires the users:read permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        page query int false "Page, starting at 1 (default 1)"
+// @Param        size query int false "Users per page, 1-100 (default 20)"
+// @Param        sort query string false "created_at (default), email or name"
+// @Param        order query string false "desc (default) or asc"
+// @Param        email query string false "Part of the email"
+// @Param        verified query bool false "Email verified"
+// @Param        role query string false "Role code"
+// @Param        created_from query string false "Created on or after YYYY-MM-DD"
+// @Param        created_to query string false "Created on or before YYYY-MM-DD"
+// @Success      200  {object}  dto.UserListResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/users [get]
+func (ac *AdminController) ListUsers(c *fiber.Ctx) error {
+	var req dto.ListUsersRequest
+	if err := c.QueryParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid query parameters"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := ac.svc.ListUsers(c.UserContext(), &req)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "invalid created_") {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// GetRetentionStats godoc
+// @Summary      Data retention job metrics
+// @Description  Returns, per retention policy, the last run time, rows purged (last run and total) and last error. Requires the stats:read permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {array}   service.RetentionStats
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/retention [get]
+func (ac *AdminController) GetRetentionStats(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(ac.svc.GetRetentionStats())
+}
+
+// StartPasswordHashScan godoc
+// @Summary      Scan password hashes for outdated parameters
+// @Description  Starts a background scan that flags password credentials hashed with a legacy scheme (bcrypt) or weaker Argon2 parameters than the current ones. Flagged hashes are upgraded at the user's next login. Progress at GET /admin/password-hashes/scan. Requires the settings:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      202  {object}  dto.PasswordHashScanReport
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string "A scan is already running"
+// @Router       /admin/password-hashes/scan [post]
+func (ac *AdminController) StartPasswordHashScan(c *fiber.Ctx) error {
+	report, err := ac.svc.StartPasswordHashScan()
+	if err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(report)
+}
+
+// GetPasswordHashScan godoc
+// @Summary      Password hash scan result
+// @Description  Returns the progress of the running password hash scan or the result of the last one: hashes scanned, flagged for rehash and per scheme. Requires the stats:read permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  dto.PasswordHashScanReport
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/password-hashes/scan [get]
+func (ac *AdminController) GetPasswordHashScan(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(ac.svc.GetPasswordHashScan())
+}
+
+// GetRegistrationSettings godoc
+// @Summary      Get registration policy
+// @Description  Returns the registration mode (open, restricted, closed) and allowed email domains. Requires the settings:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  dto.RegistrationSettings
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/settings/registration [get]
+func (ac *AdminController) GetRegistrationSettings(c *fiber.Ctx) error {
+	settings, err := ac.svc.GetRegistrationSettings(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(settings)
+}
+
+// UpdateRegistrationSettings godoc
+// @Summary      Update registration policy
+// @Description  Opens, restricts (invite token or allowed email domain) or closes public registration at runtime. Requires the settings:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.RegistrationSettings true "Registration policy"
+// @Success      200  {object}  dto.RegistrationSettings
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/settings/registration [put]
+func (ac *AdminController) UpdateRegistrationSettings(c *fiber.Ctx) error {
+	var req dto.RegistrationSettings
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := ac.svc.UpdateRegistrationSettings(c.UserContext(), &req); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(req)
+}
+
+// GetProvisioningSettings godoc
+// @Summary      Get JIT provisioning policy
+// @Description  Returns how accounts are created on the first login of a social, upstream OIDC or LDAP identity: auto-create, default roles, allowed email domains and attribute mapping. Requires the settings:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  dto.ProvisioningSettings
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/settings/provisioning [get]
+func (ac *AdminController) GetProvisioningSettings(c *fiber.Ctx) error {
+	settings, err := ac.svc.GetProvisioningSettings(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(settings)
+}
+
+// UpdateProvisioningSettings godoc
+// @Summary      Update JIT provisioning policy
+// @Description  Changes the provisioning policy of federated logins at runtime. Attribute mapping targets are "name" or "metadata.<key>". Requires the settings:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.ProvisioningSettings true "Provisioning policy"
+// @Success      200  {object}  dto.ProvisioningSettings
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/settings/provisioning [put]
+func (ac *AdminController) UpdateProvisioningSettings(c *fiber.Ctx) error {
+	var req dto.ProvisioningSettings
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := ac.svc.UpdateProvisioningSettings(c.UserContext(), &req); err != nil {
+		if err.Error() == "invalid attribute mapping target" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(req)
+}
+
+// CreateInvite godoc
+// @Summary      Create a registration invite
+// @Description  Creates a single-use invite token, optionally bound to an email. The token is returned once. Requires the users:write permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.CreateInviteRequest true "Invite options"
+// @Success      201  {object}  dto.InviteResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/invites [post]
+func (ac *AdminController) CreateInvite(c *fiber.Ctx) error {
+	var req dto.CreateInviteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	adminID, _ := c.Locals("user_id").(string)
+	res, err := ac.svc.CreateInvite(c.UserContext(), adminID, &req)
+	if err != nil {
+		if err.Error() == "invalid expires_in" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+// ResetUserMFA godoc
+// @Summary      Reset a user's MFA
+// @Description  Removes the TOTP secret, the SMS and email factors and the recovery codes of a user who lost their device, after verifying their identity out of band. The admin and the reason are recorded in the user.mfa_reset event (webhooks, SIEM) and the user is notified by email. Requires the users:write permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        id path string true "User ID"
+// @Param        payload body dto.AdminMFAResetRequest true "Reason"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /admin/users/{id}/mfa/reset [post]
+func (ac *AdminController) ResetUserMFA(c *fiber.Ctx) error {
+	var req dto.AdminMFAResetRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	adminID, _ := c.Locals("user_id").(string)
+	if err := ac.svc.ResetUserMFA(c.UserContext(), adminID, c.Params("id"), req.Reason); err != nil {
+		switch err.Error() {
+		case "invalid user ID format", "MFA not enabled":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "user not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "MFA reset"})
+}
+
+// LogoutUser godoc
+// @Summary      Log a user out everywhere
+// @Description  Revokes every refresh token of the user and bumps their token version, so access tokens issued so far are rejected as well (compromised account, lost device). The admin is recorded in the user.sessions_revoked event. Requires the users:write permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        id path string true "User ID"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /admin/users/{id}/logout-all [post]
+func (ac *AdminController) LogoutUser(c *fiber.Ctx) error {
+	adminID, _ := c.Locals("user_id").(string)
+	if err := ac.svc.LogoutUser(c.UserContext(), adminID, c.Params("id")); err != nil {
+		switch err.Error() {
+		case "invalid user ID format":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "user not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "all sessions revoked"})
+}
+
+// SendTemporaryPassword godoc
+// @Summary      Email a temporary password
+// @Description  Replaces the user's password with a random one and emails it to them. The user is signed out everywhere, the account is unlocked, and the next login returns {password_expired, must_change_password, password_change_token} until a new password is set at /auth/password-expired. Requires the users:write permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        id path string true "User ID"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /admin/users/{id}/temporary-password [post]
+func (ac *AdminController) SendTemporaryPassword(c *fiber.Ctx) error {
+	adminID, _ := c.Locals("user_id").(string)
+	emailSvc := service.NewEmailService()
+	if err := ac.svc.SendTemporaryPassword(c.UserContext(), adminID, c.Params("id"), emailSvc); err != nil {
+		return adminPasswordError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "temporary password sent"})
+}
+
+// ResetUserPassword godoc
+// @Summary      Reset a user's password
+// @Description  Sets a password chosen by the admin, who passes it to the user out of band. The password has to meet the password policy. Like a temporary password, the user is signed out everywhere and must choose a new password at the next login. Requires the users:write permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        id path string true "User ID"
+// @Param        payload body dto.AdminPasswordResetRequest true "New password"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /admin/users/{id}/password [post]
+func (ac *AdminController) ResetUserPassword(c *fiber.Ctx) error {
+	var req dto.AdminPasswordResetRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	adminID, _ := c.Locals("user_id").(string)
+	if err := ac.svc.ResetUserPassword(c.UserContext(), adminID, c.Params("id"), req.Password); err != nil {
+		return adminPasswordError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "password reset, the user must change it at the next login"})
+}
+
+// adminPasswordError maps the errors of the admin password resets to status codes
+func adminPasswordError(c *fiber.Ctx, err error) error {
+	switch {
+	case err.Error() == "invalid user ID format", err.Error() == "guest accounts have no password", service.IsPasswordPolicyError(err):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case err.Error() == "user not found":
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
+
+// GetActiveUsers godoc
+// @Summary      Daily/monthly active users
+// @Description  Returns DAU with rolling 30-day MAU per day, or distinct active users per calendar month (granularity=month) for billing/licensing. Users count as active when a token is issued to them (login or refresh). format=csv downloads the report. Requires the stats:read permission.
+// @Tags         admin
+// @Produce      json
+// @Produce      text/csv
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        from query string false "Start date YYYY-MM-DD (default: 30 days ago)"
+// @Param        to query string false "End date YYYY-MM-DD (default: today)"
+// @Param        granularity query string false "day (default) or month"
+// @Param        format query string false "json (default) or csv"
+// @Success      200  {array}   dto.DailyActiveUsers
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/stats/active-users [get]
+func (ac *AdminController) GetActiveUsers(c *fiber.Ctx) error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, err := parseReportDate(c.Query("from"), today.AddDate(0, 0, -30))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid from date, expected YYYY-MM-DD"})
+	}
+	to, err := parseReportDate(c.Query("to"), today)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid to date, expected YYYY-MM-DD"})
+	}
+
+	csvExport := c.Query("format") == "csv"
+
+	switch c.Query("granularity", "day") {
+	case "day":
+		rows, err := ac.svc.GetDailyActiveUsers(c.UserContext(), from, to)
+		if err != nil {
+			return activeUsersError(c, err)
+		}
+		if !csvExport {
+			return c.Status(fiber.StatusOK).JSON(rows)
+		}
+		records := [][]string{{"date", "dau", "mau"}}
+		for _, r := range rows {
+			records = append(records, []string{r.Date, strconv.FormatInt(r.DAU, 10), strconv.FormatInt(r.MAU, 10)})
+		}
+		return sendCSV(c, "active-users-daily.csv", records)
+	case "month":
+		rows, err := ac.svc.GetMonthlyActiveUsers(c.UserContext(), from, to)
+		if err != nil {
+			return activeUsersError(c, err)
+		}
+		if !csvExport {
+			return c.Status(fiber.StatusOK).JSON(rows)
+		}
+		records := [][]string{{"month", "active_users"}}
+		for _, r := range rows {
+			records = append(records, []string{r.Month, strconv.FormatInt(r.ActiveUsers, 10)})
+		}
+		return sendCSV(c, "active-users-monthly.csv", records)
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "granularity must be day or month"})
+}
+
+// parseReportDate parses a YYYY-MM-DD query value (UTC), returning fallback when empty
+func parseReportDate(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func activeUsersError(c *fiber.Ctx, err error) error {
+	if err.Error() == "invalid date range" || err.Error() == "date range too large" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
+
+// sendCSV writes records as a CSV attachment
+func sendCSV(c *fiber.Ctx, filename string, records [][]string) error {
+	var buf bytes.Buffer
+	if err := csv.NewWriter(&buf).WriteAll(records); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
+
+// RotateSigningKey godoc
+// @Summary      Rotate the JWT signing key
+// @Description  Generates a new signing key and starts signing with it. The previous key keeps verifying tokens until the overlap window (KEY_ROTATION_OVERLAP) ends and is then retired automatically. With tenant, the organization's own key is rotated; its first rotation gives it a key of its own instead of the global one. Requires SIGNING_KEY_SECRET and the keys:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        tenant query string false "Organization (TENANTS); the global keys when omitted"
+// @Success      200  {object}  dto.KeyRotationResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      501  {object}  map[string]string
+// @Router       /admin/keys/rotate [post]
+func (ac *AdminController) RotateSigningKey(c *fiber.Ctx) error {
+	res, err := ac.svc.RotateSigningKey(c.UserContext(), c.Query("tenant"))
+	if err != nil {
+		switch err.Error() {
+		case "key rotation not configured":
+			return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{"error": err.Error()})
+		case "unknown tenant":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// ListSigningKeys godoc
+// @Summary      List JWT signing keys
+// @Description  Returns every global signing key, or every key of a tenant, with its kid and status: active (signs new tokens), verifying (rotated out, still inside the overlap window) or retired. A tenant without keys of its own has none (its tokens are signed with the global key). Requires the keys:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        tenant query string false "Organization (TENANTS); the global keys when omitted"
+// @Success      200  {array}   dto.SigningKeyInfo
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/keys [get]
+func (ac *AdminController) ListSigningKeys(c *fiber.Ctx) error {
+	keys, err := ac.svc.ListSigningKeys(c.UserContext(), c.Query("tenant"))
+	if err != nil {
+		if err.Error() == "unknown tenant" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(keys)
+}
+
+// AddSigningKey godoc
+// @Summary      Add a JWT signing key
+// @Description  Imports an externally generated private key (PEM, matching JWT_SIGNING_ALG; encrypted PKCS8 keys need the passphrase) and starts signing with it, for the global keys or for one tenant. The previous key keeps verifying tokens until the overlap window ends. Requires SIGNING_KEY_SECRET and the keys:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        tenant query string false "Organization (TENANTS); the global keys when omitted"
+// @Param        payload body dto.AddSigningKeyRequest true "Private key"
+// @Success      201  {object}  dto.KeyRotationResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      501  {object}  map[string]string
+// @Router       /admin/keys [post]
+func (ac *AdminController) AddSigningKey(c *fiber.Ctx) error {
+	var req dto.AddSigningKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := ac.svc.AddSigningKey(c.UserContext(), c.Query("tenant"), req.PrivateKey, req.Passphrase)
+	if err != nil {
+		switch {
+		case err.Error() == "key rotation not configured":
+			return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{"error": err.Error()})
+		case err.Error() == "signing key already exists":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "invalid private key"), err.Error() == "unknown tenant":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+// RetireSigningKey godoc
+// @Summary      Retire a JWT signing key
+// @Description  Stops a rotated-out key (global or of a tenant) from verifying tokens immediately instead of at the end of its overlap window (e.g. a leaked key). Tokens signed with it are rejected once every replica has reloaded the key ring. Active keys can't be retired. Requires the keys:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        kid path string true "Key ID"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /admin/keys/{kid} [delete]
+func (ac *AdminController) RetireSigningKey(c *fiber.Ctx) error {
+	if err := ac.svc.RetireSigningKey(c.UserContext(), c.Params("kid")); err != nil {
+		switch err.Error() {
+		case "signing key not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case "cannot retire the active signing key":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "signing key retired"})
+}
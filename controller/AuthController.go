@@ -24,13 +24,14 @@ func NewAuthController(s *service.AuthService) *AuthController {
 
 // Register godoc
 // @Summary      Register a new user
-// @Description  Create a user account with email and password. Assigns default 'user' role.
+// @Description  Create a user account with email and password. Assigns default 'user' role. Extra top-level fields declared in REGISTRATION_FIELDS are validated and stored as user metadata.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        payload body dto.RegisterRequest true "Register payload"
 // @Success      201  {object}  dto.RegisterResponse
 // @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Registration closed, invite required or invalid invite"
 // @Failure      500  {object}  map[string]string
 // @Router       /auth/register [post]
 func (ac *AuthController) Register(c *fiber.Ctx) error {
@@ -39,26 +40,49 @@ func (ac *AuthController) Register(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
 	}
 
-	res, err := ac.svc.Register(&req)
+	// Extra fields declared via REGISTRATION_FIELDS are stored in the user's metadata
+	metadata, err := util.ParseRegistrationFields(c.Body())
 	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := ac.svc.Register(c.UserContext(), &req, metadata)
+	if err != nil {
+		if service.IsPasswordPolicyError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if isRegistrationPolicyError(err) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(res)
 }
 
+// isRegistrationPolicyError reports whether the registration policy rejected the request (403)
+func isRegistrationPolicyError(err error) bool {
+	switch err.Error() {
+	case "registration is closed", "registration requires an invite", "invalid or expired invite":
+		return true
+	}
+	return false
+}
+
 // Login godoc
-// @Summary      Login with email and password
-// @Description  Validates credentials, returns Access Token in JSON, and sets Refresh Token in HttpOnly Cookie. If email is not verified, sends verification email and returns 403.
+// @Summary      Login with email or username and password
+// @Description  Validates credentials (email or username, and password), returns Access Token in JSON, and sets Refresh Token in HttpOnly Cookie. If email is not verified, sends verification email and returns 403. Users with MFA enabled get {mfa_required, mfa_challenge, expires_in} instead of tokens and finish at /auth/mfa/verify, unless the request carries a valid mfa_device cookie (remembered device). When the password is older than PASSWORD_MAX_AGE or is a temporary password set by an admin (must_change_password), returns {password_expired, must_change_password, password_change_token, expires_in} instead and the client sets a new password at /auth/password-expired. With remember_me the refresh token lives JWT_REMEMBER_ME_TTL in a persistent cookie, otherwise it is a session cookie.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        payload body dto.LoginRequest true "Login payload"
-// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}, {mfa_required, mfa_challenge, expires_in} or {password_expired, must_change_password, password_change_token, expires_in}"
 // @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure"
 // @Failure      400  {object}  map[string]string
 // @Failure      401  {object}  map[string]string
-// @Failure      403  {object}  map[string]string "Email not verified - verification email sent"
+// @Failure      403  {object}  map[string]string "Email not verified - verification email sent, or too many active sessions (SESSION_LIMIT_POLICY=reject)"
+// @Failure      423  {object}  map[string]string "Account locked after repeated failed logins"
+// @Failure      429  {object}  map[string]string "Too many failed attempts, retry after a delay"
 // @Failure      500  {object}  map[string]string
 // @Router       /auth/login [post]
 func (ac *AuthController) Login(c *fiber.Ctx) error {
@@ -66,51 +90,131 @@ func (ac *AuthController) Login(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
 	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
 
 	clientIP := c.IP()
 	userAgent := c.Get("User-Agent")
 
-	res, err := ac.svc.Login(&req, clientIP, userAgent)
+	res, err := ac.svc.Login(c.UserContext(), &req, c.Cookies(deviceCookieName), clientIP, userAgent)
 	if err != nil {
 		if err.Error() == "invalid credentials" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid credentials"})
 		}
+		if err.Error() == "too many attempts" {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "too many attempts", "message": "too many failed attempts, wait a moment before trying again"})
+		}
+		if err.Error() == "account locked" {
+			return c.Status(fiber.StatusLocked).JSON(fiber.Map{"error": "account locked", "message": "too many failed login attempts, try again later or reset your password"})
+		}
 		if err.Error() == "email not verified" {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "email not verified", "message": "verification email has been sent to your email address"})
 		}
+		if err.Error() == "too many active sessions" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error(), "message": "sign out another device before logging in"})
+		}
+		// First LDAP login of a directory account creates its shadow user
+		switch err.Error() {
+		case "registration is closed", "registration requires an invite", "automatic sign-up is disabled", "email domain not allowed for sign-up":
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Get refresh token TTL from env, default to 168h (7 days)
-	refreshTTL := os.Getenv("JWT_REFRESH_TTL")
-	if refreshTTL == "" {
-		refreshTTL = "168h"
-	}
-	duration, _ := time.ParseDuration(refreshTTL)
+	return loginResponse(c, res)
+}
+
+// deviceCookieName holds the token of a remembered device (skips MFA at login)
+const deviceCookieName = "mfa_device"
 
-	// Get cookie path from env, default to /api/v1/auth
+// refreshCookiePath returns COOKIE_PATH (default /api/v1/auth), prefixed with /t/{org} on tenant routes
+func refreshCookiePath(c *fiber.Ctx) string {
 	cookiePath := os.Getenv("COOKIE_PATH")
 	if cookiePath == "" {
 		cookiePath = "/api/v1/auth"
 	}
 
-	// SECURE COOKIE SETTING
+	if tenant, ok := c.Locals("tenant").(string); ok && tenant != "" {
+		cookiePath = "/t/" + tenant + cookiePath
+	}
+	return cookiePath
+}
+
+// expireRefreshCookie removes the refresh token cookie (same path as when it was set)
+func expireRefreshCookie(c *fiber.Ctx) {
 	c.Cookie(&fiber.Cookie{
 		Name:     "refresh_token",
-		Value:    res.RefreshToken,
-		Expires:  time.Now().Add(duration),
-		HTTPOnly: true,     // JS cannot access
-		Secure:   true,     // HTTPS only (set false for localhost if needed)
-		SameSite: "Strict", // CSRF protection
-		Path:     cookiePath,
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: "Strict",
+		Path:     refreshCookiePath(c),
+	})
+}
+
+// loginResponse sets the refresh token cookie and returns the token pair
+func loginResponse(c *fiber.Ctx, res *dto.LoginResponse) error {
+	// MFA enabled: no tokens yet, the client continues at /auth/mfa/verify
+	if res.MFAChallenge != "" {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"mfa_required":  true,
+			"mfa_challenge": res.MFAChallenge,
+			"mfa_methods":   res.MFAMethods,
+			"expires_in":    res.ExpiresIn,
+		})
+	}
+
+	// Password expired or temporary: no tokens yet, the client continues at /auth/password-expired
+	if res.PasswordChangeToken != "" {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"password_expired":      true,
+			"must_change_password":  res.MustChangePassword,
+			"password_change_token": res.PasswordChangeToken,
+			"expires_in":            res.ExpiresIn,
+		})
+	}
+
+	// Remember me: the cookie lives as long as the refresh token, otherwise it ends with the browser session
+	cookiePath := refreshCookiePath(c)
+
+	// SECURE COOKIE SETTING
+	c.Cookie(&fiber.Cookie{
+		Name:        "refresh_token",
+		Value:       res.RefreshToken,
+		Expires:     res.RefreshExpiresAt,
+		SessionOnly: !res.RememberMe,
+		HTTPOnly:    true,     // JS cannot access
+		Secure:      true,     // HTTPS only (set false for localhost if needed)
+		SameSite:    "Strict", // CSRF protection
+		Path:        cookiePath,
 	})
 
+	// MFA login with remember_device: later logins from this device skip MFA
+	if res.DeviceToken != "" {
+		c.Cookie(&fiber.Cookie{
+			Name:     deviceCookieName,
+			Value:    res.DeviceToken,
+			Expires:  res.DeviceExpiresAt,
+			HTTPOnly: true,
+			Secure:   true,
+			SameSite: "Strict",
+			Path:     cookiePath,
+		})
+	}
+
 	// Return only Access Token to client memory
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+	body := fiber.Map{
 		"access_token":  res.AccessToken,
 		"refresh_token": res.RefreshToken, //remove after production
 		"expires_in":    res.ExpiresIn,
-	})
+	}
+	// Roles requiring MFA were left out: the client should send the user to MFA setup
+	if res.MFAEnrollmentRequired {
+		body["mfa_enrollment_required"] = true
+	}
+	return c.Status(fiber.StatusOK).JSON(body)
 }
 
 // Refresh godoc
@@ -142,39 +246,35 @@ func (ac *AuthController) Refresh(c *fiber.Ctx) error {
 	userAgent := c.Get("User-Agent")
 
 	// 3. Call Service
-	res, err := ac.svc.Refresh(&req, clientIP, userAgent)
+	// OAuth refresh tokens are redeemed at /oauth/token by their client only
+	res, err := ac.svc.Refresh(c.UserContext(), &req, "", clientIP, userAgent)
 	if err != nil {
 		// Clear cookie on failure
 		c.ClearCookie("refresh_token")
 
-		if err.Error() == "invalid or unknown refresh token" || err.Error() == "refresh token expired or revoked" {
+		switch err.Error() {
+		case "invalid refresh token", "invalid or unknown refresh token", "user mismatch", "client mismatch", "token was revoked",
+			"refresh token reuse detected: session revoked for security", "session expired",
+			"session expired due to inactivity", "refresh token used from another client",
+			"invalid DPoP proof":
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Get refresh token TTL from env, default to 168h (7 days)
-	refreshTTL := os.Getenv("JWT_REFRESH_TTL")
-	if refreshTTL == "" {
-		refreshTTL = "168h"
-	}
-	duration, _ := time.ParseDuration(refreshTTL)
-
-	// Get cookie path from env, default to /api/v1/auth
-	cookiePath := os.Getenv("COOKIE_PATH")
-	if cookiePath == "" {
-		cookiePath = "/api/v1/auth"
-	}
+	// Remember me: the cookie lives as long as the refresh token, otherwise it ends with the browser session
+	cookiePath := refreshCookiePath(c)
 
 	// 4. Rotate Cookie
 	c.Cookie(&fiber.Cookie{
-		Name:     "refresh_token",
-		Value:    res.RefreshToken,
-		Expires:  time.Now().Add(duration),
-		HTTPOnly: true,
-		Secure:   true,
-		SameSite: "Strict",
-		Path:     cookiePath,
+		Name:        "refresh_token",
+		Value:       res.RefreshToken,
+		Expires:     res.RefreshExpiresAt,
+		SessionOnly: !res.RememberMe,
+		HTTPOnly:    true,
+		Secure:      true,
+		SameSite:    "Strict",
+		Path:        cookiePath,
 	})
 
 	// 5. Return new Access Token
@@ -184,6 +284,152 @@ func (ac *AuthController) Refresh(c *fiber.Ctx) error {
 	})
 }
 
+// Logout godoc
+// @Summary      Log out
+// @Description  Revokes the refresh token from the 'refresh_token' cookie together with the opaque access tokens issued with it, and expires the cookie. An opaque bearer token in the Authorization header is revoked as well; JWT access tokens stay valid until they expire. Missing or already revoked tokens are not an error, so the call can be repeated. Remembered MFA devices are kept.
+// @Tags         auth
+// @Produce      json
+// @Param        Cookie header string false "Cookie containing refresh_token"
+// @Param        Authorization header string false "Bearer <access_token>"
+// @Success      200  {object}  map[string]string
+// @Header       200  {string}  Set-Cookie "refresh_token=; expires in the past"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/logout [post]
+func (ac *AuthController) Logout(c *fiber.Ctx) error {
+	accessToken := util.BearerToken(c.Get("Authorization"))
+
+	if err := ac.svc.Logout(c.UserContext(), c.Cookies("refresh_token"), accessToken); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	expireRefreshCookie(c)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "logged out"})
+}
+
+// LogoutAll godoc
+// @Summary      Log out everywhere
+// @Description  Ends every session of the current user: all refresh tokens are revoked and the token version is bumped, so every access token issued so far (including the one used for this call) is rejected. The refresh_token cookie is expired. Remembered MFA devices and API keys are kept.
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/logout-all [post]
+func (ac *AuthController) LogoutAll(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	if err := ac.svc.LogoutAll(c.UserContext(), "self", userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	expireRefreshCookie(c)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "logged out everywhere"})
+}
+
+// ChangeExpiredPassword godoc
+// @Summary      Set a new password after it expired
+// @Description  Redeems the password_change_token returned by /auth/login when the password is older than PASSWORD_MAX_AGE or temporary (must_change_password). The token is valid for 10 minutes and stands in for the old password. After the change the login continues like /auth/login (tokens, or an MFA challenge).
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.ExpiredPasswordChangeRequest true "Password change token and new password"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in} or {mfa_required, mfa_challenge, expires_in}"
+// @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/password-expired [post]
+func (ac *AuthController) ChangeExpiredPassword(c *fiber.Ctx) error {
+	var req dto.ExpiredPasswordChangeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := ac.svc.ChangeExpiredPassword(c.UserContext(), req.PasswordChangeToken, req.NewPassword, c.Cookies(deviceCookieName), c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		if service.IsPasswordPolicyError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		switch err.Error() {
+		case "invalid or expired password change token":
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		case "new password must be different from old password":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
+
+// SendMagicLink godoc
+// @Summary      Send a passwordless login link
+// @Description  Emails a single-use login link to a verified address. The link opens /auth/magic-link/callback, which signs the user in like /auth/login. Valid for MAGIC_LINK_TTL (default 15 minutes). Always returns 202 so emails can't be enumerated.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.MagicLinkRequest true "Email address"
+// @Success      202  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/magic-link [post]
+func (ac *AuthController) SendMagicLink(c *fiber.Ctx) error {
+	var req dto.MagicLinkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// The callback is next to this route (tenant routes included)
+	base := util.GetIssuerBaseURL()
+	if base == "" {
+		base = c.BaseURL()
+	}
+	callbackURL := base + c.Path() + "/callback"
+
+	if err := ac.svc.SendMagicLink(c.UserContext(), req.Email, callbackURL, service.NewEmailService()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "if the email is registered, a login link has been sent"})
+}
+
+// MagicLinkCallback godoc
+// @Summary      Login with an emailed link
+// @Description  Redeems the token of a link sent by /auth/magic-link. The link works once. Sets the refresh token cookie like /auth/login; users with MFA get an MFA challenge instead of tokens.
+// @Tags         auth
+// @Produce      json
+// @Param        token query string true "Login link token"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in} or {mfa_required, mfa_challenge, expires_in}"
+// @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/magic-link/callback [get]
+func (ac *AuthController) MagicLinkCallback(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "missing token"})
+	}
+
+	res, err := ac.svc.LoginWithMagicLink(c.UserContext(), token, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		if err.Error() == "invalid or expired login link" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
+
 // SendPasswordChangeOTP godoc
 // @Summary      Send OTP for password change
 // @Description  Sends a 6-digit OTP code to the authenticated user's email for password change verification. Requires valid access token in Authorization header.
@@ -198,20 +444,11 @@ func (ac *AuthController) Refresh(c *fiber.Ctx) error {
 // @Failure      500  {object}  map[string]string
 // @Router       /auth/password-change/send-otp [post]
 func (ac *AuthController) SendPasswordChangeOTP(c *fiber.Ctx) error {
-	// 1. Extract user ID from Authorization header (JWT token)
-	authHeader := c.Get("Authorization")
-	if authHeader == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing authorization header"})
-	}
-
-	// Parse Bearer token to get user ID
-	userID, err := util.ExtractUserIDFromToken(authHeader)
-	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired token"})
-	}
+	// 1. User ID of the access token (RequireAuth)
+	userID, _ := c.Locals("user_id").(string)
 
 	// 2. Send OTP using user ID
-	userEmail, err := ac.svc.SendPasswordChangeOTPByUserID(userID)
+	userEmail, err := ac.svc.SendPasswordChangeOTPByUserID(c.UserContext(), userID)
 	if err != nil {
 		if err.Error() == "user not found" {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "user not found"})
@@ -225,9 +462,96 @@ func (ac *AuthController) SendPasswordChangeOTP(c *fiber.Ctx) error {
 	})
 }
 
+// StepUp godoc
+// @Summary      Re-authenticate for sensitive operations
+// @Description  Endpoints such as password change, removing a second factor or unlinking an identity require a login within STEP_UP_MAX_AGE (default 10 minutes), and for users with MFA one that used the second factor (acr 2); otherwise they answer 401 insufficient_user_authentication. This endpoint re-verifies the user behind the access token with the password and/or a second factor code (authenticator app, recovery code, or an SMS or email code from /auth/step-up/code) and returns a new access token with a fresh auth_time (same scope). Users with MFA must send a code, and send the password too to reach acr 2. The refresh token is not renewed: refreshed access tokens keep the time of the original login.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.StepUpRequest true "Password and/or code"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      429  {object}  map[string]string "Too many failed attempts, retry after a delay"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/step-up [post]
+func (ac *AuthController) StepUp(c *fiber.Ctx) error {
+	// API keys can't be exchanged for an access token
+	claims, _ := c.Locals("claims").(*dto.AuthClaims)
+	if _, apiKey := c.Locals("api_key_id").(string); apiKey || claims == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+
+	var req dto.StepUpRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := ac.svc.StepUp(c.UserContext(), claims, req.Password, req.Code, c.IP())
+	if err != nil {
+		switch err.Error() {
+		case "invalid token":
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired token"})
+		case "invalid credentials", "second factor required":
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		case "too many attempts":
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": err.Error(), "message": "too many failed attempts, wait a moment before trying again"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"access_token": res.AccessToken, "expires_in": res.ExpiresIn})
+}
+
+// SendStepUpCode godoc
+// @Summary      Send a step-up code by SMS or email
+// @Description  Sends a 6-digit code to the verified phone (method sms) or email address (method email) of the user behind the access token, for users with that second factor. Redeem it as code at /auth/step-up. At most 3 codes per 5 minutes.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.StepUpCodeRequest true "sms or email"
+// @Success      202  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      429  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/step-up/code [post]
+func (ac *AuthController) SendStepUpCode(c *fiber.Ctx) error {
+	// API keys can't be exchanged for an access token
+	claims, _ := c.Locals("claims").(*dto.AuthClaims)
+	if _, apiKey := c.Locals("api_key_id").(string); apiKey || claims == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+
+	var req dto.StepUpCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := ac.svc.SendStepUpCode(c.UserContext(), claims, req.Method, service.NewSMSService(), service.NewEmailService()); err != nil {
+		switch err.Error() {
+		case "invalid token":
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired token"})
+		case "SMS factor not enabled", "email factor not enabled", "invalid method":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "too many codes requested":
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "step-up code sent"})
+}
+
 // ChangePassword godoc
 // @Summary      Change password with OTP verification
-// @Description  Changes the user's password. Requires old password, new password, and OTP code. User ID is read from JWT access token header.
+// @Description  Changes the user's password. Requires old password, new password, and OTP code. User ID is read from JWT access token header. Access tokens issued so far (including the one of this request), the other sessions and all API keys are revoked; the session of the refresh_token cookie stays, so the client gets a new access token at /auth/refresh.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
@@ -239,17 +563,8 @@ func (ac *AuthController) SendPasswordChangeOTP(c *fiber.Ctx) error {
 // @Failure      500  {object}  map[string]string
 // @Router       /auth/password-change [post]
 func (ac *AuthController) ChangePassword(c *fiber.Ctx) error {
-	// 1. Extract user ID from Authorization header (JWT token)
-	authHeader := c.Get("Authorization")
-	if authHeader == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing authorization header"})
-	}
-
-	// Parse Bearer token
-	userID, err := util.ExtractUserIDFromToken(authHeader)
-	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired token"})
-	}
+	// 1. User ID of the access token (RequireRecentAuth)
+	userID, _ := c.Locals("user_id").(string)
 
 	// 2. Parse request body
 	var req dto.PasswordChangeRequest
@@ -268,18 +583,18 @@ func (ac *AuthController) ChangePassword(c *fiber.Ctx) error {
 	}
 
 	// 5. Call service to change password
-	if err := ac.svc.ChangePassword(userID, req.OldPassword, req.NewPassword, req.OTPCode); err != nil {
+	if err := ac.svc.ChangePassword(c.UserContext(), userID, req.OldPassword, req.NewPassword, req.OTPCode, c.Cookies("refresh_token")); err != nil {
 		if err.Error() == "invalid old password" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid old password"})
 		}
-		if err.Error() == "invalid verification code" || err.Error() == "code expired" {
+		if err.Error() == "invalid verification code" || err.Error() == "code expired" || service.IsPasswordPolicyError(err) {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	// Get user to return email
-	user, err := ac.svc.GetUserByID(userID)
+	user, err := ac.svc.GetUserByID(c.UserContext(), userID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch user"})
 	}
@@ -316,7 +631,7 @@ func (ac *AuthController) SendForgotPasswordOTP(c *fiber.Ctx) error {
 	emailSvc := service.NewEmailService()
 
 	// Send OTP (silently fails if email not found)
-	if err := ac.svc.SendForgotPasswordOTP(req.Email, emailSvc); err != nil {
+	if err := ac.svc.SendForgotPasswordOTP(c.UserContext(), req.Email, emailSvc); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
@@ -327,44 +642,82 @@ func (ac *AuthController) SendForgotPasswordOTP(c *fiber.Ctx) error {
 
 // ResetPasswordWithOTP godoc
 // @Summary      Reset password with OTP
-// @Description  Validates the OTP code and resets the user's password to a temporary one. The temporary password is sent to the user's email.
+// @Description  Validates the OTP code and sets the new password chosen by the user. Pass email for codes sent by email, phone for codes sent by SMS (/auth/forgot-password/send-sms). All existing sessions (refresh tokens) are revoked.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
-// @Param        payload body dto.ResetPasswordWithOTPRequest true "Email and OTP code"
+// @Param        payload body dto.ResetPasswordRequest true "Email, OTP code and new password"
 // @Success      200  {object}  dto.ResetPasswordWithOTPResponse
 // @Failure      400  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /auth/forgot-password/reset [post]
 func (ac *AuthController) ResetPasswordWithOTP(c *fiber.Ctx) error {
-	var req dto.ResetPasswordWithOTPRequest
+	var req dto.ResetPasswordRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
 	}
 
-	// Validate request
+	// Validate request (the password policy itself is checked by the service)
 	if err := util.ValidateStruct(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Get email service
-	emailSvc := service.NewEmailService()
-
-	// Reset password
-	if err := ac.svc.ResetPasswordWithOTP(req.Email, req.OTP, emailSvc); err != nil {
+	// Reset password (code sent by email, or by SMS to the verified phone)
+	reset := ac.svc.ResetPasswordWithOTP
+	account := req.Email
+	if req.Phone != "" {
+		reset, account = ac.svc.ResetPasswordWithSMS, req.Phone
+	}
+	if err := reset(c.UserContext(), account, req.Code, req.NewPassword, c.IP()); err != nil {
+		if err.Error() == "too many attempts" {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": err.Error()})
+		}
 		if err.Error() == "user not found" {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "user not found"})
 		}
-		if err.Error() == "invalid or expired OTP code" {
+		if err.Error() == "invalid or expired OTP code" || service.IsPasswordPolicyError(err) {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(dto.ResetPasswordWithOTPResponse{
-		Message: "password has been reset, check your email for the temporary password",
-		Email:   req.Email,
+		Message: "password has been reset, please log in with your new password",
+		Email:   req.Email, // empty for resets by phone
+	})
+}
+
+// ResetPasswordWithLink godoc
+// @Summary      Reset password with a reset link
+// @Description  Sets the new password with the token of the link in the password reset email (sent when PASSWORD_RESET_URL is configured). The link is valid for ACTION_LINK_TTL (default 30 minutes) and can be used once. All existing sessions (refresh tokens) are revoked.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.ResetPasswordWithLinkRequest true "Link token and new password"
+// @Success      200  {object}  dto.ResetPasswordWithOTPResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/forgot-password/reset-link [post]
+func (ac *AuthController) ResetPasswordWithLink(c *fiber.Ctx) error {
+	var req dto.ResetPasswordWithLinkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := ac.svc.ResetPasswordWithToken(c.UserContext(), req.Token, req.NewPassword); err != nil {
+		if err.Error() == "invalid or expired link" || service.IsPasswordPolicyError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(dto.ResetPasswordWithOTPResponse{
+		Message: "password has been reset, please log in with your new password",
 	})
 }
 
@@ -378,20 +731,13 @@ func (ac *AuthController) ResetPasswordWithOTP(c *fiber.Ctx) error {
 // @Success      200  {object}  dto.MFASetupResponse
 // @Failure      400  {object}  map[string]string
 // @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
 // @Failure      500  {object}  map[string]string
 // @Router       /auth/mfa/setup [post]
 func (ac *AuthController) SetupMFA(c *fiber.Ctx) error {
-	authHeader := c.Get("Authorization")
-	if authHeader == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing authorization header"})
-	}
+	userID, _ := c.Locals("user_id").(string)
 
-	userID, err := util.ExtractUserIDFromToken(authHeader)
-	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired token"})
-	}
-
-	secret, qrURL, err := ac.svc.InitiateMFA(userID)
+	secret, qrURL, err := ac.svc.InitiateMFA(c.UserContext(), userID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -455,29 +801,81 @@ func (ac *AuthController) GetMFAQRCodeBase64(c *fiber.Ctx) error {
 
 // ConfirmMFA godoc
 // @Summary      Confirm MFA setup
-// @Description  Verifies the TOTP token provided by the user and enables MFA for their account. Requires Authorization header.
+// @Description  Verifies the TOTP token provided by the user and enables MFA for their account. Returns 10 single-use recovery codes (shown once). Requires a login within STEP_UP_MAX_AGE (see /auth/step-up), so a stolen access token can't replace the authenticator.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        Authorization header string true "Bearer <access_token>"
 // @Param        payload body dto.MFASetupVerifyRequest true "MFA verify payload"
-// @Success      200  {object}  map[string]string
+// @Success      200  {object}  dto.MFAConfirmResponse
 // @Failure      400  {object}  map[string]string
-// @Failure      401  {object}  map[string]string
+// @Failure      401  {object}  map[string]string "Invalid token, invalid code or insufficient_user_authentication"
 // @Failure      500  {object}  map[string]string
 // @Router       /auth/mfa/confirm [post]
 func (ac *AuthController) ConfirmMFA(c *fiber.Ctx) error {
-	authHeader := c.Get("Authorization")
-	if authHeader == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing authorization header"})
+	userID, _ := c.Locals("user_id").(string)
+
+	var req dto.MFASetupVerifyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	userID, err := util.ExtractUserIDFromToken(authHeader)
+	codes, err := ac.svc.ConfirmMFA(c.UserContext(), userID, req.Secret, req.Token)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired token"})
+		if err.Error() == "invalid MFA token" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid MFA token"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	var req dto.MFASetupVerifyRequest
+	return c.Status(fiber.StatusOK).JSON(dto.MFAConfirmResponse{Message: "MFA enabled successfully", RecoveryCodes: codes})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary      Regenerate MFA recovery codes
+// @Description  Replaces the user's 10 single-use MFA recovery codes; the previous codes stop working. The codes are returned once. Requires Authorization header and MFA enabled.
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  dto.MFAConfirmResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/mfa/recovery-codes [post]
+func (ac *AuthController) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	codes, err := ac.svc.RegenerateRecoveryCodes(c.UserContext(), userID)
+	if err != nil {
+		if err.Error() == "MFA not enabled" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(dto.MFAConfirmResponse{Message: "recovery codes regenerated", RecoveryCodes: codes})
+}
+
+// VerifyMFA godoc
+// @Summary      Complete login with a TOTP code
+// @Description  Second login step for users with MFA enabled: /auth/login (and the social and phone logins) return {mfa_required, mfa_challenge, mfa_methods, expires_in} instead of tokens; this endpoint redeems the challenge with the authenticator code, the SMS or email code (/auth/mfa/sms, /auth/mfa/email) or one of the recovery codes. The challenge is single-use, expires after 5 minutes and is dropped after 5 wrong codes. Sets the refresh token cookie like /auth/login. With remember_device, also sets the mfa_device cookie so password logins from this device skip MFA for MFA_REMEMBER_DEVICE_TTL (default 30 days).
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.MFAVerifyRequest true "Challenge and code"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure (and mfa_device=... with remember_device)"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Too many active sessions (SESSION_LIMIT_POLICY=reject)"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/mfa/verify [post]
+func (ac *AuthController) VerifyMFA(c *fiber.Ctx) error {
+	var req dto.MFAVerifyRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
 	}
@@ -486,12 +884,381 @@ func (ac *AuthController) ConfirmMFA(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	if err := ac.svc.ConfirmMFA(userID, req.Secret, req.Token); err != nil {
-		if err.Error() == "invalid MFA token" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid MFA token"})
+	res, err := ac.svc.VerifyMFA(c.UserContext(), req.MFAChallenge, req.Code, req.RememberDevice, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid or expired MFA challenge", "invalid MFA code":
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		case "too many attempts":
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": err.Error()})
+		case "too many active sessions":
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error(), "message": "sign out another device before logging in"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
+
+// SendMFAEmail godoc
+// @Summary      Send MFA login code by email
+// @Description  Emails a 6-digit code for an MFA challenge to the user's verified address (users with the email factor, see mfa_methods). Redeem it at /auth/mfa/verify. At most 3 codes per challenge.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.MFAChallengeRequest true "MFA challenge"
+// @Success      202  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      429  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/mfa/email [post]
+func (ac *AuthController) SendMFAEmail(c *fiber.Ctx) error {
+	var req dto.MFAChallengeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := ac.svc.SendMFAEmail(c.UserContext(), req.MFAChallenge, service.NewEmailService()); err != nil {
+		switch err.Error() {
+		case "email factor not enabled":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "invalid or expired MFA challenge":
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		case "too many codes requested":
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "login code sent"})
+}
+
+// EnableEmailMFA godoc
+// @Summary      Enable email codes as second factor
+// @Description  Logins of the authenticated user then need a code sent to their verified email address. This is separate from email verification. Returns recovery codes once when this is the user's first factor.
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  dto.MFAConfirmResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/mfa/email [post]
+func (ac *AuthController) EnableEmailMFA(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	codes, err := ac.svc.EnableEmailMFA(c.UserContext(), userID)
+	if err != nil {
+		if err.Error() == "email not verified" || err.Error() == "invalid user ID format" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(dto.MFAConfirmResponse{Message: "email MFA enabled", RecoveryCodes: codes})
+}
+
+// DisableEmailMFA godoc
+// @Summary      Disable email codes as second factor
+// @Description  Stops sending MFA codes by email; other factors stay active.
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/mfa/email [delete]
+func (ac *AuthController) DisableEmailMFA(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	if err := ac.svc.DisableEmailMFA(c.UserContext(), userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "email MFA disabled"})
+}
+
+// RequestMFAReset godoc
+// @Summary      Start MFA reset (lost device)
+// @Description  For users who lost their second factor and recovery codes: emails a 6-digit code to confirm the reset at /auth/mfa/reset/confirm. Always returns 202 so accounts can't be enumerated.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.MFAResetRequest true "Email address"
+// @Success      202  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/mfa/reset [post]
+func (ac *AuthController) RequestMFAReset(c *fiber.Ctx) error {
+	var req dto.MFAResetRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := ac.svc.RequestMFAReset(c.UserContext(), req.Email, service.NewEmailService()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "if the account uses MFA, a reset code has been sent"})
+}
+
+// ConfirmMFAReset godoc
+// @Summary      Confirm MFA reset
+// @Description  Checks the emailed code and schedules the MFA reset. It takes effect after a cooldown (MFA_RESET_COOLDOWN, default 72h) at the next login; the user is notified by email and a successful MFA login before then cancels it.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.MFAResetConfirmRequest true "Email and code"
+// @Success      202  {object}  dto.MFAResetResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/mfa/reset/confirm [post]
+func (ac *AuthController) ConfirmMFAReset(c *fiber.Ctx) error {
+	var req dto.MFAResetConfirmRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	effectiveAt, err := ac.svc.ConfirmMFAReset(c.UserContext(), req.Email, req.Code, c.IP())
+	if err != nil {
+		if err.Error() == "invalid or expired OTP code" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err.Error() == "too many attempts" {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(dto.MFAResetResponse{Message: "MFA reset scheduled", EffectiveAt: effectiveAt})
+}
+
+// ListCredentials godoc
+// @Summary      List sign-in methods
+// @Description  Returns the credential inventory of the current user for a security settings page: whether a password is set (and when it expires), the enrolled second factors with the number of unused recovery codes, linked social, LDAP and federated identities, the username, the verified phone and the number of remembered devices. Secret values are never returned.
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  dto.CredentialInventoryResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/credentials [get]
+func (ac *AuthController) ListCredentials(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	res, err := ac.svc.ListCredentials(c.UserContext(), userID)
+	if err != nil {
+		switch err.Error() {
+		case "invalid user ID format":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "user not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// ListSessions godoc
+// @Summary      List active sessions
+// @Description  Returns where the current user is signed in: every refresh token that is not revoked, rotated or expired, with IP, User-Agent, a device label, the login time (created_at) and the last refresh (last_used_at). current marks the session of the refresh_token cookie sent with this request.
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {array}   dto.SessionResponse
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/sessions [get]
+func (ac *AuthController) ListSessions(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	sessions, err := ac.svc.ListSessions(c.UserContext(), userID, c.Cookies("refresh_token"))
+	if err != nil {
+		if err.Error() == "invalid user ID format" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(sessions)
+}
+
+// RevokeSession godoc
+// @Summary      Sign out a session
+// @Description  Revokes one session of the current user ("sign out that device"): the refresh token from GET /auth/me/sessions, the tokens that replaced it if it was refreshed in the meantime, and their opaque access tokens. JWT access tokens already issued to that device stay valid until they expire.
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        id path string true "Session ID"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/sessions/{id} [delete]
+func (ac *AuthController) RevokeSession(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	if err := ac.svc.RevokeSession(c.UserContext(), userID, c.Params("id")); err != nil {
+		switch err.Error() {
+		case "invalid user ID format":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "session not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "MFA enabled successfully"})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "session revoked"})
+}
+
+// ListRememberedDevices godoc
+// @Summary      List remembered devices
+// @Description  Returns the devices of the current user that skip MFA at login (remember_device at /auth/mfa/verify). current marks the device of this request.
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {array}   dto.RememberedDeviceResponse
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/devices [get]
+func (ac *AuthController) ListRememberedDevices(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	devices, err := ac.svc.ListRememberedDevices(c.UserContext(), userID, c.Cookies(deviceCookieName))
+	if err != nil {
+		if err.Error() == "invalid user ID format" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(devices)
+}
+
+// ForgetDevice godoc
+// @Summary      Forget a remembered device
+// @Description  Revokes one remembered device; its next login asks for the second factor again.
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        id path string true "Device ID"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/devices/{id} [delete]
+func (ac *AuthController) ForgetDevice(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	if err := ac.svc.ForgetDevice(c.UserContext(), userID, c.Params("id")); err != nil {
+		switch err.Error() {
+		case "invalid user ID format":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "device not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "device forgotten"})
+}
+
+// ForgetAllDevices godoc
+// @Summary      Forget all remembered devices
+// @Description  Revokes every remembered device of the current user (e.g. after losing one); all of them ask for the second factor again.
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/devices [delete]
+func (ac *AuthController) ForgetAllDevices(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	if err := ac.svc.ForgetAllDevices(c.UserContext(), userID); err != nil {
+		if err.Error() == "invalid user ID format" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.ClearCookie(deviceCookieName)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "all devices forgotten"})
+}
+
+// CheckUsername godoc
+// @Summary      Check username availability
+// @Description  Tells whether a username can be taken: 3-32 lowercase letters, digits, dots, dashes and underscores, starting with a letter or digit, not reserved. Usernames are case-insensitive; the normalized (lowercase) username is returned.
+// @Tags         auth
+// @Produce      json
+// @Param        username query string true "Username"
+// @Success      200  {object}  dto.UsernameAvailabilityResponse
+// @Failure      400  {object}  map[string]string
+// @Router       /auth/username/available [get]
+func (ac *AuthController) CheckUsername(c *fiber.Ctx) error {
+	username := c.Query("username")
+	if username == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "username is required"})
+	}
+	return c.Status(fiber.StatusOK).JSON(ac.svc.CheckUsername(c.UserContext(), username))
+}
+
+// ChangeUsername godoc
+// @Summary      Set or change the username
+// @Description  Sets the username of the current user, who can then log in with it instead of the email address. The old username is released immediately.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.UsernameRequest true "New username"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string "Username already in use"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/username [put]
+func (ac *AuthController) ChangeUsername(c *fiber.Ctx) error {
+	var req dto.UsernameRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid username"})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	if err := ac.svc.ChangeUsername(c.UserContext(), userID, req.Username); err != nil {
+		switch err.Error() {
+		case "invalid user ID format", "invalid username":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "user not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case "username already in use":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "username updated", "username": util.NormalizeUsername(req.Username)})
 }
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"mein-idaas/dto"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DiscoveryController serves the OpenID Connect discovery document and the JWKS, so relying parties
+// can configure themselves and verify tokens from the issuer URL alone
+type DiscoveryController struct{}
+
+func NewDiscoveryController() *DiscoveryController {
+	return &DiscoveryController{}
+}
+
+// GetOpenIDConfiguration godoc
+// @Summary      OpenID Connect discovery document
+// @Description  Advertises the issuer, authorization/token endpoints, JWKS URI and supported algorithms. Also served per tenant under /t/{org}/.well-known/openid-configuration when multi-tenancy is enabled.
+// @Tags         oidc
+// @Produce      json
+// @Success      200  {object}  dto.OpenIDConfiguration
+// @Router       /.well-known/openid-configuration [get]
+func (dc *DiscoveryController) GetOpenIDConfiguration(c *fiber.Ctx) error {
+	tenant, _ := c.Locals("tenant").(string)
+	base := publicBaseURL(c, tenant)
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	return c.Status(fiber.StatusOK).JSON(dto.OpenIDConfiguration{
+		Issuer:                            util.TenantIssuer(tenant),
+		AuthorizationEndpoint:             base + "/oauth/authorize",
+		TokenEndpoint:                     base + "/oauth/token",
+		IntrospectionEndpoint:             base + "/oauth/introspect",
+		RevocationEndpoint:                base + "/oauth/revoke",
+		UserInfoEndpoint:                  base + "/oauth/userinfo",
+		RegistrationEndpoint:              base + "/oauth/register",
+		JWKSURI:                           base + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "urn:ietf:params:oauth:grant-type:token-exchange"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{util.GetSigningAlg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ScopesSupported:                   []string{"openid", "email", "profile", "offline_access"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "email", "email_verified", "name", "preferred_username", "phone_number", "phone_number_verified", "roles", "tenant"},
+		DPoPSigningAlgValuesSupported:     util.DPoPSigningAlgs,
+	})
+}
+
+// GetJWKS godoc
+// @Summary      JSON Web Key Set
+// @Description  Publishes the public keys that verify access tokens, with kid values matching the token header. During a key rotation both the new and the retiring key are listed. Also served per tenant under /t/{org}/.well-known/jwks.json, with the tenant's own keys (if it has any) first and the global keys; keys of other tenants are never listed.
+// @Tags         oidc
+// @Produce      json
+// @Success      200  {object}  dto.JWKS
+// @Failure      500  {object}  map[string]string
+// @Router       /.well-known/jwks.json [get]
+func (dc *DiscoveryController) GetJWKS(c *fiber.Ctx) error {
+	tenant, _ := c.Locals("tenant").(string)
+	jwks := dto.JWKS{Keys: []dto.JWK{}}
+	for _, key := range util.GetVerificationKeys(tenant) {
+		jwk, err := util.PublicJWK(key)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	// Short cache so resource servers pick up a rotated key quickly
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(jwks)
+}
+
+// publicBaseURL is the externally visible URL of this IdP (ISSUER_BASE_URL, or the request's
+// scheme and host), with the /t/{org} prefix for tenant routes
+func publicBaseURL(c *fiber.Ctx, tenant string) string {
+	base := util.GetIssuerBaseURL()
+	if base == "" {
+		base = c.BaseURL()
+	}
+	if tenant != "" {
+		base += "/t/" + tenant
+	}
+	return base
+}
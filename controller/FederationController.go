@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"path"
+	"strings"
+	"time"
+
+	"mein-idaas/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// federationCookie holds state, nonce and PKCE verifier of a running upstream login
+const federationCookie = "idaas_federation"
+
+// FederationController provides handlers for sign-in via upstream OIDC providers
+type FederationController struct {
+	authSvc *service.AuthService
+	fedSvc  *service.FederationService
+}
+
+func NewFederationController(authSvc *service.AuthService, fedSvc *service.FederationService) *FederationController {
+	return &FederationController{
+		authSvc: authSvc,
+		fedSvc:  fedSvc,
+	}
+}
+
+// ListProviders godoc
+// @Summary      List upstream identity providers
+// @Description  Returns the OIDC providers configured via OIDC_PROVIDERS that users can sign in with.
+// @Tags         identities
+// @Produce      json
+// @Success      200  {array}   dto.FederationProviderResponse
+// @Router       /auth/federation/providers [get]
+func (fc *FederationController) ListProviders(c *fiber.Ctx) error {
+	return c.JSON(fc.fedSvc.Providers())
+}
+
+// Start godoc
+// @Summary      Start login at an upstream OIDC provider
+// @Description  Redirects the browser to the provider's authorization endpoint (authorization code flow with PKCE and nonce). The provider returns to /auth/federation/{provider}/callback, which must be registered as redirect URI at the provider.
+// @Tags         identities
+// @Param        provider path string true "Provider name from OIDC_PROVIDERS"
+// @Success      302  {string}  string "Redirect to the provider"
+// @Failure      404  {object}  map[string]string
+// @Failure      502  {object}  map[string]string
+// @Router       /auth/federation/{provider}/start [get]
+func (fc *FederationController) Start(c *fiber.Ctx) error {
+	target, flow, err := fc.fedSvc.Start(c.UserContext(), c.Params("provider"), callbackURI(c))
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// SameSite=Lax: the cookie must come along when the provider redirects back
+	c.Cookie(&fiber.Cookie{
+		Name:     federationCookie,
+		Value:    flow.State + "." + flow.Nonce + "." + flow.CodeVerifier,
+		Expires:  time.Now().Add(10 * time.Minute),
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: "Lax",
+		Path:     path.Dir(c.Path()),
+	})
+	return c.Redirect(target, fiber.StatusFound)
+}
+
+// Callback godoc
+// @Summary      Finish login at an upstream OIDC provider
+// @Description  Exchanges the authorization code, verifies the provider's ID token and signs in like /auth/social/google: the identity logs in its linked account, is linked to the verified account with the same email, or creates a new account. Sets the refresh token cookie like /auth/login.
+// @Tags         identities
+// @Produce      json
+// @Param        provider path  string true "Provider name from OIDC_PROVIDERS"
+// @Param        code     query string true "Authorization code from the provider"
+// @Param        state    query string true "State from the start request"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      502  {object}  map[string]string
+// @Router       /auth/federation/{provider}/callback [get]
+func (fc *FederationController) Callback(c *fiber.Ctx) error {
+	// The flow is single-use
+	parts := strings.Split(c.Cookies(federationCookie), ".")
+	c.Cookie(&fiber.Cookie{
+		Name:     federationCookie,
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: "Lax",
+		Path:     path.Dir(c.Path()),
+	})
+
+	if e := c.Query("error"); e != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "upstream login failed: " + e})
+	}
+	if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(c.Query("state"))) != 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid state"})
+	}
+	if c.Query("code") == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "missing code"})
+	}
+
+	flow := &service.FederationFlow{State: parts[0], Nonce: parts[1], CodeVerifier: parts[2]}
+	identity, err := fc.fedSvc.Finish(c.UserContext(), c.Params("provider"), c.Query("code"), callbackURI(c), flow)
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := fc.authSvc.SignInWithIdentity(c.UserContext(), identity, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
@@ -0,0 +1,292 @@
+package controller
+
+import (
+	"strings"
+
+	"mein-idaas/dto"
+	"mein-idaas/service"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GroupController manages groups, their members and the roles they grant (admin)
+// Each route is mounted behind middleware.RequirePermission (see setupRoutes)
+type GroupController struct {
+	svc *service.GroupService
+}
+
+func NewGroupController(s *service.GroupService) *GroupController {
+	return &GroupController{svc: s}
+}
+
+// ListGroups godoc
+// @Summary      List groups
+// @Description  Returns all groups with the roles they grant. Requires the groups:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {array}   dto.GroupResponse
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/groups [get]
+func (gc *GroupController) ListGroups(c *fiber.Ctx) error {
+	res, err := gc.svc.ListGroups(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// GetGroup godoc
+// @Summary      Get a group
+// @Description  Returns the group with the given code. Requires the groups:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Group code"
+// @Success      200  {object}  dto.GroupResponse
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /admin/groups/{code} [get]
+func (gc *GroupController) GetGroup(c *fiber.Ctx) error {
+	res, err := gc.svc.GetGroup(c.UserContext(), c.Params("code"))
+	if err != nil {
+		if err.Error() == "group not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// CreateGroup godoc
+// @Summary      Create a group
+// @Description  Adds a group (team) without members or roles. The code (2-50 lowercase letters, digits, _ or -, starting with a letter) can't be changed later. Requires the groups:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.CreateGroupRequest true "Group"
+// @Success      201  {object}  dto.GroupResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /admin/groups [post]
+func (gc *GroupController) CreateGroup(c *fiber.Ctx) error {
+	var req dto.CreateGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := gc.svc.CreateGroup(c.UserContext(), &req)
+	if err != nil {
+		switch err.Error() {
+		case "invalid group code":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "group already exists":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+// UpdateGroup godoc
+// @Summary      Update a group
+// @Description  Changes the name and description of a group. The code can't be changed. Requires the groups:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Group code"
+// @Param        payload body dto.UpdateGroupRequest true "Name and description"
+// @Success      200  {object}  dto.GroupResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /admin/groups/{code} [put]
+func (gc *GroupController) UpdateGroup(c *fiber.Ctx) error {
+	var req dto.UpdateGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := gc.svc.UpdateGroup(c.UserContext(), c.Params("code"), &req)
+	if err != nil {
+		switch err.Error() {
+		case "group not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case "group name already in use":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// DeleteGroup godoc
+// @Summary      Delete a group
+// @Description  Deletes a group; its members lose the roles they had through it (roles granted to them directly stay). Access tokens already issued keep those roles until they expire. Requires the groups:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Group code"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /admin/groups/{code} [delete]
+func (gc *GroupController) DeleteGroup(c *fiber.Ctx) error {
+	if err := gc.svc.DeleteGroup(c.UserContext(), c.Params("code")); err != nil {
+		if err.Error() == "group not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "group deleted"})
+}
+
+// SetGroupRoles godoc
+// @Summary      Set the roles of a group
+// @Description  Replaces the roles every member of the group gets in addition to their own (inherited roles and MFA_REQUIRED_ROLES apply as for direct grants). Access tokens issued from now on carry them; tokens already issued keep the old roles until they expire. Requires the groups:manage and roles:manage permissions.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Group code"
+// @Param        payload body dto.SetGroupRolesRequest true "Role codes"
+// @Success      200  {object}  dto.GroupResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /admin/groups/{code}/roles [put]
+func (gc *GroupController) SetGroupRoles(c *fiber.Ctx) error {
+	var req dto.SetGroupRolesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := gc.svc.SetGroupRoles(c.UserContext(), c.Params("code"), &req)
+	if err != nil {
+		switch {
+		case err.Error() == "group not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "unknown role"):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// ListGroupMembers godoc
+// @Summary      List the members of a group
+// @Description  Returns one page of the group's members ordered by email, with their directly granted roles. Requires the groups:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Group code"
+// @Param        page query int false "Page, starting at 1 (default 1)"
+// @Param        size query int false "Users per page, 1-100 (default 20)"
+// @Success      200  {object}  dto.UserListResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /admin/groups/{code}/members [get]
+func (gc *GroupController) ListGroupMembers(c *fiber.Ctx) error {
+	var req dto.ListGroupMembersRequest
+	if err := c.QueryParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid query parameters"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := gc.svc.ListMembers(c.UserContext(), c.Params("code"), &req)
+	if err != nil {
+		if err.Error() == "group not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// AddGroupMembers godoc
+// @Summary      Add users to a group
+// @Description  Adds up to 100 users to a group; users already in it are skipped. Nothing is added if one of the users doesn't exist. The users get the group's roles in tokens issued from now on. Requires the groups:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Group code"
+// @Param        payload body dto.AddGroupMembersRequest true "User IDs"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /admin/groups/{code}/members [post]
+func (gc *GroupController) AddGroupMembers(c *fiber.Ctx) error {
+	var req dto.AddGroupMembersRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := gc.svc.AddMembers(c.UserContext(), c.Params("code"), &req); err != nil {
+		switch {
+		case err.Error() == "group not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case err.Error() == "invalid user ID format", strings.HasPrefix(err.Error(), "unknown user"):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "members added"})
+}
+
+// RemoveGroupMember godoc
+// @Summary      Remove a user from a group
+// @Description  Takes a user out of a group; the user loses the roles they had through it. Access tokens already issued keep those roles until they expire. Requires the groups:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Group code"
+// @Param        user_id path string true "User ID"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /admin/groups/{code}/members/{user_id} [delete]
+func (gc *GroupController) RemoveGroupMember(c *fiber.Ctx) error {
+	if err := gc.svc.RemoveMember(c.UserContext(), c.Params("code"), c.Params("user_id")); err != nil {
+		switch err.Error() {
+		case "group not found", "user is not a member of the group":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case "invalid user ID format":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "member removed"})
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"mein-idaas/dto"
+	"mein-idaas/service"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GuestController provides handlers for anonymous guest accounts and their upgrade path
+type GuestController struct {
+	svc *service.AuthService
+}
+
+func NewGuestController(s *service.AuthService) *GuestController {
+	return &GuestController{svc: s}
+}
+
+// CreateGuest godoc
+// @Summary      Create a guest account
+// @Description  Creates an anonymous device-bound account with the limited 'guest' role and returns a token pair plus a device secret. The device secret is shown only once.
+// @Tags         guest
+// @Produce      json
+// @Success      201  {object}  dto.GuestResponse
+// @Failure      403  {object}  map[string]string "Registration closed"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/guest [post]
+func (gc *GuestController) CreateGuest(c *fiber.Ctx) error {
+	res, err := gc.svc.CreateGuest(c.UserContext(), c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		if isRegistrationPolicyError(err) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+// LoginGuest godoc
+// @Summary      Login to a guest account
+// @Description  Exchanges the device secret of a guest account for a token pair. Sets the refresh token cookie like /auth/login.
+// @Tags         guest
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.GuestLoginRequest true "Device secret"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/guest/login [post]
+func (gc *GuestController) LoginGuest(c *fiber.Ctx) error {
+	var req dto.GuestLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := gc.svc.LoginGuest(c.UserContext(), req.DeviceSecret, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		if err.Error() == "invalid device secret" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
+
+// ClaimGuest godoc
+// @Summary      Claim a guest account
+// @Description  Upgrades the authenticated guest account to a regular account with email and password. The user ID stays the same; a verification email is sent. Guests can also claim their account by linking a social identity.
+// @Tags         guest
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.ClaimAccountRequest true "Account details"
+// @Success      200  {object}  dto.RegisterResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Registration policy rejected the claim, or authenticated with an API key"
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/claim [post]
+func (gc *GuestController) ClaimGuest(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var req dto.ClaimAccountRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := gc.svc.ClaimGuest(c.UserContext(), userID, &req)
+	if err != nil {
+		if service.IsPasswordPolicyError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if isRegistrationPolicyError(err) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+		}
+		switch err.Error() {
+		case "invalid user ID format", "account is not a guest account":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "user not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case "email already in use":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(res)
+}
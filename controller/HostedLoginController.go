@@ -0,0 +1,307 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"embed"
+	"html/template"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"mein-idaas/service"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	ssoCookieName  = "idaas_sso"
+	csrfCookieName = "idaas_csrf"
+
+	// pageCSP: pages can't be framed (clickjacking), and forms may only post to this origin
+	pageCSP = "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'"
+	// samlPostScript is the hash of the auto-submit script of saml_post.html
+	samlPostScript = "'sha256-ePniVEkSivX/c7XWBGafqh8tSpiRrKiqYeqbG7N1TOE='"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// HostedLoginController serves the server-rendered login, MFA and consent pages for browser SSO
+// Signing in sets the idaas_sso session cookie, so the authorization flow can skip the login page
+// for users who already have a session. Forms are protected with a double-submit CSRF cookie.
+type HostedLoginController struct {
+	ssoSvc  *service.SSOService
+	pages   map[string]*template.Template
+	appName string
+}
+
+func NewHostedLoginController(ssoSvc *service.SSOService) *HostedLoginController {
+	pages := make(map[string]*template.Template)
+	for _, page := range []string{"login", "mfa", "session", "consent", "saml_post"} {
+		pages[page] = template.Must(template.ParseFS(templateFS, "templates/layout.html", "templates/"+page+".html"))
+	}
+
+	appName := os.Getenv("APP_NAME")
+	if appName == "" {
+		appName = "mein-idaas"
+	}
+
+	return &HostedLoginController{ssoSvc: ssoSvc, pages: pages, appName: appName}
+}
+
+// ConsentPage describes an authorization request shown on the consent page
+// The form posts back to Action with Params, the CSRF token and consent=approve|deny
+type ConsentPage struct {
+	ClientName string
+	Scopes     []string
+	Action     string
+	Params     map[string]string
+}
+
+// ShowLogin renders the login form, or continues to return_to when a session already exists
+func (hc *HostedLoginController) ShowLogin(c *fiber.Ctx) error {
+	returnTo := safeReturnTo(c.Query("return_to"))
+
+	if _, user, err := hc.ssoSvc.GetSession(c.UserContext(), c.Cookies(ssoCookieName)); err == nil {
+		if returnTo != "" {
+			return c.Redirect(returnTo, fiber.StatusFound)
+		}
+		return hc.render(c, fiber.StatusOK, "session", "Signed in", fiber.Map{"Email": user.Email})
+	}
+
+	return hc.render(c, fiber.StatusOK, "login", "Sign in", fiber.Map{"ReturnTo": returnTo})
+}
+
+// Login checks the submitted credentials and starts the SSO session
+func (hc *HostedLoginController) Login(c *fiber.Ctx) error {
+	returnTo := safeReturnTo(c.FormValue("return_to"))
+	email := c.FormValue("email")
+
+	if !checkCSRF(c) {
+		return hc.render(c, fiber.StatusForbidden, "login", "Sign in", fiber.Map{
+			"Error": "Your form expired, please try again.", "Email": email, "ReturnTo": returnTo,
+		})
+	}
+
+	token, session, err := hc.ssoSvc.Login(c.UserContext(), email, c.FormValue("password"), c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		msg := "Invalid email or password."
+		switch err.Error() {
+		case "email not verified":
+			msg = "Please verify your email address first. We sent you a new verification code."
+		case "password expired":
+			msg = "Your password has expired. Please sign in to the app and choose a new password."
+		case "password change required":
+			msg = "You signed in with a temporary password. Please sign in to the app and choose a new password."
+		case "too many attempts":
+			msg = "Too many failed sign-in attempts. Please wait a moment and try again."
+		case "account locked":
+			msg = "Your account is temporarily locked after too many failed sign-in attempts. Try again later or reset your password."
+		}
+		return hc.render(c, fiber.StatusUnauthorized, "login", "Sign in", fiber.Map{
+			"Error": msg, "Email": email, "ReturnTo": returnTo,
+		})
+	}
+
+	setSSOCookie(c, token, session.ExpiresAt)
+	if session.MFAPending {
+		return c.Redirect(ssoPath(c, "/login/mfa")+"?return_to="+url.QueryEscape(returnTo), fiber.StatusSeeOther)
+	}
+	return continueTo(c, returnTo)
+}
+
+// ShowMFA renders the MFA code form for a session that passed the password step
+func (hc *HostedLoginController) ShowMFA(c *fiber.Ctx) error {
+	returnTo := safeReturnTo(c.Query("return_to"))
+	if !hc.ssoSvc.PendingMFA(c.UserContext(), c.Cookies(ssoCookieName)) {
+		return c.Redirect(ssoPath(c, "/login")+"?return_to="+url.QueryEscape(returnTo), fiber.StatusFound)
+	}
+
+	return hc.render(c, fiber.StatusOK, "mfa", "Two-factor authentication", fiber.Map{"ReturnTo": returnTo})
+}
+
+// VerifyMFA checks the TOTP, SMS or email code and completes the sign-in
+func (hc *HostedLoginController) VerifyMFA(c *fiber.Ctx) error {
+	returnTo := safeReturnTo(c.FormValue("return_to"))
+
+	if !checkCSRF(c) {
+		return hc.render(c, fiber.StatusForbidden, "mfa", "Two-factor authentication", fiber.Map{
+			"Error": "Your form expired, please try again.", "ReturnTo": returnTo,
+		})
+	}
+
+	token := c.Cookies(ssoCookieName)
+	if err := hc.ssoSvc.CompleteMFA(c.UserContext(), token, c.FormValue("code")); err != nil {
+		if err.Error() == "invalid MFA code" {
+			return hc.render(c, fiber.StatusUnauthorized, "mfa", "Two-factor authentication", fiber.Map{
+				"Error": "Invalid code, please try again.", "ReturnTo": returnTo,
+			})
+		}
+		if err.Error() == "too many attempts" {
+			return hc.render(c, fiber.StatusTooManyRequests, "mfa", "Two-factor authentication", fiber.Map{
+				"Error": "Too many failed attempts. Please wait a moment and try again.", "ReturnTo": returnTo,
+			})
+		}
+		return hc.render(c, fiber.StatusUnauthorized, "login", "Sign in", fiber.Map{
+			"Error": "Your sign-in expired, please start again.", "ReturnTo": returnTo,
+		})
+	}
+
+	setSSOCookie(c, token, time.Now().Add(hc.ssoSvc.SessionTTL()))
+	return continueTo(c, returnTo)
+}
+
+// Logout ends the SSO session
+func (hc *HostedLoginController) Logout(c *fiber.Ctx) error {
+	if !checkCSRF(c) {
+		return c.Redirect(ssoPath(c, "/login"), fiber.StatusSeeOther)
+	}
+
+	if err := hc.ssoSvc.Logout(c.UserContext(), c.Cookies(ssoCookieName)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("failed to sign out")
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     ssoCookieName,
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: "Lax",
+		Path:     ssoCookiePath(c),
+	})
+	return c.Redirect(ssoPath(c, "/login"), fiber.StatusSeeOther)
+}
+
+// RenderConsent shows the consent page for an authorization request of the signed-in user
+// Used by the authorization endpoint; the decision is posted back to page.Action
+func (hc *HostedLoginController) RenderConsent(c *fiber.Ctx, email string, page ConsentPage) error {
+	return hc.render(c, fiber.StatusOK, "consent", "Authorize "+page.ClientName, fiber.Map{
+		"Email":      email,
+		"ClientName": page.ClientName,
+		"Scopes":     page.Scopes,
+		"Action":     page.Action,
+		"Params":     page.Params,
+	})
+}
+
+// RenderSAMLPost posts a SAMLResponse to the service provider (HTTP-POST binding)
+// The form submits itself; the CSP allows only that script and posting to the ACS URL's origin.
+func (hc *HostedLoginController) RenderSAMLPost(c *fiber.Ctx, email string, spName string, acsURL string, samlResponse string, relayState string) error {
+	target, err := url.Parse(acsURL)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("invalid acs url")
+	}
+
+	csp := "default-src 'none'; style-src 'unsafe-inline'; script-src " + samlPostScript +
+		"; form-action " + target.Scheme + "://" + target.Host + "; frame-ancestors 'none'"
+	return hc.renderWithCSP(c, fiber.StatusOK, "saml_post", "Signing in to "+spName, fiber.Map{
+		"Email":        email,
+		"SPName":       spName,
+		"ACSURL":       acsURL,
+		"SAMLResponse": samlResponse,
+		"RelayState":   relayState,
+	}, csp)
+}
+
+// render executes a page with the shared layout
+// Pages can't be framed (clickjacking) or cached, and forms may only post to this origin
+func (hc *HostedLoginController) render(c *fiber.Ctx, status int, page string, title string, data fiber.Map) error {
+	return hc.renderWithCSP(c, status, page, title, data, pageCSP)
+}
+
+// renderWithCSP is render with a page-specific Content-Security-Policy
+func (hc *HostedLoginController) renderWithCSP(c *fiber.Ctx, status int, page string, title string, data fiber.Map, csp string) error {
+	data["Title"] = title
+	data["AppName"] = hc.appName
+	data["CSRF"] = csrfToken(c)
+	data["SSOBase"] = ssoPath(c, "")
+
+	var buf bytes.Buffer
+	if err := hc.pages[page].ExecuteTemplate(&buf, "layout", data); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("failed to render page")
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	c.Set(fiber.HeaderContentSecurityPolicy, csp)
+	return c.Status(status).Send(buf.Bytes())
+}
+
+// setSSOCookie stores the session token; SameSite=Lax so it is sent when a relying party redirects here
+func setSSOCookie(c *fiber.Ctx, token string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     ssoCookieName,
+		Value:    token,
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: "Lax",
+		Path:     ssoCookiePath(c),
+	})
+}
+
+// ssoPath returns the path of a hosted page, prefixed with /t/{org} on tenant routes
+func ssoPath(c *fiber.Ctx, page string) string {
+	if tenant, ok := c.Locals("tenant").(string); ok && tenant != "" {
+		return "/t/" + tenant + "/sso" + page
+	}
+	return "/sso" + page
+}
+
+// ssoCookiePath scopes tenant sessions to /t/{org}, so each organization has its own sign-in
+func ssoCookiePath(c *fiber.Ctx) string {
+	if tenant, ok := c.Locals("tenant").(string); ok && tenant != "" {
+		return "/t/" + tenant
+	}
+	return "/"
+}
+
+// continueTo redirects to return_to after sign-in, or to the session page
+func continueTo(c *fiber.Ctx, returnTo string) error {
+	if returnTo == "" {
+		returnTo = ssoPath(c, "/login")
+	}
+	return c.Redirect(returnTo, fiber.StatusSeeOther)
+}
+
+// csrfToken returns the double-submit CSRF token, issuing the cookie on first use
+func csrfToken(c *fiber.Ctx) string {
+	if token := c.Cookies(csrfCookieName); token != "" {
+		return token
+	}
+
+	token, err := util.GenerateSecureToken(32)
+	if err != nil {
+		return ""
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: "Strict",
+		Path:     "/",
+	})
+	return token
+}
+
+// checkCSRF compares the form token with the CSRF cookie
+func checkCSRF(c *fiber.Ctx) bool {
+	cookie := c.Cookies(csrfCookieName)
+	form := c.FormValue("_csrf")
+	return cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(form)) == 1
+}
+
+// safeReturnTo only allows local paths, so the login page can't be used as an open redirect
+func safeReturnTo(value string) string {
+	if !strings.HasPrefix(value, "/") || strings.HasPrefix(value, "//") || strings.HasPrefix(value, "/\\") ||
+		strings.ContainsAny(value, "\r\n") {
+		return ""
+	}
+	return value
+}
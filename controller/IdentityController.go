@@ -0,0 +1,375 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"path"
+	"strings"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/service"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// IdentityController provides handlers for linked social identities (Google, GitHub, Apple, Facebook)
+type IdentityController struct {
+	authSvc *service.AuthService
+	linkSvc *service.LinkCredentialService
+}
+
+func NewIdentityController(authSvc *service.AuthService, linkSvc *service.LinkCredentialService) *IdentityController {
+	return &IdentityController{
+		authSvc: authSvc,
+		linkSvc: linkSvc,
+	}
+}
+
+// identityErrorStatus maps identity service errors to HTTP status codes
+func identityErrorStatus(err error) int {
+	switch err.Error() {
+	case "invalid identity token", "identity not linked":
+		return fiber.StatusUnauthorized
+	case "identity provider not configured", "invalid user ID format", "invalid identity type",
+		"provider did not share an email", "provider did not share a verified email", "email permission not granted":
+		return fiber.StatusBadRequest
+	case "registration is closed", "registration requires an invite",
+		"automatic sign-up is disabled", "email domain not allowed for sign-up":
+		return fiber.StatusForbidden
+	case "user not found", "provider not linked", "unknown identity provider":
+		return fiber.StatusNotFound
+	case "identity already linked", "identity linked to another account", "provider already linked", "email already in use",
+		"cannot remove the only login method, set a password first":
+		return fiber.StatusConflict
+	case "identity provider unavailable":
+		return fiber.StatusBadGateway
+	}
+	return fiber.StatusInternalServerError
+}
+
+// LinkIdentity godoc
+// @Summary      Link a social identity to the current account
+// @Description  Verifies a Google or Apple ID token, or a GitHub or Facebook access token with the provider and links the identity to the authenticated account. Later logins via /auth/login/social resolve to the same user.
+// @Tags         identities
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.LinkIdentityRequest true "Provider type and token"
+// @Success      201  {object}  dto.LinkIdentityResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/identities/link [post]
+func (ic *IdentityController) LinkIdentity(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var req dto.LinkIdentityRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	identity, err := ic.linkSvc.LinkIdentity(c.UserContext(), userID, req.Type, req.Token)
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(dto.LinkIdentityResponse{
+		Message: "identity linked",
+		Type:    string(identity.Type),
+		Email:   identity.Email,
+	})
+}
+
+// UnlinkIdentity godoc
+// @Summary      Unlink a social identity from the current account
+// @Description  Removes the linked credential of the given provider. Refused when it is the only login method left (set a password first). The user is notified by email.
+// @Tags         identities
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        type path string true "Provider type (e.g. google, github)"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/identities/{type} [delete]
+func (ic *IdentityController) UnlinkIdentity(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	if err := ic.linkSvc.UnlinkIdentity(c.UserContext(), userID, c.Params("type")); err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "identity unlinked"})
+}
+
+// GoogleLogin godoc
+// @Summary      Sign in with Google
+// @Description  Verifies a Google ID token, or exchanges a Google authorization code for one, and signs the user in. On first use the Google identity is linked to the verified account with the same email, or a new verified account is created (registration policy applies). Sets the refresh token cookie like /auth/login.
+// @Tags         identities
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.GoogleLoginRequest true "Google ID token or authorization code"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/social/google [post]
+func (ic *IdentityController) GoogleLogin(c *fiber.Ctx) error {
+	var req dto.GoogleLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var identity *service.ExternalIdentity
+	var err error
+	if req.IDToken != "" {
+		identity, err = ic.linkSvc.VerifyIdentity(c.UserContext(), string(model.CredTypeGoogle), req.IDToken)
+	} else {
+		identity, err = ic.linkSvc.VerifyGoogleCode(c.UserContext(), req.Code, req.RedirectURI, req.CodeVerifier)
+	}
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := ic.authSvc.SignInWithIdentity(c.UserContext(), identity, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
+
+// AppleLogin godoc
+// @Summary      Sign in with Apple
+// @Description  Signs in with an Apple ID token, or with an authorization code the server exchanges using the ES256 client secret (APPLE_TEAM_ID / APPLE_KEY_ID / APPLE_PRIVATE_KEY). Works like /auth/social/google: the Apple identity logs in its linked account, is linked to the verified account with the same email, or creates a new account. "Hide My Email" relay addresses are accepted as the account email. Sets the refresh token cookie like /auth/login.
+// @Tags         identities
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.AppleLoginRequest true "ID token or authorization code, and the name Apple shared on first sign-in"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/social/apple [post]
+func (ic *IdentityController) AppleLogin(c *fiber.Ctx) error {
+	var req dto.AppleLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var identity *service.ExternalIdentity
+	var err error
+	if req.IDToken != "" {
+		identity, err = ic.linkSvc.VerifyIdentity(c.UserContext(), string(model.CredTypeApple), req.IDToken)
+	} else {
+		identity, err = ic.linkSvc.VerifyAppleCode(c.UserContext(), req.Code, req.RedirectURI)
+	}
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	identity.Name = strings.TrimSpace(req.Name)
+
+	res, err := ic.authSvc.SignInWithIdentity(c.UserContext(), identity, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
+
+// FacebookLogin godoc
+// @Summary      Sign in with Facebook
+// @Description  Verifies a Facebook user access token with the Graph API (issued to FACEBOOK_APP_ID, email permission granted) and signs in like /auth/social/google: the Facebook identity logs in its linked account, is linked to the verified account with the same email, or creates a new account. Sets the refresh token cookie like /auth/login.
+// @Tags         identities
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.FacebookLoginRequest true "Facebook user access token"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure"
+// @Failure      400  {object}  map[string]string "Also returned when the user declined the email permission"
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/social/facebook [post]
+func (ic *IdentityController) FacebookLogin(c *fiber.Ctx) error {
+	var req dto.FacebookLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	identity, err := ic.linkSvc.VerifyFacebookToken(c.UserContext(), req.AccessToken)
+	if err != nil {
+		if err.Error() == "email permission not granted" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   err.Error(),
+				"message": "ask for the email permission again (auth_type=rerequest)",
+			})
+		}
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := ic.authSvc.SignInWithIdentity(c.UserContext(), identity, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
+
+// githubStateCookie holds the state of a running GitHub login (CSRF protection of the callback)
+const githubStateCookie = "github_oauth_state"
+
+// GithubStart godoc
+// @Summary      Start GitHub login
+// @Description  Redirects the browser to GitHub to authorize the OAuth app (GITHUB_CLIENT_ID / GITHUB_CLIENT_SECRET). GitHub returns to /auth/social/github/callback, which must be allowed by the app's callback URL.
+// @Tags         identities
+// @Success      302  {string}  string "Redirect to GitHub"
+// @Failure      400  {object}  map[string]string
+// @Router       /auth/social/github/start [get]
+func (ic *IdentityController) GithubStart(c *fiber.Ctx) error {
+	state, err := util.GenerateSecureToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to start login"})
+	}
+
+	target, err := ic.linkSvc.GithubAuthorizeURL(state, callbackURI(c))
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// SameSite=Lax: the cookie must come along when GitHub redirects back
+	c.Cookie(&fiber.Cookie{
+		Name:     githubStateCookie,
+		Value:    state,
+		Expires:  time.Now().Add(10 * time.Minute),
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: "Lax",
+		Path:     path.Dir(c.Path()),
+	})
+	return c.Redirect(target, fiber.StatusFound)
+}
+
+// GithubCallback godoc
+// @Summary      Finish GitHub login
+// @Description  Exchanges the authorization code, reads the user's primary verified email and signs in like /auth/social/google: the GitHub identity logs in its linked account, is linked to the verified account with that email, or creates a new account. Sets the refresh token cookie like /auth/login.
+// @Tags         identities
+// @Produce      json
+// @Param        code   query string true "Authorization code from GitHub"
+// @Param        state  query string true "State from the start request"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/social/github/callback [get]
+func (ic *IdentityController) GithubCallback(c *fiber.Ctx) error {
+	// The state is single-use
+	state := c.Cookies(githubStateCookie)
+	c.Cookie(&fiber.Cookie{
+		Name:     githubStateCookie,
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: "Lax",
+		Path:     path.Dir(c.Path()),
+	})
+
+	if e := c.Query("error"); e != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "github login failed: " + e})
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid state"})
+	}
+	if c.Query("code") == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "missing code"})
+	}
+
+	identity, err := ic.linkSvc.VerifyGithubCode(c.UserContext(), c.Query("code"), callbackURI(c))
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := ic.authSvc.SignInWithIdentity(c.UserContext(), identity, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
+
+// callbackURI is the callback URL next to the current start/callback route (tenant routes included)
+func callbackURI(c *fiber.Ctx) string {
+	base := util.GetIssuerBaseURL()
+	if base == "" {
+		base = c.BaseURL()
+	}
+	return base + path.Dir(c.Path()) + "/callback"
+}
+
+// LoginWithIdentity godoc
+// @Summary      Login with a linked social identity
+// @Description  Verifies a Google or Apple ID token, or a GitHub or Facebook access token and logs in the account the identity is linked to. Sets the refresh token cookie like /auth/login.
+// @Tags         identities
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.IdentityLoginRequest true "Provider type and token"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/login/social [post]
+func (ic *IdentityController) LoginWithIdentity(c *fiber.Ctx) error {
+	var req dto.IdentityLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	identity, err := ic.linkSvc.VerifyIdentity(c.UserContext(), req.Type, req.Token)
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := ic.authSvc.LoginWithIdentity(c.UserContext(), identity, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		return c.Status(identityErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
@@ -0,0 +1,548 @@
+package controller
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/service"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OAuthController serves the OAuth 2.0 authorization and token endpoints for third-party apps
+// The user signs in and approves the request on the hosted pages (idaas_sso session cookie).
+type OAuthController struct {
+	oauthSvc *service.OAuthService
+	ssoSvc   *service.SSOService
+	pages    *HostedLoginController
+}
+
+func NewOAuthController(oauthSvc *service.OAuthService, ssoSvc *service.SSOService, pages *HostedLoginController) *OAuthController {
+	return &OAuthController{
+		oauthSvc: oauthSvc,
+		ssoSvc:   ssoSvc,
+		pages:    pages,
+	}
+}
+
+// oauthError maps OAuth service errors to the HTTP status and RFC 6749 error code
+func oauthError(err error) (int, string) {
+	switch err.Error() {
+	case "invalid client":
+		return fiber.StatusUnauthorized, "invalid_client"
+	case "invalid authorization code", "invalid code_verifier", "invalid refresh token":
+		return fiber.StatusBadRequest, "invalid_grant"
+	case "invalid DPoP proof":
+		return fiber.StatusBadRequest, "invalid_dpop_proof"
+	case "too many active sessions":
+		return fiber.StatusForbidden, "access_denied"
+	case "unsupported grant_type":
+		return fiber.StatusBadRequest, "unsupported_grant_type"
+	case "unauthorized client":
+		return fiber.StatusBadRequest, "unauthorized_client"
+	case "unsupported response_type":
+		return fiber.StatusBadRequest, "unsupported_response_type"
+	case "invalid scope":
+		return fiber.StatusBadRequest, "invalid_scope"
+	case "invalid target":
+		return fiber.StatusBadRequest, "invalid_target"
+	case "unknown client", "redirect_uri not registered", "code_challenge required", "unsupported code_challenge_method",
+		"invalid code_challenge", "missing code", "missing refresh_token", "missing token", "missing subject_token",
+		"unsupported subject_token_type", "unsupported requested_token_type", "invalid subject token":
+		return fiber.StatusBadRequest, "invalid_request"
+	}
+	return fiber.StatusInternalServerError, "server_error"
+}
+
+// Authorize godoc
+// @Summary      OAuth 2.0 authorization endpoint
+// @Description  Starts the authorization code flow. Unauthenticated users are sent to the hosted login page first, then asked to approve the client. On approval the browser is redirected to redirect_uri with code and state. PKCE (S256) is required for public clients.
+// @Tags         oauth
+// @Produce      html
+// @Param        response_type          query string true  "Must be 'code'"
+// @Param        client_id              query string true  "Registered client ID"
+// @Param        redirect_uri           query string true  "Registered redirect URI (exact match)"
+// @Param        scope                  query string false "Space-separated scopes (openid, email, profile)"
+// @Param        state                  query string false "Opaque value returned to the client"
+// @Param        code_challenge         query string false "PKCE challenge (base64url SHA-256 of the verifier)"
+// @Param        code_challenge_method  query string false "Must be 'S256'"
+// @Param        nonce                  query string false "OIDC nonce"
+// @Param        prompt                 query string false "'none' fails with login_required instead of showing a page"
+// @Success      200  {string}  string "Consent page"
+// @Success      302  {string}  string "Redirect to the login page or the client"
+// @Failure      400  {object}  map[string]string
+// @Router       /oauth/authorize [get]
+func (oc *OAuthController) Authorize(c *fiber.Ctx) error {
+	var req dto.AuthorizeRequest
+	if err := c.QueryParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request", "error_description": "invalid query parameters"})
+	}
+	return oc.authorize(c, &req, "")
+}
+
+// Consent godoc
+// @Summary      Submit the consent decision
+// @Description  Posted by the consent page with the authorization parameters, the CSRF token and consent=approve|deny.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Success      302  {string}  string "Redirect to the client with code or error"
+// @Failure      400  {object}  map[string]string
+// @Router       /oauth/authorize [post]
+func (oc *OAuthController) Consent(c *fiber.Ctx) error {
+	var req dto.AuthorizeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request", "error_description": "invalid form"})
+	}
+
+	// An expired form shows the consent page again with a fresh token
+	if !checkCSRF(c) {
+		return c.Redirect(c.Path()+"?"+authorizeQuery(&req).Encode(), fiber.StatusSeeOther)
+	}
+	return oc.authorize(c, &req, c.FormValue("consent"))
+}
+
+// authorize runs the authorization request; decision is empty until the user submitted the consent page
+func (oc *OAuthController) authorize(c *fiber.Ctx, req *dto.AuthorizeRequest, decision string) error {
+	ctx := c.UserContext()
+
+	client, err := oc.oauthSvc.ValidateClient(ctx, req.ClientID, req.RedirectURI)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request", "error_description": err.Error()})
+	}
+
+	scopes, err := oc.oauthSvc.ValidateRequest(ctx, client, req)
+	if err != nil {
+		_, code := oauthError(err)
+		return redirectToClient(c, req, url.Values{"error": {code}, "error_description": {err.Error()}})
+	}
+
+	session, user, err := oc.ssoSvc.GetSession(ctx, c.Cookies(ssoCookieName))
+	if err != nil {
+		if req.Prompt == "none" {
+			return redirectToClient(c, req, url.Values{"error": {"login_required"}})
+		}
+		returnTo := c.Path() + "?" + authorizeQuery(req).Encode()
+		return c.Redirect(ssoPath(c, "/login")+"?return_to="+url.QueryEscape(returnTo), fiber.StatusFound)
+	}
+
+	// Scopes the user granted before are approved without asking again, unless prompt=consent
+	consented := req.Prompt != "consent" && oc.oauthSvc.HasConsent(ctx, user.ID, client.ID, req.Scope)
+
+	switch {
+	case decision == "deny":
+		return redirectToClient(c, req, url.Values{"error": {"access_denied"}})
+	case decision == "approve":
+		if err := oc.oauthSvc.GrantConsent(ctx, user.ID, client.ID, req.Scope); err != nil {
+			return redirectToClient(c, req, url.Values{"error": {"server_error"}})
+		}
+	case consented:
+	case req.Prompt == "none":
+		return redirectToClient(c, req, url.Values{"error": {"consent_required"}})
+	default:
+		return oc.renderConsent(c, req, client.Name, user.Email, scopes)
+	}
+
+	code, err := oc.oauthSvc.IssueCode(ctx, client, user, session.AuthTime, req)
+	if err != nil {
+		return redirectToClient(c, req, url.Values{"error": {"server_error"}})
+	}
+	return redirectToClient(c, req, url.Values{"code": {code}})
+}
+
+// renderConsent asks the user to approve the requested scopes; the form posts back to this endpoint
+func (oc *OAuthController) renderConsent(c *fiber.Ctx, req *dto.AuthorizeRequest, clientName string, email string, scopes []model.Scope) error {
+	descriptions := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		descriptions = append(descriptions, s.Description)
+	}
+
+	params := make(map[string]string)
+	for name, values := range authorizeQuery(req) {
+		params[name] = values[0]
+	}
+	return oc.pages.RenderConsent(c, email, ConsentPage{
+		ClientName: clientName,
+		Scopes:     descriptions,
+		Action:     c.Path(),
+		Params:     params,
+	})
+}
+
+// authorizeQuery encodes the authorization parameters, so the request can resume after login or consent
+func authorizeQuery(req *dto.AuthorizeRequest) url.Values {
+	q := url.Values{}
+	for name, value := range map[string]string{
+		"response_type":         req.ResponseType,
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+		"prompt":                req.Prompt,
+	} {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+	return q
+}
+
+// redirectToClient sends the browser back to the validated redirect URI with params and state
+func redirectToClient(c *fiber.Ctx, req *dto.AuthorizeRequest, params url.Values) error {
+	target, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request", "error_description": "invalid redirect_uri"})
+	}
+
+	q := target.Query()
+	for name, values := range params {
+		q[name] = values
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	target.RawQuery = q.Encode()
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Redirect(target.String(), fiber.StatusFound)
+}
+
+// Token godoc
+// @Summary      OAuth 2.0 token endpoint
+// @Description  Exchanges an authorization code (with the PKCE code_verifier) or a refresh token for tokens, or swaps a user's access token for a downstream token (RFC 8693 token exchange). Confidential clients authenticate with HTTP Basic (client_secret_basic) or client_id/client_secret form fields (client_secret_post); public clients send only client_id.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        grant_type     formData string true  "authorization_code, refresh_token or urn:ietf:params:oauth:grant-type:token-exchange"
+// @Param        code           formData string false "Authorization code"
+// @Param        redirect_uri   formData string false "Redirect URI used in the authorization request"
+// @Param        code_verifier  formData string false "PKCE verifier"
+// @Param        refresh_token  formData string false "Refresh token"
+// @Param        subject_token       formData string false "Token exchange: the user's access token"
+// @Param        subject_token_type  formData string false "Token exchange: urn:ietf:params:oauth:token-type:access_token"
+// @Param        audience            formData string false "Token exchange: target service (see TOKEN_EXCHANGE_AUDIENCES)"
+// @Param        scope               formData string false "Token exchange: requested scopes (subset of the subject token's)"
+// @Param        client_id      formData string false "Client ID (when not using HTTP Basic)"
+// @Param        client_secret  formData string false "Client secret (client_secret_post)"
+// @Success      200  {object}  dto.OAuthTokenResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Router       /oauth/token [post]
+func (oc *OAuthController) Token(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderPragma, "no-cache")
+
+	var req dto.TokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request", "error_description": "invalid form"})
+	}
+
+	auth := clientAuth(c, req.ClientID, req.ClientSecret)
+
+	var res *dto.OAuthTokenResponse
+	var err error
+	switch req.GrantType {
+	case "authorization_code":
+		res, err = oc.oauthSvc.ExchangeCode(c.UserContext(), &req, auth, c.IP(), c.Get("User-Agent"))
+	case "refresh_token":
+		res, err = oc.oauthSvc.RefreshToken(c.UserContext(), &req, auth, c.IP(), c.Get("User-Agent"))
+	case model.GrantTokenExchange:
+		res, err = oc.oauthSvc.ExchangeToken(c.UserContext(), &req, auth)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unsupported_grant_type"})
+	}
+	if err != nil {
+		status, code := oauthError(err)
+		if status == fiber.StatusUnauthorized && auth.Method == model.AuthMethodSecretBasic {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+		}
+		if status == fiber.StatusInternalServerError {
+			return c.Status(status).JSON(fiber.Map{"error": code})
+		}
+		return c.Status(status).JSON(fiber.Map{"error": code, "error_description": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// clientAuth reads the client credentials of a token endpoint request
+// HTTP Basic (form-urlencoded id and secret, RFC 6749 2.3.1) takes precedence over the form fields.
+func clientAuth(c *fiber.Ctx, formID, formSecret string) service.ClientAuth {
+	if id, secret, ok := basicClientCredentials(c); ok {
+		return service.ClientAuth{ID: id, Secret: secret, Method: model.AuthMethodSecretBasic}
+	}
+	if formSecret != "" {
+		return service.ClientAuth{ID: formID, Secret: formSecret, Method: model.AuthMethodSecretPost}
+	}
+	return service.ClientAuth{ID: formID, Method: model.AuthMethodNone}
+}
+
+// basicClientCredentials reads client_secret_basic credentials from the Authorization header
+func basicClientCredentials(c *fiber.Ctx) (string, string, bool) {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if len(auth) < 6 || !strings.EqualFold(auth[:6], "basic ") {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[6:]))
+	if err != nil {
+		return "", "", false
+	}
+	id, secret, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return "", "", false
+	}
+
+	id, errID := url.QueryUnescape(id)
+	secret, errSecret := url.QueryUnescape(secret)
+	if errID != nil || errSecret != nil {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// Introspect godoc
+// @Summary      OAuth 2.0 token introspection (RFC 7662)
+// @Description  Reports whether an access or refresh token is active and returns its claims. Refresh tokens are checked against the database, so rotated, revoked (logout, reuse detection) and expired tokens are inactive. Requires client authentication like /oauth/token.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        token            formData string true  "Token to introspect"
+// @Param        token_type_hint  formData string false "access_token or refresh_token"
+// @Param        client_id        formData string false "Client ID (when not using HTTP Basic)"
+// @Param        client_secret    formData string false "Client secret (client_secret_post)"
+// @Success      200  {object}  dto.IntrospectionResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Router       /oauth/introspect [post]
+func (oc *OAuthController) Introspect(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	var req dto.IntrospectionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request", "error_description": "invalid form"})
+	}
+
+	auth := clientAuth(c, req.ClientID, req.ClientSecret)
+
+	res, err := oc.oauthSvc.Introspect(c.UserContext(), &req, auth)
+	if err != nil {
+		status, code := oauthError(err)
+		if status == fiber.StatusUnauthorized && auth.Method == model.AuthMethodSecretBasic {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+		}
+		return c.Status(status).JSON(fiber.Map{"error": code, "error_description": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// Revoke godoc
+// @Summary      OAuth 2.0 token revocation (RFC 7009)
+// @Description  Revokes a refresh token (and the opaque access tokens issued with it) or an opaque access token, effective immediately. JWT access tokens can't be revoked. Unknown tokens and tokens issued to another client are ignored. Requires client authentication like /oauth/token.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        token            formData string true  "Token to revoke"
+// @Param        token_type_hint  formData string false "access_token or refresh_token"
+// @Param        client_id        formData string false "Client ID (when not using HTTP Basic)"
+// @Param        client_secret    formData string false "Client secret (client_secret_post)"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Router       /oauth/revoke [post]
+func (oc *OAuthController) Revoke(c *fiber.Ctx) error {
+	var req dto.RevocationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request", "error_description": "invalid form"})
+	}
+
+	auth := clientAuth(c, req.ClientID, req.ClientSecret)
+	if err := oc.oauthSvc.Revoke(c.UserContext(), &req, auth); err != nil {
+		status, code := oauthError(err)
+		if status == fiber.StatusUnauthorized && auth.Method == model.AuthMethodSecretBasic {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+		}
+		return c.Status(status).JSON(fiber.Map{"error": code, "error_description": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{})
+}
+
+// UserInfo godoc
+// @Summary      OIDC UserInfo endpoint
+// @Description  Returns the standard claims of the user the bearer access token was issued to, read from the user store (current email, name and roles). DPoP-bound tokens must be sent with the DPoP scheme and a proof of their key. Refresh tokens are rejected.
+// @Tags         oauth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token> or DPoP <access_token>"
+// @Success      200  {object}  dto.UserInfoResponse
+// @Failure      401  {object}  map[string]string
+// @Router       /oauth/userinfo [get]
+func (oc *OAuthController) UserInfo(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	// The scheme is case-insensitive; DPoP-bound tokens come with the DPoP scheme
+	scheme, token, _ := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	token = strings.TrimSpace(token)
+	switch {
+	case token == "":
+	case strings.EqualFold(scheme, "bearer"):
+		scheme = "Bearer"
+	case strings.EqualFold(scheme, "dpop"):
+		scheme = "DPoP"
+	default:
+		token = ""
+	}
+	if token == "" {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="oauth"`)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid_request", "error_description": "missing bearer token"})
+	}
+
+	res, err := oc.oauthSvc.UserInfo(c.UserContext(), scheme+" "+token)
+	if err != nil {
+		if err.Error() == "invalid token" {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="oauth", error="invalid_token"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid_token", "error_description": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "server_error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// ListConsents godoc
+// @Summary      List apps the current user authorized
+// @Description  Returns the OAuth clients the user granted access to, with the granted scopes. Authorization requests within these scopes skip the consent page.
+// @Tags         oauth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {array}   dto.ConsentResponse
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/consents [get]
+func (oc *OAuthController) ListConsents(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	consents, err := oc.oauthSvc.ListConsents(c.UserContext(), userID)
+	if err != nil {
+		if err.Error() == "invalid user ID format" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(consents)
+}
+
+// RevokeConsent godoc
+// @Summary      Revoke an app's access
+// @Description  Removes the stored consent for the client, so its next authorization request shows the consent page again.
+// @Tags         oauth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        client_id path string true "Client ID"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/consents/{client_id} [delete]
+func (oc *OAuthController) RevokeConsent(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	if err := oc.oauthSvc.RevokeConsent(c.UserContext(), userID, c.Params("client_id")); err != nil {
+		switch err.Error() {
+		case "invalid user ID format":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "consent not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "consent revoked"})
+}
+
+// RegisterClient godoc
+// @Summary      Dynamic client registration (RFC 7591)
+// @Description  Registers an OAuth client from its metadata and returns the client ID and, for confidential clients, the secret (shown once). Requires an access token with the clients:manage permission unless OAUTH_REGISTRATION=open; anonymous registrations may only use the authorization_code and refresh_token grants and the openid, email, profile and offline_access scopes. On tenant routes the client belongs to that organization.
+// @Tags         oauth
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string false "Bearer <access_token> (clients:manage permission, unless registration is open)"
+// @Param        payload body dto.ClientRegistrationRequest true "Client metadata"
+// @Success      201  {object}  dto.ClientRegistrationResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /oauth/register [post]
+func (oc *OAuthController) RegisterClient(c *fiber.Ctx) error {
+	var req dto.ClientRegistrationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_client_metadata", "error_description": "invalid request payload"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_client_metadata", "error_description": err.Error()})
+	}
+
+	// Callers with the clients:manage permission passed the guard; anonymous ones (open registration) didn't send credentials
+	_, privileged := c.Locals("claims").(*dto.AuthClaims)
+
+	res, err := oc.oauthSvc.RegisterClient(c.UserContext(), &req, privileged)
+	if err != nil {
+		switch err.Error() {
+		case "invalid redirect_uri", "redirect_uris required":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_redirect_uri", "error_description": err.Error()})
+		case "unsupported grant type", "unsupported response type", "unsupported token_endpoint_auth_method", "invalid scope",
+			"grant type requires the clients:manage permission", "scope requires the clients:manage permission":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_client_metadata", "error_description": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "server_error"})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+// CreateClient godoc
+// @Summary      Register an OAuth client
+// @Description  Registers a third-party application for the authorization code flow. The client secret is only returned once; public clients (mobile apps, SPAs) get no secret and must use PKCE. Redirect URIs must be https, loopback http, or a custom app scheme. Requires the clients:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.CreateOAuthClientRequest true "Client"
+// @Success      201  {object}  dto.OAuthClientResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /admin/oauth/clients [post]
+func (oc *OAuthController) CreateClient(c *fiber.Ctx) error {
+	var req dto.CreateOAuthClientRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := oc.oauthSvc.CreateClient(c.UserContext(), &req)
+	if err != nil {
+		switch err.Error() {
+		case "unknown tenant", "invalid redirect_uri":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
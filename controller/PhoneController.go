@@ -0,0 +1,260 @@
+package controller
+
+import (
+	"mein-idaas/dto"
+	"mein-idaas/service"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PhoneController provides handlers for phone verification, passwordless phone login and the SMS factor
+type PhoneController struct {
+	svc *service.AuthService
+}
+
+func NewPhoneController(s *service.AuthService) *PhoneController {
+	return &PhoneController{svc: s}
+}
+
+// phoneErrorStatus maps phone flow errors to HTTP status codes
+func phoneErrorStatus(err error) int {
+	switch err.Error() {
+	case "invalid user ID format":
+		return fiber.StatusBadRequest
+	case "invalid or expired OTP code":
+		return fiber.StatusUnauthorized
+	case "user not found":
+		return fiber.StatusNotFound
+	case "phone already in use":
+		return fiber.StatusConflict
+	case "phone not verified", "SMS factor not enabled":
+		return fiber.StatusBadRequest
+	case "invalid or expired MFA challenge":
+		return fiber.StatusUnauthorized
+	case "too many codes requested", "too many attempts":
+		return fiber.StatusTooManyRequests
+	}
+	return fiber.StatusInternalServerError
+}
+
+// StartPhoneVerification godoc
+// @Summary      Send phone verification code
+// @Description  Sends a 6-digit SMS code to the phone number (E.164) the authenticated user wants to attach.
+// @Tags         phone
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.PhoneRequest true "Phone number"
+// @Success      202  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/phone [post]
+func (pc *PhoneController) StartPhoneVerification(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var req dto.PhoneRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := pc.svc.StartPhoneVerification(c.UserContext(), userID, req.Phone, service.NewSMSService()); err != nil {
+		return c.Status(phoneErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "verification code sent"})
+}
+
+// ConfirmPhone godoc
+// @Summary      Confirm phone number
+// @Description  Verifies the SMS code and stores the phone number as verified, enabling passwordless phone login. Requires a login within STEP_UP_MAX_AGE (see /auth/step-up), since SMS codes go to this number.
+// @Tags         phone
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.PhoneVerifyRequest true "Phone number and code"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string "Invalid token, invalid code or insufficient_user_authentication"
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/phone/verify [post]
+func (pc *PhoneController) ConfirmPhone(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var req dto.PhoneVerifyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := pc.svc.ConfirmPhone(c.UserContext(), userID, req.Phone, req.Code); err != nil {
+		return c.Status(phoneErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "phone verified"})
+}
+
+// SendPhoneLoginOTP godoc
+// @Summary      Send passwordless login code by SMS
+// @Description  Sends a 6-digit SMS code to a verified phone number. Always returns 202 so phone numbers can't be enumerated.
+// @Tags         phone
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.PhoneRequest true "Phone number"
+// @Success      202  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/login/phone [post]
+func (pc *PhoneController) SendPhoneLoginOTP(c *fiber.Ctx) error {
+	var req dto.PhoneRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := pc.svc.SendPhoneLoginOTP(c.UserContext(), req.Phone, service.NewSMSService()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "if the phone is registered, a login code has been sent"})
+}
+
+// LoginWithPhone godoc
+// @Summary      Login with SMS code
+// @Description  Exchanges the SMS code for a token pair. Sets the refresh token cookie like /auth/login.
+// @Tags         phone
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.PhoneVerifyRequest true "Phone number and code"
+// @Success      200  {object}  map[string]interface{} "Returns {access_token, expires_in}"
+// @Header       200  {string}  Set-Cookie "refresh_token=...; HttpOnly; Secure"
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/login/phone/verify [post]
+func (pc *PhoneController) LoginWithPhone(c *fiber.Ctx) error {
+	var req dto.PhoneVerifyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := pc.svc.LoginWithPhone(c.UserContext(), req.Phone, req.Code, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		return c.Status(phoneErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return loginResponse(c, res)
+}
+
+// SendMFASMS godoc
+// @Summary      Send MFA login code by SMS
+// @Description  Texts a 6-digit code for an MFA challenge to the user's verified phone (users with the SMS factor, see mfa_methods). Redeem it at /auth/mfa/verify. At most 3 codes per challenge.
+// @Tags         phone
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.MFAChallengeRequest true "MFA challenge"
+// @Success      202  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      429  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/mfa/sms [post]
+func (pc *PhoneController) SendMFASMS(c *fiber.Ctx) error {
+	var req dto.MFAChallengeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := pc.svc.SendMFASMS(c.UserContext(), req.MFAChallenge, service.NewSMSService()); err != nil {
+		return c.Status(phoneErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "login code sent"})
+}
+
+// EnableSMSMFA godoc
+// @Summary      Enable SMS as second factor
+// @Description  Logins of the authenticated user then need a code sent by SMS to their verified phone (or the authenticator code, when TOTP is enabled too). Returns recovery codes once when this is the user's first factor.
+// @Tags         phone
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  dto.MFAConfirmResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string "Authenticated with an API key"
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/mfa/sms [post]
+func (pc *PhoneController) EnableSMSMFA(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	codes, err := pc.svc.EnableSMSMFA(c.UserContext(), userID)
+	if err != nil {
+		return c.Status(phoneErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(dto.MFAConfirmResponse{Message: "SMS MFA enabled", RecoveryCodes: codes})
+}
+
+// DisableSMSMFA godoc
+// @Summary      Disable SMS as second factor
+// @Description  Stops sending MFA codes by SMS; a TOTP authenticator stays active.
+// @Tags         phone
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/me/mfa/sms [delete]
+func (pc *PhoneController) DisableSMSMFA(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	if err := pc.svc.DisableSMSMFA(c.UserContext(), userID); err != nil {
+		return c.Status(phoneErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "SMS MFA disabled"})
+}
+
+// SendForgotPasswordSMS godoc
+// @Summary      Send password reset code by SMS
+// @Description  Account recovery: sends the password reset code to a verified phone number; complete the reset at /auth/forgot-password/reset with phone, code and new_password. Always returns 202 so phone numbers can't be enumerated.
+// @Tags         phone
+// @Accept       json
+// @Produce      json
+// @Param        payload body dto.PhoneRequest true "Phone number"
+// @Success      202  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/forgot-password/send-sms [post]
+func (pc *PhoneController) SendForgotPasswordSMS(c *fiber.Ctx) error {
+	var req dto.PhoneRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := pc.svc.SendForgotPasswordSMS(c.UserContext(), req.Phone, service.NewSMSService()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "if the phone is registered, a password reset code has been sent"})
+}
@@ -0,0 +1,320 @@
+package controller
+
+import (
+	"strings"
+
+	"mein-idaas/dto"
+	"mein-idaas/service"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RoleController manages roles and the permissions they grant (admin)
+// Each route is mounted behind middleware.RequirePermission (see setupRoutes)
+type RoleController struct {
+	svc     *service.RoleService
+	permSvc *service.PermissionService
+}
+
+func NewRoleController(s *service.RoleService, permissions *service.PermissionService) *RoleController {
+	return &RoleController{svc: s, permSvc: permissions}
+}
+
+// ListRoles godoc
+// @Summary      List roles
+// @Description  Returns all roles, system roles first. Requires the roles:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {array}   dto.RoleResponse
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/roles [get]
+func (rc *RoleController) ListRoles(c *fiber.Ctx) error {
+	res, err := rc.svc.ListRoles(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// GetRole godoc
+// @Summary      Get a role
+// @Description  Returns the role with the given code. Requires the roles:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Role code"
+// @Success      200  {object}  dto.RoleResponse
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /admin/roles/{code} [get]
+func (rc *RoleController) GetRole(c *fiber.Ctx) error {
+	res, err := rc.svc.GetRole(c.UserContext(), c.Params("code"))
+	if err != nil {
+		if err.Error() == "role not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// CreateRole godoc
+// @Summary      Create a role
+// @Description  Adds a role users can be assigned. The code (2-50 lowercase letters, digits, _ or -, starting with a letter) appears in the roles claim of tokens and can't be changed later. Roles created here are never system roles. Requires the roles:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.CreateRoleRequest true "Role"
+// @Success      201  {object}  dto.RoleResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /admin/roles [post]
+func (rc *RoleController) CreateRole(c *fiber.Ctx) error {
+	var req dto.CreateRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := rc.svc.CreateRole(c.UserContext(), &req)
+	if err != nil {
+		switch err.Error() {
+		case "invalid role code":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "role already exists":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+// UpdateRole godoc
+// @Summary      Update a role
+// @Description  Changes the name and description of a role, including system roles. The code can't be changed. Requires the roles:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Role code"
+// @Param        payload body dto.UpdateRoleRequest true "Name and description"
+// @Success      200  {object}  dto.RoleResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /admin/roles/{code} [put]
+func (rc *RoleController) UpdateRole(c *fiber.Ctx) error {
+	var req dto.UpdateRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := rc.svc.UpdateRole(c.UserContext(), c.Params("code"), &req)
+	if err != nil {
+		switch err.Error() {
+		case "role not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case "role name already in use":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// DeleteRole godoc
+// @Summary      Delete a role
+// @Description  Deletes a role and removes it from every user that had it. System roles (admin, user, guest) can't be deleted. Access tokens already issued keep the role until they expire. Requires the roles:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Role code"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /admin/roles/{code} [delete]
+func (rc *RoleController) DeleteRole(c *fiber.Ctx) error {
+	if err := rc.svc.DeleteRole(c.UserContext(), c.Params("code")); err != nil {
+		switch err.Error() {
+		case "role not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case "system roles cannot be deleted":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "role deleted"})
+}
+
+// SetRolePermissions godoc
+// @Summary      Set the permissions of a role
+// @Description  Replaces the permissions a role grants (system roles included; the admin role always keeps roles:manage). Access tokens issued from now on carry them in the permissions claim; tokens already issued keep the old ones until they expire. Requires the roles:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Role code"
+// @Param        payload body dto.SetRolePermissionsRequest true "Permission codes"
+// @Success      200  {object}  dto.RoleResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /admin/roles/{code}/permissions [put]
+func (rc *RoleController) SetRolePermissions(c *fiber.Ctx) error {
+	var req dto.SetRolePermissionsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := rc.svc.SetRolePermissions(c.UserContext(), c.Params("code"), &req)
+	if err != nil {
+		if err.Error() == "role not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		if strings.HasPrefix(err.Error(), "unknown permission") || strings.HasPrefix(err.Error(), "the admin role must keep") {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// SetInheritedRoles godoc
+// @Summary      Set the roles a role inherits
+// @Description  Replaces the roles a role inherits (e.g. admin inherits moderator, moderator inherits user). Users with the role get the inherited role codes in the roles claim and their permissions; inheritance is transitive. Changes that would create a cycle are rejected. Requires the roles:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Role code"
+// @Param        payload body dto.SetInheritedRolesRequest true "Inherited role codes"
+// @Success      200  {object}  dto.RoleResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /admin/roles/{code}/inherits [put]
+func (rc *RoleController) SetInheritedRoles(c *fiber.Ctx) error {
+	var req dto.SetInheritedRolesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := rc.svc.SetInheritedRoles(c.UserContext(), c.Params("code"), &req)
+	if err != nil {
+		switch {
+		case err.Error() == "role not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "unknown role"), err.Error() == "a role cannot inherit itself":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "role inheritance cycle"):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// ListPermissions godoc
+// @Summary      List permissions
+// @Description  Returns all permissions roles can grant. Requires the roles:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {array}   dto.PermissionResponse
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Router       /admin/permissions [get]
+func (rc *RoleController) ListPermissions(c *fiber.Ctx) error {
+	res, err := rc.permSvc.ListPermissions(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
+
+// CreatePermission godoc
+// @Summary      Create a permission
+// @Description  Adds a permission (e.g. invoices:read) that roles can grant: lowercase letters, digits, _ . - with : separators. Requires the roles:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.CreatePermissionRequest true "Permission"
+// @Success      201  {object}  dto.PermissionResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /admin/permissions [post]
+func (rc *RoleController) CreatePermission(c *fiber.Ctx) error {
+	var req dto.CreatePermissionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := rc.permSvc.CreatePermission(c.UserContext(), &req)
+	if err != nil {
+		switch err.Error() {
+		case "invalid permission code":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "permission already exists":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+// DeletePermission godoc
+// @Summary      Delete a permission
+// @Description  Deletes a permission and takes it away from every role. roles:manage can't be deleted. Access tokens already issued keep it until they expire. Requires the roles:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        code path string true "Permission code"
+// @Success      200  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /admin/permissions/{code} [delete]
+func (rc *RoleController) DeletePermission(c *fiber.Ctx) error {
+	if err := rc.permSvc.DeletePermission(c.UserContext(), c.Params("code")); err != nil {
+		switch {
+		case err.Error() == "permission not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case strings.HasSuffix(err.Error(), "cannot be deleted"):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "permission deleted"})
+}
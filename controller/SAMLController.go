@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"net/url"
+
+	"mein-idaas/dto"
+	"mein-idaas/saml"
+	"mein-idaas/service"
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SAMLController serves the SAML 2.0 identity provider endpoints for legacy enterprise apps
+// Users sign in on the hosted pages (idaas_sso session cookie), like in the OAuth authorization flow.
+type SAMLController struct {
+	samlSvc *service.SAMLService
+	ssoSvc  *service.SSOService
+	pages   *HostedLoginController
+}
+
+func NewSAMLController(samlSvc *service.SAMLService, ssoSvc *service.SSOService, pages *HostedLoginController) *SAMLController {
+	return &SAMLController{
+		samlSvc: samlSvc,
+		ssoSvc:  ssoSvc,
+		pages:   pages,
+	}
+}
+
+// samlEntityID is the IdP entity ID: the metadata URL of the (tenant) issuer
+func samlEntityID(c *fiber.Ctx) string {
+	tenant, _ := c.Locals("tenant").(string)
+	return publicBaseURL(c, tenant) + "/saml/metadata"
+}
+
+// Metadata godoc
+// @Summary      SAML 2.0 IdP metadata
+// @Description  EntityDescriptor with the signing certificate and the SSO endpoint (HTTP-Redirect and HTTP-POST bindings). The entity ID is this URL. Requires RSA signing keys. Also served per tenant under /t/{org}/saml/metadata.
+// @Tags         saml
+// @Produce      xml
+// @Success      200  {string}  string "EntityDescriptor"
+// @Failure      500  {object}  map[string]string
+// @Router       /saml/metadata [get]
+func (sc *SAMLController) Metadata(c *fiber.Ctx) error {
+	entityID := samlEntityID(c)
+	tenant, _ := c.Locals("tenant").(string)
+
+	metadata, err := sc.samlSvc.Metadata(tenant, entityID, publicBaseURL(c, tenant)+"/saml/sso")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/samlmetadata+xml")
+	c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	return c.Status(fiber.StatusOK).Send(metadata)
+}
+
+// SSO godoc
+// @Summary      SAML 2.0 single sign-on endpoint
+// @Description  Receives an AuthnRequest (HTTP-Redirect or HTTP-POST binding) from a registered service provider. Users without an SSO session are sent to the hosted login page first. The signed assertion is posted to the registered ACS URL.
+// @Tags         saml
+// @Produce      html
+// @Param        SAMLRequest  query string true  "Base64 (and deflated for HTTP-Redirect) AuthnRequest"
+// @Param        RelayState   query string false "Opaque value returned to the service provider"
+// @Success      200  {string}  string "Auto-submitting form to the ACS URL"
+// @Success      302  {string}  string "Redirect to the login page"
+// @Failure      400  {object}  map[string]string
+// @Router       /saml/sso [get]
+func (sc *SAMLController) SSO(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+
+	encoded, relayState := c.Query("SAMLRequest"), c.Query("RelayState")
+	if c.Method() == fiber.MethodPost {
+		encoded, relayState = c.FormValue("SAMLRequest"), c.FormValue("RelayState")
+	}
+	if encoded == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "missing SAMLRequest"})
+	}
+
+	req, err := saml.ParseAuthnRequest(encoded)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	sp, err := sc.samlSvc.ValidateRequest(ctx, req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	session, user, err := sc.ssoSvc.GetSession(ctx, c.Cookies(ssoCookieName))
+	if err != nil {
+		// The request resumes as a GET after login; ParseAuthnRequest accepts both encodings
+		q := url.Values{"SAMLRequest": {encoded}}
+		if relayState != "" {
+			q.Set("RelayState", relayState)
+		}
+		returnTo := c.Path() + "?" + q.Encode()
+		return c.Redirect(ssoPath(c, "/login")+"?return_to="+url.QueryEscape(returnTo), fiber.StatusFound)
+	}
+
+	res, err := sc.samlSvc.IssueResponse(c.UserContext(), sp, user, session, samlEntityID(c), req.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return sc.pages.RenderSAMLPost(c, user.Email, sp.Name, sp.ACSURL, res, relayState)
+}
+
+// CreateServiceProvider godoc
+// @Summary      Register a SAML service provider
+// @Description  Registers a SAML 2.0 SP by entity ID and ACS URL (https, or loopback http). name_id_format is email (default) or persistent (user ID); the user's roles are sent in role_attribute (default: roles). Requires the clients:manage permission.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Param        payload body dto.CreateSAMLServiceProviderRequest true "Service provider"
+// @Success      201  {object}  dto.SAMLServiceProviderResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /admin/saml/service-providers [post]
+func (sc *SAMLController) CreateServiceProvider(c *fiber.Ctx) error {
+	var req dto.CreateSAMLServiceProviderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request payload"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	res, err := sc.samlSvc.CreateServiceProvider(c.UserContext(), &req)
+	if err != nil {
+		switch err.Error() {
+		case "unknown tenant", "invalid acs_url":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		case "service provider already exists":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+// ListServiceProviders godoc
+// @Summary      List SAML service providers
+// @Description  Returns the registered SAML service providers. Requires the clients:manage permission.
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200  {array}   dto.SAMLServiceProviderResponse
+// @Failure      401  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /admin/saml/service-providers [get]
+func (sc *SAMLController) ListServiceProviders(c *fiber.Ctx) error {
+	res, err := sc.samlSvc.ListServiceProviders(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
@@ -47,7 +47,7 @@ func (vc *VerificationController) VerifyEmail(c *fiber.Ctx) error {
 	}
 
 	// 3. Get user by email first
-	user, err := vc.authSvc.GetUserByEmail(req.Email)
+	user, err := vc.authSvc.GetUserByEmail(c.UserContext(), req.Email)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user not found"})
 	}
@@ -58,7 +58,7 @@ func (vc *VerificationController) VerifyEmail(c *fiber.Ctx) error {
 	}
 
 	// 5. Mark user as verified
-	if err := vc.authSvc.MarkEmailVerified(user.ID.String()); err != nil {
+	if err := vc.authSvc.MarkEmailVerified(c.UserContext(), user.ID.String()); err != nil {
 		log.Printf("Failed to mark email verified for %s: %v", req.Email, err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update user verification status"})
 	}
@@ -68,6 +68,35 @@ func (vc *VerificationController) VerifyEmail(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "email verified"})
 }
 
+// VerifyEmailLink godoc
+// @Summary      Verify email with a verification link
+// @Description  Target of the link in the verification email (sent next to the code when ISSUER_BASE_URL is configured). Activates the account like /auth/verify. The link is valid for ACTION_LINK_TTL (default 30 minutes) and can be used once.
+// @Tags         verification
+// @Produce      json
+// @Param        token query string true "Link token"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/verify/link [get]
+func (vc *VerificationController) VerifyEmailLink(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token is required"})
+	}
+
+	email, err := vc.authSvc.VerifyEmailWithToken(c.UserContext(), token)
+	if err != nil {
+		if err.Error() == "invalid or expired link" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Printf("Failed to verify email by link: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update user verification status"})
+	}
+	log.Printf("Email verified by link for %s", email)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "email verified"})
+}
+
 // ResendVerificationCode godoc
 // @Summary      Resend verification code to email
 // @Description  Generates and sends a new verification code to the specified email if the user exists.
@@ -90,12 +119,12 @@ func (vc *VerificationController) ResendVerificationCode(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	user, err := vc.authSvc.GetUserByEmail(req.Email)
+	user, err := vc.authSvc.GetUserByEmail(c.UserContext(), req.Email)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "user not found"})
 	}
 
-	if err := vc.verificationSvc.SendVerificationCode(user.ID.String(), user.Email); err != nil {
+	if err := vc.verificationSvc.SendVerificationCode(user.ID.String(), user.Email, user.Tenant); err != nil {
 		log.Printf("Failed to initiate verification email for %s: %v", req.Email, err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to send verification code"})
 	}
@@ -103,3 +132,41 @@ func (vc *VerificationController) ResendVerificationCode(c *fiber.Ctx) error {
 	log.Printf("Verification code send initiated for %s", req.Email)
 	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "verification code sent"})
 }
+
+// GetVerificationStatus godoc
+// @Summary      Get email verification status
+// @Description  Lets client apps poll whether an account has completed email verification and whether a verification code is still pending. Rate-limited like every other endpoint.
+// @Tags         verification
+// @Produce      json
+// @Param        email query string true "Email address"
+// @Success      200  {object}  dto.VerificationStatusResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /auth/verification-status [get]
+func (vc *VerificationController) GetVerificationStatus(c *fiber.Ctx) error {
+	var req dto.VerificationStatusRequest
+	if err := c.QueryParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid query parameters"})
+	}
+
+	if err := util.ValidateStruct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	user, err := vc.authSvc.GetUserByEmail(c.UserContext(), req.Email)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	// A code is only relevant while the account is still unverified
+	pending := false
+	if !user.IsEmailVerified {
+		pending = vc.verificationSvc.HasPendingCode(user.ID.String())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(dto.VerificationStatusResponse{
+		Email:       user.Email,
+		Verified:    user.IsEmailVerified,
+		CodePending: pending,
+	})
+}
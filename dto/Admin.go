@@ -0,0 +1,124 @@
+package dto
+
+import "time"
+
+// UserExportRecord is one line of the NDJSON user export
+type UserExportRecord struct {
+	ID              string                 `json:"id"`
+	Name            string                 `json:"name"`
+	Email           string                 `json:"email"`
+	IsEmailVerified bool                   `json:"is_email_verified"`
+	IsMFAEnabled    bool                   `json:"is_mfa_enabled"`
+	Roles           []string               `json:"roles"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
+	CreatedAt       time.Time              `json:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+}
+
+// ListUsersRequest are the query parameters of GET /admin/users
+type ListUsersRequest struct {
+	Page        int    `query:"page" validate:"omitempty,min=1"`
+	Size        int    `query:"size" validate:"omitempty,min=1,max=100"`
+	Sort        string `query:"sort" validate:"omitempty,oneof=created_at email name"`
+	Order       string `query:"order" validate:"omitempty,oneof=asc desc"`
+	Email       string `query:"email" validate:"omitempty,max=255"` // Part of the email, case-insensitive
+	Verified    *bool  `query:"verified"`
+	Role        string `query:"role" validate:"omitempty,max=50"`
+	CreatedFrom string `query:"created_from"` // YYYY-MM-DD, inclusive
+	CreatedTo   string `query:"created_to"`   // YYYY-MM-DD, inclusive
+}
+
+// SearchUsersRequest are the query parameters of GET /admin/users/search
+type SearchUsersRequest struct {
+	Q    string `query:"q" validate:"required,min=2,max=100"` // Words that must all appear in the name or email
+	Page int    `query:"page" validate:"omitempty,min=1"`
+	Size int    `query:"size" validate:"omitempty,min=1,max=100"`
+}
+
+// UserListResponse is one page of users with the total number of matching users
+type UserListResponse struct {
+	Users      []UserExportRecord `json:"users"`
+	Page       int                `json:"page"`
+	Size       int                `json:"size"`
+	Total      int64              `json:"total"`
+	TotalPages int64              `json:"total_pages"`
+}
+
+// RegistrationSettings is the runtime registration policy
+// Modes: "open" (anyone), "restricted" (invite token or allowed email domain), "closed" (nobody)
+type RegistrationSettings struct {
+	Mode           string   `json:"mode" validate:"required,oneof=open restricted closed"`
+	AllowedDomains []string `json:"allowed_domains" validate:"dive,fqdn"`
+}
+
+// ProvisioningSettings is the just-in-time provisioning policy for the first login of a federated identity
+// (social, upstream OIDC or LDAP). The registration policy applies as well.
+// AttributeMapping maps a provider attribute (OIDC claim, LDAP attribute, ...) to "name" or "metadata.<key>".
+type ProvisioningSettings struct {
+	AutoCreate       bool              `json:"auto_create"` // false: only existing accounts can sign in
+	DefaultRoles     []string          `json:"default_roles" validate:"required,min=1,dive,required"`
+	AllowedDomains   []string          `json:"allowed_domains" validate:"dive,fqdn"` // empty: any email domain
+	AttributeMapping map[string]string `json:"attribute_mapping"`
+}
+
+// CreateInviteRequest creates a single-use registration invite
+type CreateInviteRequest struct {
+	Email     string `json:"email" validate:"omitempty,email"` // Optional: bind the invite to this email
+	ExpiresIn string `json:"expires_in"`                       // Go duration, default 168h
+}
+
+// InviteResponse returns the invite token (shown once)
+type InviteResponse struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	Email     string    `json:"email,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DailyActiveUsers is one day of the active user report
+type DailyActiveUsers struct {
+	Date string `json:"date"` // YYYY-MM-DD (UTC)
+	DAU  int64  `json:"dau"`
+	MAU  int64  `json:"mau"` // Distinct users in the 30 days ending on Date
+}
+
+// MonthlyActiveUsers is one calendar month of the active user report
+type MonthlyActiveUsers struct {
+	Month       string `json:"month"` // YYYY-MM
+	ActiveUsers int64  `json:"active_users"`
+}
+
+// KeyRotationResponse describes a signing key rotation
+type KeyRotationResponse struct {
+	Kid               string     `json:"kid"`                           // New active key
+	Tenant            string     `json:"tenant,omitempty"`              // Organization the key signs for, unset for the global keys
+	PreviousKid       string     `json:"previous_kid,omitempty"`        // Key rotated out, unset for the first key of a tenant
+	PreviousRetiresAt *time.Time `json:"previous_retires_at,omitempty"` // End of the overlap window
+}
+
+// AddSigningKeyRequest imports an externally generated signing key
+type AddSigningKeyRequest struct {
+	PrivateKey string `json:"private_key" validate:"required"` // PEM (PKCS1, SEC1 or PKCS8), matching JWT_SIGNING_ALG
+	Passphrase string `json:"passphrase"`                      // For an encrypted PKCS8 key ("ENCRYPTED PRIVATE KEY")
+}
+
+// SigningKeyInfo describes one JWT signing key (admin key listing)
+type SigningKeyInfo struct {
+	Kid       string     `json:"kid"`
+	Tenant    string     `json:"tenant,omitempty"`
+	Algorithm string     `json:"alg"`
+	Status    string     `json:"status"`               // active (signs), verifying (overlap window) or retired
+	CreatedAt *time.Time `json:"created_at,omitempty"` // Unset for the env key
+	RetiresAt *time.Time `json:"retires_at,omitempty"`
+}
+
+// PasswordHashScanReport is the state of the password hash scan (admin)
+type PasswordHashScanReport struct {
+	Running    bool             `json:"running"`
+	StartedAt  *time.Time       `json:"started_at,omitempty"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+	Scanned    int64            `json:"scanned"`
+	Outdated   int64            `json:"outdated"`  // Credentials flagged for rehash (legacy scheme or weaker Argon2 parameters)
+	ByScheme   map[string]int64 `json:"by_scheme"` // Hashes per scheme (argon2id, bcrypt, unknown)
+	Error      string           `json:"error,omitempty"`
+}
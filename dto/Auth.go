@@ -1,9 +1,12 @@
 package dto
 
+import "time"
+
 type RegisterRequest struct {
-	Name     string `json:"name" validate:"required,min=2,max=50"`
-	Email    string `json:"email" validate:"required,email,max=255"`
-	Password string `json:"password" validate:"required,min=8,max=72"` // Max 72 is a common bcrypt limit
+	Name        string `json:"name" validate:"required,min=2,max=50"`
+	Email       string `json:"email" validate:"required,email,max=255"`
+	Password    string `json:"password" validate:"required,max=72"` // Max 72 is a common bcrypt limit
+	InviteToken string `json:"invite_token,omitempty"`              // Required while registration is restricted
 }
 
 type RegisterResponse struct {
@@ -13,26 +16,49 @@ type RegisterResponse struct {
 }
 
 // LoginRequest/Response for authentication
+// The account is identified by Email or Username
 type LoginRequest struct {
-	Email    string `json:"email" validate:"required,email"`
+	Email    string `json:"email" validate:"required_without=Username,omitempty,email"`
+	Username string `json:"username" validate:"required_without=Email,omitempty,max=32"`
 	Password string `json:"password" validate:"required"`
+	// Stay signed in: long-lived refresh token (JWT_REMEMBER_ME_TTL) and a persistent cookie instead of a session cookie
+	RememberMe bool `json:"remember_me,omitempty"`
 }
 
 type LoginResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int    `json:"expires_in"` // seconds
+	// Set instead of tokens when the user has MFA enabled: the challenge is redeemed at /auth/mfa/verify
+	MFAChallenge string   `json:"mfa_challenge,omitempty"`
+	MFAMethods   []string `json:"mfa_methods,omitempty"` // "totp", "sms", "email", "recovery_code"
+	// Set when roles were withheld from the tokens (MFA_REQUIRED_ROLES) until the user enrolls a factor
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+	// Set instead of tokens when the password is older than PASSWORD_MAX_AGE or temporary: redeemed at /auth/password-expired
+	PasswordChangeToken string `json:"password_change_token,omitempty"`
+	MustChangePassword  bool   `json:"must_change_password,omitempty"` // The password was set by an admin
+	// Set after an MFA login with remember_device: sent as the mfa_device cookie, not in the body
+	DeviceToken     string    `json:"-"`
+	DeviceExpiresAt time.Time `json:"-"`
+	// Expiry of the refresh token, used for the refresh_token cookie (persistent only with remember me)
+	RefreshExpiresAt time.Time `json:"-"`
+	RememberMe       bool      `json:"-"`
 }
 
 // RefreshRequest/Response for token rotation
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
+	Scope        string `json:"scope,omitempty"` // Subset of the granted scopes for the new access token (OAuth only)
 }
 
 type RefreshResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+	// Expiry of the refresh token (sliding, capped by JWT_REFRESH_ABSOLUTE_TTL), used for the refresh_token cookie
+	RefreshExpiresAt time.Time `json:"-"`
+	RememberMe       bool      `json:"-"`
 }
 
 // PasswordChangeSendOTPRequest for initiating password change with OTP
@@ -48,8 +74,8 @@ type PasswordChangeSendOTPResponse struct {
 
 // PasswordChangeRequest for completing password change with OTP verification
 type PasswordChangeRequest struct {
-	OldPassword string `json:"old_password" validate:"required,min=8,max=72"`
-	NewPassword string `json:"new_password" validate:"required,min=8,max=72"`
+	OldPassword string `json:"old_password" validate:"required,max=72"`
+	NewPassword string `json:"new_password" validate:"required,max=72"`
 	OTPCode     string `json:"otp_code" validate:"required,len=6"`
 }
 
@@ -63,10 +89,24 @@ type ForgotPasswordRequest struct {
 	Email string `json:"email" validate:"required,email"`
 }
 
+// ResetPasswordRequest completes password reset with OTP validation and a user-chosen password
+// Email identifies the account for codes sent by email, Phone for codes sent by SMS
 type ResetPasswordRequest struct {
-	Email       string `json:"email" validate:"required,email"`
+	Email       string `json:"email" validate:"required_without=Phone,omitempty,email"`
+	Phone       string `json:"phone" validate:"required_without=Email,omitempty,e164"`
 	Code        string `json:"code" validate:"required,len=6"` // The OTP
-	NewPassword string `json:"new_password" validate:"required,min=8"`
+	NewPassword string `json:"new_password" validate:"required,max=72"`
+}
+
+// ExpiredPasswordChangeRequest sets a new password after a login returned password_expired
+type ExpiredPasswordChangeRequest struct {
+	PasswordChangeToken string `json:"password_change_token" validate:"required"`
+	NewPassword         string `json:"new_password" validate:"required,max=72"`
+}
+
+// MagicLinkRequest asks for a passwordless login link by email
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
 }
 
 // ForgotPasswordSendOTPRequest initiates password reset with OTP
@@ -79,10 +119,10 @@ type ForgotPasswordSendOTPResponse struct {
 	Message string `json:"message"`
 }
 
-// ResetPasswordWithOTPRequest completes password reset with OTP validation
-type ResetPasswordWithOTPRequest struct {
-	Email string `json:"email" validate:"required,email"`
-	OTP   string `json:"otp" validate:"required,len=6"`
+// ResetPasswordWithLinkRequest sets a new password with the token of a password reset link
+type ResetPasswordWithLinkRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,max=72"`
 }
 
 // ResetPasswordWithOTPResponse confirms password was reset
@@ -107,3 +147,189 @@ type MFASetupVerifyRequest struct {
 	Secret string `json:"secret" validate:"required"`
 	Token  string `json:"token" validate:"required,len=6"`
 }
+
+// MFAConfirmResponse returns the recovery codes once when MFA is enabled or the codes are regenerated
+type MFAConfirmResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAResetRequest starts the self-service MFA reset of a user who lost their second factor
+type MFAResetRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// MFAResetConfirmRequest proves ownership of the email address with the code sent by /auth/mfa/reset
+type MFAResetConfirmRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Code  string `json:"code" validate:"required,len=6"`
+}
+
+// MFAResetResponse tells when a confirmed MFA reset takes effect
+type MFAResetResponse struct {
+	Message     string    `json:"message"`
+	EffectiveAt time.Time `json:"effective_at"`
+}
+
+// AdminMFAResetRequest is the reason recorded when an admin resets a user's MFA
+type AdminMFAResetRequest struct {
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+// UsernameRequest sets or changes the username of the authenticated user
+type UsernameRequest struct {
+	Username string `json:"username" validate:"required,username"`
+}
+
+// UsernameAvailabilityResponse tells whether a username can be taken
+type UsernameAvailabilityResponse struct {
+	Username  string `json:"username"` // Normalized
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"` // "invalid" or "taken"
+}
+
+// StepUpRequest re-verifies the user behind an access token (/auth/step-up)
+// Password, Code (authenticator app, SMS or email code from /auth/step-up/code, or recovery code) or both;
+// users with MFA must send the code
+type StepUpRequest struct {
+	Password string `json:"password" validate:"required_without=Code,max=72"`
+	Code     string `json:"code" validate:"required_without=Password,max=32"`
+}
+
+// StepUpCodeRequest asks for an SMS or email code to step up with (/auth/step-up/code)
+type StepUpCodeRequest struct {
+	Method string `json:"method" validate:"required,oneof=sms email"`
+}
+
+// AdminPasswordResetRequest is the password an admin sets for a user, to be changed at the next login
+type AdminPasswordResetRequest struct {
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+// MFAChallengeRequest asks for an SMS or email code for a login that returned an MFA challenge
+type MFAChallengeRequest struct {
+	MFAChallenge string `json:"mfa_challenge" validate:"required"`
+}
+
+// MFAVerifyRequest completes a login that returned an MFA challenge
+// Code is the 6-digit authenticator, SMS or email code, or a recovery code
+type MFAVerifyRequest struct {
+	MFAChallenge   string `json:"mfa_challenge" validate:"required"`
+	Code           string `json:"code" validate:"required,max=32"`
+	RememberDevice bool   `json:"remember_device,omitempty"` // Skip MFA on this device for MFA_REMEMBER_DEVICE_TTL
+}
+
+// RememberedDeviceResponse is a device that skips MFA at login
+type RememberedDeviceResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	ClientIP   string    `json:"client_ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // The device this request came from
+}
+
+// SessionResponse is a signed-in session of the user (an active refresh token)
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"` // Label parsed from the User-Agent, e.g. "Chrome on Windows"
+	UserAgent  string    `json:"user_agent"`
+	ClientIP   string    `json:"client_ip"`
+	Scope      string    `json:"scope,omitempty"` // Granted OAuth scopes, empty for first-party logins
+	CreatedAt  time.Time `json:"created_at"`      // When the user logged in
+	LastUsedAt time.Time `json:"last_used_at"`    // Last refresh of the session
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // The session of the refresh_token cookie sent with this request
+}
+
+// CredentialInventoryResponse lists how the user can sign in, without secret values (/auth/me/credentials)
+type CredentialInventoryResponse struct {
+	Password          PasswordCredentialInfo `json:"password"`
+	MFA               MFACredentialInfo      `json:"mfa"`
+	Identities        []IdentityInfo         `json:"identities"` // Social, LDAP and federated logins
+	Username          string                 `json:"username,omitempty"`
+	Phone             string                 `json:"phone,omitempty"` // Verified number (passwordless login, SMS recovery)
+	RememberedDevices int                    `json:"remembered_devices"`
+}
+
+// PasswordCredentialInfo describes the password of a user
+type PasswordCredentialInfo struct {
+	Set                bool       `json:"set"`
+	ChangedAt          *time.Time `json:"changed_at,omitempty"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"` // Set when PASSWORD_MAX_AGE is configured
+	MustChangePassword bool       `json:"must_change_password,omitempty"`
+}
+
+// MFACredentialInfo lists the enrolled second factors
+type MFACredentialInfo struct {
+	TOTP                   bool       `json:"totp"`
+	SMS                    bool       `json:"sms"`
+	Email                  bool       `json:"email"`
+	RecoveryCodesRemaining int64      `json:"recovery_codes_remaining"`
+	ResetPendingAt         *time.Time `json:"reset_pending_at,omitempty"` // Self-service MFA reset takes effect at this time
+}
+
+// IdentityInfo is a linked login method of a user
+type IdentityInfo struct {
+	Type     string    `json:"type"` // google, github, ldap, oidc:<provider>, ...
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// PhoneRequest starts phone verification or passwordless phone login
+type PhoneRequest struct {
+	Phone string `json:"phone" validate:"required,e164"`
+}
+
+// PhoneVerifyRequest completes phone verification or passwordless phone login with the SMS OTP
+type PhoneVerifyRequest struct {
+	Phone string `json:"phone" validate:"required,e164"`
+	Code  string `json:"code" validate:"required,len=6"`
+}
+
+// GuestResponse is returned once when a guest account is created
+// DeviceSecret must be stored on the device: it is the only way back into the account
+type GuestResponse struct {
+	UserID       string `json:"user_id"`
+	DeviceSecret string `json:"device_secret"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// GuestLoginRequest logs back into a guest account from its device
+type GuestLoginRequest struct {
+	DeviceSecret string `json:"device_secret" validate:"required"`
+}
+
+// ClaimAccountRequest upgrades the authenticated guest account to a regular one (same user ID)
+type ClaimAccountRequest struct {
+	Name        string `json:"name" validate:"required,min=2,max=50"`
+	Email       string `json:"email" validate:"required,email,max=255"`
+	Password    string `json:"password" validate:"required,max=72"`
+	InviteToken string `json:"invite_token,omitempty"` // Required while registration is restricted
+}
+
+// CreateAPIKeyRequest issues an API key for the X-API-Key header
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name" validate:"required,max=100"`
+	Scopes    []string `json:"scopes,omitempty" validate:"max=20,dive,max=64"` // Empty for full access
+	ExpiresIn int64    `json:"expires_in,omitempty" validate:"min=0"`          // Seconds, 0 never expires
+}
+
+// APIKeyResponse describes an API key without its secret
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateAPIKeyResponse contains the plaintext key, shown only once
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
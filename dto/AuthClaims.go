@@ -1,13 +1,117 @@
 package dto
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Actor is the RFC 8693 act claim; nested for chained delegation
+type Actor struct {
+	Sub string `json:"sub"`
+	Act *Actor `json:"act,omitempty"`
+}
+
+// Confirmation is the RFC 7800 cnf claim; jkt binds a token to a DPoP key (RFC 9449)
+type Confirmation struct {
+	JKT string `json:"jkt"`
+}
+
 // AuthClaims will be encoded inside the token
 type AuthClaims struct {
 	//UserID string   `json:"user_id"`
 	Roles []string `json:"roles"`
+	// Permissions granted by the roles (role_permissions), for fine-grained checks by resource servers
+	Permissions []string `json:"permissions,omitempty"`
+	// Organization the token was issued for (multi-tenancy only, matches the /t/{org} issuer)
+	Tenant string `json:"tenant,omitempty"`
+	// Space-separated scopes of a token issued via OAuth or token exchange (RFC 8693), empty for full access
+	Scope string `json:"scope,omitempty"`
+	// Client acting on behalf of the subject (RFC 8693 delegation)
+	Act *Actor `json:"act,omitempty"`
+	// OAuth client the token was issued to (RFC 9068), its aud is the client ID; empty for first-party tokens
+	ClientID string `json:"client_id,omitempty"`
+	// When and how the user authenticated (RFC 9470 step-up): refreshed tokens keep the values of the login
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"` // RFC 8176 methods: pwd, otp, sms, fed, mfa, ...
+	ACR      string           `json:"acr,omitempty"` // "1" single factor, "2" multi-factor
+	// Key the access token is bound to when it was issued with a DPoP proof
+	Cnf *Confirmation `json:"cnf,omitempty"`
+	// The user's token version at issuance; logout-all bumps it, rejecting every older access token
+	TokenVersion int `json:"token_version,omitempty"`
+	// Custom claims added by claims mappers (util.RegisterClaimsMapper), written at the top level of the token.
+	// Only set when issuing: parsed tokens leave it empty.
+	Extra map[string]interface{} `json:"-"`
 	// Standard claims (exp, iss, iat) are embedded here
 	jwt.RegisteredClaims
 }
+
+// MarshalJSON flattens Extra into the token; the standard claims win on a name collision
+func (c AuthClaims) MarshalJSON() ([]byte, error) {
+	type plain AuthClaims
+	data, err := json.Marshal(plain(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+
+	var standard map[string]interface{}
+	if err := json.Unmarshal(data, &standard); err != nil {
+		return nil, err
+	}
+	merged := make(map[string]interface{}, len(standard)+len(c.Extra))
+	for name, value := range c.Extra {
+		merged[name] = value
+	}
+	for name, value := range standard {
+		merged[name] = value
+	}
+	return json.Marshal(merged)
+}
+
+// IsRefreshToken reports whether the claims are those of a refresh token
+// Access tokens always name an audience and carry a random jti; refresh tokens have no audience and their jti is the database row ID.
+func (c *AuthClaims) IsRefreshToken() bool {
+	return len(c.Audience) == 0
+}
+
+// IsThirdParty reports whether the claims are those of a token issued to an OAuth client or exchanged for one:
+// such tokens carry the client's grant (scope), not the user's full access to this server's own API
+func (c *AuthClaims) IsThirdParty() bool {
+	return c.ClientID != "" || c.Scope != "" || c.Act != nil
+}
+
+// Authentication methods (amr values, RFC 8176 where it defines one)
+const (
+	AMRPassword  = "pwd" // Password
+	AMROTP       = "otp" // Authenticator app, recovery code, or a one-time code or link sent by email
+	AMRSMS       = "sms" // Code sent by SMS
+	AMRFederated = "fed" // Social or federated login (the upstream provider checked the user)
+	AMRDevice    = "swk" // Device secret of a guest account
+	AMRMFA       = "mfa" // Added when more than one factor was used
+)
+
+// Authentication is when and how the user proved their identity; it goes into the access tokens (auth_time, amr, acr)
+type Authentication struct {
+	Time       time.Time
+	Methods    []string
+	RememberMe bool // The user asked to stay signed in (long-lived refresh token, persistent cookie)
+}
+
+// NewAuthentication records an authentication that happened now with the given methods
+func NewAuthentication(methods ...string) Authentication {
+	if len(methods) > 1 {
+		methods = append(methods, AMRMFA)
+	}
+	return Authentication{Time: time.Now(), Methods: methods}
+}
+
+// ACR is the authentication context class: "2" when a second factor was used, "1" otherwise
+func (a Authentication) ACR() string {
+	for _, m := range a.Methods {
+		if m == AMRMFA {
+			return "2"
+		}
+	}
+	return "1"
+}
@@ -0,0 +1,53 @@
+package dto
+
+import "time"
+
+// UserRegisteredEvent is the outbox payload for model.EventUserRegistered
+type UserRegisteredEvent struct {
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Provider string `json:"provider,omitempty"` // Social sign-up (google, github); the email is already verified
+	Tenant   string `json:"tenant,omitempty"`   // Organization of the user (multi-tenancy)
+}
+
+// UserEvent is the outbox payload for identity events that only reference the user
+type UserEvent struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// AccountLockedEvent is the outbox payload for model.EventUserLocked
+type AccountLockedEvent struct {
+	UserID         string    `json:"user_id"`
+	Email          string    `json:"email"`
+	FailedAttempts int       `json:"failed_attempts"`
+	LockedUntil    time.Time `json:"locked_until"`
+}
+
+// SessionsRevokedEvent is the outbox payload for model.EventUserSessionsRevoked (logout-all)
+type SessionsRevokedEvent struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	Actor  string `json:"actor"` // "self" or the ID of the admin who ended the sessions
+}
+
+// TokenReuseEvent is the outbox payload for model.EventUserTokenReuse: a rotated refresh token was presented again,
+// and for model.EventUserSessionBindingMismatch: a refresh token was presented by another client
+type TokenReuseEvent struct {
+	UserID        string `json:"user_id"`
+	Email         string `json:"email"`
+	FamilyID      string `json:"family_id"`      // The login whose tokens were revoked
+	ClientIP      string `json:"client_ip"`      // Of the request that replayed the token
+	UserAgent     string `json:"user_agent"`     // Of the request that replayed the token
+	RevokedTokens int    `json:"revoked_tokens"` // Tokens of the family that were still active
+}
+
+// MFAResetEvent is the outbox payload for model.EventUserMFAResetRequested and model.EventUserMFAReset
+type MFAResetEvent struct {
+	UserID      string     `json:"user_id"`
+	Email       string     `json:"email"`
+	Actor       string     `json:"actor"` // "self" or the ID of the admin who reset the factors
+	Reason      string     `json:"reason,omitempty"`
+	EffectiveAt *time.Time `json:"effective_at,omitempty"` // When a requested reset takes effect
+}
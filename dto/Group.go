@@ -0,0 +1,43 @@
+package dto
+
+import "time"
+
+// CreateGroupRequest defines a new group (admin)
+type CreateGroupRequest struct {
+	Code        string `json:"code" validate:"required,min=2,max=50"` // Lowercase letters, digits, _ and -; starts with a letter
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=255"`
+}
+
+// UpdateGroupRequest changes the name and description of a group (the code is fixed)
+type UpdateGroupRequest struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=255"`
+}
+
+// GroupResponse describes a group (admin group management)
+type GroupResponse struct {
+	ID          string    `json:"id"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Roles       []string  `json:"roles"` // Granted to every member
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SetGroupRolesRequest replaces the roles a group grants its members
+type SetGroupRolesRequest struct {
+	Roles []string `json:"roles" validate:"max=50,dive,required,max=50"` // Role codes, empty removes all
+}
+
+// AddGroupMembersRequest adds users to a group
+type AddGroupMembersRequest struct {
+	UserIDs []string `json:"user_ids" validate:"required,min=1,max=100,dive,required"`
+}
+
+// ListGroupMembersRequest are the query parameters of GET /admin/groups/{code}/members
+type ListGroupMembersRequest struct {
+	Page int `query:"page" validate:"omitempty,min=1"`
+	Size int `query:"size" validate:"omitempty,min=1,max=100"`
+}
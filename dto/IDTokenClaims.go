@@ -0,0 +1,21 @@
+package dto
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IDTokenClaims is the OIDC ID token returned by /oauth/token when the openid scope was granted
+type IDTokenClaims struct {
+	AuthTime *jwt.NumericDate `json:"auth_time"`
+	Nonce    string           `json:"nonce,omitempty"`
+	AtHash   string           `json:"at_hash,omitempty"` // Left half of the access token's SHA-256 (SHA-512 for EdDSA), base64url
+	Azp      string           `json:"azp,omitempty"`
+
+	// Only with the email / profile scopes
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+
+	Tenant string `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
@@ -0,0 +1,59 @@
+package dto
+
+// LinkIdentityRequest connects a social identity to the authenticated account
+// Token is a Google or Apple ID token, or a GitHub or Facebook access token obtained by the client
+type LinkIdentityRequest struct {
+	Type  string `json:"type" validate:"required,oneof=google github apple facebook"`
+	Token string `json:"token" validate:"required"`
+}
+
+// LinkIdentityResponse describes the linked identity
+type LinkIdentityResponse struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Email   string `json:"email,omitempty"` // Email reported by the provider
+}
+
+// IdentityLoginRequest logs in with a previously linked social identity
+type IdentityLoginRequest struct {
+	Type  string `json:"type" validate:"required,oneof=google github apple facebook"`
+	Token string `json:"token" validate:"required"`
+}
+
+// GoogleLoginRequest signs in with Google: either a Google ID token obtained by the client,
+// or an authorization code that the server exchanges (needs GOOGLE_CLIENT_SECRET)
+type GoogleLoginRequest struct {
+	IDToken      string `json:"id_token" validate:"required_without=Code"`
+	Code         string `json:"code" validate:"required_without=IDToken"`
+	RedirectURI  string `json:"redirect_uri" validate:"required_with=Code"`
+	CodeVerifier string `json:"code_verifier"` // PKCE verifier of the code flow, if used
+}
+
+// AppleLoginRequest signs in with Apple: either an Apple ID token obtained by the client, or an
+// authorization code that the server exchanges (needs the Sign in with Apple key)
+// Apple shares the user's name only with the client and only on the first authorization, so the client passes it on.
+type AppleLoginRequest struct {
+	IDToken     string `json:"id_token" validate:"required_without=Code"`
+	Code        string `json:"code" validate:"required_without=IDToken"`
+	RedirectURI string `json:"redirect_uri"` // Web login redirect URI; empty for codes of native apps
+	Name        string `json:"name" validate:"omitempty,max=50"`
+}
+
+// FacebookLoginRequest signs in with a Facebook user access token obtained by the client (Facebook Login SDK)
+// The token must include the email permission.
+type FacebookLoginRequest struct {
+	AccessToken string `json:"access_token" validate:"required"`
+}
+
+// IdentityEvent is the outbox payload of identity link/unlink events
+type IdentityEvent struct {
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	Provider string `json:"provider"`
+}
+
+// FederationProviderResponse is an upstream OIDC provider users can sign in with
+type FederationProviderResponse struct {
+	Name   string `json:"name"`
+	Issuer string `json:"issuer"`
+}
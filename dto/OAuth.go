@@ -0,0 +1,146 @@
+package dto
+
+import "time"
+
+// AuthorizeRequest holds the /oauth/authorize parameters (query on GET, form on the consent POST)
+type AuthorizeRequest struct {
+	ResponseType        string `query:"response_type" form:"response_type"`
+	ClientID            string `query:"client_id" form:"client_id"`
+	RedirectURI         string `query:"redirect_uri" form:"redirect_uri"`
+	Scope               string `query:"scope" form:"scope"`
+	State               string `query:"state" form:"state"`
+	Nonce               string `query:"nonce" form:"nonce"`
+	CodeChallenge       string `query:"code_challenge" form:"code_challenge"`
+	CodeChallengeMethod string `query:"code_challenge_method" form:"code_challenge_method"`
+	Prompt              string `query:"prompt" form:"prompt"`
+}
+
+// TokenRequest holds the /oauth/token form parameters
+// Client credentials may also come from HTTP Basic auth (client_secret_basic)
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+
+	// Token exchange (RFC 8693)
+	SubjectToken       string `form:"subject_token"`
+	SubjectTokenType   string `form:"subject_token_type"`
+	RequestedTokenType string `form:"requested_token_type"`
+	Audience           string `form:"audience"`
+	Scope              string `form:"scope"`
+}
+
+// OAuthTokenResponse is the RFC 6749 token response
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"` // openid scope only
+
+	IssuedTokenType string `json:"issued_token_type,omitempty"` // Token exchange only
+}
+
+// IntrospectionRequest holds the /oauth/introspect form parameters (RFC 7662)
+type IntrospectionRequest struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"` // access_token or refresh_token
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+// RevocationRequest holds the /oauth/revoke form parameters (RFC 7009)
+type RevocationRequest struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+// IntrospectionResponse is the RFC 7662 introspection response; inactive tokens only carry active=false
+type IntrospectionResponse struct {
+	Active      bool          `json:"active"`
+	TokenType   string        `json:"token_type,omitempty"` // Bearer or DPoP (access token) or refresh_token
+	Sub         string        `json:"sub,omitempty"`
+	Exp         int64         `json:"exp,omitempty"`
+	Iat         int64         `json:"iat,omitempty"`
+	Iss         string        `json:"iss,omitempty"`
+	Aud         []string      `json:"aud,omitempty"`
+	Jti         string        `json:"jti,omitempty"`
+	ClientID    string        `json:"client_id,omitempty"` // OAuth client the token was issued to
+	Roles       []string      `json:"roles,omitempty"`
+	Permissions []string      `json:"permissions,omitempty"`
+	Tenant      string        `json:"tenant,omitempty"`
+	Scope       string        `json:"scope,omitempty"`
+	Act         *Actor        `json:"act,omitempty"`
+	Cnf         *Confirmation `json:"cnf,omitempty"` // DPoP key the token is bound to
+}
+
+// UserInfoResponse holds the standard OIDC claims of the token's user (/oauth/userinfo)
+type UserInfoResponse struct {
+	Sub                 string   `json:"sub"`
+	Email               string   `json:"email"`
+	EmailVerified       bool     `json:"email_verified"`
+	Name                string   `json:"name"`
+	PreferredUsername   string   `json:"preferred_username,omitempty"`
+	PhoneNumber         string   `json:"phone_number,omitempty"` // E.164, only verified numbers are stored
+	PhoneNumberVerified bool     `json:"phone_number_verified,omitempty"`
+	Roles               []string `json:"roles"`
+}
+
+// ConsentResponse is a client the user granted access to (/auth/me/consents)
+type ConsentResponse struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	GrantedAt  time.Time `json:"granted_at"`
+}
+
+// ClientRegistrationRequest is the client metadata of a dynamic registration (RFC 7591)
+type ClientRegistrationRequest struct {
+	ClientName              string   `json:"client_name" validate:"max=100"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`                // Default: authorization_code; also refresh_token, token exchange
+	ResponseTypes           []string `json:"response_types"`             // Default: code
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"` // Default: client_secret_basic; none registers a public client
+	Scope                   string   `json:"scope"`                      // Space-separated; empty allows all scopes
+}
+
+// ClientRegistrationResponse returns the registered client and its credentials (RFC 7591)
+type ClientRegistrationResponse struct {
+	ClientID                string   `json:"client_id"`
+	ClientSecret            string   `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64    `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   int64    `json:"client_secret_expires_at"` // 0: never expires
+	ClientName              string   `json:"client_name"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	Scope                   string   `json:"scope,omitempty"`
+}
+
+// CreateOAuthClientRequest registers a third-party application
+// Public clients (mobile apps, SPAs) get no secret and must use PKCE
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name" validate:"required,max=100"`
+	RedirectURIs []string `json:"redirect_uris" validate:"required,min=1,dive,required"`
+	Public       bool     `json:"public"`
+	Tenant       string   `json:"tenant"` // Optional organization slug (multi-tenancy)
+}
+
+// OAuthClientResponse describes a registered client; the secret is only returned on creation
+type OAuthClientResponse struct {
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Public       bool      `json:"public"`
+	Tenant       string    `json:"tenant,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
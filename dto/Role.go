@@ -0,0 +1,52 @@
+package dto
+
+import "time"
+
+// CreateRoleRequest defines a new role (admin); roles created through the API are never system roles
+type CreateRoleRequest struct {
+	Code        string `json:"code" validate:"required,min=2,max=50"` // Lowercase letters, digits, _ and -; starts with a letter
+	Name        string `json:"name" validate:"required,max=50"`
+	Description string `json:"description" validate:"max=255"`
+}
+
+// UpdateRoleRequest changes the name and description of a role (the code is fixed)
+type UpdateRoleRequest struct {
+	Name        string `json:"name" validate:"required,max=50"`
+	Description string `json:"description" validate:"max=255"`
+}
+
+// RoleResponse describes a role (admin role management)
+type RoleResponse struct {
+	ID          string    `json:"id"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	IsSystem    bool      `json:"is_system"`   // Seeded role, can't be deleted
+	Permissions []string  `json:"permissions"` // Granted directly, without the inherited ones
+	Inherits    []string  `json:"inherits"`    // Roles this role includes
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SetRolePermissionsRequest replaces the permissions of a role
+type SetRolePermissionsRequest struct {
+	Permissions []string `json:"permissions" validate:"max=200,dive,required,max=100"` // Permission codes, empty removes all
+}
+
+// SetInheritedRolesRequest replaces the roles a role inherits
+type SetInheritedRolesRequest struct {
+	Roles []string `json:"roles" validate:"max=50,dive,required,max=50"` // Role codes, empty removes all
+}
+
+// CreatePermissionRequest defines a new permission (admin)
+type CreatePermissionRequest struct {
+	Code        string `json:"code" validate:"required,max=100"` // e.g. "invoices:read": lowercase letters, digits, _ . - and : separators
+	Description string `json:"description" validate:"max=255"`
+}
+
+// PermissionResponse describes a permission
+type PermissionResponse struct {
+	Code        string    `json:"code"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,25 @@
+package dto
+
+import "time"
+
+// CreateSAMLServiceProviderRequest registers a SAML 2.0 service provider
+type CreateSAMLServiceProviderRequest struct {
+	EntityID      string `json:"entity_id" validate:"required,max=255"`
+	Name          string `json:"name" validate:"required,max=100"`
+	ACSURL        string `json:"acs_url" validate:"required,url"`
+	NameIDFormat  string `json:"name_id_format" validate:"omitempty,oneof=email persistent"` // Default: email
+	RoleAttribute string `json:"role_attribute" validate:"max=255"`                          // Default: roles
+	Tenant        string `json:"tenant"`                                                     // Optional organization slug (multi-tenancy)
+}
+
+// SAMLServiceProviderResponse describes a registered service provider
+type SAMLServiceProviderResponse struct {
+	ID            string    `json:"id"`
+	EntityID      string    `json:"entity_id"`
+	Name          string    `json:"name"`
+	ACSURL        string    `json:"acs_url"`
+	NameIDFormat  string    `json:"name_id_format"`
+	RoleAttribute string    `json:"role_attribute"`
+	Tenant        string    `json:"tenant,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -10,3 +10,15 @@ type VerifyEmailRequest struct {
 type ResendOTPRequest struct {
 	Email string `json:"email" validate:"required,email"`
 }
+
+// VerificationStatusRequest is read from the query string of /auth/verification-status
+type VerificationStatusRequest struct {
+	Email string `query:"email" validate:"required,email"`
+}
+
+// VerificationStatusResponse tells a client whether the account is verified and a code is still pending
+type VerificationStatusResponse struct {
+	Email       string `json:"email"`
+	Verified    bool   `json:"verified"`
+	CodePending bool   `json:"code_pending"`
+}
@@ -0,0 +1,177 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// BER tags used by the LDAP messages of this client (RFC 4511, section 4)
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+
+	tagBindRequest       = 0x60 // [APPLICATION 0], constructed
+	tagBindResponse      = 0x61 // [APPLICATION 1]
+	tagUnbindRequest     = 0x42 // [APPLICATION 2], primitive
+	tagSearchRequest     = 0x63 // [APPLICATION 3]
+	tagSearchResultEntry = 0x64 // [APPLICATION 4]
+	tagSearchResultDone  = 0x65 // [APPLICATION 5]
+	tagSearchResultRef   = 0x73 // [APPLICATION 19]
+	tagExtendedRequest   = 0x77 // [APPLICATION 23]
+	tagExtendedResponse  = 0x78 // [APPLICATION 24]
+	tagAuthSimple        = 0x80 // [0] in BindRequest
+	tagExtendedName      = 0x80 // [0] in ExtendedRequest
+	tagFilterAnd         = 0xa0 // [0] in Filter
+	tagFilterEquality    = 0xa3 // [3] in Filter
+	tagFilterPresent     = 0x87 // [7] in Filter, primitive
+	maxMessageSize       = 16 << 20
+	maxLengthOctets      = 4
+)
+
+// element is a decoded BER TLV; constructed elements keep their raw content for parsing on demand
+type element struct {
+	tag     byte
+	content []byte
+}
+
+// encode writes a TLV with a definite length (DER-style, which every LDAP server accepts)
+func encode(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+
+	out := []byte{tag}
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	default:
+		var l []byte
+		for v := n; v > 0; v >>= 8 {
+			l = append([]byte{byte(v)}, l...)
+		}
+		out = append(out, 0x80|byte(len(l)))
+		out = append(out, l...)
+	}
+	for _, c := range content {
+		out = append(out, c...)
+	}
+	return out
+}
+
+func encodeInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	// Non-negative values need a leading zero when the high bit is set
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return encode(tag, b)
+}
+
+func encodeString(tag byte, s string) []byte {
+	return encode(tag, []byte(s))
+}
+
+func encodeBool(v bool) []byte {
+	if v {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0})
+}
+
+// readElement reads one complete TLV (an LDAPMessage) from the connection
+func readElement(r *bufio.Reader) (*element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxMessageSize {
+		return nil, errors.New("ldap: message too large")
+	}
+
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return &element{tag: tag, content: content}, nil
+}
+
+func readLength(r io.ByteReader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b < 0x80 {
+		return int(b), nil
+	}
+
+	octets := int(b & 0x7f)
+	if octets == 0 || octets > maxLengthOctets {
+		return 0, errors.New("ldap: unsupported length encoding")
+	}
+	n := 0
+	for i := 0; i < octets; i++ {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		n = n<<8 | int(b)
+	}
+	return n, nil
+}
+
+// children splits the content of a constructed element into its TLVs
+func (e *element) children() ([]*element, error) {
+	var out []*element
+	rest := e.content
+	for len(rest) > 0 {
+		if len(rest) < 2 {
+			return nil, errors.New("ldap: truncated element")
+		}
+		tag := rest[0]
+		br := &sliceReader{b: rest[1:]}
+		n, err := readLength(br)
+		if err != nil {
+			return nil, err
+		}
+		rest = br.b
+		if n > len(rest) {
+			return nil, errors.New("ldap: truncated element")
+		}
+		out = append(out, &element{tag: tag, content: rest[:n]})
+		rest = rest[n:]
+	}
+	return out, nil
+}
+
+func (e *element) int() int {
+	v := 0
+	for _, b := range e.content {
+		v = v<<8 | int(b)
+	}
+	return v
+}
+
+// sliceReader is an io.ByteReader over a byte slice that exposes the unread rest
+type sliceReader struct {
+	b []byte
+}
+
+func (s *sliceReader) ReadByte() (byte, error) {
+	if len(s.b) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	c := s.b[0]
+	s.b = s.b[1:]
+	return c, nil
+}
@@ -0,0 +1,375 @@
+// Package ldap is a minimal LDAPv3 client (RFC 4511): simple bind, StartTLS and search with
+// equality filters, which is all the directory login backend needs.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Result codes the callers act on (RFC 4511, section 4.1.9)
+const (
+	ResultSuccess            = 0
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+)
+
+const oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+// Error is a non-success LDAPResult
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// IsInvalidCredentials reports whether err is an invalidCredentials bind result
+func IsInvalidCredentials(err error) bool {
+	var le *Error
+	return errors.As(err, &le) && le.Code == ResultInvalidCredentials
+}
+
+// Conn is a single LDAP connection; operations are sent one at a time
+type Conn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	nextID  int
+	timeout time.Duration
+}
+
+// Dial connects to an ldap:// or ldaps:// URL; startTLS upgrades a plain ldap:// connection
+func Dial(ctx context.Context, rawURL string, tlsConfig *tls.Config, startTLS bool, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	var secure bool
+	switch strings.ToLower(u.Scheme) {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+	case "ldaps":
+		secure = true
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+
+	d := &net.Dialer{Timeout: timeout}
+	var nc net.Conn
+	if secure {
+		nc, err = (&tls.Dialer{NetDialer: d, Config: cfg}).DialContext(ctx, "tcp", host)
+	} else {
+		nc, err = d.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{conn: nc, reader: bufio.NewReader(nc), nextID: 1, timeout: timeout}
+	if startTLS && !secure {
+		if err := c.startTLS(ctx, cfg); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// startTLS runs the StartTLS extended operation and switches the connection to TLS (RFC 4511, section 4.14)
+func (c *Conn) startTLS(ctx context.Context, cfg *tls.Config) error {
+	res, err := c.roundTrip(ctx, encode(tagExtendedRequest, encodeString(tagExtendedName, oidStartTLS)), tagExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := resultError(res); err != nil {
+		return err
+	}
+
+	tc := tls.Client(c.conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	c.conn = tc
+	c.reader = bufio.NewReader(tc)
+	return nil
+}
+
+// Bind authenticates the connection with a simple bind
+// An empty password is refused here: servers treat it as an unauthenticated bind that always succeeds (RFC 4513, section 5.1.2).
+func (c *Conn) Bind(ctx context.Context, dn string, password string) error {
+	if password == "" {
+		return &Error{Code: ResultInvalidCredentials, Message: "empty password"}
+	}
+
+	req := encode(tagBindRequest,
+		encodeInt(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(tagAuthSimple, password),
+	)
+	res, err := c.roundTrip(ctx, req, tagBindResponse)
+	if err != nil {
+		return err
+	}
+	return resultError(res)
+}
+
+// Entry is a search result entry; attribute names are matched case-insensitively
+type Entry struct {
+	DN         string
+	Attributes map[string][][]byte
+}
+
+// Values returns the values of an attribute as strings
+func (e *Entry) Values(attr string) []string {
+	var out []string
+	for _, v := range e.Attributes[strings.ToLower(attr)] {
+		out = append(out, string(v))
+	}
+	return out
+}
+
+// Value returns the first value of an attribute, or "" when it is missing
+func (e *Entry) Value(attr string) string {
+	if v := e.Attributes[strings.ToLower(attr)]; len(v) > 0 {
+		return string(v[0])
+	}
+	return ""
+}
+
+// RawValue returns the first value of an attribute as bytes (binary attributes such as objectGUID)
+func (e *Entry) RawValue(attr string) []byte {
+	if v := e.Attributes[strings.ToLower(attr)]; len(v) > 0 {
+		return v[0]
+	}
+	return nil
+}
+
+// Filter is an encoded search filter built with Equal, Present and And
+// Values are sent as raw octets, so no RFC 4515 escaping is needed.
+type Filter []byte
+
+// Equal matches entries whose attribute has the value
+func Equal(attr string, value string) Filter {
+	return encode(tagFilterEquality, encodeString(tagOctetString, attr), encodeString(tagOctetString, value))
+}
+
+// Present matches entries that have the attribute
+func Present(attr string) Filter {
+	return encodeString(tagFilterPresent, attr)
+}
+
+// And matches entries that match all filters
+func And(filters ...Filter) Filter {
+	content := make([][]byte, len(filters))
+	for i, f := range filters {
+		content[i] = f
+	}
+	return encode(tagFilterAnd, content...)
+}
+
+// Search returns the entries below baseDN (whole subtree) that match the filter, with the requested attributes
+func (c *Conn) Search(ctx context.Context, baseDN string, filter Filter, attributes []string, sizeLimit int) ([]*Entry, error) {
+	attrs := make([][]byte, len(attributes))
+	for i, a := range attributes {
+		attrs[i] = encodeString(tagOctetString, a)
+	}
+
+	req := encode(tagSearchRequest,
+		encodeString(tagOctetString, baseDN),
+		encodeInt(tagEnumerated, 2), // wholeSubtree
+		encodeInt(tagEnumerated, 0), // neverDerefAliases
+		encodeInt(tagInteger, sizeLimit),
+		encodeInt(tagInteger, int(c.timeout/time.Second)),
+		encodeBool(false),
+		filter,
+		encode(tagSequence, attrs...),
+	)
+
+	id, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case tagSearchResultEntry:
+			entry, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case tagSearchResultRef:
+			// Referrals to other servers are not followed
+		case tagSearchResultDone:
+			if err := resultError(op); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response tag 0x%x", op.tag)
+		}
+	}
+}
+
+// Close sends an unbind request and closes the connection
+func (c *Conn) Close() error {
+	msg := encode(tagSequence, encodeInt(tagInteger, c.nextID), encode(tagUnbindRequest))
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.conn.Write(msg)
+	return c.conn.Close()
+}
+
+// roundTrip sends a request and waits for the single response with the expected tag
+func (c *Conn) roundTrip(ctx context.Context, op []byte, expected byte) (*element, error) {
+	id, err := c.send(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if res.tag != expected {
+		return nil, fmt.Errorf("ldap: unexpected response tag 0x%x", res.tag)
+	}
+	return res, nil
+}
+
+func (c *Conn) send(ctx context.Context, op []byte) (int, error) {
+	id := c.nextID
+	c.nextID++
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	_, err := c.conn.Write(encode(tagSequence, encodeInt(tagInteger, id), op))
+	return id, err
+}
+
+// receive reads the next LDAPMessage and returns its protocolOp
+func (c *Conn) receive(id int) (*element, error) {
+	msg, err := readElement(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if msg.tag != tagSequence {
+		return nil, errors.New("ldap: malformed message")
+	}
+	parts, err := msg.children()
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) < 2 || parts[0].tag != tagInteger {
+		return nil, errors.New("ldap: malformed message")
+	}
+
+	// Message ID 0 is an unsolicited notification, e.g. the server closing the connection
+	if msgID := parts[0].int(); msgID != id {
+		if msgID == 0 && parts[1].tag == tagExtendedResponse {
+			if err := resultError(parts[1]); err != nil {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("ldap: unexpected message ID %d", msgID)
+	}
+	return parts[1], nil
+}
+
+// resultError converts the LDAPResult at the start of a response into an error
+func resultError(op *element) error {
+	parts, err := op.children()
+	if err != nil {
+		return err
+	}
+	if len(parts) < 3 || parts[0].tag != tagEnumerated {
+		return errors.New("ldap: malformed result")
+	}
+	if code := parts[0].int(); code != ResultSuccess {
+		return &Error{Code: code, Message: string(parts[2].content)}
+	}
+	return nil
+}
+
+func parseEntry(op *element) (*Entry, error) {
+	parts, err := op.children()
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) != 2 {
+		return nil, errors.New("ldap: malformed search entry")
+	}
+
+	entry := &Entry{DN: string(parts[0].content), Attributes: make(map[string][][]byte)}
+	attrs, err := parts[1].children()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attrs {
+		fields, err := a.children()
+		if err != nil || len(fields) != 2 {
+			return nil, errors.New("ldap: malformed attribute")
+		}
+		values, err := fields[1].children()
+		if err != nil {
+			return nil, err
+		}
+		name := strings.ToLower(string(fields[0].content))
+		for _, v := range values {
+			entry.Attributes[name] = append(entry.Attributes[name], v.content)
+		}
+	}
+	return entry, nil
+}
+
+// EscapeDN escapes a value for use in a distinguished name (RFC 4514, section 2.4)
+func EscapeDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			(r == ' ' || r == '#') && i == 0,
+			r == ' ' && i == len(value)-1:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
+	"mein-idaas/bench"
 	"mein-idaas/middleware"
+	"mein-idaas/model"
 	"mein-idaas/seeder"
 	"os"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	swag "github.com/gofiber/swagger"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	_ "mein-idaas/docs" // <-- required to register swagger spec
 
@@ -32,6 +37,14 @@ import (
 // @host            localhost:4000
 // @BasePath        /api/v1
 func main() {
+	// Subcommand: load-test a running instance (does not need DB or keys)
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := bench.Run(os.Args[2:]); err != nil {
+			log.Fatalf("bench failed: %v", err)
+		}
+		return
+	}
+
 	// Load .env file with proper error handling
 	if err := godotenv.Load(); err != nil {
 		log.Printf("warning: failed to load .env file: %v (using system environment variables)", err)
@@ -40,27 +53,126 @@ func main() {
 	// Initialize Argon2 parameters from environment variables
 	util.InitArgon2Params()
 
-	// Initialize RSA keys for JWT signing
-	if err := util.InitRSAKeys(); err != nil {
-		log.Fatalf("failed to initialize RSA keys: %v", err)
+	// Initialize JWT signing keys (RS256 by default, ES256 or EdDSA via JWT_SIGNING_ALG)
+	if err := util.InitSigningKeys(); err != nil {
+		log.Fatalf("failed to initialize signing keys: %v", err)
+	}
+
+	// Optional multi-tenancy (per-organization issuer URLs under /t/{org})
+	if err := util.InitTenants(); err != nil {
+		log.Fatalf("failed to load tenants: %v", err)
+	}
+
+	// Optional Redis for state shared across replicas (rate limiting / IP bans)
+	if err := util.InitRedis(); err != nil {
+		log.Fatalf("failed to connect to Redis: %v", err)
+	}
+
+	// Extra registration fields stored in user metadata
+	if err := util.InitRegistrationSchema(); err != nil {
+		log.Fatalf("failed to load registration schema: %v", err)
 	}
 
 	db := util.InitDB()
 
 	seeder.SeedRoles(db)
+	seeder.SeedPermissions(db)
+	seeder.SeedScopes(db)
 
 	userRepo := repository.NewUserRepository(db)
 	credentialRepo := repository.NewCredentialRepository(db)
 	refreshTokenRepo := repository.NewRefreshTokenRepository(db)
 	roleRepo := repository.NewRoleRepository(db)
 	verificationRepo := repository.NewInMemoryVerificationRepo()
+	util.RegisterGaugeFunc("idaas_otp_store_size", "Verification/OTP codes currently held in the in-memory store.", func() float64 {
+		return float64(verificationRepo.Count())
+	})
+	roleCache := repository.NewInMemoryRoleCache(util.GetRoleCacheTTL())
+	outboxRepo := repository.NewOutboxRepository(db)
+	unitOfWork := repository.NewUnitOfWork(db)
 
 	util.StartDailyCleanup(refreshTokenRepo)
+
+	// Retention policies (purge old outbox events, revoked tokens, ...) run nightly at 03:00
+	retentionService := service.NewRetentionService(repository.NewRetentionRepository(db))
+
+	// Registration policy (open / restricted / closed), editable at runtime by admins
+	registrationService := service.NewRegistrationPolicyService(repository.NewSettingRepository(db), repository.NewInviteRepository(db))
+
+	// JIT provisioning of federated logins (auto-create, default roles, domains, attribute mapping), editable by admins
+	provisioningService := service.NewProvisioningPolicyService(repository.NewSettingRepository(db))
+
+	// JWT key ring shared by all replicas (signing key rotation, see KEY_ROTATION_*)
+	keyService := service.NewKeyRotationService(repository.NewSigningKeyRepository(db))
+	if err := keyService.Start(context.Background()); err != nil {
+		log.Fatalf("failed to load signing keys: %v", err)
+	}
+
+	// Opaque access tokens (ACCESS_TOKEN_FORMAT=opaque) are validated by a database lookup
+	opaqueTokenService := service.NewOpaqueTokenService(repository.NewAccessTokenRepository(db))
+	if opaqueTokenService.Enabled() {
+		util.SetOpaqueTokenLookup(opaqueTokenService.Lookup)
+	}
+
+	// JWT access tokens revoked before they expire (logout); shared through Redis when REDIS_URL is set
+	tokenDenylist := repository.NewInMemoryTokenDenylist()
+	if client := util.GetRedisClient(); client != nil {
+		tokenDenylist = repository.NewRedisTokenDenylist(client)
+	}
+	util.SetDenylistLookup(tokenDenylist.Contains)
+
+	// IDs of used DPoP proofs and action tokens (email links), so they can't be used twice
+	replayCache := repository.NewInMemoryReplayCache()
+	if client := util.GetRedisClient(); client != nil {
+		replayCache = repository.NewRedisReplayCache(client)
+	}
+	util.SetReplayCheck(replayCache.Claim)
+
+	// Custom access token claims: user metadata fields listed in TOKEN_METADATA_CLAIMS
+	// (deployments can register further util.ClaimsMapper implementations here)
+	if mapper := service.NewMetadataClaimsMapper(userRepo); mapper != nil {
+		util.RegisterClaimsMapper(mapper)
+	}
+
+	// Role inheritance (role_inherits) and the permissions claim: the permissions granted by the user's roles
+	permissionService := service.NewPermissionService(repository.NewPermissionRepository(db), roleRepo)
+	util.SetRoleExpansion(permissionService.ExpandRoles)
+	util.SetPermissionLookup(permissionService.PermissionsForRoles)
+
+	// Distinct active users per day (DAU/MAU reporting)
+	activityService := service.NewActivityService(repository.NewActivityRepository(db))
+	util.StartDailyJob("data retention purge", 3, retentionService.RunOnce)
 	emailService := service.NewEmailService()
 	verificationService := service.NewVerificationService(verificationRepo, emailService)
 
+	// Outbox dispatcher delivers side effects written in the same transaction as the change
+	outboxDispatcher := service.NewOutboxDispatcher(outboxRepo)
+	outboxDispatcher.Register(model.EventUserRegistered, verificationService.HandleUserRegistered)
+	outboxDispatcher.Register(model.EventUserIdentityLinked, emailService.HandleIdentityEvent)
+	outboxDispatcher.Register(model.EventUserIdentityUnlinked, emailService.HandleIdentityEvent)
+	outboxDispatcher.Register(model.EventUserMFAResetRequested, emailService.HandleMFAResetEvent)
+	outboxDispatcher.Register(model.EventUserMFAReset, emailService.HandleMFAResetEvent)
+	outboxDispatcher.Register(model.EventUserLocked, emailService.HandleAccountLockedEvent)
+	outboxDispatcher.Register(model.EventUserTokenReuse, emailService.HandleTokenReuseEvent)
+	outboxDispatcher.Register(model.EventUserSessionBindingMismatch, emailService.HandleTokenReuseEvent)
+	if webhookService := service.NewWebhookService(); webhookService.Enabled() {
+		for _, eventType := range model.IdentityEventTypes {
+			outboxDispatcher.Register(eventType, webhookService.HandleEvent)
+		}
+	}
+	if siemExporter := service.NewSIEMExporter(); siemExporter.Enabled() {
+		for _, eventType := range model.IdentityEventTypes {
+			outboxDispatcher.Register(eventType, siemExporter.HandleEvent)
+		}
+		util.RegisterGaugeFunc("idaas_siem_buffered_messages", "Audit messages waiting to be written to the SIEM.", func() float64 {
+			return float64(siemExporter.Buffered())
+		})
+		siemExporter.Start()
+	}
+	outboxDispatcher.Start()
+
 	app := fiber.New()
-	setupRoutes(app, userRepo, credentialRepo, refreshTokenRepo, roleRepo, verificationService)
+	setupRoutes(app, userRepo, credentialRepo, refreshTokenRepo, roleRepo, roleCache, unitOfWork, verificationService, retentionService, registrationService, provisioningService, activityService, opaqueTokenService, tokenDenylist, keyService, repository.NewSSOSessionRepository(db), repository.NewOAuthClientRepository(db), repository.NewAuthorizationCodeRepository(db), repository.NewScopeRepository(db), repository.NewConsentRepository(db), repository.NewSAMLServiceProviderRepository(db), repository.NewRememberedDeviceRepository(db), repository.NewAPIKeyRepository(db), repository.NewGroupRepository(db), permissionService)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -70,46 +182,260 @@ func main() {
 	log.Fatal(app.Listen(":" + port))
 }
 
-func setupRoutes(app *fiber.App, userRepo repository.UserRepository, credentialRepo repository.CredentialRepository, refreshTokenRepo repository.RefreshTokenRepository, roleRepo repository.RoleRepository, verificationService *service.VerificationService) {
+func setupRoutes(app *fiber.App, userRepo repository.UserRepository, credentialRepo repository.CredentialRepository, refreshTokenRepo repository.RefreshTokenRepository, roleRepo repository.RoleRepository, roleCache repository.RoleCache, unitOfWork repository.UnitOfWork, verificationService *service.VerificationService, retentionService *service.RetentionService, registrationService *service.RegistrationPolicyService, provisioningService *service.ProvisioningPolicyService, activityService *service.ActivityService, opaqueTokenService *service.OpaqueTokenService, tokenDenylist repository.TokenDenylist, keyService *service.KeyRotationService, ssoSessionRepo repository.SSOSessionRepository, oauthClientRepo repository.OAuthClientRepository, authCodeRepo repository.AuthorizationCodeRepository, scopeRepo repository.ScopeRepository, consentRepo repository.ConsentRepository, samlSPRepo repository.SAMLServiceProviderRepository, rememberedDeviceRepo repository.RememberedDeviceRepository, apiKeyRepo repository.APIKeyRepository, groupRepo repository.GroupRepository, permissionService *service.PermissionService) {
 	// Apply rate limiter globally to all routes (must be first)
-	app.Use(middleware.RateLimitMiddleware)
+	app.Use(middleware.InitRateLimiter())
 
 	// Apply timer metrics middleware globally to all routes
 	app.Use(middleware.TimerMetrics)
 
+	// Per-request deadline propagated to services and DB queries
+	app.Use(middleware.RequestTimeout())
+
+	// DPoP proofs (RFC 9449) bind issued tokens to the client's key
+	app.Use(middleware.VerifyDPoP)
+
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"status": "ok"})
 	})
 
+	// Query counters collected by the GORM logger (see DB_LOG_LEVEL)
+	app.Get("/metrics/db", func(c *fiber.Ctx) error {
+		return c.JSON(util.GetDBQueryStats())
+	})
+
+	// Prometheus metrics (DB pool, janitor runs, OTP store size, query counters)
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.HandlerFor(util.GetMetricsRegistry(), promhttp.HandlerOpts{})))
+
 	app.Get("/swagger/*", swag.HandlerDefault)
 
 	// create services and controllers
-	authService := service.NewAuthService(userRepo, credentialRepo, refreshTokenRepo, roleRepo, verificationService)
+	authService := service.NewAuthService(userRepo, credentialRepo, refreshTokenRepo, roleRepo, roleCache, unitOfWork, rememberedDeviceRepo, verificationService, registrationService, activityService, opaqueTokenService, tokenDenylist, provisioningService, service.NewLDAPAuthenticator(), service.NewPasswordPolicyService())
 	authController := controller.NewAuthController(authService)
+	// JWT access tokens older than the user's token version (logout-all) are rejected
+	util.SetTokenVersionLookup(authService.TokenVersion)
+	util.SetMFALookup(authService.UsesMFA)
+	// API keys (X-API-Key) are accepted wherever the auth guards accept access tokens
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, authService)
+	util.SetAPIKeyLookup(apiKeyService.Lookup)
+	apiKeyController := controller.NewAPIKeyController(apiKeyService)
 	verifyController := controller.NewVerificationController(authService, verificationService)
+	linkService := service.NewLinkCredentialService(userRepo, credentialRepo, roleCache, unitOfWork)
+	identityController := controller.NewIdentityController(authService, linkService)
+	federationController := controller.NewFederationController(authService, service.NewFederationService())
+	phoneController := controller.NewPhoneController(authService)
+	guestController := controller.NewGuestController(authService)
+	adminService := service.NewAdminService(userRepo, retentionService, registrationService, provisioningService, activityService, keyService, authService, service.NewPasswordHashScanService(credentialRepo))
+	adminController := controller.NewAdminController(adminService)
+	roleController := controller.NewRoleController(service.NewRoleService(roleRepo, roleCache, permissionService), permissionService)
+	groupController := controller.NewGroupController(service.NewGroupService(groupRepo, roleRepo, roleCache))
+	ssoService := service.NewSSOService(authService, ssoSessionRepo)
+	hostedLoginController := controller.NewHostedLoginController(ssoService)
+	discoveryController := controller.NewDiscoveryController()
+	oauthService := service.NewOAuthService(authService, oauthClientRepo, authCodeRepo, refreshTokenRepo, scopeRepo, consentRepo)
+	oauthController := controller.NewOAuthController(oauthService, ssoService, hostedLoginController)
+	samlController := controller.NewSAMLController(service.NewSAMLService(samlSPRepo, userRepo), ssoService, hostedLoginController)
 
 	api := app.Group("/api/v1")
-	auth := api.Group("/auth")
 
-	auth.Post("/register", authController.Register)
-	auth.Post("/login", authController.Login)
-	auth.Post("/refresh", authController.Refresh)
+	// CAPTCHA on abuse-prone endpoints (CAPTCHA_PROVIDER, CAPTCHA_ENDPOINTS)
+	captcha := middleware.InitCaptcha()
+	// Sensitive operations need a login within STEP_UP_MAX_AGE, see /auth/step-up
+	recentAuth := middleware.InitRecentAuth()
+
+	// authRoutes mounts the auth API; it is served globally and, with multi-tenancy, once per organization
+	authRoutes := func(auth fiber.Router) {
+		auth.Post("/register", captcha("register"), authController.Register)
+		auth.Post("/login", captcha("login"), authController.Login)
+		auth.Post("/refresh", authController.Refresh)
+		auth.Post("/logout", authController.Logout)
+		auth.Post("/logout-all", middleware.RequireAuth, authController.LogoutAll)
+		auth.Post("/step-up", middleware.RequireAuth, authController.StepUp)
+		auth.Post("/step-up/code", middleware.RequireAuth, authController.SendStepUpCode)
+		auth.Get("/username/available", authController.CheckUsername)
+		auth.Post("/login/social", identityController.LoginWithIdentity)
+		auth.Post("/social/google", identityController.GoogleLogin)
+		auth.Post("/social/apple", identityController.AppleLogin)
+		auth.Post("/social/facebook", identityController.FacebookLogin)
+		auth.Get("/social/github/start", identityController.GithubStart)
+		auth.Get("/social/github/callback", identityController.GithubCallback)
+		auth.Get("/federation/providers", federationController.ListProviders)
+		auth.Get("/federation/:provider/start", federationController.Start)
+		auth.Get("/federation/:provider/callback", federationController.Callback)
+		auth.Post("/login/phone", captcha("phone-login"), phoneController.SendPhoneLoginOTP)
+		auth.Post("/login/phone/verify", phoneController.LoginWithPhone)
+		auth.Post("/magic-link", captcha("magic-link"), authController.SendMagicLink)
+		auth.Get("/magic-link/callback", authController.MagicLinkCallback)
+
+		// guest (anonymous) accounts
+		auth.Post("/guest", captcha("guest"), guestController.CreateGuest)
+		auth.Post("/guest/login", guestController.LoginGuest)
+
+		// MFA endpoints
+		auth.Post("/mfa/setup", middleware.RequireAuth, middleware.RejectAPIKeys, authController.SetupMFA)
+		auth.Get("/mfa/qrcode", authController.GetMFAQRCode)
+		auth.Get("/mfa/qrcode/base64", authController.GetMFAQRCodeBase64)
+		auth.Post("/mfa/confirm", recentAuth, authController.ConfirmMFA)
+		auth.Post("/mfa/verify", authController.VerifyMFA)
+		auth.Post("/mfa/recovery-codes", recentAuth, authController.RegenerateRecoveryCodes)
+		auth.Post("/mfa/sms", phoneController.SendMFASMS)
+		auth.Post("/mfa/email", authController.SendMFAEmail)
+		auth.Post("/mfa/reset", authController.RequestMFAReset)
+		auth.Post("/mfa/reset/confirm", authController.ConfirmMFAReset)
+
+		// password change endpoints
+		auth.Post("/password-change/send-otp", middleware.RequireAuth, authController.SendPasswordChangeOTP)
+		auth.Post("/password-change", recentAuth, authController.ChangePassword)
+		auth.Post("/password-expired", authController.ChangeExpiredPassword)
 
-	// MFA endpoints
-	auth.Post("/mfa/setup", authController.SetupMFA)
-	auth.Get("/mfa/qrcode", authController.GetMFAQRCode)
-	auth.Get("/mfa/qrcode/base64", authController.GetMFAQRCodeBase64)
-	auth.Post("/mfa/confirm", authController.ConfirmMFA)
+		// password reset endpoints (forgot password flow)
+		auth.Post("/forgot-password/send-otp", captcha("forgot-password"), authController.SendForgotPasswordOTP)
+		auth.Post("/forgot-password/send-sms", captcha("forgot-password"), phoneController.SendForgotPasswordSMS)
+		auth.Post("/forgot-password/reset", authController.ResetPasswordWithOTP)
+		auth.Post("/forgot-password/reset-link", authController.ResetPasswordWithLink)
 
-	// password change endpoints
-	auth.Post("/password-change/send-otp", authController.SendPasswordChangeOTP)
-	auth.Post("/password-change", authController.ChangePassword)
+		// verification endpoints
+		auth.Post("/verify", verifyController.VerifyEmail)
+		auth.Get("/verify/link", verifyController.VerifyEmailLink)
+		auth.Post("/resend", verifyController.ResendVerificationCode)
+		auth.Get("/verification-status", verifyController.GetVerificationStatus)
 
-	// password reset endpoints (forgot password flow)
-	auth.Post("/forgot-password/send-otp", authController.SendForgotPasswordOTP)
-	auth.Post("/forgot-password/reset", authController.ResetPasswordWithOTP)
+		// linked identity endpoints (authenticated user); API keys can't manage sessions, devices, keys, factors, phone or identities
+		me := auth.Group("/me", middleware.RequireAuth)
+		me.Post("/identities/link", middleware.RejectAPIKeys, identityController.LinkIdentity)
+		me.Delete("/identities/:type", recentAuth, identityController.UnlinkIdentity)
+		me.Post("/phone", middleware.RejectAPIKeys, phoneController.StartPhoneVerification)
+		me.Post("/phone/verify", recentAuth, phoneController.ConfirmPhone)
+		me.Put("/username", middleware.RejectAPIKeys, authController.ChangeUsername)
+		me.Post("/mfa/sms", middleware.RejectAPIKeys, phoneController.EnableSMSMFA)
+		me.Delete("/mfa/sms", recentAuth, phoneController.DisableSMSMFA)
+		me.Post("/mfa/email", middleware.RejectAPIKeys, authController.EnableEmailMFA)
+		me.Delete("/mfa/email", recentAuth, authController.DisableEmailMFA)
+		me.Post("/claim", middleware.RejectAPIKeys, guestController.ClaimGuest)
+		me.Get("/consents", oauthController.ListConsents)
+		me.Delete("/consents/:client_id", oauthController.RevokeConsent)
+		me.Get("/credentials", authController.ListCredentials)
+		me.Get("/sessions", middleware.RejectAPIKeys, authController.ListSessions)
+		me.Delete("/sessions/:id", middleware.RejectAPIKeys, authController.RevokeSession)
+		me.Get("/devices", middleware.RejectAPIKeys, authController.ListRememberedDevices)
+		me.Delete("/devices", middleware.RejectAPIKeys, authController.ForgetAllDevices)
+		me.Delete("/devices/:id", middleware.RejectAPIKeys, authController.ForgetDevice)
+		me.Get("/api-keys", middleware.RejectAPIKeys, apiKeyController.ListAPIKeys)
+		me.Post("/api-keys", recentAuth, apiKeyController.CreateAPIKey)
+		me.Delete("/api-keys/:id", middleware.RejectAPIKeys, apiKeyController.RevokeAPIKey)
+	}
+	authRoutes(api.Group("/auth"))
+
+	// ssoRoutes mounts the hosted login pages for browser SSO (idaas_sso session cookie)
+	ssoRoutes := func(sso fiber.Router) {
+		sso.Get("/login", hostedLoginController.ShowLogin)
+		sso.Post("/login", hostedLoginController.Login)
+		sso.Get("/login/mfa", hostedLoginController.ShowMFA)
+		sso.Post("/login/mfa", hostedLoginController.VerifyMFA)
+		sso.Post("/logout", hostedLoginController.Logout)
+	}
+	ssoRoutes(app.Group("/sso"))
+
+	// oauthRoutes mounts the OAuth 2.0 authorization code flow for third-party apps
+	oauthRoutes := func(oauth fiber.Router) {
+		oauth.Get("/authorize", oauthController.Authorize)
+		oauth.Post("/authorize", oauthController.Consent)
+		oauth.Post("/token", oauthController.Token)
+		oauth.Post("/introspect", oauthController.Introspect)
+		oauth.Post("/revoke", oauthController.Revoke)
+		oauth.Get("/userinfo", oauthController.UserInfo)
+		oauth.Post("/userinfo", oauthController.UserInfo)
+
+		// dynamic client registration: clients:manage permission required unless OAUTH_REGISTRATION=open
+		// (anonymous registrations are limited to the authorization code flow and the standard OIDC scopes)
+		if oauthService.RegistrationOpen() {
+			oauth.Post("/register", middleware.OptionalPermission("clients:manage"), oauthController.RegisterClient)
+		} else {
+			oauth.Post("/register", middleware.RequirePermission("clients:manage"), oauthController.RegisterClient)
+		}
+	}
+	oauthRoutes(app.Group("/oauth"))
+
+	// samlRoutes mounts the SAML 2.0 identity provider for legacy enterprise apps
+	samlRoutes := func(saml fiber.Router) {
+		saml.Get("/metadata", samlController.Metadata)
+		saml.Get("/sso", samlController.SSO)
+		saml.Post("/sso", samlController.SSO)
+	}
+	samlRoutes(app.Group("/saml"))
+
+	// OpenID Connect discovery and public signing keys
+	app.Get("/.well-known/openid-configuration", discoveryController.GetOpenIDConfiguration)
+	app.Get("/.well-known/jwks.json", discoveryController.GetJWKS)
+
+	// Tenant routes: tokens issued here carry the tenant claim and the {ISSUER_BASE_URL}/t/{org} issuer
+	if util.MultiTenancyEnabled() {
+		authRoutes(app.Group("/t/:tenant/api/v1/auth", middleware.ResolveTenant))
+		ssoRoutes(app.Group("/t/:tenant/sso", middleware.ResolveTenant))
+		oauthRoutes(app.Group("/t/:tenant/oauth", middleware.ResolveTenant))
+		samlRoutes(app.Group("/t/:tenant/saml", middleware.ResolveTenant))
+
+		tenantWellKnown := app.Group("/t/:tenant/.well-known", middleware.ResolveTenant)
+		tenantWellKnown.Get("/openid-configuration", discoveryController.GetOpenIDConfiguration)
+		tenantWellKnown.Get("/jwks.json", discoveryController.GetJWKS)
+	}
 
-	// verification endpoints
-	auth.Post("/verify", verifyController.VerifyEmail)
-	auth.Post("/resend", verifyController.ResendVerificationCode)
+	// admin endpoints: each route requires a permission (seeded for the admin role, see SeedPermissions)
+	// RequirePermission authenticates the caller itself; RequireAuth would refuse API keys scoped to these permissions
+	admin := api.Group("/admin")
+	usersRead := middleware.RequirePermission("users:read")
+	usersWrite := middleware.RequirePermission("users:write")
+	usersExport := middleware.RequirePermission("users:export")
+	statsRead := middleware.RequirePermission("stats:read")
+	manageSettings := middleware.RequirePermission("settings:manage")
+	manageRoles := middleware.RequirePermission("roles:manage")
+	manageGroups := middleware.RequirePermission("groups:manage")
+	manageKeys := middleware.RequirePermission("keys:manage")
+	manageClients := middleware.RequirePermission("clients:manage")
+	admin.Get("/users", usersRead, adminController.ListUsers)
+	admin.Get("/users/search", usersRead, adminController.SearchUsers)
+	admin.Get("/users/export", usersExport, adminController.ExportUsers)
+	admin.Post("/users/:id/mfa/reset", usersWrite, adminController.ResetUserMFA)
+	admin.Post("/users/:id/temporary-password", usersWrite, adminController.SendTemporaryPassword)
+	admin.Post("/users/:id/password", usersWrite, adminController.ResetUserPassword)
+	admin.Post("/users/:id/logout-all", usersWrite, adminController.LogoutUser)
+	admin.Get("/users/:id/api-keys", usersRead, apiKeyController.ListUserAPIKeys)
+	admin.Post("/users/:id/api-keys", usersWrite, apiKeyController.CreateUserAPIKey)
+	admin.Delete("/users/:id/api-keys/:key_id", usersWrite, apiKeyController.RevokeUserAPIKey)
+	admin.Get("/retention", statsRead, adminController.GetRetentionStats)
+	admin.Get("/password-hashes/scan", statsRead, adminController.GetPasswordHashScan)
+	admin.Post("/password-hashes/scan", manageSettings, adminController.StartPasswordHashScan)
+	admin.Get("/settings/registration", manageSettings, adminController.GetRegistrationSettings)
+	admin.Put("/settings/registration", manageSettings, adminController.UpdateRegistrationSettings)
+	admin.Get("/settings/provisioning", manageSettings, adminController.GetProvisioningSettings)
+	admin.Put("/settings/provisioning", manageSettings, adminController.UpdateProvisioningSettings)
+	admin.Post("/invites", usersWrite, adminController.CreateInvite)
+	admin.Get("/stats/active-users", statsRead, adminController.GetActiveUsers)
+	admin.Get("/roles", manageRoles, roleController.ListRoles)
+	admin.Post("/roles", manageRoles, roleController.CreateRole)
+	admin.Get("/roles/:code", manageRoles, roleController.GetRole)
+	admin.Put("/roles/:code", manageRoles, roleController.UpdateRole)
+	admin.Delete("/roles/:code", manageRoles, roleController.DeleteRole)
+	admin.Put("/roles/:code/permissions", manageRoles, roleController.SetRolePermissions)
+	admin.Put("/roles/:code/inherits", manageRoles, roleController.SetInheritedRoles)
+	admin.Get("/permissions", manageRoles, roleController.ListPermissions)
+	admin.Post("/permissions", manageRoles, roleController.CreatePermission)
+	admin.Delete("/permissions/:code", manageRoles, roleController.DeletePermission)
+	admin.Get("/groups", manageGroups, groupController.ListGroups)
+	admin.Post("/groups", manageGroups, groupController.CreateGroup)
+	admin.Get("/groups/:code", manageGroups, groupController.GetGroup)
+	admin.Put("/groups/:code", manageGroups, groupController.UpdateGroup)
+	admin.Delete("/groups/:code", manageGroups, groupController.DeleteGroup)
+	// Mapping roles to a group grants them to its members: that takes roles:manage, not just groups:manage
+	admin.Put("/groups/:code/roles", manageGroups, manageRoles, groupController.SetGroupRoles)
+	admin.Get("/groups/:code/members", manageGroups, groupController.ListGroupMembers)
+	admin.Post("/groups/:code/members", manageGroups, groupController.AddGroupMembers)
+	admin.Delete("/groups/:code/members/:user_id", manageGroups, groupController.RemoveGroupMember)
+	admin.Get("/keys", manageKeys, adminController.ListSigningKeys)
+	admin.Post("/keys", manageKeys, adminController.AddSigningKey)
+	admin.Post("/keys/rotate", manageKeys, adminController.RotateSigningKey)
+	admin.Delete("/keys/:kid", manageKeys, adminController.RetireSigningKey)
+	admin.Post("/oauth/clients", manageClients, oauthController.CreateClient)
+	admin.Get("/saml/service-providers", manageClients, samlController.ListServiceProviders)
+	admin.Post("/saml/service-providers", manageClients, samlController.CreateServiceProvider)
 }
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"strings"
+
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireAdmin only lets users with the 'admin' role through
+var RequireAdmin = RequireRoles("admin")
+
+// RequireRoles validates the Bearer access token (or an X-API-Key) like RequireAuth and only lets users
+// with at least one of roles through (403 otherwise); API keys with scopes are refused like by RequireAuth
+func RequireRoles(roles ...string) fiber.Handler {
+	denied := strings.Join(roles, " or ") + " role required"
+	return func(c *fiber.Ctx) error {
+		claims, err := authenticate(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+		if scopedAPIKey(c, claims) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "API key scope does not cover this route"})
+		}
+
+		for _, role := range roles {
+			if util.HasRole(claims, role) {
+				return c.Next()
+			}
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": denied})
+	}
+}
+
+// RequirePermission validates the Bearer access token (or an X-API-Key) like RequireAuth and only lets
+// callers through whose roles grant all of permissions (403 otherwise), see util.PermissionChecker
+// An API key with scopes must also name each of permissions in its scopes.
+func RequirePermission(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, err := authenticate(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+		if scopedAPIKey(c, claims) {
+			if p := uncoveredScope(claims.Scope, permissions); p != "" {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "API key scope does not cover " + p})
+			}
+		}
+
+		missing, err := util.DefaultPermissionChecker().Missing(c.UserContext(), claims, permissions...)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to check permissions"})
+		}
+		if missing != "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": missing + " permission required"})
+		}
+		return c.Next()
+	}
+}
+
+// OptionalPermission lets anonymous requests through and checks the credentials of the others like RequirePermission,
+// for endpoints open to anyone that grant more to callers holding permissions (c.Locals("claims") is set for them)
+func OptionalPermission(permissions ...string) fiber.Handler {
+	require := RequirePermission(permissions...)
+	return func(c *fiber.Ctx) error {
+		if c.Get(fiber.HeaderAuthorization) == "" && c.Get(apiKeyHeader) == "" {
+			return c.Next()
+		}
+		return require(c)
+	}
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// captchaHeader carries the widget token for JSON clients
+const captchaHeader = "X-Captcha-Token"
+
+// defaultCaptchaEndpoints are protected when CAPTCHA_ENDPOINTS is not set
+const defaultCaptchaEndpoints = "register,login,forgot-password"
+
+// CaptchaVerifier checks the token a CAPTCHA widget produced in the browser
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, token string, remoteIP string) (bool, error)
+}
+
+// captchaVerifyURLs are the siteverify endpoints of the supported providers
+// All three share the same API: POST secret, response and remoteip as a form, get {"success": bool}.
+var captchaVerifyURLs = map[string]string{
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// siteVerifyCaptcha verifies tokens with a provider's siteverify endpoint
+type siteVerifyCaptcha struct {
+	verifyURL string
+	secret    string
+	minScore  float64 // reCAPTCHA v3 / hCaptcha Enterprise only; ignored when the response has no score
+	client    *http.Client
+}
+
+// NewCaptchaVerifier returns the verifier for provider ("recaptcha", "hcaptcha" or "turnstile")
+func NewCaptchaVerifier(provider string, secret string, minScore float64) (CaptchaVerifier, error) {
+	verifyURL, ok := captchaVerifyURLs[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown captcha provider %q", provider)
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("captcha provider %s requires a secret", provider)
+	}
+	return &siteVerifyCaptcha{
+		verifyURL: verifyURL,
+		secret:    secret,
+		minScore:  minScore,
+		client:    &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+func (v *siteVerifyCaptcha) Verify(ctx context.Context, token string, remoteIP string) (bool, error) {
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("captcha siteverify returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		Score      *float64 `json:"score"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	if !result.Success {
+		log.Printf("captcha rejected: %v", result.ErrorCodes)
+		return false, nil
+	}
+	return result.Score == nil || *result.Score >= v.minScore, nil
+}
+
+// InitCaptcha returns a factory for per-endpoint CAPTCHA middleware
+// Environment variables:
+// - CAPTCHA_PROVIDER: recaptcha, hcaptcha or turnstile (disabled when empty)
+// - CAPTCHA_SECRET: the provider's secret key
+// - CAPTCHA_ENDPOINTS: comma-separated endpoint names to protect (default: register,login,forgot-password)
+// - CAPTCHA_MIN_SCORE: minimum score for score-based providers such as reCAPTCHA v3 (default: 0.5)
+//
+// Endpoints that are not enabled get a handler that does nothing.
+func InitCaptcha() func(endpoint string) fiber.Handler {
+	disabled := func(string) fiber.Handler {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+
+	provider := strings.ToLower(os.Getenv("CAPTCHA_PROVIDER"))
+	if provider == "" {
+		return disabled
+	}
+
+	minScore, err := strconv.ParseFloat(os.Getenv("CAPTCHA_MIN_SCORE"), 64)
+	if err != nil || minScore < 0 || minScore > 1 {
+		minScore = 0.5
+	}
+	verifier, err := NewCaptchaVerifier(provider, os.Getenv("CAPTCHA_SECRET"), minScore)
+	if err != nil {
+		log.Printf("warning: CAPTCHA disabled: %v", err)
+		return disabled
+	}
+
+	endpoints := os.Getenv("CAPTCHA_ENDPOINTS")
+	if endpoints == "" {
+		endpoints = defaultCaptchaEndpoints
+	}
+	enabled := make(map[string]bool)
+	for _, e := range strings.Split(endpoints, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			enabled[e] = true
+		}
+	}
+	log.Printf("CAPTCHA (%s) required on: %s", provider, endpoints)
+
+	return func(endpoint string) fiber.Handler {
+		if !enabled[endpoint] {
+			return disabled(endpoint)
+		}
+		return RequireCaptcha(verifier)
+	}
+}
+
+// RequireCaptcha rejects requests without a valid CAPTCHA token
+// The token is read from the X-Captcha-Token header, a captcha_token field in the JSON body, or the form field
+// the provider's widget adds (g-recaptcha-response, h-captcha-response, cf-turnstile-response).
+func RequireCaptcha(verifier CaptchaVerifier) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := captchaToken(c)
+		if token == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "captcha token required"})
+		}
+
+		ok, err := verifier.Verify(c.UserContext(), token, c.IP())
+		if err != nil {
+			log.Printf("captcha verification failed: %v", err)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "captcha verification unavailable"})
+		}
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "captcha verification failed"})
+		}
+		return c.Next()
+	}
+}
+
+func captchaToken(c *fiber.Ctx) string {
+	if token := c.Get(captchaHeader); token != "" {
+		return token
+	}
+
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		var body struct {
+			CaptchaToken string `json:"captcha_token"`
+		}
+		_ = json.Unmarshal(c.Body(), &body)
+		return body.CaptchaToken
+	}
+
+	for _, field := range []string{"g-recaptcha-response", "h-captcha-response", "cf-turnstile-response", "captcha_token"} {
+		if token := c.FormValue(field); token != "" {
+			return token
+		}
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// VerifyDPoP checks the DPoP proof header of a request (RFC 9449) and stores it in the request context:
+// tokens issued for the request are bound to the proof's key, DPoP-bound access tokens are matched against it.
+// Requests without a DPoP header pass through unchanged.
+func VerifyDPoP(c *fiber.Ctx) error {
+	proof := c.Get("DPoP")
+	if proof == "" {
+		return c.Next()
+	}
+
+	// htu is the public URL the client called (ISSUER_BASE_URL behind a proxy)
+	base := util.GetIssuerBaseURL()
+	if base == "" {
+		base = c.BaseURL()
+	}
+	verified, err := util.VerifyDPoPProof(c.UserContext(), proof, c.Method(), base+c.Path())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_dpop_proof", "error_description": err.Error()})
+	}
+
+	c.SetUserContext(util.WithDPoPProof(c.UserContext(), verified))
+	return c.Next()
+}
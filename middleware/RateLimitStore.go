@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimitStore counts requests per key using a sliding window counter
+// Implementations must keep O(1) state per key (no per-request timestamps)
+type RateLimitStore interface {
+	// Hit records one request for key and returns the estimated number of requests
+	// in the current sliding window, and whether the key is currently banned
+	Hit(key string) (count float64, banned bool, err error)
+
+	// Ban blocks the key until the ban duration elapses
+	Ban(key string, duration time.Duration) error
+}
+
+// slidingWindowEstimate weights the previous fixed window by how much of it still overlaps
+// the sliding window: estimate = prev * (1 - elapsed/window) + curr
+func slidingWindowEstimate(prev, curr int64, elapsed, window time.Duration) float64 {
+	weight := 1 - float64(elapsed)/float64(window)
+	if weight < 0 {
+		weight = 0
+	}
+	return float64(prev)*weight + float64(curr)
+}
+
+// windowCounter is the constant-size state kept per key
+type windowCounter struct {
+	windowStart time.Time
+	prev        int64
+	curr        int64
+	bannedUntil time.Time
+}
+
+// memoryRateLimitStore keeps counters in process memory (single replica deployments)
+type memoryRateLimitStore struct {
+	mu     sync.Mutex
+	window time.Duration
+	keys   map[string]*windowCounter
+}
+
+// NewMemoryRateLimitStore creates an in-memory sliding window store
+func NewMemoryRateLimitStore(window time.Duration) RateLimitStore {
+	store := &memoryRateLimitStore{
+		window: window,
+		keys:   make(map[string]*windowCounter),
+	}
+	go store.cleanup()
+	return store
+}
+
+func (s *memoryRateLimitStore) Hit(key string) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	wc, exists := s.keys[key]
+	if !exists {
+		wc = &windowCounter{windowStart: now.Truncate(s.window)}
+		s.keys[key] = wc
+	}
+
+	if now.Before(wc.bannedUntil) {
+		return 0, true, nil
+	}
+
+	// Roll the fixed windows forward
+	currentStart := now.Truncate(s.window)
+	if elapsed := currentStart.Sub(wc.windowStart); elapsed > 0 {
+		if elapsed == s.window {
+			wc.prev = wc.curr
+		} else {
+			wc.prev = 0 // more than one full window passed
+		}
+		wc.curr = 0
+		wc.windowStart = currentStart
+	}
+
+	wc.curr++
+	return slidingWindowEstimate(wc.prev, wc.curr, now.Sub(currentStart), s.window), false, nil
+}
+
+func (s *memoryRateLimitStore) Ban(key string, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wc, exists := s.keys[key]
+	if !exists {
+		wc = &windowCounter{windowStart: time.Now().Truncate(s.window)}
+		s.keys[key] = wc
+	}
+	wc.bannedUntil = time.Now().Add(duration)
+	return nil
+}
+
+// cleanup removes idle keys and expired bans periodically
+func (s *memoryRateLimitStore) cleanup() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		now := time.Now()
+		for key, wc := range s.keys {
+			idle := now.Sub(wc.windowStart) > 2*s.window
+			if idle && now.After(wc.bannedUntil) {
+				delete(s.keys, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisRateLimitStore shares counters and bans across replicas
+// Keys: ratelimit:<key>:<window index> (counter, expires after 2 windows), ratelimit:ban:<key>
+type redisRateLimitStore struct {
+	client *redis.Client
+	window time.Duration
+}
+
+// NewRedisRateLimitStore creates a Redis-backed sliding window store
+func NewRedisRateLimitStore(client *redis.Client, window time.Duration) RateLimitStore {
+	return &redisRateLimitStore{client: client, window: window}
+}
+
+func (s *redisRateLimitStore) Hit(key string) (float64, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	now := time.Now()
+	idx := now.UnixNano() / int64(s.window)
+	currKey := "ratelimit:" + key + ":" + strconv.FormatInt(idx, 10)
+	prevKey := "ratelimit:" + key + ":" + strconv.FormatInt(idx-1, 10)
+
+	pipe := s.client.Pipeline()
+	banCmd := pipe.Exists(ctx, "ratelimit:ban:"+key)
+	currCmd := pipe.Incr(ctx, currKey)
+	pipe.Expire(ctx, currKey, 2*s.window)
+	prevCmd := pipe.Get(ctx, prevKey)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, false, err
+	}
+
+	if banCmd.Val() > 0 {
+		return 0, true, nil
+	}
+
+	prev, _ := prevCmd.Int64() // redis.Nil -> 0
+	elapsed := time.Duration(now.UnixNano() - idx*int64(s.window))
+	return slidingWindowEstimate(prev, currCmd.Val(), elapsed, s.window), false, nil
+}
+
+func (s *redisRateLimitStore) Ban(key string, duration time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	return s.client.Set(ctx, "ratelimit:ban:"+key, "1", duration).Err()
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequestTimeout attaches a deadline to the request context (c.UserContext())
+// Services and repositories run their queries with this context, so a slow database
+// cancels the query instead of piling up goroutines. Configure with REQUEST_TIMEOUT (default: 10s)
+func RequestTimeout() fiber.Handler {
+	timeout, err := time.ParseDuration(os.Getenv("REQUEST_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+
+		c.SetUserContext(ctx)
+		err := c.Next()
+
+		// Map a timed-out request to 503 unless the handler already produced a response
+		if ctx.Err() == context.DeadlineExceeded && err == nil && c.Response().StatusCode() >= fiber.StatusInternalServerError {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "request timed out"})
+		}
+		return err
+	}
+}
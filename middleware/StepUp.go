@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultStepUpMaxAge is how recent the login behind a token must be when STEP_UP_MAX_AGE is not set
+const defaultStepUpMaxAge = 10 * time.Minute
+
+// InitRecentAuth returns RequireRecentAuth with the maximum login age of STEP_UP_MAX_AGE (default 10m)
+func InitRecentAuth() fiber.Handler {
+	maxAge := defaultStepUpMaxAge
+	if v := os.Getenv("STEP_UP_MAX_AGE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			maxAge = d
+		}
+	}
+	return RequireRecentAuth(maxAge)
+}
+
+// RequireRecentAuth guards sensitive operations (password change, removing a factor, ...): like RequireAuth,
+// but the user must have authenticated within maxAge (auth_time claim), and users with a second factor must
+// have used it (acr "2"), so a password alone can't remove a factor. Refreshed tokens keep the auth_time and acr
+// of the login, so a long-lived session has to step up at /auth/step-up first. API keys never qualify.
+// Otherwise it answers 401 with an RFC 9470 insufficient_user_authentication challenge.
+func RequireRecentAuth(maxAge time.Duration) fiber.Handler {
+	maxAgeSeconds := int(maxAge.Seconds())
+	return func(c *fiber.Ctx) error {
+		claims, err := bearerClaims(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		if claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > maxAge {
+			c.Set(fiber.HeaderWWWAuthenticate, fmt.Sprintf(
+				`Bearer error="insufficient_user_authentication", error_description="a more recent authentication is required", max_age=%d`,
+				maxAgeSeconds))
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error":   "insufficient_user_authentication",
+				"message": "re-authenticate at /auth/step-up within the last " + strconv.Itoa(maxAgeSeconds) + " seconds",
+				"max_age": maxAgeSeconds,
+			})
+		}
+
+		if claims.ACR != "2" {
+			usesMFA, err := util.UsesMFA(c.UserContext(), claims.Subject)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to check authentication"})
+			}
+			if usesMFA {
+				c.Set(fiber.HeaderWWWAuthenticate,
+					`Bearer error="insufficient_user_authentication", error_description="the second factor is required", acr_values="2"`)
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error":      "insufficient_user_authentication",
+					"message":    "re-authenticate at /auth/step-up with your password and second factor",
+					"acr_values": "2",
+				})
+			}
+		}
+
+		return c.Next()
+	}
+}
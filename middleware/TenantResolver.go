@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"mein-idaas/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ResolveTenant checks the :tenant path parameter against TENANTS and scopes the request context to it
+// The tenant is also stored in c.Locals("tenant")
+func ResolveTenant(c *fiber.Ctx) error {
+	org := c.Params("tenant")
+	if !util.IsKnownTenant(org) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "unknown tenant"})
+	}
+
+	c.Locals("tenant", org)
+	c.SetUserContext(util.WithTenant(c.UserContext(), org))
+	return c.Next()
+}
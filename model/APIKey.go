@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// APIKey authenticates scripts and service accounts as a user via the X-API-Key header
+// The key is "mk_<prefix>_<secret>": the prefix finds the row, only the hash of the secret is stored.
+type APIKey struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+	Name       string     `gorm:"size:100;not null"`
+	Prefix     string     `gorm:"size:16;not null;uniqueIndex"`
+	SecretHash string     `gorm:"size:64;not null"` // SHA256 of the secret
+	Scopes     StringList `gorm:"type:jsonb"`       // Empty for full access
+	ExpiresAt  *time.Time `gorm:"index"`            // NULL never expires
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+func (k *APIKey) BeforeCreate(_ *gorm.DB) (err error) {
+	if k.ID == uuid.Nil {
+		k.ID = uuid.New()
+	}
+	return
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccessToken is an opaque access token (ACCESS_TOKEN_FORMAT=opaque)
+// The client only gets a random string; the claims live here, so revoking the row takes effect immediately.
+type AccessToken struct {
+	TokenHash      string     `gorm:"size:64;primaryKey"` // SHA256 of the token
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;index"`
+	RefreshTokenID *uuid.UUID `gorm:"type:uuid;index"` // Session the token was issued with (nil for token exchange)
+	Claims         string     `gorm:"type:jsonb;not null"`
+	ExpiresAt      time.Time  `gorm:"not null;index"`
+	RevokedAt      *time.Time
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+}
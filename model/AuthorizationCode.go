@@ -0,0 +1,23 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuthorizationCode is a single-use code issued by /oauth/authorize and redeemed at /oauth/token
+type AuthorizationCode struct {
+	CodeHash            string    `gorm:"size:64;primaryKey"` // SHA256 of the code
+	ClientID            string    `gorm:"size:64;not null;index"`
+	UserID              uuid.UUID `gorm:"type:uuid;not null"`
+	RedirectURI         string    `gorm:"type:text;not null"`
+	Scope               string    `gorm:"type:text"`
+	CodeChallenge       string    `gorm:"size:128"` // PKCE (RFC 7636), S256 only
+	CodeChallengeMethod string    `gorm:"size:10"`
+	Nonce               string    `gorm:"type:text"` // OIDC nonce, echoed in the ID token
+	AuthTime            time.Time `gorm:"not null"`
+	ExpiresAt           time.Time `gorm:"not null;index"`
+	UsedAt              *time.Time
+	CreatedAt           time.Time `gorm:"autoCreateTime"`
+}
@@ -11,11 +11,17 @@ type Credential struct {
 	ID     uuid.UUID `gorm:"type:uuid;primaryKey"`
 	UserID uuid.UUID `gorm:"type:uuid;not null;index:idx_user_credential,unique"`
 	//Type      string    `gorm:"size:50;not null"`   "Deprecated"
-	Type      CredentialType `gorm:"size:50;not null;index:idx_user_credential,unique"`
-	Value     string         `gorm:"type:text;not null"` // hashed password or encrypted API key
+	Type      CredentialType `gorm:"size:50;not null;index:idx_user_credential,unique;index:idx_credential_type_value,unique"`
+	Value     string         `gorm:"type:text;not null;index:idx_credential_type_value,unique"` // hashed password, encrypted API key or provider subject ID
 	Active    bool           `gorm:"default:true"`
 	CreatedAt time.Time      `gorm:"autoCreateTime"`
 	UpdatedAt time.Time      `gorm:"autoUpdateTime"`
+	// When the password was set (password credentials only, see PASSWORD_MAX_AGE)
+	PasswordChangedAt *time.Time
+	// Set by the password hash scan when the hash uses a legacy scheme or outdated Argon2 parameters
+	NeedsRehash bool `gorm:"not null;default:false;index"`
+	// Set for passwords chosen by an admin (temporary password, admin reset): the next login has to replace it
+	MustChangePassword bool `gorm:"not null;default:false"`
 
 	// Foreign Key
 	User User `gorm:"foreignKey:UserID"`
@@ -25,5 +31,32 @@ func (c *Credential) BeforeCreate(tx *gorm.DB) (err error) {
 	if c.ID == uuid.Nil {
 		c.ID = uuid.New()
 	}
+	if c.Type == CredTypePassword && c.PasswordChangedAt == nil {
+		now := time.Now()
+		c.PasswordChangedAt = &now
+	}
 	return
 }
+
+// SetPassword stores a new password hash chosen by the user and records when it was set
+func (c *Credential) SetPassword(hash string) {
+	now := time.Now()
+	c.Value = hash
+	c.PasswordChangedAt = &now
+	c.NeedsRehash = false
+	c.MustChangePassword = false
+}
+
+// SetTemporaryPassword stores a password hash set by an admin; the user must change it at the next login
+func (c *Credential) SetTemporaryPassword(hash string) {
+	c.SetPassword(hash)
+	c.MustChangePassword = true
+}
+
+// PasswordSetAt returns when the password was set; rows from before PasswordChangedAt existed fall back to UpdatedAt
+func (c *Credential) PasswordSetAt() time.Time {
+	if c.PasswordChangedAt != nil {
+		return *c.PasswordChangedAt
+	}
+	return c.UpdatedAt
+}
@@ -1,5 +1,7 @@
 package model
 
+import "strings"
+
 // 1. Define the custom type (underlying type is string)
 type CredentialType string
 
@@ -9,15 +11,26 @@ const (
 	CredTypeGoogle   CredentialType = "google"
 	CredTypeFacebook CredentialType = "facebook"
 	CredTypeGithub   CredentialType = "github"
+	CredTypeApple    CredentialType = "apple"
 	CredTypeZalo     CredentialType = "zalo" // easy to add new ones here
 	CredTypePornhub  CredentialType = "pornhub"
+	CredTypeDevice   CredentialType = "device" // Hashed device secret of a guest account
+	CredTypeLDAP     CredentialType = "ldap"   // Directory ID of an LDAP / Active Directory account
+
+	// CredTypeOIDCPrefix + provider name: identity at an upstream OIDC provider (federation)
+	CredTypeOIDCPrefix = "oidc:"
 )
 
+// OIDCCredentialType returns the credential type of an upstream OIDC provider
+func OIDCCredentialType(provider string) CredentialType {
+	return CredentialType(CredTypeOIDCPrefix + provider)
+}
+
 // Optional: Helper to validate if a string is a valid enum
 func (ct CredentialType) IsValid() bool {
 	switch ct {
-	case CredTypePassword, CredTypeGoogle, CredTypeFacebook, CredTypeGithub, CredTypeZalo, CredTypePornhub:
+	case CredTypePassword, CredTypeGoogle, CredTypeFacebook, CredTypeGithub, CredTypeApple, CredTypeZalo, CredTypePornhub, CredTypeDevice, CredTypeLDAP:
 		return true
 	}
-	return false
+	return strings.HasPrefix(string(ct), CredTypeOIDCPrefix) && len(ct) > len(CredTypeOIDCPrefix)
 }
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Group is a team of users (user_groups) mapped to roles (group_roles): members get the group's roles
+// on top of the roles granted to them directly, so access can be managed per team.
+type Group struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Code        string    `gorm:"size:50;not null;uniqueIndex"`
+	Name        string    `gorm:"size:100;not null;uniqueIndex"`
+	Description string    `gorm:"size:255"`
+	Roles       []Role    `gorm:"many2many:group_roles;constraint:OnDelete:CASCADE;"`
+	// Never preloaded: groups can be large, members are read page by page
+	Members   []User    `gorm:"many2many:user_groups;constraint:OnDelete:CASCADE;"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+func (g *Group) BeforeCreate(_ *gorm.DB) (err error) {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Invite lets one person register while registration is restricted
+type Invite struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex"` // SHA256 of the invite token
+	Email     string     `gorm:"size:255"`                     // Optional: only this email may use the invite
+	CreatedBy uuid.UUID  `gorm:"type:uuid"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"index"`
+	UsedBy    *uuid.UUID `gorm:"type:uuid"`
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
+
+func (i *Invite) BeforeCreate(_ *gorm.DB) (err error) {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Grant types a client can be registered for
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// TokenTypeAccessToken identifies access tokens in token exchange requests (RFC 8693)
+const TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// Token endpoint authentication methods (RFC 7591)
+const (
+	AuthMethodNone        = "none"
+	AuthMethodSecretBasic = "client_secret_basic"
+	AuthMethodSecretPost  = "client_secret_post"
+)
+
+// OAuthClient is a third-party application allowed to log users in via /oauth/authorize
+// Public clients (mobile/SPA) have no secret and must use PKCE
+type OAuthClient struct {
+	ID           string     `gorm:"size:64;primaryKey"` // client_id
+	Name         string     `gorm:"size:100;not null"`
+	SecretHash   string     `gorm:"size:64"` // SHA256 of the client secret, empty for public clients
+	RedirectURIs StringList `gorm:"type:jsonb;not null"`
+	GrantTypes   StringList `gorm:"type:jsonb"`                        // Empty allows authorization_code and refresh_token
+	Scope        string     `gorm:"type:text"`                         // Space-separated scopes the client may request, empty allows all
+	AuthMethod   string     `gorm:"size:32"`                           // token_endpoint_auth_method, empty accepts any
+	Tenant       string     `gorm:"size:63;not null;default:'';index"` // Organization the client belongs to ('' = global)
+	CreatedAt    time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt    time.Time  `gorm:"autoUpdateTime"`
+}
+
+// IsPublic reports whether the client can't keep a secret
+func (c *OAuthClient) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsGrant reports whether the client may use the grant type
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	if len(c.GrantTypes) == 0 {
+		return grantType == GrantAuthorizationCode || grantType == GrantRefreshToken
+	}
+	for _, g := range c.GrantTypes {
+		if g == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScope reports whether the client may request the scope
+func (c *OAuthClient) AllowsScope(scope string) bool {
+	if c.Scope == "" {
+		return true
+	}
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// StringList is stored as a JSON array
+type StringList []string
+
+// Value stores the list as a JSON array
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads a JSON array from the database
+func (l *StringList) Scan(value interface{}) error {
+	if value == nil {
+		*l = nil
+		return nil
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("unsupported type for StringList")
+	}
+	return json.Unmarshal(data, l)
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OutboxStatus tracks the delivery state of an outbox event
+type OutboxStatus string
+
+const (
+	OutboxStatusPending   OutboxStatus = "pending"
+	OutboxStatusDelivered OutboxStatus = "delivered"
+	OutboxStatusDead      OutboxStatus = "dead" // gave up after max attempts
+)
+
+// Outbox event types (identity events, also delivered to webhooks)
+const (
+	EventUserRegistered        = "user.registered"
+	EventUserEmailVerified     = "user.email_verified"
+	EventUserPasswordChanged   = "user.password_changed"
+	EventUserPasswordReset     = "user.password_reset"
+	EventUserMFAEnabled        = "user.mfa_enabled"
+	EventUserMFAResetRequested = "user.mfa_reset_requested"
+	EventUserMFAReset          = "user.mfa_reset"
+	EventUserIdentityLinked    = "user.identity_linked"
+	EventUserIdentityUnlinked  = "user.identity_unlinked"
+	EventUserLocked            = "user.locked"
+	EventUserSessionsRevoked   = "user.sessions_revoked"
+	EventUserTokenReuse        = "user.token_reuse_detected"
+	// A refresh token came from another client (REFRESH_BINDING_POLICY=revoke)
+	EventUserSessionBindingMismatch = "user.session_binding_mismatch"
+)
+
+// IdentityEventTypes lists every event type emitted by the service
+var IdentityEventTypes = []string{
+	EventUserRegistered,
+	EventUserEmailVerified,
+	EventUserPasswordChanged,
+	EventUserPasswordReset,
+	EventUserMFAEnabled,
+	EventUserMFAResetRequested,
+	EventUserMFAReset,
+	EventUserIdentityLinked,
+	EventUserIdentityUnlinked,
+	EventUserLocked,
+	EventUserSessionsRevoked,
+	EventUserTokenReuse,
+	EventUserSessionBindingMismatch,
+}
+
+// OutboxEvent is written in the same transaction as the change that caused it,
+// then delivered asynchronously by the outbox dispatcher (at-least-once)
+type OutboxEvent struct {
+	ID            uuid.UUID    `gorm:"type:uuid;primaryKey"` // Also used as the deduplication ID by consumers
+	Type          string       `gorm:"size:100;not null;index"`
+	Payload       string       `gorm:"type:jsonb;not null"`
+	Status        OutboxStatus `gorm:"size:20;not null;default:pending;index:idx_outbox_pending,priority:1"`
+	Attempts      int          `gorm:"not null;default:0"`
+	NextAttemptAt time.Time    `gorm:"not null;index:idx_outbox_pending,priority:2"`
+	LastError     string       `gorm:"type:text"`
+	CreatedAt     time.Time    `gorm:"autoCreateTime"`
+	DeliveredAt   *time.Time
+}
+
+func (e *OutboxEvent) BeforeCreate(_ *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	if e.Status == "" {
+		e.Status = OutboxStatusPending
+	}
+	if e.NextAttemptAt.IsZero() {
+		e.NextAttemptAt = time.Now()
+	}
+	return nil
+}
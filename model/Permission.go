@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Permission is a fine-grained right granted through roles (role_permissions), e.g. "users:read"
+// Access tokens carry the permissions of the user's roles, so resource servers can check them
+// instead of hardcoding role codes.
+type Permission struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Code        string    `gorm:"size:100;not null;uniqueIndex"`
+	Description string    `gorm:"size:255"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+}
+
+func (p *Permission) BeforeCreate(_ *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,9 +12,18 @@ type RefreshToken struct {
 	ID                uuid.UUID  `gorm:"type:uuid;primaryKey"`
 	UserID            uuid.UUID  `gorm:"type:uuid;not null;index"`
 	TokenHash         string     `gorm:"type:text;not null;uniqueIndex"` // Hash of actual token
+	FamilyID          uuid.UUID  `gorm:"type:uuid;index"`                // ID of the login's first token, shared by all its rotations
 	ClientIP          string     `gorm:"size:45"`                        // IPv6 support
 	UserAgent         string     `gorm:"type:text"`
+	Scope             string     `gorm:"type:text"`                   // Scopes granted via OAuth, empty for first-party logins (full access)
+	ClientID          string     `gorm:"size:64;not null;default:''"` // OAuth client the token was issued to, empty for first-party logins
+	AuthTime          *time.Time // When the user logged in; kept across rotations (auth_time claim)
+	AMR               string     `gorm:"size:64"` // Space-separated authentication methods of the login (amr claim)
+	DPoPJKT           string     `gorm:"size:64"` // DPoP key thumbprint the token is bound to (RFC 9449), empty if unbound
+	RememberMe        bool       // Login with remember me: JWT_REMEMBER_ME_TTL and a persistent cookie, kept across rotations
 	ExpiresAt         time.Time  `gorm:"not null;index"`
+	AbsoluteExpiresAt *time.Time // Hard end of the login with JWT_REFRESH_ABSOLUTE_TTL: rotations never extend ExpiresAt past it
+	LastUsedAt        *time.Time // Last activity of the session (login or refresh), for JWT_REFRESH_IDLE_TIMEOUT
 	ReplacedAt        *time.Time // When it was rotated
 	ReplacedByTokenID *uuid.UUID // Points to the new child token
 	RevokedAt         *time.Time `gorm:"index"` // NULL if not revoked
@@ -30,6 +40,40 @@ func (rt *RefreshToken) BeforeCreate(_ *gorm.DB) error {
 	return nil
 }
 
+// ScopeOfflineAccess requests a long-lived refresh token (JWT_OFFLINE_REFRESH_TTL)
+const ScopeOfflineAccess = "offline_access"
+
+// GrantsScope reports whether the scope was granted with the token
+func (rt *RefreshToken) GrantsScope(scope string) bool {
+	for _, s := range strings.Fields(rt.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOffline reports whether the token was issued for offline_access
+func (rt *RefreshToken) IsOffline() bool {
+	return rt.GrantsScope(ScopeOfflineAccess)
+}
+
+// LoginTime returns when the session started: each rotation creates a new row, the login time is kept in AuthTime
+func (rt *RefreshToken) LoginTime() time.Time {
+	if rt.AuthTime != nil {
+		return *rt.AuthTime
+	}
+	return rt.CreatedAt
+}
+
+// LastUsed returns the last activity of the session; tokens from before last_used_at was recorded use their creation
+func (rt *RefreshToken) LastUsed() time.Time {
+	if rt.LastUsedAt != nil {
+		return *rt.LastUsedAt
+	}
+	return rt.CreatedAt
+}
+
 // IsValid checks if refresh token is still usable
 func (rt *RefreshToken) IsValid() bool {
 	return time.Now().Before(rt.ExpiresAt) && rt.RevokedAt == nil
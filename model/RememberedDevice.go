@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RememberedDevice lets logins from a browser or app skip MFA until ExpiresAt ("remember this device")
+// The device keeps the token in a cookie; only its hash is stored.
+type RememberedDevice struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null;index"`
+	TokenHash  string    `gorm:"size:64;not null;uniqueIndex"`
+	UserAgent  string    `gorm:"type:text"`
+	ClientIP   string    `gorm:"size:45"`
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+	LastUsedAt time.Time
+	ExpiresAt  time.Time `gorm:"not null;index"`
+}
+
+func (d *RememberedDevice) BeforeCreate(_ *gorm.DB) (err error) {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return
+}
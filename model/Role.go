@@ -11,11 +11,15 @@ type Role struct {
 	ID   uuid.UUID `gorm:"type:uuid;primaryKey;uniqueIndex"`
 	Name string    `gorm:"size:50;not null;uniqueIndex"`
 
-	Code        string    `gorm:"size:50;not null;uniqueIndex"`
-	Description string    `gorm:"size:255"`
-	IsSystem    bool      `gorm:"default:false"`
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
-	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+	Code        string       `gorm:"size:50;not null;uniqueIndex"`
+	Description string       `gorm:"size:255"`
+	IsSystem    bool         `gorm:"default:false"`
+	Permissions []Permission `gorm:"many2many:role_permissions;constraint:OnDelete:CASCADE;"`
+	// Roles this role includes (admin inherits moderator, moderator inherits user): their permissions and
+	// codes are granted with it. role_inherits is kept free of cycles.
+	Inherits  []*Role   `gorm:"many2many:role_inherits;joinForeignKey:RoleID;joinReferences:InheritedRoleID;constraint:OnDelete:CASCADE;"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
 }
 
 func (r *Role) BeforeCreate(_ *gorm.DB) (err error) {
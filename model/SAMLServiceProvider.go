@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// NameID formats a service provider can be registered with
+const (
+	NameIDEmail      = "email"      // The user's email address
+	NameIDPersistent = "persistent" // The user ID, stable across email changes
+)
+
+// SAMLServiceProvider is a SAML 2.0 SP (legacy enterprise app) allowed to sign users in via /saml/sso
+// Assertions are only posted to the registered ACS URL; AuthnRequest signatures are not required.
+type SAMLServiceProvider struct {
+	ID            uuid.UUID `gorm:"type:uuid;primaryKey"`
+	EntityID      string    `gorm:"size:255;not null;uniqueIndex:idx_saml_sp_tenant_entity"`
+	Name          string    `gorm:"size:100;not null"`
+	ACSURL        string    `gorm:"type:text;not null"` // Assertion Consumer Service (HTTP-POST binding)
+	NameIDFormat  string    `gorm:"size:20;not null;default:'email'"`
+	RoleAttribute string    `gorm:"size:255;not null;default:'roles'"`                                 // Attribute carrying the user's roles
+	Tenant        string    `gorm:"size:63;not null;default:'';uniqueIndex:idx_saml_sp_tenant_entity"` // Organization the SP belongs to ('' = global)
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime"`
+}
+
+func (sp *SAMLServiceProvider) BeforeCreate(_ *gorm.DB) error {
+	if sp.ID == uuid.Nil {
+		sp.ID = uuid.New()
+	}
+	return nil
+}
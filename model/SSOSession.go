@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SSOSession is a browser session of the hosted login pages (idaas_sso cookie)
+// A session of a user with MFA starts as MFAPending and only counts as signed in once the TOTP code is checked.
+type SSOSession struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index"`
+	TokenHash   string     `gorm:"type:text;not null;uniqueIndex"` // Hash of the cookie value
+	MFAPending  bool       `gorm:"default:false"`
+	MFAFailures int        `gorm:"default:0"`
+	AuthTime    time.Time  `gorm:"not null"` // When the user entered their password (OIDC auth_time)
+	ClientIP    string     `gorm:"size:45"`
+	UserAgent   string     `gorm:"type:text"`
+	ExpiresAt   time.Time  `gorm:"not null;index"`
+	RevokedAt   *time.Time `gorm:"index"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime"`
+}
+
+func (s *SSOSession) BeforeCreate(_ *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
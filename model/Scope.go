@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// ScopeProfile grants the user's name and roles; tokens of OAuth clients only carry roles and permissions with it
+const ScopeProfile = "profile"
+
+// Scope is a permission a client can request at /oauth/authorize
+// The description is what users see on the consent page.
+type Scope struct {
+	Name        string    `gorm:"size:64;primaryKey"`
+	Description string    `gorm:"size:255;not null"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+}
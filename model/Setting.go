@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// Setting is a runtime-editable configuration value shared by all replicas
+type Setting struct {
+	Key       string    `gorm:"size:100;primaryKey"`
+	Value     string    `gorm:"type:jsonb;not null"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// SettingKeyRegistration holds the registration policy (see dto.RegistrationSettings)
+const SettingKeyRegistration = "registration"
+
+// SettingKeyRoleHierarchySeeded marks that the default role hierarchy was seeded once (admin -> moderator -> user),
+// so inheritance removed by admins is not restored on the next start
+const SettingKeyRoleHierarchySeeded = "role_hierarchy_seeded"
+
+// SettingKeyProvisioning holds the JIT provisioning policy of federated logins (see dto.ProvisioningSettings)
+const SettingKeyProvisioning = "provisioning"
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// SigningKey is a JWT signing key created by key rotation (shared by all replicas)
+// The private key is AES-GCM encrypted with SIGNING_KEY_SECRET. Keys imported from the
+// environment when they were first rotated out have no private key (verification only).
+type SigningKey struct {
+	Kid        string     `gorm:"size:64;primaryKey"`                // RFC 7638 thumbprint
+	Tenant     string     `gorm:"size:63;not null;default:'';index"` // Organization whose tokens the key signs, '' for the global keys
+	Algorithm  string     `gorm:"size:10;not null"`
+	PrivateKey string     `gorm:"type:text"`
+	PublicKey  string     `gorm:"type:text;not null"` // PKIX PEM
+	CreatedAt  time.Time  `gorm:"autoCreateTime"`
+	RetiresAt  *time.Time `gorm:"index"` // Set when rotated out; nil for the active key
+}
@@ -1,6 +1,9 @@
 package model
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,23 +11,69 @@ import (
 )
 
 type User struct {
-	ID              uuid.UUID `gorm:"type:uuid;primaryKey"`
-	Name            string    `gorm:"size:50;not null"`
-	IsEmailVerified bool      `gorm:"default:false"` // Critical for Identity Systems
-	Email           string    `gorm:"size:255;not null;uniqueIndex"`
-	CreatedAt       time.Time `gorm:"autoCreateTime"`
-	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
-	IsMFAEnabled    bool      `gorm:"default:false"`
-	MFASecret       string    `gorm:"type:text"`
-	BackupCodes     string    `gorm:"type:text"`
-
-	Credentials   []Credential   `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
-	RefreshTokens []RefreshToken `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
-	Roles         []Role         `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE;"`
+	ID                uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	Name              string     `gorm:"size:50;not null"`
+	IsEmailVerified   bool       `gorm:"default:false"` // Critical for Identity Systems
+	Email             string     `gorm:"size:255;not null;uniqueIndex"`
+	Username          *string    `gorm:"size:32;uniqueIndex"` // Optional login name, lowercase
+	Phone             *string    `gorm:"size:20;uniqueIndex"` // E.164, set only once verified
+	IsPhoneVerified   bool       `gorm:"default:false"`
+	IsAnonymous       bool       `gorm:"default:false;index"`               // Guest account, upgraded in place when claimed
+	Tenant            string     `gorm:"size:63;not null;default:'';index"` // Organization slug (multi-tenancy), '' for the global tenant
+	CreatedAt         time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time  `gorm:"autoUpdateTime"`
+	IsMFAEnabled      bool       `gorm:"default:false"` // TOTP authenticator
+	IsSMSMFAEnabled   bool       `gorm:"default:false"` // SMS code to the verified phone as second factor
+	IsEmailMFAEnabled bool       `gorm:"default:false"` // Code sent to the verified email as second factor
+	MFASecret         string     `gorm:"type:text"`
+	MFAResetAt        *time.Time // Pending self-service MFA reset, applied at the first login after this time
+	FailedLogins      int        `gorm:"not null;default:0"` // Consecutive failed password logins (LOCKOUT_THRESHOLD)
+	LockedUntil       *time.Time // Password logins are refused until this time
+	TokenVersion      int        `gorm:"not null;default:0"` // Access tokens with an older token_version claim are rejected (logout-all)
+	BackupCodes       string     `gorm:"type:text"`
+	Metadata          JSONB      `gorm:"type:jsonb"` // Extra registration fields (see REGISTRATION_FIELDS)
+
+	Credentials   []Credential       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	RefreshTokens []RefreshToken     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	RecoveryCodes []RecoveryCode     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	Devices       []RememberedDevice `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	APIKeys       []APIKey           `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	Roles         []Role             `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE;"`
 }
 
 type JSONB map[string]interface{}
 
+// Value stores the map as a JSON document (NULL when empty)
+func (b JSONB) Value() (driver.Value, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads a JSON document from the database
+func (b *JSONB) Scan(value interface{}) error {
+	if value == nil {
+		*b = nil
+		return nil
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("unsupported type for JSONB")
+	}
+	return json.Unmarshal(data, b)
+}
+
 func (b *User) BeforeCreate(_ *gorm.DB) (err error) {
 	if b.ID == uuid.Nil {
 		b.ID = uuid.New()
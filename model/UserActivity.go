@@ -0,0 +1,14 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserActivity records that a user was issued a token on a given (UTC) day
+// One row per user per day: the source of DAU/MAU reporting
+type UserActivity struct {
+	Day    time.Time `gorm:"type:date;primaryKey"`
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+}
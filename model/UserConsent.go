@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserConsent records the scopes a user granted to an OAuth client
+// Authorization requests within the granted scopes skip the consent page; revoking the consent asks again.
+type UserConsent struct {
+	UserID    uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	ClientID  string     `gorm:"size:64;primaryKey;index"`
+	Scopes    StringList `gorm:"type:jsonb;not null"`
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt time.Time  `gorm:"autoUpdateTime"`
+}
+
+// Covers reports whether all scopes were granted
+func (c *UserConsent) Covers(scopes []string) bool {
+	granted := make(map[string]bool, len(c.Scopes))
+	for _, s := range c.Scopes {
+		granted[s] = true
+	}
+	for _, s := range scopes {
+		if !granted[s] {
+			return false
+		}
+	}
+	return true
+}
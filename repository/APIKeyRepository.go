@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"mein-idaas/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *model.APIKey) error
+	// GetActiveByPrefix returns the key unless it is revoked or expired
+	GetActiveByPrefix(ctx context.Context, prefix string) (*model.APIKey, error)
+	// ListByUser returns the user's keys that are not revoked, newest first
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error)
+	// Revoke revokes one key of the user and reports whether it was active
+	Revoke(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error)
+	// RevokeAllForUser revokes every active key of the user (password reset, logout-all)
+	RevokeAllForUser(ctx context.Context, userID uuid.UUID) error
+	// Touch records the use of a key
+	Touch(ctx context.Context, id uuid.UUID) error
+}
+
+type pgAPIKeyRepo struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) APIKeyRepository {
+	return &pgAPIKeyRepo{db: db}
+}
+
+func (r *pgAPIKeyRepo) Create(ctx context.Context, key *model.APIKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+func (r *pgAPIKeyRepo) GetActiveByPrefix(ctx context.Context, prefix string) (*model.APIKey, error) {
+	var k model.APIKey
+	err := r.db.WithContext(ctx).
+		Where("prefix = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", prefix, time.Now()).
+		First(&k).Error
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *pgAPIKeyRepo) ListByUser(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+func (r *pgAPIKeyRepo) Revoke(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.APIKey{}).
+		Where("user_id = ? AND id = ? AND revoked_at IS NULL", userID, id).
+		Update("revoked_at", time.Now())
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *pgAPIKeyRepo) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&model.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *pgAPIKeyRepo) Touch(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&model.APIKey{}).
+		Where("id = ?", id).
+		Update("last_used_at", time.Now()).Error
+}
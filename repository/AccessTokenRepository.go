@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"mein-idaas/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AccessTokenRepository interface {
+	Create(ctx context.Context, token *model.AccessToken) error
+	// GetActive returns the token unless it is revoked or expired
+	GetActive(ctx context.Context, tokenHash string) (*model.AccessToken, error)
+	// RevokeByHash revokes a single token and reports whether it was active
+	RevokeByHash(ctx context.Context, tokenHash string) (bool, error)
+	RevokeByRefreshToken(ctx context.Context, refreshID uuid.UUID) error
+	RevokeAllForUser(ctx context.Context, userID uuid.UUID) error
+}
+
+type pgAccessTokenRepo struct {
+	db *gorm.DB
+}
+
+func NewAccessTokenRepository(db *gorm.DB) AccessTokenRepository {
+	return &pgAccessTokenRepo{db: db}
+}
+
+func (r *pgAccessTokenRepo) Create(ctx context.Context, token *model.AccessToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+func (r *pgAccessTokenRepo) GetActive(ctx context.Context, tokenHash string) (*model.AccessToken, error) {
+	var t model.AccessToken
+	err := r.db.WithContext(ctx).
+		Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", tokenHash, time.Now()).
+		First(&t).Error
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *pgAccessTokenRepo) RevokeByHash(ctx context.Context, tokenHash string) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.AccessToken{}).
+		Where("token_hash = ? AND revoked_at IS NULL", tokenHash).
+		Update("revoked_at", time.Now())
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *pgAccessTokenRepo) RevokeByRefreshToken(ctx context.Context, refreshID uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&model.AccessToken{}).
+		Where("refresh_token_id = ? AND revoked_at IS NULL", refreshID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *pgAccessTokenRepo) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&model.AccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
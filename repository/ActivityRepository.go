@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"mein-idaas/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DailyActiveUsers is one point of the DAU/MAU series
+type DailyActiveUsers struct {
+	Day time.Time
+	DAU int64
+	MAU int64 // Distinct users in the 30 days ending on Day
+}
+
+// MonthlyActiveUsers is the number of distinct users in a calendar month
+type MonthlyActiveUsers struct {
+	Month       time.Time
+	ActiveUsers int64
+}
+
+type ActivityRepository interface {
+	// Record marks the user active on day (idempotent)
+	Record(ctx context.Context, day time.Time, userID uuid.UUID) error
+	DailySeries(ctx context.Context, from, to time.Time) ([]DailyActiveUsers, error)
+	MonthlySeries(ctx context.Context, from, to time.Time) ([]MonthlyActiveUsers, error)
+}
+
+type pgActivityRepo struct {
+	db *gorm.DB
+}
+
+func NewActivityRepository(db *gorm.DB) ActivityRepository {
+	return &pgActivityRepo{db: db}
+}
+
+func (r *pgActivityRepo) Record(ctx context.Context, day time.Time, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.UserActivity{Day: day, UserID: userID}).Error
+}
+
+func (r *pgActivityRepo) DailySeries(ctx context.Context, from, to time.Time) ([]DailyActiveUsers, error) {
+	var rows []DailyActiveUsers
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT d::date AS day,
+		       (SELECT COUNT(*) FROM user_activities a WHERE a.day = d::date) AS dau,
+		       (SELECT COUNT(DISTINCT a.user_id) FROM user_activities a
+		         WHERE a.day > d::date - 30 AND a.day <= d::date) AS mau
+		FROM generate_series(?::date, ?::date, interval '1 day') AS d
+		ORDER BY d`, from, to).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (r *pgActivityRepo) MonthlySeries(ctx context.Context, from, to time.Time) ([]MonthlyActiveUsers, error) {
+	var rows []MonthlyActiveUsers
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT date_trunc('month', day)::date AS month, COUNT(DISTINCT user_id) AS active_users
+		FROM user_activities
+		WHERE day >= date_trunc('month', ?::date) AND day <= ?::date
+		GROUP BY 1
+		ORDER BY 1`, from, to).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
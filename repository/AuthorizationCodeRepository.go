@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"mein-idaas/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AuthorizationCodeRepository interface {
+	Create(ctx context.Context, code *model.AuthorizationCode) error
+	// Consume marks an unused, unexpired code as used and returns it.
+	// The conditional UPDATE makes concurrent redemptions of the same code safe: only one wins.
+	Consume(ctx context.Context, codeHash string) (*model.AuthorizationCode, error)
+}
+
+type pgAuthorizationCodeRepo struct {
+	db *gorm.DB
+}
+
+func NewAuthorizationCodeRepository(db *gorm.DB) AuthorizationCodeRepository {
+	return &pgAuthorizationCodeRepo{db: db}
+}
+
+func (r *pgAuthorizationCodeRepo) Create(ctx context.Context, code *model.AuthorizationCode) error {
+	return r.db.WithContext(ctx).Create(code).Error
+}
+
+func (r *pgAuthorizationCodeRepo) Consume(ctx context.Context, codeHash string) (*model.AuthorizationCode, error) {
+	var codes []model.AuthorizationCode
+	now := time.Now()
+	res := r.db.WithContext(ctx).Model(&codes).
+		Clauses(clause.Returning{}).
+		Where("code_hash = ? AND used_at IS NULL AND expires_at > ?", codeHash, now).
+		Update("used_at", now)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if len(codes) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &codes[0], nil
+}
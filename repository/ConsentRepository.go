@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+
+	"mein-idaas/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ConsentRepository interface {
+	Get(ctx context.Context, userID uuid.UUID, clientID string) (*model.UserConsent, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]model.UserConsent, error)
+	Upsert(ctx context.Context, consent *model.UserConsent) error
+	// Delete removes the consent and reports whether one existed
+	Delete(ctx context.Context, userID uuid.UUID, clientID string) (bool, error)
+}
+
+type pgConsentRepo struct {
+	db *gorm.DB
+}
+
+func NewConsentRepository(db *gorm.DB) ConsentRepository {
+	return &pgConsentRepo{db: db}
+}
+
+func (r *pgConsentRepo) Get(ctx context.Context, userID uuid.UUID, clientID string) (*model.UserConsent, error) {
+	var c model.UserConsent
+	if err := r.db.WithContext(ctx).First(&c, "user_id = ? AND client_id = ?", userID, clientID).Error; err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *pgConsentRepo) ListByUser(ctx context.Context, userID uuid.UUID) ([]model.UserConsent, error) {
+	var consents []model.UserConsent
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("updated_at DESC").Find(&consents).Error; err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+func (r *pgConsentRepo) Upsert(ctx context.Context, consent *model.UserConsent) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scopes", "updated_at"}),
+	}).Create(consent).Error
+}
+
+func (r *pgConsentRepo) Delete(ctx context.Context, userID uuid.UUID, clientID string) (bool, error) {
+	res := r.db.WithContext(ctx).Where("user_id = ? AND client_id = ?", userID, clientID).Delete(&model.UserConsent{})
+	return res.RowsAffected > 0, res.Error
+}
@@ -1,18 +1,24 @@
 package repository
 
 import (
+	"context"
 	"mein-idaas/model"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CredentialRepository interface {
-	Create(cred *model.Credential) error
-	GetByID(id uuid.UUID) (*model.Credential, error)
-	GetByUserIDAndType(userID uuid.UUID, credType string) (*model.Credential, error)
-	Update(cred *model.Credential) error
-	Delete(id uuid.UUID) error
+	Create(ctx context.Context, cred *model.Credential) error
+	GetByID(ctx context.Context, id uuid.UUID) (*model.Credential, error)
+	GetByUserIDAndType(ctx context.Context, userID uuid.UUID, credType string) (*model.Credential, error)
+	GetByTypeAndValue(ctx context.Context, credType model.CredentialType, value string) (*model.Credential, error)
+	ListByUserIDForUpdate(ctx context.Context, userID uuid.UUID) ([]model.Credential, error)
+	Update(ctx context.Context, cred *model.Credential) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	StreamPasswords(ctx context.Context, batchSize int, fn func(creds []model.Credential) error) error
+	SetNeedsRehash(ctx context.Context, ids []uuid.UUID, needsRehash bool) error
 }
 
 type pgCredentialRepo struct {
@@ -23,30 +29,69 @@ func NewCredentialRepository(db *gorm.DB) CredentialRepository {
 	return &pgCredentialRepo{db: db}
 }
 
-func (r *pgCredentialRepo) Create(cred *model.Credential) error {
-	return r.db.Create(cred).Error
+func (r *pgCredentialRepo) Create(ctx context.Context, cred *model.Credential) error {
+	return r.db.WithContext(ctx).Create(cred).Error
 }
 
-func (r *pgCredentialRepo) GetByID(id uuid.UUID) (*model.Credential, error) {
+func (r *pgCredentialRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.Credential, error) {
 	var c model.Credential
-	if err := r.db.First(&c, "id = ?", id).Error; err != nil {
+	if err := r.db.WithContext(ctx).First(&c, "id = ?", id).Error; err != nil {
 		return nil, err
 	}
 	return &c, nil
 }
 
-func (r *pgCredentialRepo) GetByUserIDAndType(userID uuid.UUID, credType string) (*model.Credential, error) {
+func (r *pgCredentialRepo) GetByUserIDAndType(ctx context.Context, userID uuid.UUID, credType string) (*model.Credential, error) {
 	var c model.Credential
-	if err := r.db.Where("user_id = ? AND type = ?", userID, credType).First(&c).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND type = ?", userID, credType).First(&c).Error; err != nil {
 		return nil, err
 	}
 	return &c, nil
 }
 
-func (r *pgCredentialRepo) Update(cred *model.Credential) error {
-	return r.db.Save(cred).Error
+// GetByTypeAndValue finds the credential of a linked identity (e.g. google + provider subject ID)
+func (r *pgCredentialRepo) GetByTypeAndValue(ctx context.Context, credType model.CredentialType, value string) (*model.Credential, error) {
+	var c model.Credential
+	if err := r.db.WithContext(ctx).Where("type = ? AND value = ?", credType, value).First(&c).Error; err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ListByUserIDForUpdate returns and row-locks every credential of a user (must run inside a transaction)
+// Concurrent credential removals for the same user serialize on these locks
+func (r *pgCredentialRepo) ListByUserIDForUpdate(ctx context.Context, userID uuid.UUID) ([]model.Credential, error) {
+	var creds []model.Credential
+	err := r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userID).Find(&creds).Error
+	if err != nil {
+		return nil, err
+	}
+	return creds, nil
 }
 
-func (r *pgCredentialRepo) Delete(id uuid.UUID) error {
-	return r.db.Delete(&model.Credential{}, "id = ?", id).Error
+func (r *pgCredentialRepo) Update(ctx context.Context, cred *model.Credential) error {
+	return r.db.WithContext(ctx).Save(cred).Error
+}
+
+func (r *pgCredentialRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.Credential{}, "id = ?", id).Error
+}
+
+// StreamPasswords walks every password credential in primary-key order, batchSize rows at a time
+func (r *pgCredentialRepo) StreamPasswords(ctx context.Context, batchSize int, fn func(creds []model.Credential) error) error {
+	var batch []model.Credential
+	return r.db.WithContext(ctx).Where("type = ?", model.CredTypePassword).Order("id").
+		FindInBatches(&batch, batchSize, func(_ *gorm.DB, _ int) error {
+			return fn(batch)
+		}).Error
+}
+
+// SetNeedsRehash sets the rehash flag of the given credentials (UpdatedAt is left alone)
+func (r *pgCredentialRepo) SetNeedsRehash(ctx context.Context, ids []uuid.UUID, needsRehash bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Model(&model.Credential{}).Where("id IN ?", ids).
+		UpdateColumn("needs_rehash", needsRehash).Error
 }
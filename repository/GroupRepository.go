@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+
+	"mein-idaas/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GroupRepository interface {
+	GetByCode(ctx context.Context, code string) (*model.Group, error)
+	List(ctx context.Context) ([]model.Group, error)
+	Create(ctx context.Context, group *model.Group) error
+	Update(ctx context.Context, group *model.Group) error
+	Delete(ctx context.Context, group *model.Group) ([]uuid.UUID, error)
+	SetRoles(ctx context.Context, group *model.Group, roles []model.Role) error
+	MemberIDs(ctx context.Context, group *model.Group) ([]uuid.UUID, error)
+	ListMembers(ctx context.Context, group *model.Group, offset int, limit int) ([]model.User, int64, error)
+	// AddMembers adds users to a group (members already in it are skipped); when some of the users don't exist,
+	// nothing is added and their IDs are returned
+	AddMembers(ctx context.Context, group *model.Group, userIDs []uuid.UUID) ([]uuid.UUID, error)
+	RemoveMember(ctx context.Context, group *model.Group, userID uuid.UUID) (bool, error)
+}
+
+type pgGroupRepo struct {
+	db *gorm.DB
+}
+
+func NewGroupRepository(db *gorm.DB) GroupRepository {
+	return &pgGroupRepo{db: db}
+}
+
+// GetByCode returns the group with the given code and its roles
+func (r *pgGroupRepo) GetByCode(ctx context.Context, code string) (*model.Group, error) {
+	var group model.Group
+	if err := r.db.WithContext(ctx).Preload("Roles", orderByCode).Where("code = ?", code).First(&group).Error; err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// List returns all groups with their roles
+func (r *pgGroupRepo) List(ctx context.Context) ([]model.Group, error) {
+	var groups []model.Group
+	if err := r.db.WithContext(ctx).Preload("Roles", orderByCode).Order("code").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (r *pgGroupRepo) Create(ctx context.Context, group *model.Group) error {
+	return r.db.WithContext(ctx).Create(group).Error
+}
+
+// Update saves the name and description of a group; the code never changes
+func (r *pgGroupRepo) Update(ctx context.Context, group *model.Group) error {
+	return r.db.WithContext(ctx).Model(group).Select("name", "description", "updated_at").Updates(group).Error
+}
+
+// Delete removes a group, its memberships and role mappings, returning its members
+func (r *pgGroupRepo) Delete(ctx context.Context, group *model.Group) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("user_groups").Where("group_id = ?", group.ID).Pluck("user_id", &userIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM user_groups WHERE group_id = ?", group.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM group_roles WHERE group_id = ?", group.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Group{}, "id = ?", group.ID).Error
+	})
+	return userIDs, err
+}
+
+// SetRoles replaces the roles a group grants its members
+func (r *pgGroupRepo) SetRoles(ctx context.Context, group *model.Group, roles []model.Role) error {
+	return r.db.WithContext(ctx).Model(group).Association("Roles").Replace(roles)
+}
+
+func (r *pgGroupRepo) MemberIDs(ctx context.Context, group *model.Group) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	if err := r.db.WithContext(ctx).Table("user_groups").Where("group_id = ?", group.ID).Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// ListMembers returns one page of the group's members (roles preloaded), ordered by email, and the number of members
+func (r *pgGroupRepo) ListMembers(ctx context.Context, group *model.Group, offset int, limit int) ([]model.User, int64, error) {
+	db := r.db.WithContext(ctx).Model(&model.User{}).
+		Joins("JOIN user_groups ON user_groups.user_id = users.id").
+		Where("user_groups.group_id = ?", group.ID).
+		Session(&gorm.Session{})
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []model.User
+	err := db.Preload("Roles").Order("users.email").Order("users.id").Offset(offset).Limit(limit).Find(&users).Error
+	return users, total, err
+}
+
+func (r *pgGroupRepo) AddMembers(ctx context.Context, group *model.Group, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	var missing []uuid.UUID
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing []uuid.UUID
+		if err := tx.Model(&model.User{}).Where("id IN ?", userIDs).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		found := make(map[uuid.UUID]bool, len(existing))
+		for _, id := range existing {
+			found[id] = true
+		}
+		for _, id := range userIDs {
+			if !found[id] {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			return nil
+		}
+
+		rows := make([]map[string]interface{}, 0, len(userIDs))
+		for _, id := range userIDs {
+			rows = append(rows, map[string]interface{}{"group_id": group.ID, "user_id": id})
+		}
+		return tx.Table("user_groups").Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+	})
+	return missing, err
+}
+
+func (r *pgGroupRepo) RemoveMember(ctx context.Context, group *model.Group, userID uuid.UUID) (bool, error) {
+	res := r.db.WithContext(ctx).Exec("DELETE FROM user_groups WHERE group_id = ? AND user_id = ?", group.ID, userID)
+	return res.RowsAffected > 0, res.Error
+}
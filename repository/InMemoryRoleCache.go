@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type roleCacheItem struct {
+	roles     []string
+	expiresAt time.Time
+}
+
+type memRoleCache struct {
+	data sync.Map // userID -> roleCacheItem
+	ttl  time.Duration
+}
+
+func NewInMemoryRoleCache(ttl time.Duration) RoleCache {
+	cache := &memRoleCache{ttl: ttl}
+
+	// Background Janitor to drop expired entries every 10 mins
+	go func() {
+		for {
+			time.Sleep(10 * time.Minute)
+			cache.data.Range(func(key, value interface{}) bool {
+				item := value.(roleCacheItem)
+				if time.Now().After(item.expiresAt) {
+					cache.data.Delete(key)
+				}
+				return true
+			})
+		}
+	}()
+
+	return cache
+}
+
+func (c *memRoleCache) Get(userID uuid.UUID) ([]string, bool) {
+	val, ok := c.data.Load(userID)
+	if !ok {
+		return nil, false
+	}
+
+	item := val.(roleCacheItem)
+	if time.Now().After(item.expiresAt) {
+		c.data.Delete(userID)
+		return nil, false
+	}
+
+	return item.roles, true
+}
+
+func (c *memRoleCache) Set(userID uuid.UUID, roles []string) {
+	c.data.Store(userID, roleCacheItem{
+		roles:     roles,
+		expiresAt: time.Now().Add(c.ttl),
+	})
+}
+
+func (c *memRoleCache) Invalidate(userID uuid.UUID) {
+	c.data.Delete(userID)
+}
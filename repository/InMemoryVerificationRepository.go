@@ -64,3 +64,12 @@ func (r *memVerificationRepo) Delete(key string) error {
 	r.data.Delete(key)
 	return nil
 }
+
+func (r *memVerificationRepo) Count() int {
+	n := 0
+	r.data.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
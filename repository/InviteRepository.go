@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"mein-idaas/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type InviteRepository interface {
+	Create(ctx context.Context, invite *model.Invite) error
+	// Consume marks a valid (unused, unexpired) invite as used by userID. Returns false if none matched.
+	// The conditional UPDATE makes concurrent redemptions of the same invite safe.
+	Consume(ctx context.Context, tokenHash string, email string, userID uuid.UUID) (bool, error)
+}
+
+type pgInviteRepo struct {
+	db *gorm.DB
+}
+
+func NewInviteRepository(db *gorm.DB) InviteRepository {
+	return &pgInviteRepo{db: db}
+}
+
+func (r *pgInviteRepo) Create(ctx context.Context, invite *model.Invite) error {
+	return r.db.WithContext(ctx).Create(invite).Error
+}
+
+func (r *pgInviteRepo) Consume(ctx context.Context, tokenHash string, email string, userID uuid.UUID) (bool, error) {
+	now := time.Now()
+	res := r.db.WithContext(ctx).Model(&model.Invite{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+		Where("email = '' OR LOWER(email) = LOWER(?)", email).
+		Updates(map[string]interface{}{"used_at": now, "used_by": userID})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+
+	"mein-idaas/model"
+
+	"gorm.io/gorm"
+)
+
+type OAuthClientRepository interface {
+	Create(ctx context.Context, client *model.OAuthClient) error
+	GetByID(ctx context.Context, id string) (*model.OAuthClient, error)
+}
+
+type pgOAuthClientRepo struct {
+	db *gorm.DB
+}
+
+func NewOAuthClientRepository(db *gorm.DB) OAuthClientRepository {
+	return &pgOAuthClientRepo{db: db}
+}
+
+func (r *pgOAuthClientRepo) Create(ctx context.Context, client *model.OAuthClient) error {
+	return r.db.WithContext(ctx).Create(client).Error
+}
+
+func (r *pgOAuthClientRepo) GetByID(ctx context.Context, id string) (*model.OAuthClient, error) {
+	var c model.OAuthClient
+	if err := r.db.WithContext(ctx).First(&c, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+
+	"mein-idaas/model"
+
+	"gorm.io/gorm"
+)
+
+type PermissionRepository interface {
+	List(ctx context.Context) ([]model.Permission, error)
+	GetByCodes(ctx context.Context, codes []string) ([]model.Permission, error)
+	Create(ctx context.Context, permission *model.Permission) error
+	Delete(ctx context.Context, code string) (bool, error)
+	// RoleMatrix returns the permission codes of every role that has any, keyed by role code
+	RoleMatrix(ctx context.Context) (map[string][]string, error)
+}
+
+type pgPermissionRepo struct {
+	db *gorm.DB
+}
+
+func NewPermissionRepository(db *gorm.DB) PermissionRepository {
+	return &pgPermissionRepo{db: db}
+}
+
+func (r *pgPermissionRepo) List(ctx context.Context) ([]model.Permission, error) {
+	var permissions []model.Permission
+	if err := r.db.WithContext(ctx).Order("code").Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (r *pgPermissionRepo) GetByCodes(ctx context.Context, codes []string) ([]model.Permission, error) {
+	var permissions []model.Permission
+	if len(codes) == 0 {
+		return permissions, nil
+	}
+	if err := r.db.WithContext(ctx).Where("code IN ?", codes).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (r *pgPermissionRepo) Create(ctx context.Context, permission *model.Permission) error {
+	return r.db.WithContext(ctx).Create(permission).Error
+}
+
+// Delete removes a permission; the role_permissions rows go with it (ON DELETE CASCADE)
+func (r *pgPermissionRepo) Delete(ctx context.Context, code string) (bool, error) {
+	res := r.db.WithContext(ctx).Delete(&model.Permission{}, "code = ?", code)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *pgPermissionRepo) RoleMatrix(ctx context.Context) (map[string][]string, error) {
+	var rows []struct {
+		RoleCode       string
+		PermissionCode string
+	}
+	err := r.db.WithContext(ctx).Table("role_permissions").
+		Select("roles.code AS role_code, permissions.code AS permission_code").
+		Joins("JOIN roles ON roles.id = role_permissions.role_id").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Order("permissions.code").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	matrix := make(map[string][]string)
+	for _, row := range rows {
+		matrix[row.RoleCode] = append(matrix[row.RoleCode], row.PermissionCode)
+	}
+	return matrix, nil
+}
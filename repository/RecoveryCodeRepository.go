@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"mein-idaas/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type RecoveryCodeRepository interface {
+	// Replace drops the user's codes and stores the new hashes (used codes included)
+	Replace(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	// Consume marks an unused code of the user as used. Returns false if none matched.
+	// The conditional UPDATE makes concurrent use of the same code safe.
+	Consume(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error)
+	// CountUnused returns how many codes the user has left
+	CountUnused(ctx context.Context, userID uuid.UUID) (int64, error)
+}
+
+type pgRecoveryCodeRepo struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepository(db *gorm.DB) RecoveryCodeRepository {
+	return &pgRecoveryCodeRepo{db: db}
+}
+
+func (r *pgRecoveryCodeRepo) Replace(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	codes := make([]model.RecoveryCode, len(codeHashes))
+	for i, h := range codeHashes {
+		codes[i] = model.RecoveryCode{UserID: userID, CodeHash: h}
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Create(&codes).Error
+}
+
+func (r *pgRecoveryCodeRepo) Consume(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *pgRecoveryCodeRepo) CountUnused(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var n int64
+	err := r.db.WithContext(ctx).Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&n).Error
+	return n, err
+}
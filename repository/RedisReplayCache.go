@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisReplayCache shares used values across replicas
+// Keys: replay:<key>, expiring together with the value
+type redisReplayCache struct {
+	client *redis.Client
+}
+
+// NewRedisReplayCache creates a Redis-backed replay cache
+func NewRedisReplayCache(client *redis.Client) ReplayCache {
+	return &redisReplayCache{client: client}
+}
+
+func (c *redisReplayCache) Claim(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+	return c.client.SetNX(ctx, "replay:"+key, "1", ttl).Result()
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTokenDenylist shares denied tokens across replicas
+// Keys: denylist:<jti>, expiring together with the token
+type redisTokenDenylist struct {
+	client *redis.Client
+}
+
+// NewRedisTokenDenylist creates a Redis-backed denylist
+func NewRedisTokenDenylist(client *redis.Client) TokenDenylist {
+	return &redisTokenDenylist{client: client}
+}
+
+func (l *redisTokenDenylist) Add(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return l.client.Set(ctx, "denylist:"+jti, "1", ttl).Err()
+}
+
+func (l *redisTokenDenylist) Contains(ctx context.Context, jti string) (bool, error) {
+	n, err := l.client.Exists(ctx, "denylist:"+jti).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
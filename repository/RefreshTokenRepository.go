@@ -1,78 +1,177 @@
 package repository
 
 import (
+	"context"
+	"os"
+	"strconv"
 	"time"
 
 	"mein-idaas/model"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type RefreshTokenRepository interface {
-	Create(rt *model.RefreshToken) error
-	GetByID(id uuid.UUID) (*model.RefreshToken, error)
-	GetByTokenHash(hash string) (*model.RefreshToken, error)
-	RevokeByHash(hash string) error
-	RevokeByID(id uuid.UUID) error
-	RevokeAllForUser(userID uuid.UUID) error
-	Update(rt *model.RefreshToken) error
-	DeleteExpired() error
-	Delete(id uuid.UUID) error
+	Create(ctx context.Context, rt *model.RefreshToken) error
+	GetByID(ctx context.Context, id uuid.UUID) (*model.RefreshToken, error)
+	GetByIDForUpdate(ctx context.Context, id uuid.UUID) (*model.RefreshToken, error)
+	GetByTokenHash(ctx context.Context, hash string) (*model.RefreshToken, error)
+	RevokeByHash(ctx context.Context, hash string) error
+	RevokeByID(ctx context.Context, id uuid.UUID) error
+	RevokeAllForUser(ctx context.Context, userID uuid.UUID) error
+	RevokeAllForUserExcept(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) error
+	ListActiveByUser(ctx context.Context, userID uuid.UUID) ([]model.RefreshToken, error)
+	RevokeWithDescendants(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
+	RevokeFamily(ctx context.Context, familyID uuid.UUID) ([]uuid.UUID, error)
+	Update(ctx context.Context, rt *model.RefreshToken) error
+	DeleteExpired(ctx context.Context) (int64, error)
+	Delete(ctx context.Context, id uuid.UUID) error
 }
 
 type pgRefreshTokenRepo struct {
-	db *gorm.DB
+	db           *gorm.DB
+	cleanupBatch int
+	cleanupPause time.Duration
 }
 
 func NewRefreshTokenRepository(db *gorm.DB) RefreshTokenRepository {
-	return &pgRefreshTokenRepo{db: db}
+	// Batch size and pause between batches for DeleteExpired (defaults: 1000 rows, 100ms)
+	batch, err := strconv.Atoi(os.Getenv("CLEANUP_BATCH_SIZE"))
+	if err != nil || batch <= 0 {
+		batch = 1000
+	}
+	pause, err := time.ParseDuration(os.Getenv("CLEANUP_BATCH_DELAY"))
+	if err != nil || pause < 0 {
+		pause = 100 * time.Millisecond
+	}
+
+	return &pgRefreshTokenRepo{db: db, cleanupBatch: batch, cleanupPause: pause}
 }
 
-func (r *pgRefreshTokenRepo) Create(rt *model.RefreshToken) error {
-	return r.db.Create(rt).Error
+func (r *pgRefreshTokenRepo) Create(ctx context.Context, rt *model.RefreshToken) error {
+	return r.db.WithContext(ctx).Create(rt).Error
+}
+
+func (r *pgRefreshTokenRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.RefreshToken, error) {
+	var t model.RefreshToken
+	if err := r.db.WithContext(ctx).First(&t, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
 }
 
-func (r *pgRefreshTokenRepo) GetByID(id uuid.UUID) (*model.RefreshToken, error) {
+// GetByIDForUpdate loads the token and locks its row until the surrounding transaction ends
+// Only meaningful on a repository obtained from UnitOfWork.WithTransaction
+func (r *pgRefreshTokenRepo) GetByIDForUpdate(ctx context.Context, id uuid.UUID) (*model.RefreshToken, error) {
 	var t model.RefreshToken
-	if err := r.db.First(&t, "id = ?", id).Error; err != nil {
+	if err := r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&t, "id = ?", id).Error; err != nil {
 		return nil, err
 	}
 	return &t, nil
 }
 
-func (r *pgRefreshTokenRepo) GetByTokenHash(hash string) (*model.RefreshToken, error) {
+func (r *pgRefreshTokenRepo) GetByTokenHash(ctx context.Context, hash string) (*model.RefreshToken, error) {
 	var t model.RefreshToken
-	if err := r.db.Where("token_hash = ?", hash).First(&t).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("token_hash = ?", hash).First(&t).Error; err != nil {
 		return nil, err
 	}
 	return &t, nil
 }
 
-func (r *pgRefreshTokenRepo) RevokeByHash(hash string) error {
-	return r.db.Model(&model.RefreshToken{}).Where("token_hash = ?", hash).Update("revoked_at", time.Now()).Error
+func (r *pgRefreshTokenRepo) RevokeByHash(ctx context.Context, hash string) error {
+	return r.db.WithContext(ctx).Model(&model.RefreshToken{}).Where("token_hash = ?", hash).Update("revoked_at", time.Now()).Error
 }
 
-func (r *pgRefreshTokenRepo) RevokeByID(id uuid.UUID) error {
-	return r.db.Model(&model.RefreshToken{}).
+func (r *pgRefreshTokenRepo) RevokeByID(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&model.RefreshToken{}).
 		Where("id = ?", id).
 		Update("revoked_at", time.Now()).Error
 }
 
-func (r *pgRefreshTokenRepo) DeleteExpired() error {
-	return r.db.Where("expires_at < ?", time.Now()).Delete(&model.RefreshToken{}).Error
+// DeleteExpired removes expired tokens in fixed-size batches and returns the number deleted.
+// Each batch runs as its own short statement so the cleanup never holds long locks
+// or produces one huge WAL burst on tables with millions of rows.
+func (r *pgRefreshTokenRepo) DeleteExpired(ctx context.Context) (int64, error) {
+	cutoff := time.Now()
+	var total int64
+
+	for {
+		batch := r.db.WithContext(ctx).Model(&model.RefreshToken{}).
+			Select("id").
+			Where("expires_at < ?", cutoff).
+			Limit(r.cleanupBatch)
+
+		res := r.db.WithContext(ctx).Where("id IN (?)", batch).Delete(&model.RefreshToken{})
+		if res.Error != nil {
+			return total, res.Error
+		}
+		total += res.RowsAffected
+
+		// Last (partial) batch -> nothing left to delete
+		if res.RowsAffected < int64(r.cleanupBatch) {
+			return total, nil
+		}
+
+		// Throttle so the cleanup doesn't starve regular traffic
+		time.Sleep(r.cleanupPause)
+	}
 }
 
-func (r *pgRefreshTokenRepo) Update(rt *model.RefreshToken) error {
-	return r.db.Save(rt).Error
+func (r *pgRefreshTokenRepo) Update(ctx context.Context, rt *model.RefreshToken) error {
+	return r.db.WithContext(ctx).Save(rt).Error
 }
 
-func (r *pgRefreshTokenRepo) RevokeAllForUser(userID uuid.UUID) error {
-	return r.db.Model(&model.RefreshToken{}).
+func (r *pgRefreshTokenRepo) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&model.RefreshToken{}).
 		Where("user_id = ?", userID).
 		Update("revoked_at", time.Now()).Error
 }
 
-func (r *pgRefreshTokenRepo) Delete(id uuid.UUID) error {
-	return r.db.Delete(&model.RefreshToken{}, "id = ?", id).Error
+// RevokeAllForUserExcept revokes every refresh token of the user but keepID (the session making the request)
+func (r *pgRefreshTokenRepo) RevokeAllForUserExcept(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&model.RefreshToken{}).
+		Where("user_id = ? AND id <> ?", userID, keepID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// ListActiveByUser returns the user's sessions: tokens that are neither revoked, rotated nor expired, newest first
+func (r *pgRefreshTokenRepo) ListActiveByUser(ctx context.Context, userID uuid.UUID) ([]model.RefreshToken, error) {
+	var tokens []model.RefreshToken
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND replaced_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeWithDescendants revokes the token and every token that replaced it (its rotation chain) in one statement
+// Returns the IDs that were still unrevoked, so their access tokens can be revoked as well.
+func (r *pgRefreshTokenRepo) RevokeWithDescendants(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Raw(`
+		WITH RECURSIVE chain AS (
+			SELECT id, replaced_by_token_id FROM refresh_tokens WHERE id = ?
+			UNION ALL
+			SELECT t.id, t.replaced_by_token_id FROM refresh_tokens t JOIN chain c ON t.id = c.replaced_by_token_id
+		)
+		UPDATE refresh_tokens SET revoked_at = ?
+		WHERE id IN (SELECT id FROM chain) AND revoked_at IS NULL
+		RETURNING id`, id, time.Now(),
+	).Scan(&ids).Error
+	return ids, err
+}
+
+// RevokeFamily revokes every token of a rotation chain (family_id) in one statement and returns the IDs it revoked
+func (r *pgRefreshTokenRepo) RevokeFamily(ctx context.Context, familyID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Raw(
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL RETURNING id", time.Now(), familyID,
+	).Scan(&ids).Error
+	return ids, err
+}
+
+func (r *pgRefreshTokenRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.RefreshToken{}, "id = ?", id).Error
 }
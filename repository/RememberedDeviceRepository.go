@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"mein-idaas/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type RememberedDeviceRepository interface {
+	Create(ctx context.Context, device *model.RememberedDevice) error
+	// GetActive returns the unexpired device of the user with the given token hash
+	GetActive(ctx context.Context, userID uuid.UUID, tokenHash string) (*model.RememberedDevice, error)
+	// Touch records a login from the device
+	Touch(ctx context.Context, id uuid.UUID) error
+	// ListByUser returns the user's unexpired devices, most recently used first
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]model.RememberedDevice, error)
+	// Delete forgets one device of the user. Returns false if it didn't exist.
+	Delete(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error)
+	DeleteAllForUser(ctx context.Context, userID uuid.UUID) error
+}
+
+type pgRememberedDeviceRepo struct {
+	db *gorm.DB
+}
+
+func NewRememberedDeviceRepository(db *gorm.DB) RememberedDeviceRepository {
+	return &pgRememberedDeviceRepo{db: db}
+}
+
+func (r *pgRememberedDeviceRepo) Create(ctx context.Context, device *model.RememberedDevice) error {
+	return r.db.WithContext(ctx).Create(device).Error
+}
+
+func (r *pgRememberedDeviceRepo) GetActive(ctx context.Context, userID uuid.UUID, tokenHash string) (*model.RememberedDevice, error) {
+	var d model.RememberedDevice
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND token_hash = ? AND expires_at > ?", userID, tokenHash, time.Now()).
+		First(&d).Error
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *pgRememberedDeviceRepo) Touch(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&model.RememberedDevice{}).
+		Where("id = ?", id).
+		Update("last_used_at", time.Now()).Error
+}
+
+func (r *pgRememberedDeviceRepo) ListByUser(ctx context.Context, userID uuid.UUID) ([]model.RememberedDevice, error) {
+	var devices []model.RememberedDevice
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&devices).Error
+	return devices, err
+}
+
+func (r *pgRememberedDeviceRepo) Delete(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error) {
+	res := r.db.WithContext(ctx).Where("user_id = ? AND id = ?", userID, id).Delete(&model.RememberedDevice{})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *pgRememberedDeviceRepo) DeleteAllForUser(ctx context.Context, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&model.RememberedDevice{}).Error
+}
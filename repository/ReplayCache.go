@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReplayCache remembers single-use values (DPoP proof and action token IDs) until they would be rejected anyway
+type ReplayCache interface {
+	// Claim reports whether key was unused and marks it used until expiresAt
+	Claim(ctx context.Context, key string, expiresAt time.Time) (bool, error)
+}
+
+type memReplayCache struct {
+	mu   sync.Mutex
+	data map[string]time.Time // key -> expiry
+}
+
+// NewInMemoryReplayCache keeps used values in process memory (single replica deployments)
+func NewInMemoryReplayCache() ReplayCache {
+	cache := &memReplayCache{data: make(map[string]time.Time)}
+
+	// Background Janitor to drop expired entries every minute
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			now := time.Now()
+			cache.mu.Lock()
+			for key, expiresAt := range cache.data {
+				if now.After(expiresAt) {
+					delete(cache.data, key)
+				}
+			}
+			cache.mu.Unlock()
+		}
+	}()
+
+	return cache
+}
+
+func (c *memReplayCache) Claim(_ context.Context, key string, expiresAt time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if usedUntil, ok := c.data[key]; ok && time.Now().Before(usedUntil) {
+		return false, nil
+	}
+	c.data[key] = expiresAt
+	return true, nil
+}
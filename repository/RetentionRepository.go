@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetentionPolicy describes which rows of a table become purgeable and when
+type RetentionPolicy struct {
+	Name       string        // Identifier used in logs, metrics and the RETENTION_<NAME> env var
+	Table      string        // Table name
+	TimeColumn string        // Rows older than now - Retention (by this column) are purged
+	Condition  string        // Optional extra SQL filter, e.g. "status = 'delivered'"
+	Retention  time.Duration // 0 disables the policy
+}
+
+type RetentionRepository interface {
+	// Purge deletes rows matching the policy older than cutoff, in batches, returning the total deleted
+	Purge(ctx context.Context, policy RetentionPolicy, cutoff time.Time, batchSize int) (int64, error)
+}
+
+type pgRetentionRepo struct {
+	db *gorm.DB
+}
+
+func NewRetentionRepository(db *gorm.DB) RetentionRepository {
+	return &pgRetentionRepo{db: db}
+}
+
+// Purge deletes in short batches (like DeleteExpired) to avoid long locks and WAL bursts
+// Table/column names come from code-defined policies, never from user input
+func (r *pgRetentionRepo) Purge(ctx context.Context, policy RetentionPolicy, cutoff time.Time, batchSize int) (int64, error) {
+	where := fmt.Sprintf("%s < ?", policy.TimeColumn)
+	if policy.Condition != "" {
+		where += " AND (" + policy.Condition + ")"
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE ctid IN (SELECT ctid FROM %s WHERE %s LIMIT ?)",
+		policy.Table, policy.Table, where)
+
+	var total int64
+	for {
+		res := r.db.WithContext(ctx).Exec(query, cutoff, batchSize)
+		if res.Error != nil {
+			return total, res.Error
+		}
+		total += res.RowsAffected
+
+		if res.RowsAffected < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+)
+
+type RoleCache interface {
+	// Get returns the cached role codes for a user. ok is false on miss or expiry.
+	Get(userID uuid.UUID) (roles []string, ok bool)
+
+	// Set caches the role codes for a user using the cache's TTL
+	Set(userID uuid.UUID, roles []string)
+
+	// Invalidate drops the cached entry (call whenever a user's roles change)
+	Invalidate(userID uuid.UUID)
+}
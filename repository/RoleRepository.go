@@ -1,13 +1,24 @@
 package repository
 
 import (
+	"context"
 	"mein-idaas/model"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type RoleRepository interface {
-	GetByCode(code string) (*model.Role, error)
+	GetByCode(ctx context.Context, code string) (*model.Role, error)
+	GetWithPermissions(ctx context.Context, code string) (*model.Role, error)
+	List(ctx context.Context) ([]model.Role, error)
+	Create(ctx context.Context, role *model.Role) error
+	Update(ctx context.Context, role *model.Role) error
+	Delete(ctx context.Context, role *model.Role) ([]uuid.UUID, error)
+	SetPermissions(ctx context.Context, role *model.Role, permissions []model.Permission) error
+	SetInherits(ctx context.Context, role *model.Role, inherits []*model.Role) error
+	// Inheritance returns the codes of the roles each role directly inherits, keyed by role code
+	Inheritance(ctx context.Context) (map[string][]string, error)
 }
 
 type pgRoleRepo struct {
@@ -18,10 +29,98 @@ func NewRoleRepository(db *gorm.DB) RoleRepository {
 	return &pgRoleRepo{db: db}
 }
 
-func (r *pgRoleRepo) GetByCode(code string) (*model.Role, error) {
+func (r *pgRoleRepo) GetByCode(ctx context.Context, code string) (*model.Role, error) {
 	var role model.Role
-	if err := r.db.Where("code = ?", code).First(&role).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("code = ?", code).First(&role).Error; err != nil {
 		return nil, err
 	}
 	return &role, nil
 }
+
+// GetWithPermissions returns the role with the given code, its permissions and the roles it inherits
+func (r *pgRoleRepo) GetWithPermissions(ctx context.Context, code string) (*model.Role, error) {
+	var role model.Role
+	if err := r.db.WithContext(ctx).Preload("Permissions", orderByCode).Preload("Inherits", orderByCode).Where("code = ?", code).First(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// List returns all roles with their permissions and inherited roles, system roles first
+func (r *pgRoleRepo) List(ctx context.Context) ([]model.Role, error) {
+	var roles []model.Role
+	if err := r.db.WithContext(ctx).Preload("Permissions", orderByCode).Preload("Inherits", orderByCode).Order("is_system DESC, code").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *pgRoleRepo) Create(ctx context.Context, role *model.Role) error {
+	return r.db.WithContext(ctx).Create(role).Error
+}
+
+// Update saves the name and description of a role; the code and the IsSystem flag never change
+func (r *pgRoleRepo) Update(ctx context.Context, role *model.Role) error {
+	return r.db.WithContext(ctx).Model(role).Select("name", "description", "updated_at").Updates(role).Error
+}
+
+// SetPermissions replaces the permissions of a role
+func (r *pgRoleRepo) SetPermissions(ctx context.Context, role *model.Role, permissions []model.Permission) error {
+	return r.db.WithContext(ctx).Model(role).Association("Permissions").Replace(permissions)
+}
+
+// SetInherits replaces the roles a role inherits (the caller checks for cycles)
+func (r *pgRoleRepo) SetInherits(ctx context.Context, role *model.Role, inherits []*model.Role) error {
+	return r.db.WithContext(ctx).Model(role).Association("Inherits").Replace(inherits)
+}
+
+func (r *pgRoleRepo) Inheritance(ctx context.Context) (map[string][]string, error) {
+	var rows []struct {
+		RoleCode      string
+		InheritedCode string
+	}
+	err := r.db.WithContext(ctx).Table("role_inherits").
+		Select("roles.code AS role_code, inherited.code AS inherited_code").
+		Joins("JOIN roles ON roles.id = role_inherits.role_id").
+		Joins("JOIN roles AS inherited ON inherited.id = role_inherits.inherited_role_id").
+		Order("inherited.code").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	inheritance := make(map[string][]string)
+	for _, row := range rows {
+		inheritance[row.RoleCode] = append(inheritance[row.RoleCode], row.InheritedCode)
+	}
+	return inheritance, nil
+}
+
+// Delete removes a role, its assignments, group mappings and inheritance links, returning the users that had it
+// directly or through a group
+func (r *pgRoleRepo) Delete(ctx context.Context, role *model.Role) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Raw(`SELECT user_id FROM user_roles WHERE role_id = ?
+			UNION SELECT user_groups.user_id FROM user_groups JOIN group_roles ON group_roles.group_id = user_groups.group_id
+			WHERE group_roles.role_id = ?`, role.ID, role.ID).Scan(&userIDs).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM user_roles WHERE role_id = ?", role.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM group_roles WHERE role_id = ?", role.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM role_inherits WHERE role_id = ? OR inherited_role_id = ?", role.ID, role.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Role{}, "id = ? AND is_system = ?", role.ID, false).Error
+	})
+	return userIDs, err
+}
+
+func orderByCode(db *gorm.DB) *gorm.DB {
+	return db.Order("code")
+}
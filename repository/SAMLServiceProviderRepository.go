@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+
+	"mein-idaas/model"
+
+	"gorm.io/gorm"
+)
+
+type SAMLServiceProviderRepository interface {
+	Create(ctx context.Context, sp *model.SAMLServiceProvider) error
+	GetByEntityID(ctx context.Context, tenant string, entityID string) (*model.SAMLServiceProvider, error)
+	List(ctx context.Context) ([]model.SAMLServiceProvider, error)
+}
+
+type pgSAMLServiceProviderRepo struct {
+	db *gorm.DB
+}
+
+func NewSAMLServiceProviderRepository(db *gorm.DB) SAMLServiceProviderRepository {
+	return &pgSAMLServiceProviderRepo{db: db}
+}
+
+func (r *pgSAMLServiceProviderRepo) Create(ctx context.Context, sp *model.SAMLServiceProvider) error {
+	return r.db.WithContext(ctx).Create(sp).Error
+}
+
+func (r *pgSAMLServiceProviderRepo) GetByEntityID(ctx context.Context, tenant string, entityID string) (*model.SAMLServiceProvider, error) {
+	var sp model.SAMLServiceProvider
+	if err := r.db.WithContext(ctx).First(&sp, "tenant = ? AND entity_id = ?", tenant, entityID).Error; err != nil {
+		return nil, err
+	}
+	return &sp, nil
+}
+
+func (r *pgSAMLServiceProviderRepo) List(ctx context.Context) ([]model.SAMLServiceProvider, error) {
+	var sps []model.SAMLServiceProvider
+	if err := r.db.WithContext(ctx).Order("tenant, entity_id").Find(&sps).Error; err != nil {
+		return nil, err
+	}
+	return sps, nil
+}
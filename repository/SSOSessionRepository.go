@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"mein-idaas/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SSOSessionRepository interface {
+	Create(ctx context.Context, session *model.SSOSession) error
+	// GetActive returns the unexpired, unrevoked session with the given token hash
+	GetActive(ctx context.Context, tokenHash string) (*model.SSOSession, error)
+	// CompleteMFA marks a pending session as fully signed in and extends it until expiresAt
+	CompleteMFA(ctx context.Context, id uuid.UUID, expiresAt time.Time) error
+	// RecordMFAFailure increments the failure counter and returns the new value
+	RecordMFAFailure(ctx context.Context, id uuid.UUID) (int, error)
+	Revoke(ctx context.Context, id uuid.UUID) error
+}
+
+type pgSSOSessionRepo struct {
+	db *gorm.DB
+}
+
+func NewSSOSessionRepository(db *gorm.DB) SSOSessionRepository {
+	return &pgSSOSessionRepo{db: db}
+}
+
+func (r *pgSSOSessionRepo) Create(ctx context.Context, session *model.SSOSession) error {
+	return r.db.WithContext(ctx).Create(session).Error
+}
+
+func (r *pgSSOSessionRepo) GetActive(ctx context.Context, tokenHash string) (*model.SSOSession, error) {
+	var s model.SSOSession
+	err := r.db.WithContext(ctx).
+		Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", tokenHash, time.Now()).
+		First(&s).Error
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *pgSSOSessionRepo) CompleteMFA(ctx context.Context, id uuid.UUID, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&model.SSOSession{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"mfa_pending": false, "expires_at": expiresAt}).Error
+}
+
+func (r *pgSSOSessionRepo) RecordMFAFailure(ctx context.Context, id uuid.UUID) (int, error) {
+	var failures int
+	err := r.db.WithContext(ctx).
+		Raw("UPDATE sso_sessions SET mfa_failures = mfa_failures + 1 WHERE id = ? RETURNING mfa_failures", id).
+		Scan(&failures).Error
+	return failures, err
+}
+
+func (r *pgSSOSessionRepo) Revoke(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&model.SSOSession{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+
+	"mein-idaas/model"
+
+	"gorm.io/gorm"
+)
+
+type ScopeRepository interface {
+	List(ctx context.Context) ([]model.Scope, error)
+	GetByNames(ctx context.Context, names []string) ([]model.Scope, error)
+}
+
+type pgScopeRepo struct {
+	db *gorm.DB
+}
+
+func NewScopeRepository(db *gorm.DB) ScopeRepository {
+	return &pgScopeRepo{db: db}
+}
+
+func (r *pgScopeRepo) List(ctx context.Context) ([]model.Scope, error) {
+	var scopes []model.Scope
+	if err := r.db.WithContext(ctx).Order("name").Find(&scopes).Error; err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
+
+func (r *pgScopeRepo) GetByNames(ctx context.Context, names []string) ([]model.Scope, error) {
+	var scopes []model.Scope
+	if len(names) == 0 {
+		return scopes, nil
+	}
+	if err := r.db.WithContext(ctx).Where("name IN ?", names).Find(&scopes).Error; err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
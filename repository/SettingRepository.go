@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+
+	"mein-idaas/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SettingRepository interface {
+	Get(ctx context.Context, key string) (*model.Setting, error)
+	Upsert(ctx context.Context, setting *model.Setting) error
+}
+
+type pgSettingRepo struct {
+	db *gorm.DB
+}
+
+func NewSettingRepository(db *gorm.DB) SettingRepository {
+	return &pgSettingRepo{db: db}
+}
+
+func (r *pgSettingRepo) Get(ctx context.Context, key string) (*model.Setting, error) {
+	var s model.Setting
+	if err := r.db.WithContext(ctx).First(&s, "key = ?", key).Error; err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *pgSettingRepo) Upsert(ctx context.Context, setting *model.Setting) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(setting).Error
+}
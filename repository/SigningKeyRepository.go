@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"mein-idaas/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SigningKeyRepository interface {
+	// ListUsable returns the keys that are active or still inside their overlap window, newest first
+	ListUsable(ctx context.Context, now time.Time) ([]model.SigningKey, error)
+	// Rotate retires the active key(s) of newKey.Tenant at retiresAt and stores newKey as its active key.
+	// imported (optional) records the env key being rotated out. Rotations are serialized with an
+	// advisory lock; when the active key was created after notBefore, nothing happens and false is returned.
+	Rotate(ctx context.Context, newKey *model.SigningKey, imported *model.SigningKey, retiresAt time.Time, notBefore time.Time) (bool, error)
+	// List returns every stored key, including retired ones, newest first
+	List(ctx context.Context) ([]model.SigningKey, error)
+	// Retire ends the key's validity at retiresAt (keys already retired earlier are left alone).
+	// Returns false when no usable key has that kid.
+	Retire(ctx context.Context, kid string, retiresAt time.Time) (bool, error)
+}
+
+// signingKeyRotationLock is the pg_advisory_xact_lock key serializing rotations across replicas
+const signingKeyRotationLock = 7_246_001
+
+type pgSigningKeyRepo struct {
+	db *gorm.DB
+}
+
+func NewSigningKeyRepository(db *gorm.DB) SigningKeyRepository {
+	return &pgSigningKeyRepo{db: db}
+}
+
+func (r *pgSigningKeyRepo) ListUsable(ctx context.Context, now time.Time) ([]model.SigningKey, error) {
+	var keys []model.SigningKey
+	err := r.db.WithContext(ctx).
+		Where("retires_at IS NULL OR retires_at > ?", now).
+		Order("created_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+func (r *pgSigningKeyRepo) Rotate(ctx context.Context, newKey *model.SigningKey, imported *model.SigningKey, retiresAt time.Time, notBefore time.Time) (bool, error) {
+	rotated := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", signingKeyRotationLock).Error; err != nil {
+			return err
+		}
+
+		// Another replica (or the scheduler) rotated recently
+		var recent int64
+		if err := tx.Model(&model.SigningKey{}).
+			Where("tenant = ? AND retires_at IS NULL AND private_key <> '' AND created_at > ?", newKey.Tenant, notBefore).
+			Count(&recent).Error; err != nil {
+			return err
+		}
+		if recent > 0 {
+			return nil
+		}
+
+		if err := tx.Model(&model.SigningKey{}).
+			Where("tenant = ? AND retires_at IS NULL", newKey.Tenant).
+			Update("retires_at", retiresAt).Error; err != nil {
+			return err
+		}
+		if imported != nil {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(imported).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(newKey).Error; err != nil {
+			return err
+		}
+
+		rotated = true
+		return nil
+	})
+	return rotated, err
+}
+
+func (r *pgSigningKeyRepo) List(ctx context.Context) ([]model.SigningKey, error) {
+	var keys []model.SigningKey
+	err := r.db.WithContext(ctx).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+func (r *pgSigningKeyRepo) Retire(ctx context.Context, kid string, retiresAt time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.SigningKey{}).
+		Where("kid = ? AND (retires_at IS NULL OR retires_at > ?)", kid, retiresAt).
+		Update("retires_at", retiresAt)
+	return res.RowsAffected > 0, res.Error
+}
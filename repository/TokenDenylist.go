@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenDenylist holds the jti of JWT access tokens revoked before they expire (logout, OAuth revocation)
+// Entries only need to live until the token's own expiry, so the list stays as small as the access TTL allows.
+type TokenDenylist interface {
+	// Add denies the token until expiresAt (the token's exp)
+	Add(ctx context.Context, jti string, expiresAt time.Time) error
+
+	// Contains reports whether the token was denied
+	Contains(ctx context.Context, jti string) (bool, error)
+}
+
+type memTokenDenylist struct {
+	data sync.Map // jti -> expiry time.Time
+}
+
+// NewInMemoryTokenDenylist keeps the denylist in process memory (single replica deployments)
+func NewInMemoryTokenDenylist() TokenDenylist {
+	list := &memTokenDenylist{}
+
+	// Background Janitor to drop entries of expired tokens every minute
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			now := time.Now()
+			list.data.Range(func(key, value interface{}) bool {
+				if now.After(value.(time.Time)) {
+					list.data.Delete(key)
+				}
+				return true
+			})
+		}
+	}()
+
+	return list
+}
+
+func (l *memTokenDenylist) Add(_ context.Context, jti string, expiresAt time.Time) error {
+	if time.Now().Before(expiresAt) {
+		l.data.Store(jti, expiresAt)
+	}
+	return nil
+}
+
+func (l *memTokenDenylist) Contains(_ context.Context, jti string) (bool, error) {
+	val, ok := l.data.Load(jti)
+	if !ok {
+		return false, nil
+	}
+	return time.Now().Before(val.(time.Time)), nil
+}
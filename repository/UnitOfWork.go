@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Repositories groups repositories bound to the same transaction
+type Repositories struct {
+	Users         UserRepository
+	Credentials   CredentialRepository
+	RefreshTokens RefreshTokenRepository
+	Roles         RoleRepository
+	Outbox        OutboxRepository
+	Invites       InviteRepository
+	RecoveryCodes RecoveryCodeRepository
+	Devices       RememberedDeviceRepository
+	APIKeys       APIKeyRepository
+}
+
+// UnitOfWork runs a set of repository operations atomically
+type UnitOfWork interface {
+	// WithTransaction commits if fn returns nil and rolls back on error or panic
+	WithTransaction(ctx context.Context, fn func(repos *Repositories) error) error
+}
+
+type gormUnitOfWork struct {
+	db *gorm.DB
+}
+
+func NewUnitOfWork(db *gorm.DB) UnitOfWork {
+	return &gormUnitOfWork{db: db}
+}
+
+func (u *gormUnitOfWork) WithTransaction(ctx context.Context, fn func(repos *Repositories) error) error {
+	return u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&Repositories{
+			Users:         NewUserRepository(tx),
+			Credentials:   NewCredentialRepository(tx),
+			RefreshTokens: NewRefreshTokenRepository(tx),
+			Roles:         NewRoleRepository(tx),
+			Outbox:        NewOutboxRepository(tx),
+			Invites:       NewInviteRepository(tx),
+			RecoveryCodes: NewRecoveryCodeRepository(tx),
+			Devices:       NewRememberedDeviceRepository(tx),
+			APIKeys:       NewAPIKeyRepository(tx),
+		})
+	})
+}
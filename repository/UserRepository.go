@@ -1,21 +1,60 @@
 package repository
 
 import (
+	"context"
 	"mein-idaas/model"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository interface {
-	Create(user *model.User) error
-	GetByID(id uuid.UUID) (*model.User, error)
-	GetByEmail(email string) (*model.User, error)
-	Update(user *model.User) error
-	Delete(id uuid.UUID) error
-	GetDB() *gorm.DB
+	Create(ctx context.Context, user *model.User) error
+	GetByID(ctx context.Context, id uuid.UUID) (*model.User, error)
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
+	GetByPhone(ctx context.Context, phone string) (*model.User, error)
+	GetByUsername(ctx context.Context, username string) (*model.User, error)
+	GetRoleCodes(ctx context.Context, id uuid.UUID) ([]string, error)
+	GetGroupRoleCodes(ctx context.Context, id uuid.UUID) ([]string, error)
+	StreamAll(ctx context.Context, batchSize int, fn func(users []model.User) error) error
+	List(ctx context.Context, query UserListQuery) ([]model.User, int64, error)
+	Search(ctx context.Context, terms []string, offset int, limit int) ([]model.User, int64, error)
+	Update(ctx context.Context, user *model.User) error
+	ReplaceRoles(ctx context.Context, user *model.User, roles []model.Role) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	DeleteUnverifiedByEmail(ctx context.Context, email string, createdBefore time.Time) (int64, error)
+	RecordLoginFailure(ctx context.Context, id uuid.UUID) (int, error)
+	SetLoginLock(ctx context.Context, id uuid.UUID, lockedUntil *time.Time) error
+	GetTokenVersion(ctx context.Context, id uuid.UUID) (int, error)
+	BumpTokenVersion(ctx context.Context, id uuid.UUID) (int, error)
 }
 
+// UserListQuery selects a page of users for List; zero-valued filters are not applied
+type UserListQuery struct {
+	EmailContains string     // Case-insensitive substring of the email
+	Verified      *bool      // Email verified or not
+	Role          string     // Role code the user must have
+	CreatedFrom   *time.Time // Created at or after
+	CreatedBefore *time.Time // Created before
+	Sort          string     // created_at (default), email or name
+	Desc          bool
+	Offset        int
+	Limit         int
+}
+
+// UserSearchExpression is the text Search matches: name and email
+// It is indexed with pg_trgm at startup (util.InitDB); queries must use exactly this expression to hit the index.
+const UserSearchExpression = "(name || ' ' || email)"
+
+// likeEscaper escapes the LIKE wildcards in user input
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// userSortColumns are the columns List can sort by
+var userSortColumns = map[string]string{"created_at": "created_at", "email": "email", "name": "name"}
+
 type pgUserRepo struct {
 	db *gorm.DB
 }
@@ -24,36 +63,204 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 	return &pgUserRepo{db: db}
 }
 
-func (r *pgUserRepo) Create(user *model.User) error {
-	return r.db.Create(user).Error
+func (r *pgUserRepo) Create(ctx context.Context, user *model.User) error {
+	return r.db.WithContext(ctx).Create(user).Error
 }
 
-func (r *pgUserRepo) GetByID(id uuid.UUID) (*model.User, error) {
+func (r *pgUserRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.User, error) {
 	var u model.User
 	// Fetches Roles and Credentials to ensure the user object is complete
-	if err := r.db.Preload("Roles").Preload("Credentials").First(&u, "id = ?", id).Error; err != nil {
+	if err := r.db.WithContext(ctx).Preload("Roles").Preload("Credentials").First(&u, "id = ?", id).Error; err != nil {
 		return nil, err
 	}
 	return &u, nil
 }
 
-func (r *pgUserRepo) GetByEmail(email string) (*model.User, error) {
+func (r *pgUserRepo) GetByEmail(ctx context.Context, email string) (*model.User, error) {
 	var u model.User
 	// Preload Roles here so they are available for JWT generation during Login
-	if err := r.db.Preload("Roles").Preload("Credentials").Where("email = ?", email).First(&u).Error; err != nil {
+	if err := r.db.WithContext(ctx).Preload("Roles").Preload("Credentials").Where("email = ?", email).First(&u).Error; err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetByPhone finds a user by verified phone number (E.164)
+func (r *pgUserRepo) GetByPhone(ctx context.Context, phone string) (*model.User, error) {
+	var u model.User
+	if err := r.db.WithContext(ctx).Preload("Roles").Where("phone = ? AND is_phone_verified = ?", phone, true).First(&u).Error; err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetByUsername finds a user by normalized (lowercase) username
+func (r *pgUserRepo) GetByUsername(ctx context.Context, username string) (*model.User, error) {
+	var u model.User
+	if err := r.db.WithContext(ctx).Preload("Roles").Preload("Credentials").Where("username = ?", username).First(&u).Error; err != nil {
 		return nil, err
 	}
 	return &u, nil
 }
 
-func (r *pgUserRepo) Update(user *model.User) error {
-	return r.db.Save(user).Error
+// GetRoleCodes returns only the role codes of a user, granted directly or through groups (no preloads)
+func (r *pgUserRepo) GetRoleCodes(ctx context.Context, id uuid.UUID) ([]string, error) {
+	var codes []string
+	err := r.db.WithContext(ctx).Raw(`SELECT roles.code FROM roles JOIN user_roles ON user_roles.role_id = roles.id WHERE user_roles.user_id = ?
+		UNION SELECT roles.code FROM roles JOIN group_roles ON group_roles.role_id = roles.id
+		JOIN user_groups ON user_groups.group_id = group_roles.group_id WHERE user_groups.user_id = ?`, id, id).
+		Scan(&codes).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// GetGroupRoleCodes returns the codes of the roles a user gets through their groups
+func (r *pgUserRepo) GetGroupRoleCodes(ctx context.Context, id uuid.UUID) ([]string, error) {
+	var codes []string
+	err := r.db.WithContext(ctx).Model(&model.Role{}).Distinct("roles.code").
+		Joins("JOIN group_roles ON group_roles.role_id = roles.id").
+		Joins("JOIN user_groups ON user_groups.group_id = group_roles.group_id").
+		Where("user_groups.user_id = ?", id).
+		Pluck("roles.code", &codes).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// StreamAll walks every user in primary-key order, batchSize rows at a time (roles preloaded per batch)
+// Only one batch is held in memory, so it scales to millions of accounts
+func (r *pgUserRepo) StreamAll(ctx context.Context, batchSize int, fn func(users []model.User) error) error {
+	var batch []model.User
+	return r.db.WithContext(ctx).Preload("Roles").Order("id").FindInBatches(&batch, batchSize, func(_ *gorm.DB, _ int) error {
+		return fn(batch)
+	}).Error
+}
+
+// List returns one page of the users matching the query (roles preloaded) and the number of all matching users
+func (r *pgUserRepo) List(ctx context.Context, query UserListQuery) ([]model.User, int64, error) {
+	db := r.db.WithContext(ctx).Model(&model.User{})
+	if query.EmailContains != "" {
+		db = db.Where("email ILIKE ?", "%"+likeEscaper.Replace(query.EmailContains)+"%")
+	}
+	if query.Verified != nil {
+		db = db.Where("is_email_verified = ?", *query.Verified)
+	}
+	if query.Role != "" {
+		db = db.Where("EXISTS (SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = users.id AND r.code = ?)", query.Role)
+	}
+	if query.CreatedFrom != nil {
+		db = db.Where("created_at >= ?", *query.CreatedFrom)
+	}
+	if query.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *query.CreatedBefore)
+	}
+
+	// The filtered query is used twice (count and page), each from its own session
+	db = db.Session(&gorm.Session{})
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	column, ok := userSortColumns[query.Sort]
+	if !ok {
+		column = "created_at"
+	}
+	direction := "ASC"
+	if query.Desc {
+		direction = "DESC"
+	}
+	// id breaks ties, so pages don't overlap
+	var users []model.User
+	err := db.Preload("Roles").
+		Order(column + " " + direction).Order("id " + direction).
+		Offset(query.Offset).Limit(query.Limit).
+		Find(&users).Error
+	return users, total, err
+}
+
+// Search finds the users whose name or email contains every term (case-insensitive) and returns one page of them
+// (roles preloaded) with the number of all matches. Exact email matches come first, then names or emails
+// starting with the first term, then the newest users.
+func (r *pgUserRepo) Search(ctx context.Context, terms []string, offset int, limit int) ([]model.User, int64, error) {
+	db := r.db.WithContext(ctx).Model(&model.User{})
+	for _, term := range terms {
+		db = db.Where(UserSearchExpression+" ILIKE ?", "%"+likeEscaper.Replace(term)+"%")
+	}
+	db = db.Session(&gorm.Session{})
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	first := strings.ToLower(terms[0])
+	prefix := likeEscaper.Replace(first) + "%"
+	var users []model.User
+	err := db.Preload("Roles").
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "lower(email) = ? DESC, (name ILIKE ? OR email ILIKE ?) DESC, created_at DESC, id",
+			Vars: []interface{}{first, prefix, prefix},
+		}}).
+		Offset(offset).Limit(limit).
+		Find(&users).Error
+	return users, total, err
+}
+
+func (r *pgUserRepo) Update(ctx context.Context, user *model.User) error {
+	return r.db.WithContext(ctx).Save(user).Error
+}
+
+// ReplaceRoles sets the user's roles to exactly the given list (user_roles rows are rewritten)
+func (r *pgUserRepo) ReplaceRoles(ctx context.Context, user *model.User, roles []model.Role) error {
+	return r.db.WithContext(ctx).Model(user).Association("Roles").Replace(roles)
+}
+
+func (r *pgUserRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.User{}, "id = ?", id).Error
 }
 
-func (r *pgUserRepo) Delete(id uuid.UUID) error {
-	return r.db.Delete(&model.User{}, "id = ?", id).Error
+// DeleteUnverifiedByEmail removes the account holding email if it never verified and was created before the cutoff
+// Credentials, refresh tokens and role links are removed by ON DELETE CASCADE
+func (r *pgUserRepo) DeleteUnverifiedByEmail(ctx context.Context, email string, createdBefore time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Where("email = ? AND is_email_verified = ? AND is_anonymous = ? AND created_at < ?", email, false, false, createdBefore).
+		Delete(&model.User{})
+	return res.RowsAffected, res.Error
+}
+
+// RecordLoginFailure increments the failed login counter in place (safe across replicas) and returns the new count
+func (r *pgUserRepo) RecordLoginFailure(ctx context.Context, id uuid.UUID) (int, error) {
+	var attempts int
+	err := r.db.WithContext(ctx).Raw(
+		"UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins", id,
+	).Scan(&attempts).Error
+	return attempts, err
+}
+
+// SetLoginLock locks password logins until lockedUntil (nil unlocks) and resets the failed login counter
+func (r *pgUserRepo) SetLoginLock(ctx context.Context, id uuid.UUID, lockedUntil *time.Time) error {
+	return r.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"failed_logins": 0, "locked_until": lockedUntil}).Error
+}
+
+// GetTokenVersion returns the user's current token version (checked on every JWT access token)
+func (r *pgUserRepo) GetTokenVersion(ctx context.Context, id uuid.UUID) (int, error) {
+	var user model.User
+	if err := r.db.WithContext(ctx).Select("token_version").Where("id = ?", id).Take(&user).Error; err != nil {
+		return 0, err
+	}
+	return user.TokenVersion, nil
 }
 
-func (r *pgUserRepo) GetDB() *gorm.DB {
-	return r.db
+// BumpTokenVersion increments the token version in place, invalidating every access token issued so far
+func (r *pgUserRepo) BumpTokenVersion(ctx context.Context, id uuid.UUID) (int, error) {
+	var version int
+	err := r.db.WithContext(ctx).Raw(
+		"UPDATE users SET token_version = token_version + 1 WHERE id = ? RETURNING token_version", id,
+	).Scan(&version).Error
+	return version, err
 }
@@ -13,4 +13,7 @@ type VerificationRepository interface {
 
 	// Delete removes the code (used after successful verification)
 	Delete(key string) error
+
+	// Count returns the number of stored codes (exported as a metric)
+	Count() int
 }
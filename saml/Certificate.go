@@ -0,0 +1,47 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// certificates caches the self-signed certificate of each signing key (by kid and common name)
+var certificates sync.Map
+
+// SigningCertificate returns a self-signed X.509 certificate (DER) for an RSA signing key
+// SAML metadata publishes keys as certificates. The certificate is derived from the key, kid and creation
+// time only (PKCS #1 v1.5 signatures are deterministic), so every replica serves the same one.
+func SigningCertificate(key crypto.Signer, kid string, createdAt time.Time, commonName string) ([]byte, error) {
+	cacheKey := kid + "|" + commonName
+	if cert, ok := certificates.Load(cacheKey); ok {
+		return cert.([]byte), nil
+	}
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, errors.New("saml requires an RSA signing key")
+	}
+
+	serial := sha256.Sum256([]byte(kid))
+	template := &x509.Certificate{
+		SerialNumber:       new(big.Int).SetBytes(serial[:16]),
+		Subject:            pkix.Name{CommonName: commonName},
+		NotBefore:          createdAt.UTC().Truncate(time.Second),
+		NotAfter:           createdAt.UTC().Truncate(time.Second).AddDate(10, 0, 0),
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	certificates.Store(cacheKey, cert)
+	return cert, nil
+}
@@ -0,0 +1,22 @@
+package saml
+
+import (
+	"encoding/base64"
+)
+
+// Metadata returns the IdP metadata document (EntityDescriptor) that SPs import
+// ssoURL accepts both the HTTP-Redirect and the HTTP-POST binding.
+func Metadata(entityID string, ssoURL string, certificate []byte) []byte {
+	cert := base64.StdEncoding.EncodeToString(certificate)
+
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="` + escapeAttr(entityID) + `">` +
+		`<md:IDPSSODescriptor WantAuthnRequestsSigned="false" protocolSupportEnumeration="` + nsProtocol + `">` +
+		`<md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="` + nsDSig + `"><ds:X509Data><ds:X509Certificate>` + cert +
+		`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>` +
+		`<md:NameIDFormat>` + NameIDFormatEmail + `</md:NameIDFormat>` +
+		`<md:NameIDFormat>` + NameIDFormatPersistent + `</md:NameIDFormat>` +
+		`<md:SingleSignOnService Binding="` + BindingHTTPRedirect + `" Location="` + escapeAttr(ssoURL) + `"></md:SingleSignOnService>` +
+		`<md:SingleSignOnService Binding="` + BindingHTTPPost + `" Location="` + escapeAttr(ssoURL) + `"></md:SingleSignOnService>` +
+		`</md:IDPSSODescriptor></md:EntityDescriptor>`)
+}
@@ -0,0 +1,181 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// SAML 2.0 and XML Signature identifiers
+const (
+	NameIDFormatEmail      = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	NameIDFormatPersistent = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+
+	BindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	BindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsDSig      = "http://www.w3.org/2000/09/xmldsig#"
+
+	algExcC14N     = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped   = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algSHA256      = "http://www.w3.org/2001/04/xmlenc#sha256"
+	statusSuccess  = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	cmBearer       = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	acPassword     = "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport"
+	attrNameBasic  = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+	assertionValid = 5 * time.Minute
+)
+
+// Attribute is a (possibly multi-valued) SAML attribute of the subject
+type Attribute struct {
+	Name   string
+	Values []string
+}
+
+// ResponseParams describes the assertion issued to a service provider
+type ResponseParams struct {
+	Issuer       string // IdP entity ID
+	Audience     string // SP entity ID
+	ACSURL       string // Recipient and Destination
+	InResponseTo string // AuthnRequest ID
+	NameID       string
+	NameIDFormat string
+	SessionIndex string
+	AuthnInstant time.Time
+	Attributes   []Attribute
+}
+
+// SigningKey is the RSA key that signs assertions, with its certificate (DER) for KeyInfo
+type SigningKey struct {
+	Signer      crypto.Signer
+	Certificate []byte
+}
+
+// BuildResponse returns the base64 SAMLResponse for the HTTP-POST binding
+// The assertion carries an enveloped RSA-SHA256 signature. It is written directly in exclusive
+// canonical form (sorted attributes, no whitespace, explicit end tags), so the digest covers the
+// same bytes an SP computes after canonicalization.
+func BuildResponse(p ResponseParams, key SigningKey) (string, error) {
+	now := time.Now().UTC()
+	assertionID, err := newID()
+	if err != nil {
+		return "", err
+	}
+	responseID, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	issuer := `<saml:Issuer>` + escapeText(p.Issuer) + `</saml:Issuer>`
+	body := assertionBody(p, now)
+
+	// Digest of the assertion without the signature (enveloped-signature transform)
+	unsigned := `<saml:Assertion xmlns:saml="` + nsAssertion + `" ID="` + assertionID + `" IssueInstant="` + timestamp(now) +
+		`" Version="2.0">` + issuer + body + `</saml:Assertion>`
+	digest := sha256.Sum256([]byte(unsigned))
+
+	signedInfo := `<ds:SignedInfo xmlns:ds="` + nsDSig + `">` +
+		`<ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="` + algRSASHA256 + `"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + assertionID + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + algEnveloped + `"></ds:Transform>` +
+		`<ds:Transform Algorithm="` + algExcC14N + `"></ds:Transform>` +
+		`</ds:Transforms><ds:DigestMethod Algorithm="` + algSHA256 + `"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>` +
+		`</ds:Reference></ds:SignedInfo>`
+	signedInfoDigest := sha256.Sum256([]byte(signedInfo))
+	signature, err := key.Signer.Sign(rand.Reader, signedInfoDigest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	// In the document SignedInfo inherits xmlns:ds from the Signature element
+	signatureXML := `<ds:Signature xmlns:ds="` + nsDSig + `">` +
+		strings.Replace(signedInfo, ` xmlns:ds="`+nsDSig+`"`, "", 1) +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(signature) + `</ds:SignatureValue>` +
+		`<ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(key.Certificate) +
+		`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></ds:Signature>`
+
+	assertion := `<saml:Assertion xmlns:saml="` + nsAssertion + `" ID="` + assertionID + `" IssueInstant="` + timestamp(now) +
+		`" Version="2.0">` + issuer + signatureXML + body + `</saml:Assertion>`
+
+	inResponseTo := ""
+	if p.InResponseTo != "" {
+		inResponseTo = ` InResponseTo="` + escapeAttr(p.InResponseTo) + `"`
+	}
+	response := `<samlp:Response xmlns:samlp="` + nsProtocol + `" xmlns:saml="` + nsAssertion + `"` +
+		` Destination="` + escapeAttr(p.ACSURL) + `" ID="` + responseID + `"` + inResponseTo +
+		` IssueInstant="` + timestamp(now) + `" Version="2.0">` + issuer +
+		`<samlp:Status><samlp:StatusCode Value="` + statusSuccess + `"></samlp:StatusCode></samlp:Status>` +
+		assertion + `</samlp:Response>`
+
+	return base64.StdEncoding.EncodeToString([]byte(response)), nil
+}
+
+// assertionBody renders Subject, Conditions, AuthnStatement and AttributeStatement in canonical form
+func assertionBody(p ResponseParams, now time.Time) string {
+	notOnOrAfter := timestamp(now.Add(assertionValid))
+
+	var b strings.Builder
+	b.WriteString(`<saml:Subject><saml:NameID Format="` + escapeAttr(p.NameIDFormat) + `">` + escapeText(p.NameID) + `</saml:NameID>`)
+	b.WriteString(`<saml:SubjectConfirmation Method="` + cmBearer + `"><saml:SubjectConfirmationData`)
+	if p.InResponseTo != "" {
+		b.WriteString(` InResponseTo="` + escapeAttr(p.InResponseTo) + `"`)
+	}
+	b.WriteString(` NotOnOrAfter="` + notOnOrAfter + `" Recipient="` + escapeAttr(p.ACSURL) + `"></saml:SubjectConfirmationData>`)
+	b.WriteString(`</saml:SubjectConfirmation></saml:Subject>`)
+
+	// Allow a little clock skew on the SP side
+	b.WriteString(`<saml:Conditions NotBefore="` + timestamp(now.Add(-30*time.Second)) + `" NotOnOrAfter="` + notOnOrAfter + `">`)
+	b.WriteString(`<saml:AudienceRestriction><saml:Audience>` + escapeText(p.Audience) + `</saml:Audience></saml:AudienceRestriction></saml:Conditions>`)
+
+	b.WriteString(`<saml:AuthnStatement AuthnInstant="` + timestamp(p.AuthnInstant.UTC()) + `" SessionIndex="` + escapeAttr(p.SessionIndex) + `">`)
+	b.WriteString(`<saml:AuthnContext><saml:AuthnContextClassRef>` + acPassword + `</saml:AuthnContextClassRef></saml:AuthnContext></saml:AuthnStatement>`)
+
+	if len(p.Attributes) > 0 {
+		b.WriteString(`<saml:AttributeStatement>`)
+		for _, attr := range p.Attributes {
+			b.WriteString(`<saml:Attribute Name="` + escapeAttr(attr.Name) + `" NameFormat="` + attrNameBasic + `">`)
+			for _, v := range attr.Values {
+				b.WriteString(`<saml:AttributeValue>` + escapeText(v) + `</saml:AttributeValue>`)
+			}
+			b.WriteString(`</saml:Attribute>`)
+		}
+		b.WriteString(`</saml:AttributeStatement>`)
+	}
+	return b.String()
+}
+
+// newID returns an xs:ID (must not start with a digit)
+func newID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "_" + hex.EncodeToString(b), nil
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// Escaping rules of exclusive canonicalization for character data and attribute values
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
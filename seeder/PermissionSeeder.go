@@ -0,0 +1,66 @@
+package seeder
+
+import (
+	"errors"
+	"log"
+	"mein-idaas/model"
+
+	"gorm.io/gorm"
+)
+
+// defaultPermissions is the default permission matrix: each permission and the roles granting it
+// A permission is only granted to these roles when it is first created, so later changes by admins are kept.
+var defaultPermissions = []struct {
+	permission model.Permission
+	roles      []string
+}{
+	{model.Permission{Code: "profile:read", Description: "See your own profile"}, []string{"admin", "moderator", "user", "guest"}},
+	{model.Permission{Code: "profile:write", Description: "Edit your own profile and credentials"}, []string{"admin", "moderator", "user"}},
+	{model.Permission{Code: "users:read", Description: "List and search users"}, []string{"admin", "moderator"}},
+	{model.Permission{Code: "users:write", Description: "Manage users (MFA reset, passwords, sessions, API keys)"}, []string{"admin"}},
+	{model.Permission{Code: "users:export", Description: "Export all users"}, []string{"admin"}},
+	{model.Permission{Code: "roles:manage", Description: "Manage roles and permissions"}, []string{"admin"}},
+	{model.Permission{Code: "groups:manage", Description: "Manage groups, their members and roles"}, []string{"admin"}},
+	{model.Permission{Code: "settings:manage", Description: "Change registration and provisioning settings"}, []string{"admin"}},
+	{model.Permission{Code: "keys:manage", Description: "Rotate and retire signing keys"}, []string{"admin"}},
+	{model.Permission{Code: "clients:manage", Description: "Register OAuth clients and SAML service providers"}, []string{"admin"}},
+	{model.Permission{Code: "stats:read", Description: "See usage statistics and retention"}, []string{"admin", "moderator"}},
+}
+
+// SeedPermissions creates the default permissions and grants them to the default roles (run after SeedRoles)
+func SeedPermissions(db *gorm.DB) {
+	log.Println("Seeding permissions...")
+
+	for _, entry := range defaultPermissions {
+		permission := entry.permission
+
+		var existing model.Permission
+		err := db.Where("code = ?", permission.Code).First(&existing).Error
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error checking permission %s: %v", permission.Code, err)
+			continue
+		}
+
+		if err := db.Create(&permission).Error; err != nil {
+			log.Printf("Error creating permission %s: %v", permission.Code, err)
+			continue
+		}
+
+		var roles []model.Role
+		if err := db.Where("code IN ?", entry.roles).Find(&roles).Error; err != nil {
+			log.Printf("Error loading roles for permission %s: %v", permission.Code, err)
+			continue
+		}
+		for i := range roles {
+			if err := db.Model(&roles[i]).Association("Permissions").Append(&permission); err != nil {
+				log.Printf("Error granting %s to role %s: %v", permission.Code, roles[i].Code, err)
+			}
+		}
+		log.Printf("Created new permission: %s", permission.Code)
+	}
+
+	log.Println("Permission seeding completed.")
+}
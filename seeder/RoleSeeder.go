@@ -29,6 +29,12 @@ func SeedRoles(db *gorm.DB) {
 			Description: "Standard registered user",
 			IsSystem:    true, // Usually standard user role should be protected
 		},
+		{
+			Name:        "Guest",
+			Code:        "guest",
+			Description: "Anonymous device-bound account with limited access",
+			IsSystem:    true,
+		},
 	}
 
 	log.Println("Seeding roles...")
@@ -54,5 +60,49 @@ func SeedRoles(db *gorm.DB) {
 		}
 	}
 
+	seedRoleHierarchy(db)
+
 	log.Println("Role seeding completed.")
 }
+
+// defaultRoleHierarchy is the inheritance seeded once: each role code and the role it inherits
+var defaultRoleHierarchy = [][2]string{
+	{"admin", "moderator"},
+	{"moderator", "user"},
+}
+
+// seedRoleHierarchy links the default roles (admin -> moderator -> user) on the first start with role
+// inheritance; a setting records it, so links removed by admins stay removed
+func seedRoleHierarchy(db *gorm.DB) {
+	var marker model.Setting
+	err := db.Where("key = ?", model.SettingKeyRoleHierarchySeeded).First(&marker).Error
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error checking role hierarchy: %v", err)
+		return
+	}
+
+	for _, link := range defaultRoleHierarchy {
+		var role, inherited model.Role
+		if err := db.Where("code = ?", link[0]).First(&role).Error; err != nil {
+			log.Printf("Error loading role %s: %v", link[0], err)
+			return
+		}
+		if err := db.Where("code = ?", link[1]).First(&inherited).Error; err != nil {
+			log.Printf("Error loading role %s: %v", link[1], err)
+			return
+		}
+		if err := db.Model(&role).Association("Inherits").Append(&inherited); err != nil {
+			log.Printf("Error linking role %s to %s: %v", role.Code, inherited.Code, err)
+			return
+		}
+	}
+
+	if err := db.Create(&model.Setting{Key: model.SettingKeyRoleHierarchySeeded, Value: "true"}).Error; err != nil {
+		log.Printf("Error recording role hierarchy seeding: %v", err)
+		return
+	}
+	log.Println("Seeded role hierarchy: admin -> moderator -> user")
+}
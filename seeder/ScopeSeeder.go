@@ -0,0 +1,28 @@
+package seeder
+
+import (
+	"log"
+	"mein-idaas/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SeedScopes creates the standard OIDC scopes; descriptions edited in the database are kept
+func SeedScopes(db *gorm.DB) {
+	scopes := []model.Scope{
+		{Name: "openid", Description: "Sign you in with your account"},
+		{Name: "email", Description: "See your email address"},
+		{Name: "profile", Description: "See your name and roles"},
+		{Name: "offline_access", Description: "Stay connected while you're not using the app"},
+	}
+
+	log.Println("Seeding scopes...")
+
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&scopes).Error; err != nil {
+		log.Printf("Error seeding scopes: %v", err)
+		return
+	}
+
+	log.Println("Scope seeding completed.")
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// apiKeyMarker starts every key, so leaked keys are easy to recognize (secret scanners)
+	apiKeyMarker = "mk"
+	// apiKeyTouchInterval limits last_used_at updates of busy keys
+	apiKeyTouchInterval = time.Minute
+)
+
+// APIKeyService manages long-lived API keys of users and service accounts
+// A key authenticates requests via the X-API-Key header like an access token of its owner: same user ID,
+// the owner's current roles (MFA_REQUIRED_ROLES applies) and the key's scopes. Scopes are permission codes:
+// a key with scopes only holds the permissions of its owner that they name, and the guards only let it
+// through to routes requiring those permissions.
+type APIKeyService struct {
+	repo    repository.APIKeyRepository
+	authSvc *AuthService
+}
+
+func NewAPIKeyService(repo repository.APIKeyRepository, authSvc *AuthService) *APIKeyService {
+	return &APIKeyService{repo: repo, authSvc: authSvc}
+}
+
+// Create issues a new key for the user; the plaintext key is only returned here
+func (s *APIKeyService) Create(ctx context.Context, userID string, req *dto.CreateAPIKeyRequest) (*dto.CreateAPIKeyResponse, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+	user, err := s.authSvc.GetUserByID(ctx, userID)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		return nil, errors.New("user not found")
+	}
+
+	prefixBytes := make([]byte, 8)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	secret, err := util.GenerateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &model.APIKey{
+		UserID:     uid,
+		Name:       req.Name,
+		Prefix:     prefix,
+		SecretHash: util.HashToken(secret),
+		Scopes:     req.Scopes,
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		key.ExpiresAt = &expiresAt
+	}
+	if err := s.repo.Create(ctx, key); err != nil {
+		return nil, err
+	}
+
+	log.Printf("API key %s (%s) created for user %s", key.Prefix, key.Name, user.Email)
+	return &dto.CreateAPIKeyResponse{
+		APIKeyResponse: toAPIKeyResponse(key),
+		Key:            apiKeyMarker + "_" + prefix + "_" + secret,
+	}, nil
+}
+
+// List returns the user's keys that are not revoked (without secrets)
+func (s *APIKeyService) List(ctx context.Context, userID string) ([]dto.APIKeyResponse, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	keys, err := s.repo.ListByUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]dto.APIKeyResponse, 0, len(keys))
+	for i := range keys {
+		res = append(res, toAPIKeyResponse(&keys[i]))
+	}
+	return res, nil
+}
+
+// Revoke revokes one key of the user; requests with it fail right away
+func (s *APIKeyService) Revoke(ctx context.Context, userID string, keyID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+	kid, err := uuid.Parse(keyID)
+	if err != nil {
+		return errors.New("API key not found")
+	}
+
+	found, err := s.repo.Revoke(ctx, uid, kid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("API key not found")
+	}
+
+	log.Printf("API key %s of user %s revoked", keyID, userID)
+	return nil
+}
+
+// Lookup returns the claims of an active key (registered with util.SetAPIKeyLookup)
+func (s *APIKeyService) Lookup(ctx context.Context, rawKey string) (*dto.AuthClaims, error) {
+	parts := strings.SplitN(rawKey, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyMarker {
+		return nil, errors.New("invalid or revoked API key")
+	}
+
+	key, err := s.repo.GetActiveByPrefix(ctx, parts[1])
+	if err != nil {
+		return nil, errors.New("invalid or revoked API key")
+	}
+	if subtle.ConstantTimeCompare([]byte(key.SecretHash), []byte(util.HashToken(parts[2]))) != 1 {
+		return nil, errors.New("invalid or revoked API key")
+	}
+
+	user, err := s.authSvc.GetUserByID(ctx, key.UserID.String())
+	if err != nil || !inCurrentTenant(ctx, user) {
+		return nil, errors.New("invalid or revoked API key")
+	}
+	roleCodes, err := s.authSvc.tokenRoleCodes(ctx, key.UserID)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := util.PermissionsForRoles(ctx, roleCodes)
+	if err != nil {
+		return nil, err
+	}
+	if len(key.Scopes) > 0 {
+		permissions = scopedPermissions(permissions, key.Scopes)
+	}
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > apiKeyTouchInterval {
+		if err := s.repo.Touch(ctx, key.ID); err != nil {
+			log.Printf("failed to record use of API key %s: %v", key.Prefix, err)
+		}
+	}
+
+	claims := &dto.AuthClaims{
+		Roles:       roleCodes,
+		Permissions: permissions,
+		Tenant:      user.Tenant,
+		Scope:       strings.Join(key.Scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: key.UserID.String(),
+			ID:      key.ID.String(),
+		},
+	}
+	if key.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*key.ExpiresAt)
+	}
+	return claims, nil
+}
+
+// scopedPermissions returns the permissions that are also named in scopes
+func scopedPermissions(permissions []string, scopes []string) []string {
+	named := make(map[string]bool, len(scopes))
+	for _, s := range scopes {
+		named[s] = true
+	}
+	var kept []string
+	for _, p := range permissions {
+		if named[p] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+func toAPIKeyResponse(key *model.APIKey) dto.APIKeyResponse {
+	return dto.APIKeyResponse{
+		ID:         key.ID.String(),
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/repository"
+
+	"github.com/google/uuid"
+)
+
+// maxActivityRange bounds a DAU/MAU report
+const maxActivityRange = 366 * 24 * time.Hour
+
+// ActivityService tracks distinct active users per day from the token issuance path
+// Each replica writes a user at most once per UTC day; the insert is idempotent across replicas.
+type ActivityService struct {
+	repo repository.ActivityRepository
+
+	mu   sync.Mutex
+	day  string
+	seen map[uuid.UUID]struct{}
+}
+
+func NewActivityService(repo repository.ActivityRepository) *ActivityService {
+	return &ActivityService{repo: repo, seen: make(map[uuid.UUID]struct{})}
+}
+
+// Track marks the user active today without blocking the caller
+func (s *ActivityService) Track(userID uuid.UUID) {
+	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+
+	s.mu.Lock()
+	if s.day != today {
+		// New day: forget yesterday's users
+		s.day = today
+		s.seen = make(map[uuid.UUID]struct{})
+	}
+	if _, ok := s.seen[userID]; ok {
+		s.mu.Unlock()
+		return
+	}
+	s.seen[userID] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		if err := s.repo.Record(ctx, day, userID); err != nil {
+			log.Printf("failed to record activity for user %s: %v", userID, err)
+			// Let the next token issuance retry
+			s.mu.Lock()
+			if s.day == today {
+				delete(s.seen, userID)
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// DailyStats returns DAU and rolling 30-day MAU for every day in [from, to]
+func (s *ActivityService) DailyStats(ctx context.Context, from, to time.Time) ([]dto.DailyActiveUsers, error) {
+	if err := checkActivityRange(from, to); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.repo.DailySeries(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]dto.DailyActiveUsers, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, dto.DailyActiveUsers{Date: r.Day.Format("2006-01-02"), DAU: r.DAU, MAU: r.MAU})
+	}
+	return out, nil
+}
+
+// MonthlyStats returns distinct active users per calendar month (for billing/licensing)
+func (s *ActivityService) MonthlyStats(ctx context.Context, from, to time.Time) ([]dto.MonthlyActiveUsers, error) {
+	if err := checkActivityRange(from, to); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.repo.MonthlySeries(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]dto.MonthlyActiveUsers, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, dto.MonthlyActiveUsers{Month: r.Month.Format("2006-01"), ActiveUsers: r.ActiveUsers})
+	}
+	return out, nil
+}
+
+func checkActivityRange(from, to time.Time) error {
+	if to.Before(from) {
+		return errors.New("invalid date range")
+	}
+	if to.Sub(from) > maxActivityRange {
+		return errors.New("date range too large")
+	}
+	return nil
+}
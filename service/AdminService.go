@@ -0,0 +1,248 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+)
+
+// exportBatchSize is the number of users fetched per query during export
+const exportBatchSize = 1000
+
+type AdminService struct {
+	userRepo        repository.UserRepository
+	retentionSvc    *RetentionService
+	registrationSvc *RegistrationPolicyService
+	provisioningSvc *ProvisioningPolicyService
+	activitySvc     *ActivityService
+	keySvc          *KeyRotationService
+	authSvc         *AuthService
+	hashScanSvc     *PasswordHashScanService
+}
+
+func NewAdminService(u repository.UserRepository, retention *RetentionService, registration *RegistrationPolicyService, provisioning *ProvisioningPolicyService, activity *ActivityService, keys *KeyRotationService, auth *AuthService, hashScan *PasswordHashScanService) *AdminService {
+	return &AdminService{userRepo: u, retentionSvc: retention, registrationSvc: registration, provisioningSvc: provisioning, activitySvc: activity, keySvc: keys, authSvc: auth, hashScanSvc: hashScan}
+}
+
+// RotateSigningKey switches the global keys or a tenant's keys to a new JWT signing key
+// (the old one keeps verifying during the overlap window)
+func (s *AdminService) RotateSigningKey(ctx context.Context, tenant string) (*dto.KeyRotationResponse, error) {
+	return s.keySvc.Rotate(ctx, tenant)
+}
+
+// ListSigningKeys returns the global or a tenant's JWT signing keys and their status
+func (s *AdminService) ListSigningKeys(ctx context.Context, tenant string) ([]dto.SigningKeyInfo, error) {
+	return s.keySvc.ListKeys(ctx, tenant)
+}
+
+// AddSigningKey imports a signing key and makes it the active one of the global keys or of a tenant
+func (s *AdminService) AddSigningKey(ctx context.Context, tenant string, privateKeyPEM string, passphrase string) (*dto.KeyRotationResponse, error) {
+	return s.keySvc.AddKey(ctx, tenant, privateKeyPEM, passphrase)
+}
+
+// RetireSigningKey stops a rotated-out key from verifying tokens immediately
+func (s *AdminService) RetireSigningKey(ctx context.Context, kid string) error {
+	return s.keySvc.RetireKey(ctx, kid)
+}
+
+// GetDailyActiveUsers returns the DAU/MAU series for [from, to]
+func (s *AdminService) GetDailyActiveUsers(ctx context.Context, from, to time.Time) ([]dto.DailyActiveUsers, error) {
+	return s.activitySvc.DailyStats(ctx, from, to)
+}
+
+// GetMonthlyActiveUsers returns distinct active users per calendar month in [from, to]
+func (s *AdminService) GetMonthlyActiveUsers(ctx context.Context, from, to time.Time) ([]dto.MonthlyActiveUsers, error) {
+	return s.activitySvc.MonthlyStats(ctx, from, to)
+}
+
+// GetRegistrationSettings returns the current registration policy
+func (s *AdminService) GetRegistrationSettings(ctx context.Context) (*dto.RegistrationSettings, error) {
+	return s.registrationSvc.GetSettings(ctx)
+}
+
+// UpdateRegistrationSettings changes the registration policy at runtime
+func (s *AdminService) UpdateRegistrationSettings(ctx context.Context, settings *dto.RegistrationSettings) error {
+	return s.registrationSvc.UpdateSettings(ctx, settings)
+}
+
+// GetProvisioningSettings returns the JIT provisioning policy of federated logins
+func (s *AdminService) GetProvisioningSettings(ctx context.Context) (*dto.ProvisioningSettings, error) {
+	return s.provisioningSvc.GetSettings(ctx)
+}
+
+// UpdateProvisioningSettings changes the JIT provisioning policy at runtime
+func (s *AdminService) UpdateProvisioningSettings(ctx context.Context, settings *dto.ProvisioningSettings) error {
+	return s.provisioningSvc.UpdateSettings(ctx, settings)
+}
+
+// CreateInvite creates a single-use registration invite
+func (s *AdminService) CreateInvite(ctx context.Context, adminID string, req *dto.CreateInviteRequest) (*dto.InviteResponse, error) {
+	return s.registrationSvc.CreateInvite(ctx, adminID, req)
+}
+
+// ResetUserMFA removes every second factor of a user who lost their device; the reset is audited
+func (s *AdminService) ResetUserMFA(ctx context.Context, adminID string, userID string, reason string) error {
+	return s.authSvc.AdminResetMFA(ctx, adminID, userID, reason)
+}
+
+// SendTemporaryPassword emails a random password the user must change at the next login
+func (s *AdminService) SendTemporaryPassword(ctx context.Context, adminID string, userID string, emailSvc *EmailService) error {
+	return s.authSvc.SendTemporaryPassword(ctx, adminID, userID, emailSvc)
+}
+
+// ResetUserPassword sets a password chosen by the admin, the user must change it at the next login
+func (s *AdminService) ResetUserPassword(ctx context.Context, adminID string, userID string, password string) error {
+	return s.authSvc.AdminResetPassword(ctx, adminID, userID, password)
+}
+
+// LogoutUser ends every session of a user (refresh tokens, issued access tokens and API keys); the revocation is audited
+func (s *AdminService) LogoutUser(ctx context.Context, adminID string, userID string) error {
+	return s.authSvc.LogoutAll(ctx, adminID, userID)
+}
+
+// StartPasswordHashScan flags password hashes with a legacy scheme or outdated Argon2 parameters in the background
+func (s *AdminService) StartPasswordHashScan() (dto.PasswordHashScanReport, error) {
+	return s.hashScanSvc.Start()
+}
+
+// GetPasswordHashScan returns the progress or result of the password hash scan
+func (s *AdminService) GetPasswordHashScan() dto.PasswordHashScanReport {
+	return s.hashScanSvc.Report()
+}
+
+// GetRetentionStats returns the per-policy purge metrics of the retention jobs
+func (s *AdminService) GetRetentionStats() []RetentionStats {
+	return s.retentionSvc.Stats()
+}
+
+// ExportUsers writes every user as one JSON object per line (NDJSON)
+// Flushes after each batch so the client starts receiving data immediately
+func (s *AdminService) ExportUsers(ctx context.Context, w *bufio.Writer) error {
+	enc := json.NewEncoder(w)
+	total := 0
+
+	err := s.userRepo.StreamAll(ctx, exportBatchSize, func(users []model.User) error {
+		for _, u := range users {
+			record := userRecord(&u)
+			if err := enc.Encode(&record); err != nil {
+				return err
+			}
+		}
+		total += len(users)
+		return w.Flush()
+	})
+	if err != nil {
+		log.Printf("user export aborted after %d users: %v", total, err)
+		return err
+	}
+
+	log.Printf("user export completed: %d users", total)
+	return nil
+}
+
+// Page size of the user listing when none is requested
+const defaultUserPageSize = 20
+
+// ListUsers returns one page of the users matching the filters of req (validated), pages start at 1
+func (s *AdminService) ListUsers(ctx context.Context, req *dto.ListUsersRequest) (*dto.UserListResponse, error) {
+	query := repository.UserListQuery{
+		EmailContains: req.Email,
+		Verified:      req.Verified,
+		Role:          req.Role,
+		Sort:          req.Sort,
+		Desc:          req.Order != "asc",
+	}
+	if req.CreatedFrom != "" {
+		from, err := time.Parse("2006-01-02", req.CreatedFrom)
+		if err != nil {
+			return nil, errors.New("invalid created_from date, expected YYYY-MM-DD")
+		}
+		query.CreatedFrom = &from
+	}
+	if req.CreatedTo != "" {
+		to, err := time.Parse("2006-01-02", req.CreatedTo)
+		if err != nil {
+			return nil, errors.New("invalid created_to date, expected YYYY-MM-DD")
+		}
+		before := to.AddDate(0, 0, 1) // The whole day is included
+		query.CreatedBefore = &before
+	}
+
+	page, size := userPage(req.Page, req.Size)
+	query.Offset, query.Limit = (page-1)*size, size
+
+	users, total, err := s.userRepo.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return userListResponse(users, total, page, size), nil
+}
+
+// SearchUsers returns one page of the users whose name or email contains every word of req.Q (validated),
+// best matches first
+func (s *AdminService) SearchUsers(ctx context.Context, req *dto.SearchUsersRequest) (*dto.UserListResponse, error) {
+	terms := strings.Fields(req.Q)
+	if len(terms) == 0 {
+		return nil, errors.New("search query is empty")
+	}
+
+	page, size := userPage(req.Page, req.Size)
+	users, total, err := s.userRepo.Search(ctx, terms, (page-1)*size, size)
+	if err != nil {
+		return nil, err
+	}
+	return userListResponse(users, total, page, size), nil
+}
+
+// userPage applies the defaults to the requested page (1) and page size (defaultUserPageSize)
+func userPage(page, size int) (int, int) {
+	if page == 0 {
+		page = 1
+	}
+	if size == 0 {
+		size = defaultUserPageSize
+	}
+	return page, size
+}
+
+// userListResponse builds one page of a user listing
+func userListResponse(users []model.User, total int64, page, size int) *dto.UserListResponse {
+	res := &dto.UserListResponse{
+		Users:      make([]dto.UserExportRecord, 0, len(users)),
+		Page:       page,
+		Size:       size,
+		Total:      total,
+		TotalPages: (total + int64(size) - 1) / int64(size),
+	}
+	for i := range users {
+		res.Users = append(res.Users, userRecord(&users[i]))
+	}
+	return res
+}
+
+// userRecord is the admin view of a user (export and listing)
+func userRecord(u *model.User) dto.UserExportRecord {
+	roles := make([]string, 0, len(u.Roles))
+	for _, r := range u.Roles {
+		roles = append(roles, r.Code)
+	}
+	return dto.UserExportRecord{
+		ID:              u.ID.String(),
+		Name:            u.Name,
+		Email:           u.Email,
+		IsEmailVerified: u.IsEmailVerified,
+		IsMFAEnabled:    u.IsMFAEnabled,
+		Roles:           roles,
+		Metadata:        u.Metadata,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+	}
+}
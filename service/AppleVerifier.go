@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/util"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	appleIssuer        = "https://appleid.apple.com"
+	appleKeysURL       = "https://appleid.apple.com/auth/keys"
+	appleTokenURL      = "https://appleid.apple.com/auth/token"
+	applePrivateRelay  = "@privaterelay.appleid.com"
+	appleSecretTTL     = 10 * time.Minute
+	appleKeysCooldown  = time.Minute
+	appleMaxSecretSkew = time.Minute
+)
+
+// appleVerifier validates Sign in with Apple ID tokens against Apple's JWKS and redeems authorization codes
+// Code exchange needs the ES256 client secret signed with the Sign in with Apple key (APPLE_TEAM_ID, APPLE_KEY_ID, APPLE_PRIVATE_KEY).
+type appleVerifier struct {
+	clientID   string // Services ID (web) or bundle ID (native apps)
+	teamID     string
+	keyID      string
+	privateKey *ecdsa.PrivateKey // nil: only ID tokens are accepted
+	client     *http.Client
+
+	mu              sync.Mutex
+	keys            map[string]crypto.PublicKey
+	keysRefreshedAt time.Time
+}
+
+// parseApplePrivateKey reads the .p8 key downloaded from the Apple developer portal (PKCS#8, P-256)
+func parseApplePrivateKey(pemData string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ec, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an EC private key")
+	}
+	return ec, nil
+}
+
+func (v *appleVerifier) Verify(ctx context.Context, token string) (*ExternalIdentity, error) {
+	var claims struct {
+		Email          string      `json:"email"`
+		EmailVerified  interface{} `json:"email_verified"`   // Apple sends a bool or "true"/"false"
+		IsPrivateEmail interface{} `json:"is_private_email"` // same
+		jwt.RegisteredClaims
+	}
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.publicKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(appleIssuer),
+		jwt.WithAudience(v.clientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("apple token has no subject")
+	}
+
+	identity := &ExternalIdentity{
+		Type:          model.CredTypeApple,
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: appleBool(claims.EmailVerified),
+	}
+	// Private relay addresses forward to the user's real mailbox; they're verified by Apple but only
+	// deliverable from the sender domains registered for the Services ID
+	identity.PrivateEmail = appleBool(claims.IsPrivateEmail) || strings.HasSuffix(identity.Email, applePrivateRelay)
+	return identity, nil
+}
+
+// appleBool reads Apple's boolean claims, which are JSON booleans or strings
+func appleBool(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	}
+	return false
+}
+
+// exchangeCode redeems an authorization code at Apple's token endpoint and returns the ID token
+// redirectURI is required for web logins and empty for codes issued to native apps.
+func (v *appleVerifier) exchangeCode(ctx context.Context, code string, redirectURI string) (string, error) {
+	secret, err := v.clientSecret(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {v.clientID},
+		"client_secret": {secret},
+	}
+	if redirectURI != "" {
+		form.Set("redirect_uri", redirectURI)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, appleTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("apple token endpoint returned %d (%s)", resp.StatusCode, token.Error)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("apple token response has no id_token")
+	}
+	return token.IDToken, nil
+}
+
+// clientSecret builds the ES256 JWT Apple expects as client_secret (valid for a few minutes)
+func (v *appleVerifier) clientSecret(now time.Time) (string, error) {
+	if v.privateKey == nil {
+		return "", errors.New("apple private key not configured")
+	}
+
+	claims := jwt.RegisteredClaims{
+		Issuer:    v.teamID,
+		Subject:   v.clientID,
+		Audience:  jwt.ClaimStrings{appleIssuer},
+		IssuedAt:  jwt.NewNumericDate(now.Add(-appleMaxSecretSkew)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(appleSecretTTL)),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = v.keyID
+	return token.SignedString(v.privateKey)
+}
+
+// publicKey returns Apple's signing key for kid, refetching the JWKS when it is unknown (key rotation)
+func (v *appleVerifier) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if v.keys != nil && time.Since(v.keysRefreshedAt) < appleKeysCooldown {
+		return nil, fmt.Errorf("unknown apple signing key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, appleKeysURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("apple keys endpoint returned %d", resp.StatusCode)
+	}
+
+	var jwks dto.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if key, err := util.ParsePublicJWK(jwk); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	v.keys = keys
+	v.keysRefreshedAt = time.Now()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown apple signing key %q", kid)
+}
@@ -1,10 +1,15 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"mein-idaas/dto"
@@ -15,654 +20,3556 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	// mfaChallengeTTL is how long a password-checked login may wait for its TOTP code
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaMaxFailures drops a login challenge after this many wrong codes
+	mfaMaxFailures = 5
+	// recoveryCodeCount is how many MFA recovery codes a user gets
+	recoveryCodeCount = 10
+	// mfaResetActorSelf marks resets requested by the user (admin resets record the admin's ID)
+	mfaResetActorSelf = "self"
+	// mfaMaxCodesSent limits the SMS and email codes sent for one login challenge
+	mfaMaxCodesSent = 3
+	// passwordChangeTTL is how long a login with an expired password may take to set a new one
+	passwordChangeTTL = 10 * time.Minute
+)
+
 type AuthService struct {
 	userRepo        repository.UserRepository
 	credentialRepo  repository.CredentialRepository
 	refreshRepo     repository.RefreshTokenRepository
 	roleRepo        repository.RoleRepository
+	roleCache       repository.RoleCache
+	uow             repository.UnitOfWork
+	deviceRepo      repository.RememberedDeviceRepository
 	verificationSvc *VerificationService
+	registrationSvc *RegistrationPolicyService
+	activitySvc     *ActivityService
+	opaqueTokens    *OpaqueTokenService
+	denylist        repository.TokenDenylist // Revoked JWT access tokens (jti)
+	provisioningSvc *ProvisioningPolicyService
+	ldap            *LDAPAuthenticator
+	passwordPolicy  *PasswordPolicyService
+	// mfaResetCooldown delays self-service MFA resets (MFA_RESET_COOLDOWN, default 72h)
+	mfaResetCooldown time.Duration
+	// mfaRequiredRoles are only put into tokens of users with a second factor (MFA_REQUIRED_ROLES, e.g. "admin")
+	mfaRequiredRoles map[string]bool
+	// rememberDeviceTTL is how long a remembered device skips MFA (MFA_REMEMBER_DEVICE_TTL, default 720h, 0 disables)
+	rememberDeviceTTL time.Duration
+	// magicLinkTTL is how long an emailed login link stays valid (MAGIC_LINK_TTL, default 15m)
+	magicLinkTTL time.Duration
+	// passwordMaxAge forces a password change at login once the password is older (PASSWORD_MAX_AGE, default 0 = never)
+	passwordMaxAge time.Duration
+	// lockoutThreshold consecutive failed password logins lock the account for lockoutDuration
+	// (LOCKOUT_THRESHOLD, default 10, 0 disables; LOCKOUT_DURATION, default 15m)
+	lockoutThreshold int
+	lockoutDuration  time.Duration
+	// backoffBase is the delay after the first failed password or code attempt beyond backoffFree, doubled with every
+	// further failure up to backoffMax, per account and per client IP
+	// (AUTH_BACKOFF_BASE, default 1s, 0 disables; AUTH_BACKOFF_MAX, default 5m; AUTH_BACKOFF_FREE_ATTEMPTS, default 3)
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	backoffFree int
+	// refreshBinding is what happens when a refresh token comes from another client than the one it was issued to
+	// (REFRESH_BINDING_POLICY: off (default), warn, relogin or revoke), compared on refreshBindingFields
+	// (REFRESH_BINDING_FIELDS: ip and/or user_agent, default both)
+	refreshBinding       string
+	refreshBindingFields map[string]bool
+	// maxSessions caps the active sessions (refresh tokens) of a user; a login beyond it revokes the oldest session
+	// or is rejected (MAX_SESSIONS_PER_USER, default 0 = unlimited; SESSION_LIMIT_POLICY: revoke_oldest (default) or reject)
+	maxSessions  int
+	sessionLimit string
+	// passwordResetURL is the page of the client app where a user picks a new password; password reset emails
+	// link to it with ?token= (PASSWORD_RESET_URL, default empty: the email only contains the code)
+	passwordResetURL string
 }
 
-// NewAuthService now requires RoleRepository and VerificationService
+// NewAuthService now requires RoleRepository, RoleCache, UnitOfWork, RememberedDeviceRepository, VerificationService, RegistrationPolicyService, ActivityService, OpaqueTokenService and ProvisioningPolicyService
+// ldap may be nil (LDAP login disabled)
 func NewAuthService(
 	u repository.UserRepository,
 	c repository.CredentialRepository,
 	r repository.RefreshTokenRepository,
 	role repository.RoleRepository,
+	roleCache repository.RoleCache,
+	uow repository.UnitOfWork,
+	devices repository.RememberedDeviceRepository,
 	verification *VerificationService,
+	registration *RegistrationPolicyService,
+	activity *ActivityService,
+	opaque *OpaqueTokenService,
+	denylist repository.TokenDenylist,
+	provisioning *ProvisioningPolicyService,
+	ldap *LDAPAuthenticator,
+	passwordPolicy *PasswordPolicyService,
 ) *AuthService {
 	return &AuthService{
-		userRepo:        u,
-		credentialRepo:  c,
-		refreshRepo:     r,
-		roleRepo:        role,
-		verificationSvc: verification,
+		userRepo:             u,
+		credentialRepo:       c,
+		refreshRepo:          r,
+		roleRepo:             role,
+		roleCache:            roleCache,
+		uow:                  uow,
+		deviceRepo:           devices,
+		verificationSvc:      verification,
+		registrationSvc:      registration,
+		activitySvc:          activity,
+		opaqueTokens:         opaque,
+		denylist:             denylist,
+		provisioningSvc:      provisioning,
+		ldap:                 ldap,
+		passwordPolicy:       passwordPolicy,
+		mfaResetCooldown:     envDuration("MFA_RESET_COOLDOWN", 72*time.Hour),
+		mfaRequiredRoles:     parseRoleSet(os.Getenv("MFA_REQUIRED_ROLES")),
+		rememberDeviceTTL:    envDuration("MFA_REMEMBER_DEVICE_TTL", 30*24*time.Hour),
+		magicLinkTTL:         envDuration("MAGIC_LINK_TTL", 15*time.Minute),
+		passwordMaxAge:       envDuration("PASSWORD_MAX_AGE", 0),
+		lockoutThreshold:     envInt("LOCKOUT_THRESHOLD", 10, 0, 1000),
+		lockoutDuration:      envDuration("LOCKOUT_DURATION", 15*time.Minute),
+		backoffBase:          envDuration("AUTH_BACKOFF_BASE", time.Second),
+		backoffMax:           envDuration("AUTH_BACKOFF_MAX", 5*time.Minute),
+		backoffFree:          envInt("AUTH_BACKOFF_FREE_ATTEMPTS", 3, 0, 1000),
+		refreshBinding:       parseRefreshBinding(os.Getenv("REFRESH_BINDING_POLICY")),
+		refreshBindingFields: parseRefreshBindingFields(os.Getenv("REFRESH_BINDING_FIELDS")),
+		maxSessions:          envInt("MAX_SESSIONS_PER_USER", 0, 0, 10000),
+		sessionLimit:         parseSessionLimitPolicy(os.Getenv("SESSION_LIMIT_POLICY")),
+		passwordResetURL:     os.Getenv("PASSWORD_RESET_URL"),
 	}
 }
 
-// Register creates a new user, assigns default role, and creates credentials
-func (s *AuthService) Register(req *dto.RegisterRequest) (*dto.RegisterResponse, error) {
-	// 1. Start a Transaction (All or Nothing)
-	tx := s.userRepo.GetDB().Begin()
-
-	// Safety: Rollback if panic occurs or if we forget to commit
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+// parseRoleSet reads a comma-separated list of role codes
+func parseRoleSet(value string) map[string]bool {
+	roles := make(map[string]bool)
+	for _, r := range strings.Split(value, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roles[r] = true
 		}
-	}()
-
-	// 2. Prepare User
-	user := &model.User{
-		Name:  req.Name,
-		Email: req.Email,
 	}
+	return roles
+}
 
-	// 3. Attach Role
-	defaultRole, err := s.roleRepo.GetByCode("user")
-	if err != nil {
-		tx.Rollback()
-		return nil, errors.New("system error: default role not found")
+// withholdMFARoles drops the MFA_REQUIRED_ROLES from the token roles of a user without a second factor
+// Returns the roles to put into the token and whether any were withheld.
+func (s *AuthService) withholdMFARoles(user *model.User, roleCodes []string) ([]string, bool) {
+	if len(s.mfaRequiredRoles) == 0 || mfaRequired(user) {
+		return roleCodes, false
 	}
-	user.Roles = append(user.Roles, *defaultRole)
 
-	// 🛡️ CRITICAL SAFETY: Force Credentials to nil to prevent "Double Save"
-	user.Credentials = nil
-
-	// 4. Create User (USING 'tx', not 's.userRepo')
-	if err := tx.Create(user).Error; err != nil {
-		tx.Rollback()
-		if util.IsDuplicateKeyError(err) {
-			return nil, errors.New("email already in use")
+	kept := make([]string, 0, len(roleCodes))
+	for _, code := range roleCodes {
+		if !s.mfaRequiredRoles[code] {
+			kept = append(kept, code)
 		}
-		return nil, err
 	}
+	return kept, len(kept) != len(roleCodes)
+}
 
-	// 5. Hash Password
-	hashed, err := util.HashPassword(req.Password)
+// tokenRoleCodes returns the role codes for a refreshed token, expanded by role inheritance and applying
+// the MFA role policy. The user is only loaded when one of the roles requires MFA.
+func (s *AuthService) tokenRoleCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	roleCodes, err := s.getRoleCodes(ctx, userID)
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
-
-	// 6. Create Credential (USING 'tx')
-	cred := &model.Credential{
-		UserID: user.ID,
-		Type:   model.CredTypePassword, // Make sure this matches your Enum
-		Value:  hashed,
-	}
-
-	if err := tx.Debug().Create(cred).Error; err != nil {
-		tx.Rollback()
-		// This will print the exact SQL error to your API response
-		return nil, errors.New("SQL ERROR: " + err.Error())
-	}
-
-	// 7. Commit (Save everything permanently)
-	if err := tx.Commit().Error; err != nil {
+	expanded, err := util.ExpandRoles(ctx, roleCodes)
+	if err != nil {
 		return nil, err
 	}
 
-	// 8. Trigger verification email asynchronously (log failures)
-	if s.verificationSvc != nil {
-		if err := s.verificationSvc.SendVerificationCode(user.ID.String(), user.Email); err != nil {
-			log.Printf("failed to initiate verification email for %s: %v", user.Email, err)
-		} else {
-			log.Printf("verification email initiated for %s", user.Email)
+	for _, code := range expanded {
+		if s.mfaRequiredRoles[code] {
+			user, err := s.userRepo.GetByID(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+			expanded, _, err = s.expandTokenRoles(ctx, user, roleCodes)
+			return expanded, err
 		}
-	} else {
-		log.Printf("no verification service configured; skipping verification email for %s", user.Email)
 	}
-
-	return &dto.RegisterResponse{ID: user.ID.String(), Name: user.Name, Email: user.Email}, nil
+	return expanded, nil
 }
 
-// Login validates credentials and returns a token pair
-func (s *AuthService) Login(req *dto.LoginRequest, clientIP, userAgent string) (*dto.LoginResponse, error) {
-	user, err := s.userRepo.GetByEmail(req.Email)
+// expandTokenRoles expands the user's role codes by inheritance for a token; MFA_REQUIRED_ROLES are withheld
+// from users without a second factor before and after the expansion, so neither a withheld role's inherited
+// roles nor an MFA-required role inherited from another role get in. Reports whether any were withheld.
+func (s *AuthService) expandTokenRoles(ctx context.Context, user *model.User, roleCodes []string) ([]string, bool, error) {
+	roleCodes, withheld := s.withholdMFARoles(user, roleCodes)
+	expanded, err := util.ExpandRoles(ctx, roleCodes)
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, false, err
 	}
+	expanded, withheldInherited := s.withholdMFARoles(user, expanded)
+	return expanded, withheld || withheldInherited, nil
+}
 
-	var pwCred *model.Credential
-	for _, c := range user.Credentials {
-		if c.Type == model.CredTypePassword {
-			pwCred = &c
-			break
+// getRoleCodes returns the user's role codes, served from the role cache when possible
+func (s *AuthService) getRoleCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	if s.roleCache != nil {
+		if roles, ok := s.roleCache.Get(userID); ok {
+			return roles, nil
 		}
 	}
-	if pwCred == nil {
-		return nil, errors.New("invalid credentials")
+
+	roles, err := s.userRepo.GetRoleCodes(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := util.ComparePassword(pwCred.Value, req.Password); err != nil {
-		return nil, errors.New("invalid credentials")
+	if s.roleCache != nil {
+		s.roleCache.Set(userID, roles)
 	}
+	return roles, nil
+}
 
-	// Check if email is verified
-	if !user.IsEmailVerified {
-		// Send verification email asynchronously (log failures)
-		if s.verificationSvc != nil {
-			if err := s.verificationSvc.SendVerificationCode(user.ID.String(), user.Email); err != nil {
-				log.Printf("failed to send verification email for %s: %v", user.Email, err)
-			} else {
-				log.Printf("verification email sent for unverified user %s", user.Email)
-			}
-		}
-		return nil, errors.New("email not verified")
+// userRoleCodes returns the codes of the roles a user has directly (user.Roles, preloaded) and through groups
+func userRoleCodes(ctx context.Context, users repository.UserRepository, user *model.User) ([]string, error) {
+	groupCodes, err := users.GetGroupRoleCodes(ctx, user.ID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Extract Roles for Token
-	var roleCodes []string
+	codes := make([]string, 0, len(user.Roles)+len(groupCodes))
+	seen := make(map[string]bool, cap(codes))
 	for _, r := range user.Roles {
-		roleCodes = append(roleCodes, r.Code)
+		if !seen[r.Code] {
+			seen[r.Code] = true
+			codes = append(codes, r.Code)
+		}
 	}
+	for _, code := range groupCodes {
+		if !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	return codes, nil
+}
 
-	// Generate Tokens with Roles
-	pair, err := util.GenerateTokens(user.ID, roleCodes)
+// saveWithEvent runs save and writes an identity event to the outbox in the same transaction
+func (s *AuthService) saveWithEvent(ctx context.Context, eventType string, user *model.User, save func(repos *repository.Repositories) error) error {
+	event, err := NewOutboxEvent(eventType, dto.UserEvent{UserID: user.ID.String(), Email: user.Email})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	hash := util.HashToken(pair.RefreshToken)
+	return s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		if err := save(repos); err != nil {
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	})
+}
 
-	// Get refresh TTL from env (default 168h = 7 days)
-	refreshTTLStr := os.Getenv("JWT_REFRESH_TTL")
-	if refreshTTLStr == "" {
-		refreshTTLStr = "168h"
+// trackActivity counts the user as active today (DAU/MAU reporting)
+func (s *AuthService) trackActivity(userID uuid.UUID) {
+	if s.activitySvc != nil {
+		s.activitySvc.Track(userID)
 	}
-	refreshTTL, _ := time.ParseDuration(refreshTTLStr)
+}
 
-	rt := &model.RefreshToken{
-		ID:        pair.RefreshID,
-		UserID:    user.ID,
-		TokenHash: hash,
-		ExpiresAt: time.Now().Add(refreshTTL),
-		ClientIP:  clientIP,
-		UserAgent: userAgent,
-	}
-	if err := s.refreshRepo.Create(rt); err != nil {
-		return nil, err
-	}
+// inCurrentTenant reports whether the user belongs to the tenant the request is scoped to
+// Accounts of one organization can't log in through another organization's routes
+func inCurrentTenant(ctx context.Context, user *model.User) bool {
+	return user.Tenant == util.TenantFromContext(ctx)
+}
 
-	// Get access token TTL in seconds for response
-	accessTTLStr := os.Getenv("JWT_ACCESS_TTL")
-	if accessTTLStr == "" {
-		accessTTLStr = "15m"
+// InvalidateUserRoles drops the cached roles of a user. Must be called after any role change.
+func (s *AuthService) InvalidateUserRoles(userID uuid.UUID) {
+	if s.roleCache != nil {
+		s.roleCache.Invalidate(userID)
 	}
-	accessTTL, _ := time.ParseDuration(accessTTLStr)
-	expiresIn := int(accessTTL.Seconds())
-
-	return &dto.LoginResponse{AccessToken: pair.AccessToken, RefreshToken: pair.RefreshToken, ExpiresIn: expiresIn}, nil
 }
 
-// Refresh rotates refresh tokens and issues a new access token
-func (s *AuthService) Refresh(req *dto.RefreshRequest, clientIP, userAgent string) (*dto.RefreshResponse, error) {
-	// 1. Parse & Validate basic structure
-	userIDFromToken, refreshID, err := util.ParseRefreshToken(req.RefreshToken)
-	if err != nil {
-		return nil, errors.New("invalid refresh token")
+// Register creates a new user, assigns default role, and creates credentials
+// metadata holds the already-validated extra registration fields (may be nil)
+func (s *AuthService) Register(ctx context.Context, req *dto.RegisterRequest, metadata map[string]interface{}) (*dto.RegisterResponse, error) {
+	if err := s.passwordPolicy.Check(ctx, req.Password, req.Email, req.Name); err != nil {
+		return nil, err
 	}
 
-	// 2. Load Token from DB
-	existing, err := s.refreshRepo.GetByID(refreshID)
-	if err != nil {
-		return nil, errors.New("invalid or unknown refresh token")
+	// 1. Prepare User (ID generated up front so an invite can record who redeemed it)
+	user := &model.User{
+		ID:       uuid.New(),
+		Name:     req.Name,
+		Email:    req.Email,
+		Metadata: metadata,
+		Tenant:   util.TenantFromContext(ctx),
 	}
 
-	// 3. Security Checks
-	if existing.UserID != userIDFromToken {
-		return nil, errors.New("user mismatch")
-	}
-	if existing.RevokedAt != nil {
-		return nil, errors.New("token was revoked")
+	// 2. Hash Password (outside the transaction: Argon2 is slow)
+	hashed, err := util.HashPassword(req.Password)
+	if err != nil {
+		return nil, err
 	}
 
-	// ---------------------------------------------------------
-	// 4. GRACE PERIOD & REUSE DETECTION LOGIC
-	// ---------------------------------------------------------
-
-	if existing.ReplacedAt != nil {
-		duration := time.Since(*existing.ReplacedAt)
-
-		// Get grace period from env (default 10s)
-		gracePeriodStr := os.Getenv("REFRESH_GRACE_PERIOD")
-		if gracePeriodStr == "" {
-			gracePeriodStr = "10s"
+	// 3. Create user, credential and outbox event atomically (All or Nothing)
+	err = s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		// Registration policy (closed / invite-only / allowed domains)
+		if s.registrationSvc != nil {
+			if err := s.registrationSvc.Authorize(ctx, repos, user.Email, req.InviteToken, user.ID); err != nil {
+				return err
+			}
 		}
-		gracePeriod, _ := time.ParseDuration(gracePeriodStr)
 
-		// CASE A: Theft Detected (Replay attack after grace period)
-		if duration > gracePeriod {
-			return nil, errors.New("refresh token reuse detected: account locked for security")
+		// Attach Role
+		defaultRole, err := repos.Roles.GetByCode(ctx, "user")
+		if err != nil {
+			return errors.New("system error: default role not found")
 		}
+		user.Roles = append(user.Roles, *defaultRole)
 
-		// CASE B: Grace Period (Concurrency retry)
-		if existing.ReplacedByTokenID == nil {
-			return nil, errors.New("system inconsistency: replaced timestamp set but no replacement ID")
-		}
+		// 🛡️ CRITICAL SAFETY: Force Credentials to nil to prevent "Double Save"
+		user.Credentials = nil
 
-		// 1. Find the token that ALREADY replaced this one
-		childToken, err := s.refreshRepo.GetByID(*existing.ReplacedByTokenID)
-		if err != nil {
-			return nil, errors.New("child token not found")
+		// Release the email if an unverified account has been squatting it past its TTL.
+		// Runs in the same transaction, so a failed registration keeps the old account.
+		if ttl := util.GetUnverifiedAccountTTL(); ttl > 0 {
+			released, err := repos.Users.DeleteUnverifiedByEmail(ctx, user.Email, time.Now().Add(-ttl))
+			if err != nil {
+				return err
+			}
+			if released > 0 {
+				log.Printf("replaced expired unverified account for %s", user.Email)
+			}
 		}
 
-		// 2. Fetch User for Roles
-		user, err := s.userRepo.GetByID(existing.UserID)
-		if err != nil {
-			return nil, errors.New("failed to fetch user")
-		}
-		var roleCodes []string
-		for _, r := range user.Roles {
-			roleCodes = append(roleCodes, r.Code)
+		// Create User
+		if err := repos.Users.Create(ctx, user); err != nil {
+			if util.IsDuplicateKeyError(err) {
+				return errors.New("email already in use")
+			}
+			return err
 		}
 
-		// 3. Generate ONLY a new Access Token
-		newAccessToken, err := util.GenerateAccessTokenOnly(user.ID, roleCodes)
-		if err != nil {
-			return nil, err
+		// Create Credential
+		cred := &model.Credential{
+			UserID: user.ID,
+			Type:   model.CredTypePassword, // Make sure this matches your Enum
+			Value:  hashed,
+		}
+		if err := repos.Credentials.Create(ctx, cred); err != nil {
+			log.Printf("failed to create password credential for %s: %v", user.Email, err)
+			return errors.New("failed to create credentials")
 		}
 
-		// 4. Re-sign the EXISTING child token ID
-		refreshTokenString, err := util.SignRefreshToken(childToken.ID, childToken.UserID)
+		// Outbox Event: the verification email is sent by the outbox dispatcher,
+		// so it survives a crash right after commit
+		event, err := NewOutboxEvent(model.EventUserRegistered, dto.UserRegisteredEvent{
+			UserID: user.ID.String(),
+			Email:  user.Email,
+			Name:   user.Name,
+			Tenant: user.Tenant,
+		})
 		if err != nil {
-			return nil, err
+			return err
 		}
-
-		// 5. Get access token TTL in seconds for response
-		accessTTLStr := os.Getenv("JWT_ACCESS_TTL")
-		if accessTTLStr == "" {
-			accessTTLStr = "15m"
+		if err := repos.Outbox.Create(ctx, event); err != nil {
+			log.Printf("failed to write registration outbox event for %s: %v", user.Email, err)
+			return errors.New("failed to create user")
 		}
-		accessTTL, _ := time.ParseDuration(accessTTLStr)
-		expiresIn := int(accessTTL.Seconds())
-
-		return &dto.RefreshResponse{
-			AccessToken:  newAccessToken,
-			RefreshToken: refreshTokenString,
-			ExpiresIn:    expiresIn,
-		}, nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// ---------------------------------------------------------
-	// 5. NORMAL ROTATION (First time using this token)
-	// ---------------------------------------------------------
+	return &dto.RegisterResponse{ID: user.ID.String(), Name: user.Name, Email: user.Email}, nil
+}
 
-	// Fetch User Roles
-	user, err := s.userRepo.GetByID(existing.UserID)
+// Login validates credentials and returns a token pair
+// deviceToken is the remembered-device cookie, if any; a valid one skips the MFA challenge.
+func (s *AuthService) Login(ctx context.Context, req *dto.LoginRequest, deviceToken string, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	login := req.Email
+	if login == "" {
+		login = req.Username
+	}
+	user, err := s.AuthenticatePassword(ctx, login, req.Password, clientIP)
 	if err != nil {
-		return nil, errors.New("user not found")
+		return nil, err
 	}
 
-	var roleCodes []string
-	for _, r := range user.Roles {
-		roleCodes = append(roleCodes, r.Code)
+	// No tokens until an expired or temporary password is replaced (ChangeExpiredPassword)
+	if s.PasswordExpired(user) || mustChangePassword(user) {
+		return s.startPasswordChange(user)
 	}
 
-	// Generate NEW Pair
-	pair, err := util.GenerateTokens(existing.UserID, roleCodes)
-	if err != nil {
-		return nil, err
+	return s.completeLogin(ctx, user, dto.AMRPassword, deviceToken, req.RememberMe, clientIP, userAgent)
+}
+
+// passwordCredential returns the user's password credential, nil for passwordless accounts
+func passwordCredential(user *model.User) *model.Credential {
+	for i, c := range user.Credentials {
+		if c.Type == model.CredTypePassword {
+			return &user.Credentials[i]
+		}
 	}
+	return nil
+}
 
-	// Get refresh TTL from env (default 168h = 7 days)
-	refreshTTLStr := os.Getenv("JWT_REFRESH_TTL")
-	if refreshTTLStr == "" {
-		refreshTTLStr = "168h"
+// PasswordExpired reports whether the user's password is older than PASSWORD_MAX_AGE
+func (s *AuthService) PasswordExpired(user *model.User) bool {
+	if s.passwordMaxAge <= 0 {
+		return false
 	}
-	refreshTTL, _ := time.ParseDuration(refreshTTLStr)
+	pwCred := passwordCredential(user)
+	return pwCred != nil && time.Since(pwCred.PasswordSetAt()) > s.passwordMaxAge
+}
 
-	// Save the NEW Token
-	newHash := util.HashToken(pair.RefreshToken)
-	newRT := &model.RefreshToken{
-		ID:        pair.RefreshID,
-		UserID:    existing.UserID,
-		TokenHash: newHash,
-		ExpiresAt: time.Now().Add(refreshTTL),
-		ClientIP:  clientIP,
-		UserAgent: userAgent,
+// mustChangePassword reports whether the user logged in with a password set by an admin
+func mustChangePassword(user *model.User) bool {
+	pwCred := passwordCredential(user)
+	return pwCred != nil && pwCred.MustChangePassword
+}
+
+// startPasswordChange issues the single-use token that lets a login with an expired or temporary password set a new one
+// The token is only accepted by ChangeExpiredPassword, so it grants nothing but the password change.
+func (s *AuthService) startPasswordChange(user *model.User) (*dto.LoginResponse, error) {
+	if s.verificationSvc == nil {
+		return nil, errors.New("verification service not configured")
 	}
-	if err := s.refreshRepo.Create(newRT); err != nil {
+
+	token, err := util.GenerateSecureToken(32)
+	if err != nil {
 		return nil, err
 	}
-
-	// Mark OLD Token as Replaced (Link it to the new one)
-	now := time.Now()
-	existing.ReplacedAt = &now
-	existing.ReplacedByTokenID = &pair.RefreshID
-	if err := s.refreshRepo.Update(existing); err != nil {
-		_ = s.refreshRepo.Delete(newRT.ID)
-		return nil, errors.New("failed to rotate token")
+	if err := s.verificationSvc.StoreCode(passwordChangeKey(token), user.ID.String(), passwordChangeTTL); err != nil {
+		return nil, err
 	}
 
-	// Get access token TTL in seconds for response
-	accessTTLStr := os.Getenv("JWT_ACCESS_TTL")
-	if accessTTLStr == "" {
-		accessTTLStr = "15m"
+	temporary := mustChangePassword(user)
+	if temporary {
+		log.Printf("user %s logged in with a temporary password, password change required", user.Email)
+	} else {
+		log.Printf("password of user %s expired, password change required", user.Email)
 	}
-	accessTTL, _ := time.ParseDuration(accessTTLStr)
-	expiresIn := int(accessTTL.Seconds())
+	return &dto.LoginResponse{
+		PasswordChangeToken: token,
+		MustChangePassword:  temporary,
+		ExpiresIn:           int(passwordChangeTTL.Seconds()),
+	}, nil
+}
 
-	return &dto.RefreshResponse{AccessToken: pair.AccessToken, RefreshToken: pair.RefreshToken, ExpiresIn: expiresIn}, nil
+func passwordChangeKey(token string) string {
+	return "password_expired:" + util.HashToken(token)
 }
 
-// GetUserByID retrieves a user by ID with their roles and credentials
-func (s *AuthService) GetUserByID(userID string) (*model.User, error) {
+// ChangeExpiredPassword sets a new password for a login that returned password_expired, then continues the login
+// The old password was already checked at login, so the token stands in for it. The new password must differ.
+// Also used to replace a temporary password (must_change_password).
+func (s *AuthService) ChangeExpiredPassword(ctx context.Context, token string, newPassword string, deviceToken string, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	if s.verificationSvc == nil {
+		return nil, errors.New("verification service not configured")
+	}
+
+	key := passwordChangeKey(token)
+	userID, err := s.verificationSvc.GetCode(key)
+	if err != nil {
+		return nil, errors.New("invalid or expired password change token")
+	}
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		return nil, errors.New("invalid user ID format")
+		return nil, errors.New("invalid or expired password change token")
+	}
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		return nil, errors.New("invalid or expired password change token")
+	}
+
+	pwCred := passwordCredential(user)
+	if pwCred == nil {
+		return nil, errors.New("password credential not found")
 	}
-	return s.userRepo.GetByID(uid)
+	if util.ComparePassword(pwCred.Value, newPassword) == nil {
+		return nil, errors.New("new password must be different from old password")
+	}
+	if err := s.passwordPolicy.Check(ctx, newPassword, user.Email, user.Name); err != nil {
+		return nil, err
+	}
+
+	hashed, err := util.HashPassword(newPassword)
+	if err != nil {
+		return nil, err
+	}
+	pwCred.SetPassword(hashed)
+	if err := s.saveWithEvent(ctx, model.EventUserPasswordChanged, user, func(repos *repository.Repositories) error {
+		return repos.Credentials.Update(ctx, pwCred)
+	}); err != nil {
+		return nil, err
+	}
+	_ = s.verificationSvc.DeleteCode(key)
+
+	log.Printf("expired or temporary password replaced for user %s", user.Email)
+	return s.completeLogin(ctx, user, dto.AMRPassword, deviceToken, false, clientIP, userAgent)
 }
 
-// GetUserByEmail retrieves a user by email with their roles and credentials
-func (s *AuthService) GetUserByEmail(email string) (*model.User, error) {
-	return s.userRepo.GetByEmail(email)
+// AuthenticatePassword checks the password of the account identified by login (email or username)
+// and requires a verified email. Shared by the JSON login and the hosted login pages. When the local password doesn't match
+// and LDAP is configured, the directory is tried next (see authenticateLDAP).
+// Failed attempts slow down further attempts for the account and the client IP (see checkBackoff).
+func (s *AuthService) AuthenticatePassword(ctx context.Context, login string, password string, clientIP string) (*model.User, error) {
+	if !strings.Contains(login, "@") {
+		login = util.NormalizeUsername(login)
+	}
+	keys := backoffKeys(login, clientIP)
+	if err := s.checkBackoff(keys); err != nil {
+		return nil, err
+	}
+	user, err := s.authenticatePassword(ctx, login, password)
+	// An unverified email still means the password was right
+	if err != nil && err.Error() == "email not verified" {
+		s.finishBackoff(keys, nil)
+	} else {
+		s.finishBackoff(keys, err, "invalid credentials", "account locked")
+	}
+	return user, err
 }
 
-// StoreRefreshToken stores a refresh token in the database
-func (s *AuthService) StoreRefreshToken(tokenID string, userID interface{}, tokenHash string, ttl time.Duration, clientIP, userAgent string) error {
-	// Parse tokenID as UUID
-	uuidID := uuid.MustParse(tokenID)
+func (s *AuthService) authenticatePassword(ctx context.Context, login string, password string) (*model.User, error) {
+	// Usernames can't contain "@"
+	getUser := s.userRepo.GetByEmail
+	if !strings.Contains(login, "@") {
+		getUser = s.userRepo.GetByUsername
+	}
+	user, err := getUser(ctx, login)
+	if err != nil {
+		return s.authenticateLDAP(ctx, login, password)
+	}
+	if !inCurrentTenant(ctx, user) {
+		return nil, errors.New("invalid credentials")
+	}
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, errors.New("account locked")
+	}
 
-	// Ensure userID is uuid.UUID
-	var uuidUserID uuid.UUID
-	switch v := userID.(type) {
-	case uuid.UUID:
-		uuidUserID = v
-	case string:
-		uuidUserID = uuid.MustParse(v)
-	default:
-		return errors.New("invalid user ID type")
+	pwCred := passwordCredential(user)
+	if pwCred == nil || util.ComparePassword(pwCred.Value, password) != nil {
+		ldapUser, err := s.authenticateLDAP(ctx, login, password)
+		if err != nil && err.Error() == "invalid credentials" && s.recordLoginFailure(ctx, user) {
+			return nil, errors.New("account locked")
+		}
+		if err == nil {
+			s.clearLoginFailures(ctx, user)
+		}
+		return ldapUser, err
 	}
+	s.clearLoginFailures(ctx, user)
+	s.upgradePasswordHash(ctx, user, pwCred, password)
 
-	rt := &model.RefreshToken{
-		ID:        uuidID,
-		UserID:    uuidUserID,
-		TokenHash: tokenHash,
-		ExpiresAt: time.Now().Add(ttl),
-		ClientIP:  clientIP,
-		UserAgent: userAgent,
+	// Check if email is verified
+	if !user.IsEmailVerified {
+		// Send verification email asynchronously (log failures)
+		if s.verificationSvc != nil {
+			if err := s.verificationSvc.SendVerificationCode(user.ID.String(), user.Email, user.Tenant); err != nil {
+				log.Printf("failed to send verification email for %s: %v", user.Email, err)
+			} else {
+				log.Printf("verification email sent for unverified user %s", user.Email)
+			}
+		}
+		return nil, errors.New("email not verified")
 	}
-	return s.refreshRepo.Create(rt)
+
+	return user, nil
 }
 
-// MarkEmailVerified sets IsEmailVerified = true for the specified user
-func (s *AuthService) MarkEmailVerified(userID string) error {
-	uid, err := uuid.Parse(userID)
-	if err != nil {
-		return errors.New("invalid user ID format")
-	}
+// backoffResetAfter forgets the failures of an account or IP once it made no failed attempt for this long
+const backoffResetAfter = 15 * time.Minute
 
-	user, err := s.userRepo.GetByID(uid)
-	if err != nil {
-		return err
+// backoffKeys returns the backoff keys of an account (email, phone or user ID) and of the client IP
+func backoffKeys(account string, clientIP string) []string {
+	keys := []string{"backoff:account:" + strings.ToLower(account)}
+	if clientIP != "" {
+		keys = append(keys, "backoff:ip:"+clientIP)
 	}
+	return keys
+}
 
-	if user.IsEmailVerified {
+// checkBackoff refuses an attempt while the account or the client IP is still waiting after earlier failures
+// Refused attempts are not checked at all, so they neither count as failures nor reach the account lockout.
+func (s *AuthService) checkBackoff(keys []string) error {
+	if s.backoffBase <= 0 || s.verificationSvc == nil {
 		return nil
 	}
-
-	user.IsEmailVerified = true
-	if err := s.userRepo.Update(user); err != nil {
-		return err
+	for _, key := range keys {
+		if _, until := s.backoffState(key); time.Now().Before(until) {
+			return errors.New("too many attempts")
+		}
 	}
 	return nil
 }
 
-// SendPasswordChangeOTP sends an OTP to the user's email for password change
-func (s *AuthService) SendPasswordChangeOTP(email string) error {
-	user, err := s.userRepo.GetByEmail(email)
-	if err != nil {
-		return errors.New("user not found")
+// finishBackoff records the outcome of an attempt: errors listed in failures delay the next attempt
+// (exponentially), success clears the account's failures. The IP keeps its failures until they expire.
+func (s *AuthService) finishBackoff(keys []string, err error, failures ...string) {
+	if s.backoffBase <= 0 || s.verificationSvc == nil {
+		return
 	}
-
-	// Send OTP via verification service
-	if s.verificationSvc != nil {
-		if err := s.verificationSvc.SendVerificationCode(user.ID.String(), user.Email); err != nil {
-			log.Printf("failed to send password change OTP to %s: %v", user.Email, err)
-			return err
+	if err == nil {
+		_ = s.verificationSvc.DeleteCode(keys[0])
+		return
+	}
+	for _, f := range failures {
+		if err.Error() != f {
+			continue
 		}
-		log.Printf("password change OTP sent successfully to %s", user.Email)
-		return nil
+		for _, key := range keys {
+			count, _ := s.backoffState(key)
+			count++
+			var delay time.Duration
+			if count > s.backoffFree {
+				delay = s.backoffMax
+				if shift := count - s.backoffFree - 1; shift < 30 && s.backoffBase<<shift < s.backoffMax {
+					delay = s.backoffBase << shift
+				}
+			}
+			until := time.Now().Add(delay)
+			value := strconv.Itoa(count) + ":" + strconv.FormatInt(until.Unix(), 10)
+			_ = s.verificationSvc.StoreCode(key, value, delay+backoffResetAfter)
+		}
+		return
 	}
-	return errors.New("verification service not configured")
 }
 
-// SendPasswordChangeOTPByUserID sends an OTP to the user's email using their user ID
-func (s *AuthService) SendPasswordChangeOTPByUserID(userID string) (string, error) {
-	// 1. Parse userID
-	uid, err := uuid.Parse(userID)
+// backoffState returns the number of recent failures stored under key and until when attempts are refused
+func (s *AuthService) backoffState(key string) (int, time.Time) {
+	v, err := s.verificationSvc.GetCode(key)
 	if err != nil {
-		return "", errors.New("invalid user ID format")
+		return 0, time.Time{}
 	}
+	countStr, untilStr, _ := strings.Cut(v, ":")
+	count, _ := strconv.Atoi(countStr)
+	until, _ := strconv.ParseInt(untilStr, 10, 64)
+	return count, time.Unix(until, 0)
+}
 
-	// 2. Get user by ID
-	user, err := s.userRepo.GetByID(uid)
+// recordLoginFailure counts a failed password login and locks the account once LOCKOUT_THRESHOLD is reached
+// The user is notified by email (user.locked event). Returns true when this failure locked the account.
+func (s *AuthService) recordLoginFailure(ctx context.Context, user *model.User) bool {
+	if s.lockoutThreshold <= 0 {
+		return false
+	}
+
+	attempts, err := s.userRepo.RecordLoginFailure(ctx, user.ID)
 	if err != nil {
-		return "", errors.New("user not found")
+		log.Printf("failed to record login failure for %s: %v", user.Email, err)
+		return false
+	}
+	if attempts < s.lockoutThreshold {
+		return false
 	}
 
-	// 3. Send OTP via verification service
-	if s.verificationSvc != nil {
-		if err := s.verificationSvc.SendPasswordChangeCode(user.ID.String(), user.Email); err != nil {
-			log.Printf("failed to send password change OTP to %s: %v", user.Email, err)
-			return "", err
+	lockedUntil := time.Now().Add(s.lockoutDuration)
+	event, err := NewOutboxEvent(model.EventUserLocked, dto.AccountLockedEvent{
+		UserID:         user.ID.String(),
+		Email:          user.Email,
+		FailedAttempts: attempts,
+		LockedUntil:    lockedUntil,
+	})
+	if err != nil {
+		log.Printf("failed to lock account %s: %v", user.Email, err)
+		return false
+	}
+	if err := s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		if err := repos.Users.SetLoginLock(ctx, user.ID, &lockedUntil); err != nil {
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	}); err != nil {
+		log.Printf("failed to lock account %s: %v", user.Email, err)
+		return false
+	}
+
+	log.Printf("account %s locked until %s after %d failed logins", user.Email, lockedUntil.Format(time.RFC3339), attempts)
+	return true
+}
+
+// upgradePasswordHash rehashes a password stored with a legacy scheme (bcrypt) or weaker Argon2 parameters
+// The plaintext is only known right after a successful comparison; failures are logged and retried next login.
+func (s *AuthService) upgradePasswordHash(ctx context.Context, user *model.User, pwCred *model.Credential, password string) {
+	if !util.PasswordNeedsRehash(pwCred.Value) {
+		return
+	}
+	hashed, err := util.HashPassword(password)
+	if err != nil {
+		log.Printf("failed to rehash password of %s: %v", user.Email, err)
+		return
+	}
+
+	// Not a password change: keep the password's age (PASSWORD_MAX_AGE) from falling back to UpdatedAt
+	if pwCred.PasswordChangedAt == nil {
+		setAt := pwCred.PasswordSetAt()
+		pwCred.PasswordChangedAt = &setAt
+	}
+	previous := pwCred.Value
+	pwCred.Value = hashed
+	pwCred.NeedsRehash = false
+	if err := s.credentialRepo.Update(ctx, pwCred); err != nil {
+		pwCred.Value = previous
+		log.Printf("failed to store rehashed password of %s: %v", user.Email, err)
+		return
+	}
+	util.ObservePasswordRehash()
+	log.Printf("password hash of %s upgraded to current parameters", user.Email)
+}
+
+// clearLoginFailures resets the failed login counter after a successful login
+func (s *AuthService) clearLoginFailures(ctx context.Context, user *model.User) {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return
+	}
+	if err := s.userRepo.SetLoginLock(ctx, user.ID, nil); err != nil {
+		log.Printf("failed to reset login failures for %s: %v", user.Email, err)
+		return
+	}
+	user.FailedLogins, user.LockedUntil = 0, nil
+}
+
+// authenticateLDAP checks the password against the directory and returns the account's shadow user
+// The directory account is linked to the local account with the same email, or a shadow user is created
+// (registration policy applies). Roles mapped from LDAP groups are synced on every login.
+func (s *AuthService) authenticateLDAP(ctx context.Context, email string, password string) (*model.User, error) {
+	if s.ldap == nil {
+		return nil, errors.New("invalid credentials")
+	}
+
+	entry, err := s.ldap.Authenticate(ctx, email, password)
+	if err != nil {
+		return nil, errors.New("invalid credentials")
+	}
+	identity := &ExternalIdentity{
+		Type:          model.CredTypeLDAP,
+		Subject:       entry.Subject,
+		Email:         entry.Email,
+		EmailVerified: true,
+		Name:          entry.Name,
+		Attributes:    entry.Attrs,
+	}
+
+	var user *model.User
+	if cred, err := s.credentialRepo.GetByTypeAndValue(ctx, model.CredTypeLDAP, entry.Subject); err == nil && cred.Active {
+		user, err = s.userRepo.GetByID(ctx, cred.UserID)
+		if err != nil || !inCurrentTenant(ctx, user) {
+			return nil, errors.New("invalid credentials")
+		}
+	} else if user, err = s.userRepo.GetByEmail(ctx, entry.Email); err == nil {
+		if !inCurrentTenant(ctx, user) {
+			return nil, errors.New("invalid credentials")
+		}
+		if err := s.linkIdentity(ctx, user, identity); err != nil {
+			log.Printf("cannot link ldap account %s to user %s: %v", entry.Subject, user.Email, err)
+			return nil, errors.New("invalid credentials")
+		}
+		// The directory vouches for the email
+		if !user.IsEmailVerified {
+			user.IsEmailVerified = true
+			if err := s.userRepo.Update(ctx, user); err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("linked ldap account %s to user %s", entry.Subject, user.Email)
+	} else {
+		if user, err = s.registerWithIdentity(ctx, identity); err != nil {
+			return nil, err
+		}
+		log.Printf("created shadow user %s for ldap account %s", user.Email, entry.Subject)
+	}
+
+	if err := s.syncLDAPRoles(ctx, user, entry.Roles); err != nil {
+		return nil, err
+	}
+	return s.userRepo.GetByID(ctx, user.ID)
+}
+
+// syncLDAPRoles grants the roles mapped from the user's groups and revokes mapped roles they lost
+// Roles outside LDAP_GROUP_ROLES (e.g. granted by an admin) are kept.
+func (s *AuthService) syncLDAPRoles(ctx context.Context, user *model.User, codes []string) error {
+	managed := s.ldap.ManagedRoles()
+	wanted := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		wanted[code] = true
+	}
+
+	var roles []model.Role
+	changed := false
+	for _, r := range user.Roles {
+		if managed[r.Code] && !wanted[r.Code] {
+			changed = true
+			continue
+		}
+		roles = append(roles, r)
+		delete(wanted, r.Code)
+	}
+	for _, code := range codes {
+		if !wanted[code] {
+			continue
+		}
+		role, err := s.roleRepo.GetByCode(ctx, code)
+		if err != nil {
+			log.Printf("warning: LDAP_GROUP_ROLES maps to unknown role '%s'", code)
+			continue
+		}
+		roles = append(roles, *role)
+		delete(wanted, code)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	if err := s.userRepo.ReplaceRoles(ctx, user, roles); err != nil {
+		return err
+	}
+	s.InvalidateUserRoles(user.ID)
+	return nil
+}
+
+// LoginWithIdentity issues a token pair for the account a verified social identity is linked to
+func (s *AuthService) LoginWithIdentity(ctx context.Context, identity *ExternalIdentity, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	cred, err := s.credentialRepo.GetByTypeAndValue(ctx, identity.Type, identity.Subject)
+	if err != nil || !cred.Active {
+		return nil, errors.New("identity not linked")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, cred.UserID)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		return nil, errors.New("identity not linked")
+	}
+
+	return s.completeLogin(ctx, user, dto.AMRFederated, "", false, clientIP, userAgent)
+}
+
+// SignInWithIdentity logs in with a social identity, linking or creating the account on first use
+// A new identity is linked to the verified account with the same email, or a new account is created
+// (registration policy applies). Unverified provider emails are refused, so an identity can't take over
+// an account by claiming its email.
+func (s *AuthService) SignInWithIdentity(ctx context.Context, identity *ExternalIdentity, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	if res, err := s.LoginWithIdentity(ctx, identity, clientIP, userAgent); err == nil {
+		return res, nil
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errors.New("provider did not share a verified email")
+	}
+
+	user, err := s.userRepo.GetByEmail(ctx, identity.Email)
+	if err == nil && user.IsEmailVerified {
+		if !inCurrentTenant(ctx, user) {
+			return nil, errors.New("email already in use")
+		}
+		if err := s.linkIdentity(ctx, user, identity); err != nil {
+			return nil, err
+		}
+		log.Printf("linked %s identity to user %s on sign-in", identity.Type, user.Email)
+		return s.completeLogin(ctx, user, dto.AMRFederated, "", false, clientIP, userAgent)
+	}
+
+	user, err = s.registerWithIdentity(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("registered user %s with %s identity", user.Email, identity.Type)
+	return s.issueTokenPair(ctx, user, dto.NewAuthentication(dto.AMRFederated), clientIP, userAgent)
+}
+
+// linkIdentity stores the identity credential of an existing account and notifies the user
+func (s *AuthService) linkIdentity(ctx context.Context, user *model.User, identity *ExternalIdentity) error {
+	for _, c := range user.Credentials {
+		if c.Type == identity.Type {
+			return errors.New("provider already linked")
+		}
+	}
+
+	event, err := NewOutboxEvent(model.EventUserIdentityLinked, dto.IdentityEvent{
+		UserID:   user.ID.String(),
+		Email:    user.Email,
+		Provider: string(identity.Type),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		if err := repos.Credentials.Create(ctx, &model.Credential{
+			UserID: user.ID,
+			Type:   identity.Type,
+			Value:  identity.Subject,
+		}); err != nil {
+			if util.IsDuplicateKeyError(err) {
+				return errors.New("identity linked to another account")
+			}
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	})
+}
+
+// registerWithIdentity creates a verified account whose only login method is the social identity
+// The provisioning policy decides whether the account may be created, its roles and mapped attributes.
+func (s *AuthService) registerWithIdentity(ctx context.Context, identity *ExternalIdentity) (*model.User, error) {
+	policy := &dto.ProvisioningSettings{AutoCreate: true, DefaultRoles: []string{"user"}}
+	if s.provisioningSvc != nil {
+		var err error
+		if policy, err = s.provisioningSvc.Authorize(ctx, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	name := identity.Name
+	if name == "" && !identity.PrivateEmail {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+	// A relay address (Apple "Hide My Email") is random, it makes no display name
+	if name == "" {
+		name = "User"
+	}
+	if runes := []rune(name); len(runes) > 50 {
+		name = string(runes[:50])
+	}
+
+	user := &model.User{
+		ID:              uuid.New(),
+		Name:            name,
+		Email:           identity.Email,
+		IsEmailVerified: true,
+		Tenant:          util.TenantFromContext(ctx),
+	}
+	applyProvisioningAttributes(policy, identity, user)
+
+	err := s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		if s.registrationSvc != nil {
+			if err := s.registrationSvc.Authorize(ctx, repos, user.Email, "", user.ID); err != nil {
+				return err
+			}
+		}
+
+		for _, code := range policy.DefaultRoles {
+			role, err := repos.Roles.GetByCode(ctx, code)
+			if err != nil {
+				return fmt.Errorf("system error: role %s not found", code)
+			}
+			user.Roles = append(user.Roles, *role)
+		}
+		user.Credentials = nil
+
+		// Same as Register: an expired unverified account doesn't block the email
+		if ttl := util.GetUnverifiedAccountTTL(); ttl > 0 {
+			if _, err := repos.Users.DeleteUnverifiedByEmail(ctx, user.Email, time.Now().Add(-ttl)); err != nil {
+				return err
+			}
+		}
+
+		if err := repos.Users.Create(ctx, user); err != nil {
+			if util.IsDuplicateKeyError(err) {
+				return errors.New("email already in use")
+			}
+			return err
+		}
+		if err := repos.Credentials.Create(ctx, &model.Credential{
+			UserID: user.ID,
+			Type:   identity.Type,
+			Value:  identity.Subject,
+		}); err != nil {
+			if util.IsDuplicateKeyError(err) {
+				return errors.New("identity linked to another account")
+			}
+			return err
+		}
+
+		// No verification email is sent for social sign-ups (see HandleUserRegistered)
+		event, err := NewOutboxEvent(model.EventUserRegistered, dto.UserRegisteredEvent{
+			UserID:   user.ID.String(),
+			Email:    user.Email,
+			Name:     user.Name,
+			Provider: string(identity.Type),
+		})
+		if err != nil {
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// completeLogin issues the token pair after the first factor, or an MFA challenge when the user enrolled TOTP
+// The challenge is skipped for a device the user chose to remember (deviceToken).
+// method is the amr value of the first factor (dto.AMRPassword, ...); rememberMe selects a long-lived session.
+func (s *AuthService) completeLogin(ctx context.Context, user *model.User, method string, deviceToken string, rememberMe bool, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	// A self-service MFA reset whose cooldown passed without being cancelled is applied now
+	if user.MFAResetAt != nil && !time.Now().Before(*user.MFAResetAt) {
+		if err := s.resetMFA(ctx, user, mfaResetActorSelf, "lost second factor"); err != nil {
+			return nil, err
+		}
+	}
+
+	if !mfaRequired(user) || s.isRememberedDevice(ctx, user, deviceToken) {
+		authn := dto.NewAuthentication(method)
+		authn.RememberMe = rememberMe
+		return s.issueTokenPair(ctx, user, authn, clientIP, userAgent)
+	}
+	if s.verificationSvc == nil {
+		return nil, errors.New("verification service not configured")
+	}
+
+	challenge, err := util.GenerateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+	// Only the hash is stored, so the storage never holds a usable challenge
+	key := mfaChallengeKey(challenge)
+	if err := s.verificationSvc.StoreCode(key, user.ID.String(), mfaChallengeTTL); err != nil {
+		return nil, err
+	}
+	// The first factor goes into the amr claim once the second one is verified
+	if err := s.verificationSvc.StoreCode(key+":amr", method, mfaChallengeTTL); err != nil {
+		return nil, err
+	}
+	if rememberMe {
+		if err := s.verificationSvc.StoreCode(key+":remember_me", "1", mfaChallengeTTL); err != nil {
+			return nil, err
+		}
+	}
+	return &dto.LoginResponse{MFAChallenge: challenge, MFAMethods: mfaMethods(user), ExpiresIn: int(mfaChallengeTTL.Seconds())}, nil
+}
+
+// usesSMSFactor reports whether the user receives MFA codes by SMS
+func usesSMSFactor(user *model.User) bool {
+	return user.IsSMSMFAEnabled && user.IsPhoneVerified && user.Phone != nil
+}
+
+// usesEmailFactor reports whether the user receives MFA codes by email
+func usesEmailFactor(user *model.User) bool {
+	return user.IsEmailMFAEnabled && user.IsEmailVerified
+}
+
+// mfaRequired reports whether logins of the user need a second factor
+func mfaRequired(user *model.User) bool {
+	return user.IsMFAEnabled || usesSMSFactor(user) || usesEmailFactor(user)
+}
+
+// mfaMethods lists the second factors the user can answer a challenge with
+func mfaMethods(user *model.User) []string {
+	var methods []string
+	if user.IsMFAEnabled {
+		methods = append(methods, "totp")
+	}
+	if usesSMSFactor(user) {
+		methods = append(methods, "sms")
+	}
+	if usesEmailFactor(user) {
+		methods = append(methods, "email")
+	}
+	return append(methods, "recovery_code")
+}
+
+func mfaChallengeKey(challenge string) string {
+	return "mfa_challenge:" + util.HashToken(challenge)
+}
+
+// challengeUser returns the user an MFA challenge was issued to
+func (s *AuthService) challengeUser(ctx context.Context, key string) (*model.User, error) {
+	if s.verificationSvc == nil {
+		return nil, errors.New("verification service not configured")
+	}
+
+	userID, err := s.verificationSvc.GetCode(key)
+	if err != nil {
+		return nil, errors.New("invalid or expired MFA challenge")
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid or expired MFA challenge")
+	}
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil || !inCurrentTenant(ctx, user) || !mfaRequired(user) {
+		s.dropMFAChallenge(key)
+		return nil, errors.New("invalid or expired MFA challenge")
+	}
+	return user, nil
+}
+
+func (s *AuthService) dropMFAChallenge(key string) {
+	for _, suffix := range []string{"", ":failures", ":amr", ":remember_me", ":sms", ":sms_sent", ":email", ":email_sent"} {
+		_ = s.verificationSvc.DeleteCode(key + suffix)
+	}
+}
+
+// VerifyMFA redeems an MFA challenge with a TOTP code, an SMS code or a recovery code and issues the token pair
+// The challenge is single-use and dropped after mfaMaxFailures wrong codes. With rememberDevice the
+// response also carries a device token that skips MFA on later logins (see MFA_REMEMBER_DEVICE_TTL).
+func (s *AuthService) VerifyMFA(ctx context.Context, challenge string, code string, rememberDevice bool, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	key := mfaChallengeKey(challenge)
+	user, err := s.challengeUser(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	keys := backoffKeys(user.ID.String(), clientIP)
+	if err := s.checkBackoff(keys); err != nil {
+		return nil, err
+	}
+
+	secondFactor, err := s.checkSecondFactor(ctx, user, key, code)
+	if err != nil {
+		return nil, err
+	}
+	if secondFactor == "" {
+		if s.recordMFAFailure(key) >= mfaMaxFailures {
+			log.Printf("too many MFA failures for %s, dropping the login challenge", user.Email)
+			s.dropMFAChallenge(key)
+		}
+		err := errors.New("invalid MFA code")
+		s.finishBackoff(keys, err, err.Error())
+		return nil, err
+	}
+
+	s.finishBackoff(keys, nil)
+	firstFactor, err := s.verificationSvc.GetCode(key + ":amr")
+	if err != nil {
+		firstFactor = dto.AMRPassword
+	}
+	authn := dto.NewAuthentication(firstFactor, secondFactor)
+	if rememberMe, err := s.verificationSvc.GetCode(key + ":remember_me"); err == nil && rememberMe == "1" {
+		authn.RememberMe = true
+	}
+	s.dropMFAChallenge(key)
+	s.cancelMFAReset(ctx, user)
+	res, err := s.issueTokenPair(ctx, user, authn, clientIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	if rememberDevice && s.rememberDeviceTTL > 0 {
+		// The login itself succeeded; failing to remember the device only means MFA is asked again next time
+		token, expiresAt, err := s.rememberDevice(ctx, user, clientIP, userAgent)
+		if err != nil {
+			log.Printf("failed to remember device of user %s: %v", user.Email, err)
+		} else {
+			res.DeviceToken = token
+			res.DeviceExpiresAt = expiresAt
+		}
+	}
+	return res, nil
+}
+
+// rememberDevice stores a new remembered device for the user and returns its token
+// Only the hash of the token is stored, like refresh tokens.
+func (s *AuthService) rememberDevice(ctx context.Context, user *model.User, clientIP, userAgent string) (string, time.Time, error) {
+	token, err := util.GenerateSecureToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	device := &model.RememberedDevice{
+		UserID:     user.ID,
+		TokenHash:  util.HashToken(token),
+		UserAgent:  userAgent,
+		ClientIP:   clientIP,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.rememberDeviceTTL),
+	}
+	if err := s.deviceRepo.Create(ctx, device); err != nil {
+		return "", time.Time{}, err
+	}
+
+	log.Printf("user %s remembered a device until %s", user.Email, device.ExpiresAt.Format(time.RFC3339))
+	return token, device.ExpiresAt, nil
+}
+
+// isRememberedDevice reports whether deviceToken belongs to an unexpired remembered device of the user
+func (s *AuthService) isRememberedDevice(ctx context.Context, user *model.User, deviceToken string) bool {
+	if deviceToken == "" || s.rememberDeviceTTL <= 0 {
+		return false
+	}
+
+	device, err := s.deviceRepo.GetActive(ctx, user.ID, util.HashToken(deviceToken))
+	if err != nil {
+		return false
+	}
+	if err := s.deviceRepo.Touch(ctx, device.ID); err != nil {
+		log.Printf("failed to record use of remembered device %s: %v", device.ID, err)
+	}
+	return true
+}
+
+// ListCredentials returns the sign-in methods and second factors of a user, without secret values
+func (s *AuthService) ListCredentials(ctx context.Context, userID string) (*dto.CredentialInventoryResponse, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	res := &dto.CredentialInventoryResponse{
+		MFA: dto.MFACredentialInfo{
+			TOTP:           user.IsMFAEnabled,
+			SMS:            user.IsSMSMFAEnabled,
+			Email:          user.IsEmailMFAEnabled,
+			ResetPendingAt: user.MFAResetAt,
+		},
+		Identities: []dto.IdentityInfo{},
+	}
+	if user.Username != nil {
+		res.Username = *user.Username
+	}
+	if user.Phone != nil && user.IsPhoneVerified {
+		res.Phone = *user.Phone
+	}
+
+	for _, c := range user.Credentials {
+		switch {
+		case !c.Active, c.Type == model.CredTypeDevice:
+			// Guest device secrets are not a login method the user manages
+		case c.Type == model.CredTypePassword:
+			setAt := c.PasswordSetAt()
+			res.Password = dto.PasswordCredentialInfo{Set: true, ChangedAt: &setAt, MustChangePassword: c.MustChangePassword}
+			if s.passwordMaxAge > 0 {
+				expiresAt := setAt.Add(s.passwordMaxAge)
+				res.Password.ExpiresAt = &expiresAt
+			}
+		default:
+			res.Identities = append(res.Identities, dto.IdentityInfo{Type: string(c.Type), LinkedAt: c.CreatedAt})
+		}
+	}
+
+	if mfaRequired(user) {
+		if err := s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+			res.MFA.RecoveryCodesRemaining, err = repos.RecoveryCodes.CountUnused(ctx, user.ID)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	devices, err := s.deviceRepo.ListByUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	res.RememberedDevices = len(devices)
+	return res, nil
+}
+
+// ListSessions returns where the user is signed in (active refresh tokens)
+// refreshToken is the cookie of the request and marks the current session.
+func (s *AuthService) ListSessions(ctx context.Context, userID string, refreshToken string) ([]dto.SessionResponse, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	tokens, err := s.refreshRepo.ListActiveByUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	currentHash := ""
+	if refreshToken != "" {
+		currentHash = util.HashToken(refreshToken)
+	}
+
+	res := make([]dto.SessionResponse, 0, len(tokens))
+	for _, rt := range tokens {
+		res = append(res, dto.SessionResponse{
+			ID:         rt.ID.String(),
+			Device:     util.DeviceLabel(rt.UserAgent),
+			UserAgent:  rt.UserAgent,
+			ClientIP:   rt.ClientIP,
+			Scope:      rt.Scope,
+			CreatedAt:  rt.LoginTime(),
+			LastUsedAt: rt.LastUsed(),
+			ExpiresAt:  rt.ExpiresAt,
+			Current:    rt.TokenHash == currentHash,
+		})
+	}
+	return res, nil
+}
+
+// RevokeSession signs one session out ("sign out that device"): the refresh token, the tokens that replaced it
+// (in case it was rotated since it was listed) and their opaque access tokens are revoked
+func (s *AuthService) RevokeSession(ctx context.Context, userID string, sessionID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+	sid, err := uuid.Parse(sessionID)
+	if err != nil {
+		return errors.New("session not found")
+	}
+
+	rt, err := s.refreshRepo.GetByID(ctx, sid)
+	if err != nil || rt.UserID != uid {
+		return errors.New("session not found")
+	}
+
+	revoked, err := s.refreshRepo.RevokeWithDescendants(ctx, rt.ID)
+	if err != nil {
+		return err
+	}
+	for _, id := range revoked {
+		if err := s.opaqueTokens.RevokeForRefreshToken(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("user %s revoked session %s (%d tokens)", userID, sid, len(revoked))
+	return nil
+}
+
+// ListRememberedDevices returns the devices that currently skip MFA for the user
+// current marks the device the request came from (deviceToken is its cookie, may be empty).
+func (s *AuthService) ListRememberedDevices(ctx context.Context, userID string, deviceToken string) ([]dto.RememberedDeviceResponse, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	devices, err := s.deviceRepo.ListByUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	currentHash := ""
+	if deviceToken != "" {
+		currentHash = util.HashToken(deviceToken)
+	}
+
+	res := make([]dto.RememberedDeviceResponse, 0, len(devices))
+	for _, d := range devices {
+		res = append(res, dto.RememberedDeviceResponse{
+			ID:         d.ID.String(),
+			UserAgent:  d.UserAgent,
+			ClientIP:   d.ClientIP,
+			CreatedAt:  d.CreatedAt,
+			LastUsedAt: d.LastUsedAt,
+			ExpiresAt:  d.ExpiresAt,
+			Current:    d.TokenHash == currentHash,
+		})
+	}
+	return res, nil
+}
+
+// ForgetDevice revokes one remembered device; its next login needs MFA again
+func (s *AuthService) ForgetDevice(ctx context.Context, userID string, deviceID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+	did, err := uuid.Parse(deviceID)
+	if err != nil {
+		return errors.New("device not found")
+	}
+
+	found, err := s.deviceRepo.Delete(ctx, uid, did)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("device not found")
+	}
+
+	log.Printf("user %s forgot remembered device %s", userID, deviceID)
+	return nil
+}
+
+// ForgetAllDevices revokes every remembered device of the user
+func (s *AuthService) ForgetAllDevices(ctx context.Context, userID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+	return s.deviceRepo.DeleteAllForUser(ctx, uid)
+}
+
+// SendMFASMS texts a login code for an MFA challenge to the user's verified phone
+// A new code replaces the previous one; at most mfaMaxCodesSent codes are sent per challenge.
+func (s *AuthService) SendMFASMS(ctx context.Context, challenge string, smsSvc SMSService) error {
+	key := mfaChallengeKey(challenge)
+	user, err := s.challengeUser(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !usesSMSFactor(user) {
+		return errors.New("SMS factor not enabled")
+	}
+	return s.sendMFASMS(user, key, smsSvc)
+}
+
+// SendMFAEmail emails a login code for an MFA challenge to the user's verified address
+// Same limits as SendMFASMS; the code is kept apart from email verification codes.
+func (s *AuthService) SendMFAEmail(ctx context.Context, challenge string, emailSvc *EmailService) error {
+	key := mfaChallengeKey(challenge)
+	user, err := s.challengeUser(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !usesEmailFactor(user) {
+		return errors.New("email factor not enabled")
+	}
+	return s.sendMFAEmail(user, key, emailSvc)
+}
+
+// sendMFASMS stores a new SMS code under key+":sms" and sends it
+func (s *AuthService) sendMFASMS(user *model.User, key string, smsSvc SMSService) error {
+	return s.sendMFACode(user, key, "sms", func(code string) error {
+		return smsSvc.SendOTP(*user.Phone, code)
+	})
+}
+
+// sendMFAEmail stores a new email code under key+":email" and sends it
+func (s *AuthService) sendMFAEmail(user *model.User, key string, emailSvc *EmailService) error {
+	return s.sendMFACode(user, key, "email", func(code string) error {
+		return emailSvc.SendMFACode(user.Email, code)
+	})
+}
+
+// sendMFACode stores a new code for the channel ("sms", "email") of a challenge and delivers it
+func (s *AuthService) sendMFACode(user *model.User, key string, channel string, deliver func(code string) error) error {
+	sent := 0
+	if v, err := s.verificationSvc.GetCode(key + ":" + channel + "_sent"); err == nil {
+		sent, _ = strconv.Atoi(v)
+	}
+	if sent >= mfaMaxCodesSent {
+		return errors.New("too many codes requested")
+	}
+	_ = s.verificationSvc.StoreCode(key+":"+channel+"_sent", strconv.Itoa(sent+1), mfaChallengeTTL)
+
+	otpCode := util.GenerateRandomDigits(6)
+	if err := s.verificationSvc.StoreCode(key+":"+channel, otpCode, mfaChallengeTTL); err != nil {
+		return err
+	}
+	if err := deliver(otpCode); err != nil {
+		log.Printf("failed to send MFA %s code to %s: %v", channel, user.Email, err)
+		return errors.New("failed to send " + channel)
+	}
+	return nil
+}
+
+// checkSecondFactor checks a TOTP code, the SMS or email code stored under key, or a recovery code
+// Returns the amr value of the factor that matched, "" when none did.
+func (s *AuthService) checkSecondFactor(ctx context.Context, user *model.User, key string, code string) (string, error) {
+	if !isTOTPCode(code) {
+		ok, err := s.useRecoveryCode(ctx, user, code)
+		if !ok || err != nil {
+			return "", err
+		}
+		return dto.AMROTP, nil
+	}
+	// An authenticator code is accepted once: replaying the code of a login fails
+	if user.IsMFAEnabled {
+		ok, err := util.UseTOTP(ctx, user.ID.String(), user.MFASecret, code)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return dto.AMROTP, nil
+		}
+	}
+	if s.verificationSvc == nil {
+		return "", nil
+	}
+	if usesSMSFactor(user) && s.verificationSvc.VerifyCode(key+":sms", code) == nil {
+		return dto.AMRSMS, nil
+	}
+	if usesEmailFactor(user) && s.verificationSvc.VerifyCode(key+":email", code) == nil {
+		return dto.AMROTP, nil
+	}
+	return "", nil
+}
+
+// isTOTPCode tells authenticator codes (6 digits) from recovery codes
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// recordMFAFailure counts a wrong code for the challenge and returns the number of failures so far
+func (s *AuthService) recordMFAFailure(key string) int {
+	failures := 0
+	if v, err := s.verificationSvc.GetCode(key + ":failures"); err == nil {
+		failures, _ = strconv.Atoi(v)
+	}
+	failures++
+	_ = s.verificationSvc.StoreCode(key+":failures", strconv.Itoa(failures), mfaChallengeTTL)
+	return failures
+}
+
+// issueTokenPair generates access/refresh tokens for an authenticated user and stores the refresh token
+// authn is the login the tokens are issued for; the refresh token keeps it for the access tokens it renews
+func (s *AuthService) issueTokenPair(ctx context.Context, user *model.User, authn dto.Authentication, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	return s.issueScopedTokenPair(ctx, user, "", "", authn, clientIP, userAgent)
+}
+
+// issueScopedTokenPair is issueTokenPair for OAuth clients: the granted scopes are stored with the
+// refresh token and put into the access token; offline_access extends the refresh token lifetime.
+// The refresh token only refreshes for clientID, the access token is issued for the client (aud = client ID).
+func (s *AuthService) issueScopedTokenPair(ctx context.Context, user *model.User, scope string, clientID string, authn dto.Authentication, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	// Roles for the token: the user's own and those of their groups
+	roleCodes, err := userRoleCodes(ctx, s.userRepo, user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Inherited roles are added; roles that require MFA are withheld until the user enrolls a second factor
+	roleCodes, withheld, err := s.expandTokenRoles(ctx, user, roleCodes)
+	if err != nil {
+		return nil, err
+	}
+	if withheld {
+		log.Printf("user %s has no second factor, withholding MFA-required roles from the token", user.Email)
+	}
+
+	rt := &model.RefreshToken{
+		UserID:     user.ID,
+		Scope:      scope,
+		ClientID:   clientID,
+		AMR:        strings.Join(authn.Methods, " "),
+		RememberMe: authn.RememberMe,
+		DPoPJKT:    util.DPoPThumbprint(ctx),
+		ClientIP:   clientIP,
+		UserAgent:  userAgent,
+	}
+	if !authn.Time.IsZero() {
+		rt.AuthTime = &authn.Time
+	}
+	now := time.Now()
+	rt.LastUsedAt = &now
+	if absTTL := util.GetRefreshAbsoluteTTL(); absTTL > 0 {
+		absoluteExpiresAt := now.Add(absTTL)
+		rt.AbsoluteExpiresAt = &absoluteExpiresAt
+	}
+	rt.ExpiresAt = refreshExpiry(now, rt)
+
+	// Generate Tokens with Roles
+	pair, err := util.GenerateTokens(ctx, user.ID, roleCodes, user.Tenant, scope, clientID, rt.ExpiresAt, authn, user.TokenVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	rt.ID = pair.RefreshID
+	rt.FamilyID = pair.RefreshID // A login starts a new rotation chain
+	rt.TokenHash = util.HashToken(pair.RefreshToken)
+	// Make room for the new session
+	if err := s.enforceSessionLimit(ctx, user); err != nil {
+		return nil, err
+	}
+	if err := s.refreshRepo.Create(ctx, rt); err != nil {
+		return nil, err
+	}
+	accessToken, err := s.opaqueTokens.Issue(ctx, pair.AccessToken, &pair.RefreshID)
+	if err != nil {
+		return nil, err
+	}
+	s.trackActivity(user.ID)
+
+	// Get access token TTL in seconds for response
+	accessTTLStr := os.Getenv("JWT_ACCESS_TTL")
+	if accessTTLStr == "" {
+		accessTTLStr = "15m"
+	}
+	accessTTL, _ := time.ParseDuration(accessTTLStr)
+	expiresIn := int(accessTTL.Seconds())
+
+	return &dto.LoginResponse{AccessToken: accessToken, RefreshToken: pair.RefreshToken, ExpiresIn: expiresIn, MFAEnrollmentRequired: withheld, RefreshExpiresAt: rt.ExpiresAt, RememberMe: rt.RememberMe}, nil
+}
+
+// refreshExpiry returns when a refresh token issued at now expires: every rotation renews the refresh TTL
+// (JWT_REMEMBER_ME_TTL for remember me logins), but never past the absolute end of the login (absolute_expires_at, JWT_REFRESH_ABSOLUTE_TTL)
+func refreshExpiry(now time.Time, rt *model.RefreshToken) time.Time {
+	ttl := util.GetRefreshTTLFor(rt.IsOffline())
+	if rt.RememberMe && !rt.IsOffline() {
+		ttl = util.GetRememberMeTTL()
+	}
+	expiresAt := now.Add(ttl)
+	if rt.AbsoluteExpiresAt != nil && rt.AbsoluteExpiresAt.Before(expiresAt) {
+		return *rt.AbsoluteExpiresAt
+	}
+	return expiresAt
+}
+
+// absoluteExpiry returns the absolute end of the login a refresh token belongs to (nil = no cap)
+// Tokens from before the cap was configured get it from their login time, or from now if that is unknown.
+func absoluteExpiry(rt *model.RefreshToken) *time.Time {
+	absTTL := util.GetRefreshAbsoluteTTL()
+	if rt.AbsoluteExpiresAt != nil || absTTL <= 0 {
+		return rt.AbsoluteExpiresAt
+	}
+	start := time.Now()
+	if rt.AuthTime != nil {
+		start = *rt.AuthTime
+	}
+	absoluteExpiresAt := start.Add(absTTL)
+	return &absoluteExpiresAt
+}
+
+// Logout ends the session of a refresh token: the token and the opaque access tokens issued with it are revoked
+// accessToken (optional) is the bearer token of the request; an opaque one is revoked, a JWT goes on the denylist.
+// Unknown, expired or foreign tokens are ignored so that logging out twice is not an error.
+func (s *AuthService) Logout(ctx context.Context, refreshToken, accessToken string) error {
+	if accessToken != "" {
+		if _, err := s.opaqueTokens.Revoke(ctx, accessToken); err != nil {
+			return err
+		}
+		if err := s.DenyAccessToken(ctx, accessToken); err != nil {
+			return err
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+
+	userID, refreshID, tenant, err := util.ParseRefreshToken(refreshToken)
+	if err != nil || tenant != util.TenantFromContext(ctx) {
+		return nil
+	}
+	rt, err := s.refreshRepo.GetByID(ctx, refreshID)
+	if err != nil || rt.UserID != userID {
+		return nil
+	}
+	if rt.RevokedAt == nil {
+		if err := s.refreshRepo.RevokeByID(ctx, rt.ID); err != nil {
+			return err
+		}
+	}
+	if err := s.opaqueTokens.RevokeForRefreshToken(ctx, rt.ID); err != nil {
+		return err
+	}
+
+	log.Printf("user %s logged out (session %s)", userID, rt.ID)
+	return nil
+}
+
+// DenyAccessToken revokes a JWT access token before it expires by putting its jti on the denylist
+// Invalid tokens, refresh tokens and opaque tokens (revoked in the database instead) are ignored.
+func (s *AuthService) DenyAccessToken(ctx context.Context, accessToken string) error {
+	if s.denylist == nil || !strings.Contains(accessToken, ".") {
+		return nil
+	}
+	claims, err := util.ParseAccessToken(accessToken)
+	if err != nil || claims.IsRefreshToken() || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	return s.denylist.Add(ctx, claims.ID, claims.ExpiresAt.Time)
+}
+
+// LogoutAll ends every session of a user: all refresh tokens and API keys are revoked and the token version
+// is bumped, so JWT access tokens issued so far are rejected too. actor is "self" or the ID of the admin.
+func (s *AuthService) LogoutAll(ctx context.Context, actor string, userID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		return errors.New("user not found")
+	}
+
+	event, err := NewOutboxEvent(model.EventUserSessionsRevoked, dto.SessionsRevokedEvent{
+		UserID: user.ID.String(),
+		Email:  user.Email,
+		Actor:  actor,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		if _, err := repos.Users.BumpTokenVersion(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := repos.RefreshTokens.RevokeAllForUser(ctx, user.ID); err != nil {
+			return err
+		}
+		// A key minted from a stolen session must not outlive it
+		if err := repos.APIKeys.RevokeAllForUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	}); err != nil {
+		return err
+	}
+
+	if err := s.opaqueTokens.RevokeAllForUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	log.Printf("all sessions of user %s revoked by %s", user.Email, actor)
+	return nil
+}
+
+// TokenVersion returns the current token version of a user (registered with util.SetTokenVersionLookup)
+func (s *AuthService) TokenVersion(ctx context.Context, userID string) (int, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return 0, errors.New("invalid user ID format")
+	}
+	return s.userRepo.GetTokenVersion(ctx, uid)
+}
+
+// UsesMFA reports whether the user has a second factor (registered with util.SetMFALookup)
+func (s *AuthService) UsesMFA(ctx context.Context, userID string) (bool, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return false, errors.New("invalid user ID format")
+	}
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return false, err
+	}
+	return mfaRequired(user), nil
+}
+
+// Refresh rotates refresh tokens and issues a new access token
+// clientID is the client authenticated at /oauth/token ("" at /auth/refresh): a token only refreshes for the
+// client it was issued to, so first-party and OAuth refresh tokens can't be swapped between the two (RFC 6749 section 6).
+// The parent token row is locked (SELECT ... FOR UPDATE) for the whole rotation, so concurrent
+// requests with the same token serialize: the first rotates, the others take the grace-period path
+func (s *AuthService) Refresh(ctx context.Context, req *dto.RefreshRequest, clientID string, clientIP, userAgent string) (*dto.RefreshResponse, error) {
+	// 1. Parse & Validate basic structure
+	userIDFromToken, refreshID, tenant, err := util.ParseRefreshToken(req.RefreshToken)
+	if err != nil || tenant != util.TenantFromContext(ctx) {
+		return nil, errors.New("invalid refresh token")
+	}
+
+	var res *dto.RefreshResponse
+	var reused, hijacked *model.RefreshToken
+	err = s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		// 2. Load & Lock Token from DB
+		existing, err := repos.RefreshTokens.GetByIDForUpdate(ctx, refreshID)
+		if err != nil {
+			return errors.New("invalid or unknown refresh token")
+		}
+
+		// 3. Security Checks
+		if existing.UserID != userIDFromToken {
+			return errors.New("user mismatch")
+		}
+		if existing.ClientID != clientID {
+			return errors.New("client mismatch")
+		}
+		if existing.RevokedAt != nil {
+			return errors.New("token was revoked")
+		}
+		if idleExpired(existing) {
+			return errors.New("session expired due to inactivity")
+		}
+		// A DPoP-bound token only refreshes with a proof of the same key
+		if existing.DPoPJKT != "" && util.DPoPThumbprint(ctx) != existing.DPoPJKT {
+			return errors.New("invalid DPoP proof")
+		}
+		if mismatch := s.bindingMismatch(existing, clientIP, userAgent); mismatch != "" {
+			log.Printf("refresh token %s of user %s presented with a different %s (REFRESH_BINDING_POLICY=%s)",
+				existing.ID, existing.UserID, mismatch, s.refreshBinding)
+			switch s.refreshBinding {
+			case refreshBindingRelogin:
+				return errRefreshBindingMismatch
+			case refreshBindingRevoke:
+				hijacked = existing
+				return errRefreshBindingMismatch
+			}
+		}
+
+		// 4. Scope narrowing: the new access token may carry a subset of the granted scopes
+		scope, err := narrowScope(existing, req.Scope)
+		if err != nil {
+			return err
+		}
+
+		// 5. Already rotated -> grace period or reuse detection
+		if existing.ReplacedAt != nil {
+			res, err = s.refreshWithinGracePeriod(ctx, repos, existing, tenant, scope)
+			if errors.Is(err, errRefreshTokenReuse) {
+				reused = existing
+			}
+			return err
+		}
+
+		// 6. Normal rotation (first time using this token)
+		res, err = s.rotateRefreshToken(ctx, repos, existing, tenant, scope, clientIP, userAgent)
+		return err
+	})
+	// 7. Replay: the rolled-back transaction changed nothing, the whole family is revoked on its own
+	if reused != nil {
+		s.revokeFamily(ctx, reused, model.EventUserTokenReuse, clientIP, userAgent)
+	}
+	if hijacked != nil {
+		s.revokeFamily(ctx, hijacked, model.EventUserSessionBindingMismatch, clientIP, userAgent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// idleExpired reports whether the session of a refresh token was inactive for longer than JWT_REFRESH_IDLE_TIMEOUT
+// offline_access tokens are meant to be used while the user is away and have no idle timeout.
+func idleExpired(rt *model.RefreshToken) bool {
+	idleTimeout := util.GetRefreshIdleTimeout()
+	return idleTimeout > 0 && !rt.IsOffline() && time.Since(rt.LastUsed()) > idleTimeout
+}
+
+// Policies when a login exceeds MAX_SESSIONS_PER_USER (SESSION_LIMIT_POLICY)
+const (
+	sessionLimitRevokeOldest = "revoke_oldest" // The sessions that started first are signed out
+	sessionLimitReject       = "reject"        // The login fails until the user signs out a session
+)
+
+var errTooManySessions = errors.New("too many active sessions")
+
+// parseSessionLimitPolicy reads SESSION_LIMIT_POLICY (default revoke_oldest)
+func parseSessionLimitPolicy(value string) string {
+	switch policy := strings.ToLower(strings.TrimSpace(value)); policy {
+	case "":
+		return sessionLimitRevokeOldest
+	case sessionLimitRevokeOldest, sessionLimitReject:
+		return policy
+	default:
+		log.Printf("warning: invalid SESSION_LIMIT_POLICY value '%s', using default revoke_oldest", value)
+		return sessionLimitRevokeOldest
+	}
+}
+
+// enforceSessionLimit is called before a new session of user is stored: at MAX_SESSIONS_PER_USER active
+// sessions it revokes the oldest ones, so the new session fits, or rejects the login (SESSION_LIMIT_POLICY)
+func (s *AuthService) enforceSessionLimit(ctx context.Context, user *model.User) error {
+	if s.maxSessions == 0 {
+		return nil
+	}
+	sessions, err := s.refreshRepo.ListActiveByUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	excess := len(sessions) - s.maxSessions + 1
+	if excess <= 0 {
+		return nil
+	}
+	if s.sessionLimit == sessionLimitReject {
+		log.Printf("login of user %s rejected: %d active sessions (limit %d)", user.Email, len(sessions), s.maxSessions)
+		return errTooManySessions
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LoginTime().Before(sessions[j].LoginTime())
+	})
+	for _, rt := range sessions[:excess] {
+		revoked, err := s.refreshRepo.RevokeWithDescendants(ctx, rt.ID)
+		if err != nil {
+			return err
+		}
+		for _, id := range revoked {
+			if err := s.opaqueTokens.RevokeForRefreshToken(ctx, id); err != nil {
+				return err
+			}
+		}
+		log.Printf("session limit of user %s reached (%d): revoked oldest session %s", user.Email, s.maxSessions, rt.ID)
+	}
+	return nil
+}
+
+// Refresh token client binding policies (REFRESH_BINDING_POLICY)
+const (
+	refreshBindingOff     = "off"     // Client IP and user agent are stored for the session list only
+	refreshBindingWarn    = "warn"    // A mismatch is logged, the refresh succeeds
+	refreshBindingRelogin = "relogin" // The refresh fails; the session keeps working for the client it is bound to
+	refreshBindingRevoke  = "revoke"  // The refresh fails and the whole session is revoked, the user is notified
+)
+
+// errRefreshBindingMismatch is returned when a refresh token comes from another client and the policy rejects it
+var errRefreshBindingMismatch = errors.New("refresh token used from another client")
+
+// parseRefreshBinding reads REFRESH_BINDING_POLICY
+func parseRefreshBinding(value string) string {
+	switch policy := strings.ToLower(strings.TrimSpace(value)); policy {
+	case "":
+		return refreshBindingOff
+	case refreshBindingOff, refreshBindingWarn, refreshBindingRelogin, refreshBindingRevoke:
+		return policy
+	default:
+		log.Printf("warning: invalid REFRESH_BINDING_POLICY value '%s', using default off", value)
+		return refreshBindingOff
+	}
+}
+
+// parseRefreshBindingFields reads REFRESH_BINDING_FIELDS (ip, user_agent; default both)
+func parseRefreshBindingFields(value string) map[string]bool {
+	fields := parseRoleSet(strings.ToLower(value))
+	for field := range fields {
+		if field != "ip" && field != "user_agent" {
+			log.Printf("warning: unknown REFRESH_BINDING_FIELDS entry '%s' ignored", field)
+			delete(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return map[string]bool{"ip": true, "user_agent": true}
+	}
+	return fields
+}
+
+// bindingMismatch returns the field ("ip" or "user_agent") on which a refresh request differs from the client
+// the token was issued to, "" if it matches or binding is off. Tokens without a stored value are not compared.
+func (s *AuthService) bindingMismatch(rt *model.RefreshToken, clientIP, userAgent string) string {
+	if s.refreshBinding == refreshBindingOff {
+		return ""
+	}
+	if s.refreshBindingFields["ip"] && rt.ClientIP != "" && rt.ClientIP != clientIP {
+		return "ip"
+	}
+	if s.refreshBindingFields["user_agent"] && rt.UserAgent != "" && rt.UserAgent != userAgent {
+		return "user_agent"
+	}
+	return ""
+}
+
+// errRefreshTokenReuse is returned when a rotated refresh token is presented after the grace period
+var errRefreshTokenReuse = errors.New("refresh token reuse detected: session revoked for security")
+
+// revokeFamily ends the login a replayed refresh token belongs to: every token of its rotation chain
+// (the attacker's and the legitimate client's) and their opaque access tokens are revoked, and the user is notified.
+// eventType is model.EventUserTokenReuse or model.EventUserSessionBindingMismatch.
+// Tokens from before families were recorded fall back to the token's own chain of replacements.
+func (s *AuthService) revokeFamily(ctx context.Context, rt *model.RefreshToken, eventType string, clientIP, userAgent string) {
+	user, err := s.userRepo.GetByID(ctx, rt.UserID)
+	if err != nil {
+		log.Printf("%s for unknown user %s: %v", eventType, rt.UserID, err)
+		return
+	}
+
+	familyID := rt.FamilyID
+	if familyID == uuid.Nil {
+		familyID = rt.ID
+	}
+
+	var revoked []uuid.UUID
+	if err := s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		var err error
+		if rt.FamilyID == uuid.Nil {
+			revoked, err = repos.RefreshTokens.RevokeWithDescendants(ctx, rt.ID)
+		} else {
+			revoked, err = repos.RefreshTokens.RevokeFamily(ctx, rt.FamilyID)
+		}
+		if err != nil {
+			return err
+		}
+
+		event, err := NewOutboxEvent(eventType, dto.TokenReuseEvent{
+			UserID:        user.ID.String(),
+			Email:         user.Email,
+			FamilyID:      familyID.String(),
+			ClientIP:      clientIP,
+			UserAgent:     userAgent,
+			RevokedTokens: len(revoked),
+		})
+		if err != nil {
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	}); err != nil {
+		log.Printf("failed to revoke token family %s of user %s: %v", familyID, user.Email, err)
+		return
+	}
+
+	for _, id := range revoked {
+		if err := s.opaqueTokens.RevokeForRefreshToken(ctx, id); err != nil {
+			log.Printf("failed to revoke access tokens of refresh token %s: %v", id, err)
+		}
+	}
+	log.Printf("%s for user %s from %s: revoked %d tokens of family %s", eventType, user.Email, clientIP, len(revoked), familyID)
+}
+
+// StepUp re-verifies the user behind an access token with their password and/or a second factor code
+// and returns a new access token with a fresh auth_time for endpoints behind RequireRecentAuth.
+// Users with MFA must present a code: the password alone doesn't re-authenticate them.
+// The token keeps the scope and tenant of the presented one; its session (refresh token) keeps the old auth_time.
+func (s *AuthService) StepUp(ctx context.Context, claims *dto.AuthClaims, password string, code string, clientIP string) (*dto.LoginResponse, error) {
+	user, err := s.stepUpUser(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if code == "" && mfaRequired(user) {
+		return nil, errors.New("second factor required")
+	}
+
+	keys := backoffKeys(user.ID.String(), clientIP)
+	if err := s.checkBackoff(keys); err != nil {
+		return nil, err
+	}
+	var methods []string
+	if password != "" {
+		pwCred := passwordCredential(user)
+		if pwCred == nil || util.ComparePassword(pwCred.Value, password) != nil {
+			err := errors.New("invalid credentials")
+			s.finishBackoff(keys, err, err.Error())
+			return nil, err
+		}
+		methods = append(methods, dto.AMRPassword)
+	}
+	if code != "" {
+		// SMS and email codes are sent by SendStepUpCode
+		factor := ""
+		if mfaRequired(user) {
+			if factor, err = s.checkSecondFactor(ctx, user, "step_up:"+user.ID.String(), code); err != nil {
+				return nil, err
+			}
+		}
+		if factor == "" {
+			err := errors.New("invalid credentials")
+			s.finishBackoff(keys, err, err.Error())
+			return nil, err
+		}
+		methods = append(methods, factor)
+	}
+	s.finishBackoff(keys, nil)
+
+	roleCodes, err := s.tokenRoleCodes(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := util.GenerateAccessTokenOnly(ctx, user.ID, roleCodes, claims.Tenant, claims.Scope, claims.ClientID, dto.NewAuthentication(methods...), user.TokenVersion)
+	if err != nil {
+		return nil, err
+	}
+	if accessToken, err = s.opaqueTokens.Issue(ctx, accessToken, nil); err != nil {
+		return nil, err
+	}
+
+	log.Printf("user %s stepped up with %v", user.Email, methods)
+	return &dto.LoginResponse{AccessToken: accessToken, ExpiresIn: int(util.GetAccessTTL().Seconds())}, nil
+}
+
+// SendStepUpCode sends an SMS or email code (method "sms" or "email") for StepUp to a user whose second factor
+// is SMS or email. Same limits as the codes of a login MFA challenge.
+func (s *AuthService) SendStepUpCode(ctx context.Context, claims *dto.AuthClaims, method string, smsSvc SMSService, emailSvc *EmailService) error {
+	user, err := s.stepUpUser(ctx, claims)
+	if err != nil {
+		return err
+	}
+
+	key := "step_up:" + user.ID.String()
+	switch method {
+	case "sms":
+		if !usesSMSFactor(user) {
+			return errors.New("SMS factor not enabled")
+		}
+		return s.sendMFASMS(user, key, smsSvc)
+	case "email":
+		if !usesEmailFactor(user) {
+			return errors.New("email factor not enabled")
+		}
+		return s.sendMFAEmail(user, key, emailSvc)
+	}
+	return errors.New("invalid method")
+}
+
+// stepUpUser returns the user behind the access token presented for a step-up
+func (s *AuthService) stepUpUser(ctx context.Context, claims *dto.AuthClaims) (*model.User, error) {
+	// Delegated tokens act for a client, which can't re-authenticate the user
+	if claims.Act != nil || claims.Tenant != util.TenantFromContext(ctx) {
+		return nil, errors.New("invalid token")
+	}
+	uid, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	return user, nil
+}
+
+// refreshAuthentication returns the login a refresh token was issued for
+// Tokens from before auth_time was recorded return a zero Authentication (no auth_time claim).
+func refreshAuthentication(rt *model.RefreshToken) dto.Authentication {
+	if rt.AuthTime == nil {
+		return dto.Authentication{}
+	}
+	return dto.Authentication{Time: *rt.AuthTime, Methods: strings.Fields(rt.AMR)}
+}
+
+// narrowScope returns the scope of the refreshed access token (RFC 6749 section 6)
+// Without a requested scope the granted scopes are kept; first-party tokens (no scopes) can't be narrowed.
+func narrowScope(existing *model.RefreshToken, requested string) (string, error) {
+	if requested == "" {
+		return existing.Scope, nil
+	}
+
+	scopes := strings.Fields(requested)
+	for _, scope := range scopes {
+		if !existing.GrantsScope(scope) {
+			return "", errors.New("invalid scope")
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// refreshWithinGracePeriod handles a token that was already rotated.
+// Within the grace period (concurrency retry) it re-issues the existing child; after it, it's a replay.
+func (s *AuthService) refreshWithinGracePeriod(ctx context.Context, repos *repository.Repositories, existing *model.RefreshToken, tenant string, scope string) (*dto.RefreshResponse, error) {
+	duration := time.Since(*existing.ReplacedAt)
+
+	// Get grace period from env (default 10s)
+	gracePeriodStr := os.Getenv("REFRESH_GRACE_PERIOD")
+	if gracePeriodStr == "" {
+		gracePeriodStr = "10s"
+	}
+	gracePeriod, _ := time.ParseDuration(gracePeriodStr)
+
+	// CASE A: Theft Detected (Replay attack after grace period), the caller revokes the family
+	if duration > gracePeriod {
+		return nil, errRefreshTokenReuse
+	}
+
+	// CASE B: Grace Period (Concurrency retry)
+	if existing.ReplacedByTokenID == nil {
+		return nil, errors.New("system inconsistency: replaced timestamp set but no replacement ID")
+	}
+
+	// 1. Find the token that ALREADY replaced this one
+	childToken, err := repos.RefreshTokens.GetByID(ctx, *existing.ReplacedByTokenID)
+	if err != nil {
+		return nil, errors.New("child token not found")
+	}
+	// A session ended since (logout, revoked session) must not come back through a retry of its parent
+	if childToken.RevokedAt != nil || time.Now().After(childToken.ExpiresAt) {
+		return nil, errors.New("invalid refresh token")
+	}
+
+	// 2. Fetch Roles (cached) and the token version
+	roleCodes, err := s.tokenRoleCodes(ctx, existing.UserID)
+	if err != nil {
+		return nil, errors.New("failed to fetch user")
+	}
+	tokenVersion, err := repos.Users.GetTokenVersion(ctx, existing.UserID)
+	if err != nil {
+		return nil, errors.New("failed to fetch user")
+	}
+
+	// 3. Generate ONLY a new Access Token
+	newAccessToken, err := util.GenerateAccessTokenOnly(ctx, existing.UserID, roleCodes, tenant, scope, existing.ClientID, refreshAuthentication(childToken), tokenVersion)
+	if err != nil {
+		return nil, err
+	}
+	if newAccessToken, err = s.opaqueTokens.Issue(ctx, newAccessToken, &childToken.ID); err != nil {
+		return nil, err
+	}
+
+	// 4. Re-sign the EXISTING child token ID
+	refreshTokenString, err := util.SignRefreshToken(childToken.ID, childToken.UserID, tenant, childToken.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// 5. Get access token TTL in seconds for response
+	accessTTLStr := os.Getenv("JWT_ACCESS_TTL")
+	if accessTTLStr == "" {
+		accessTTLStr = "15m"
+	}
+	accessTTL, _ := time.ParseDuration(accessTTLStr)
+	expiresIn := int(accessTTL.Seconds())
+
+	return &dto.RefreshResponse{
+		AccessToken:      newAccessToken,
+		RefreshToken:     refreshTokenString,
+		ExpiresIn:        expiresIn,
+		Scope:            scope,
+		RefreshExpiresAt: childToken.ExpiresAt,
+		RememberMe:       childToken.RememberMe,
+	}, nil
+}
+
+// rotateRefreshToken issues a new pair and links the (locked) parent token to its single child
+// The child keeps the granted scopes (and offline lifetime); only the access token is narrowed to scope
+func (s *AuthService) rotateRefreshToken(ctx context.Context, repos *repository.Repositories, existing *model.RefreshToken, tenant string, scope string, clientIP, userAgent string) (*dto.RefreshResponse, error) {
+	// Fetch User Roles (cached) and the token version
+	roleCodes, err := s.tokenRoleCodes(ctx, existing.UserID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	tokenVersion, err := repos.Users.GetTokenVersion(ctx, existing.UserID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	// The child renews the refresh TTL, capped by the absolute end of the login
+	newRT := &model.RefreshToken{
+		UserID:            existing.UserID,
+		FamilyID:          existing.FamilyID,
+		Scope:             existing.Scope,
+		ClientID:          existing.ClientID,
+		AuthTime:          existing.AuthTime,
+		AMR:               existing.AMR,
+		RememberMe:        existing.RememberMe,
+		DPoPJKT:           util.DPoPThumbprint(ctx),
+		AbsoluteExpiresAt: absoluteExpiry(existing),
+		ClientIP:          clientIP,
+		UserAgent:         userAgent,
+	}
+	now := time.Now()
+	newRT.LastUsedAt = &now
+	newRT.ExpiresAt = refreshExpiry(now, newRT)
+	if !newRT.ExpiresAt.After(now) {
+		return nil, errors.New("session expired")
+	}
+
+	// Generate NEW Pair
+	pair, err := util.GenerateTokens(ctx, existing.UserID, roleCodes, tenant, scope, existing.ClientID, newRT.ExpiresAt, refreshAuthentication(existing), tokenVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	// Save the NEW Token
+	newRT.ID = pair.RefreshID
+	newRT.TokenHash = util.HashToken(pair.RefreshToken)
+	if err := repos.RefreshTokens.Create(ctx, newRT); err != nil {
+		return nil, err
+	}
+
+	// Mark OLD Token as Replaced (Link it to the new one) - same transaction, rolled back together
+	existing.ReplacedAt = &now
+	existing.ReplacedByTokenID = &pair.RefreshID
+	if err := repos.RefreshTokens.Update(ctx, existing); err != nil {
+		return nil, errors.New("failed to rotate token")
+	}
+	accessToken, err := s.opaqueTokens.Issue(ctx, pair.AccessToken, &pair.RefreshID)
+	if err != nil {
+		return nil, err
+	}
+	s.trackActivity(existing.UserID)
+
+	// Get access token TTL in seconds for response
+	accessTTLStr := os.Getenv("JWT_ACCESS_TTL")
+	if accessTTLStr == "" {
+		accessTTLStr = "15m"
+	}
+	accessTTL, _ := time.ParseDuration(accessTTLStr)
+	expiresIn := int(accessTTL.Seconds())
+
+	return &dto.RefreshResponse{AccessToken: accessToken, RefreshToken: pair.RefreshToken, ExpiresIn: expiresIn, Scope: scope, RefreshExpiresAt: newRT.ExpiresAt, RememberMe: newRT.RememberMe}, nil
+}
+
+// GetUserByID retrieves a user by ID with their roles and credentials
+func (s *AuthService) GetUserByID(ctx context.Context, userID string) (*model.User, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+	return s.userRepo.GetByID(ctx, uid)
+}
+
+// GetUserByEmail retrieves a user by email with their roles and credentials
+func (s *AuthService) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	return s.userRepo.GetByEmail(ctx, email)
+}
+
+// StoreRefreshToken stores a refresh token in the database
+func (s *AuthService) StoreRefreshToken(ctx context.Context, tokenID string, userID interface{}, tokenHash string, ttl time.Duration, clientIP, userAgent string) error {
+	// Parse tokenID as UUID
+	uuidID := uuid.MustParse(tokenID)
+
+	// Ensure userID is uuid.UUID
+	var uuidUserID uuid.UUID
+	switch v := userID.(type) {
+	case uuid.UUID:
+		uuidUserID = v
+	case string:
+		uuidUserID = uuid.MustParse(v)
+	default:
+		return errors.New("invalid user ID type")
+	}
+
+	rt := &model.RefreshToken{
+		ID:        uuidID,
+		UserID:    uuidUserID,
+		TokenHash: tokenHash,
+		FamilyID:  uuidID,
+		ExpiresAt: time.Now().Add(ttl),
+		ClientIP:  clientIP,
+		UserAgent: userAgent,
+	}
+	return s.refreshRepo.Create(ctx, rt)
+}
+
+// MarkEmailVerified sets IsEmailVerified = true for the specified user
+func (s *AuthService) MarkEmailVerified(ctx context.Context, userID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	if user.IsEmailVerified {
+		return nil
+	}
+
+	user.IsEmailVerified = true
+	if err := s.saveWithEvent(ctx, model.EventUserEmailVerified, user, func(repos *repository.Repositories) error {
+		return repos.Users.Update(ctx, user)
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// VerifyEmailWithToken verifies the email of the user a verification link was sent to and returns the address
+// The link can only be used once; the pending code of the user is dropped with it.
+func (s *AuthService) VerifyEmailWithToken(ctx context.Context, token string) (string, error) {
+	invalid := errors.New("invalid or expired link")
+
+	action, err := util.ParseActionToken(token, util.ActionVerifyEmail)
+	if err != nil || action.Tenant != util.TenantFromContext(ctx) {
+		return "", invalid
+	}
+	user, err := s.userRepo.GetByID(ctx, action.UserID)
+	if err != nil {
+		return "", invalid
+	}
+	if err := action.Consume(ctx); err != nil {
+		return "", invalid
+	}
+
+	if err := s.MarkEmailVerified(ctx, user.ID.String()); err != nil {
+		return "", err
+	}
+	if s.verificationSvc != nil {
+		_ = s.verificationSvc.DeleteCode(user.ID.String()) // Ignore error if key doesn't exist
+	}
+	return user.Email, nil
+}
+
+// SendPasswordChangeOTP sends an OTP to the user's email for password change
+func (s *AuthService) SendPasswordChangeOTP(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return errors.New("user not found")
+	}
+
+	// Send OTP via verification service
+	if s.verificationSvc != nil {
+		if err := s.verificationSvc.SendVerificationCode(user.ID.String(), user.Email, user.Tenant); err != nil {
+			log.Printf("failed to send password change OTP to %s: %v", user.Email, err)
+			return err
+		}
+		log.Printf("password change OTP sent successfully to %s", user.Email)
+		return nil
+	}
+	return errors.New("verification service not configured")
+}
+
+// SendPasswordChangeOTPByUserID sends an OTP to the user's email using their user ID
+func (s *AuthService) SendPasswordChangeOTPByUserID(ctx context.Context, userID string) (string, error) {
+	// 1. Parse userID
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return "", errors.New("invalid user ID format")
+	}
+
+	// 2. Get user by ID
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return "", errors.New("user not found")
+	}
+
+	// 3. Send OTP via verification service
+	if s.verificationSvc != nil {
+		if err := s.verificationSvc.SendPasswordChangeCode(user.ID.String(), user.Email); err != nil {
+			log.Printf("failed to send password change OTP to %s: %v", user.Email, err)
+			return "", err
+		}
+		log.Printf("password change OTP sent successfully to %s", user.Email)
+		return user.Email, nil
+	}
+	return "", errors.New("verification service not configured")
+}
+
+// ChangePassword changes the user's password after OTP verification
+// Access tokens issued so far, the other sessions and the API keys are revoked; the session of refreshToken
+// (the refresh cookie of the request, may be empty) stays signed in.
+func (s *AuthService) ChangePassword(ctx context.Context, userID string, oldPassword string, newPassword string, otpCode string, refreshToken string) error {
+	// 1. Parse userID
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+
+	// 2. Get user with credentials
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	// 3. Check the new password before the OTP is used up
+	if err := s.passwordPolicy.Check(ctx, newPassword, user.Email, user.Name); err != nil {
+		return err
+	}
+	if s.verificationSvc != nil {
+		if err := s.verificationSvc.VerifyCode(userID, otpCode); err != nil {
+			return err
+		}
+	} else {
+		return errors.New("verification service not configured")
+	}
+
+	// 4. Find existing password credential
+	pwCred := passwordCredential(user)
+	if pwCred == nil {
+		return errors.New("password credential not found")
+	}
+
+	// 5. Verify old password
+	if err := util.ComparePassword(pwCred.Value, oldPassword); err != nil {
+		return errors.New("invalid old password")
+	}
+
+	// 6. Hash new password
+	hashedNewPassword, err := util.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	// 7. Update credential and sign out everywhere else in one transaction: access tokens issued so far
+	// are rejected, the other sessions and the API keys are revoked. The session making the request
+	// (refreshToken, the cookie) stays, so the client gets a new access token at /auth/refresh.
+	keepID := uuid.Nil
+	if refreshToken != "" {
+		if rt, err := s.refreshRepo.GetByTokenHash(ctx, util.HashToken(refreshToken)); err == nil && rt.UserID == user.ID {
+			keepID = rt.ID
+		}
+	}
+	pwCred.SetPassword(hashedNewPassword)
+	if err := s.saveWithEvent(ctx, model.EventUserPasswordChanged, user, func(repos *repository.Repositories) error {
+		if err := repos.Credentials.Update(ctx, pwCred); err != nil {
+			return err
+		}
+		if _, err := repos.Users.BumpTokenVersion(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := repos.RefreshTokens.RevokeAllForUserExcept(ctx, user.ID, keepID); err != nil {
+			return err
+		}
+		return repos.APIKeys.RevokeAllForUser(ctx, user.ID)
+	}); err != nil {
+		return err
+	}
+
+	if err := s.opaqueTokens.RevokeAllForUser(ctx, user.ID); err != nil {
+		log.Printf("failed to revoke access tokens of user %s: %v", user.Email, err)
+	}
+
+	log.Printf("password changed successfully for user %s", user.Email)
+	return nil
+}
+
+// SendForgotPasswordOTP sends a 6-digit OTP code to the user's email for password reset
+// If email doesn't exist, silently logs and returns no error (for security)
+func (s *AuthService) SendForgotPasswordOTP(ctx context.Context, email string, emailSvc *EmailService) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		// Silently log that email was not found - security best practice
+		log.Printf("password reset request for non-existent email: %s", email)
+		return nil // Return success to prevent email enumeration
+	}
+
+	// Generate 6-digit OTP code and store it with a 5-minute TTL before it goes out,
+	// so the code in the email always works
+	otpCode := util.GenerateRandomDigits(6)
+	if s.verificationSvc != nil {
+		resetKey := "forgot_password:" + user.ID.String()
+		if err := s.verificationSvc.StoreCode(resetKey, otpCode, 5*time.Minute); err != nil {
+			log.Printf("failed to store password reset OTP for %s: %v", user.Email, err)
+			return err
+		}
+	}
+
+	// With PASSWORD_RESET_URL the email also links to the reset page with a one-time token
+	link := ""
+	if s.passwordResetURL != "" {
+		token, err := util.GenerateActionToken(user.ID, user.Tenant, util.ActionResetPassword)
+		if err != nil {
+			return err
+		}
+		sep := "?"
+		if strings.Contains(s.passwordResetURL, "?") {
+			sep = "&"
+		}
+		link = s.passwordResetURL + sep + "token=" + url.QueryEscape(token)
+	}
+
+	// Send OTP via email
+	if err := emailSvc.SendForgotPasswordOTP(user.Email, otpCode, link); err != nil {
+		log.Printf("failed to send password reset OTP to %s: %v", user.Email, err)
+		return err
+	}
+
+	log.Printf("password reset OTP sent successfully to %s", user.Email)
+	return nil
+}
+
+// ResetPasswordWithOTP validates the OTP, sets the password chosen by the user and revokes all of their sessions
+func (s *AuthService) ResetPasswordWithOTP(ctx context.Context, email string, otpCode string, newPassword string, clientIP string) error {
+	keys := backoffKeys(email, clientIP)
+	if err := s.checkBackoff(keys); err != nil {
+		return err
+	}
+
+	// 1. Get user by email (users of another tenant are not found)
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		err = errors.New("user not found")
+	} else {
+		err = s.resetPassword(ctx, user, otpCode, newPassword)
+	}
+	s.finishBackoff(keys, err, "user not found", "invalid or expired OTP code")
+	return err
+}
+
+// ResetPasswordWithSMS is ResetPasswordWithOTP for a code sent to the user's verified phone
+func (s *AuthService) ResetPasswordWithSMS(ctx context.Context, phone string, otpCode string, newPassword string, clientIP string) error {
+	keys := backoffKeys(phone, clientIP)
+	if err := s.checkBackoff(keys); err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetByPhone(ctx, phone)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		err = errors.New("invalid or expired OTP code")
+	} else {
+		err = s.resetPassword(ctx, user, otpCode, newPassword)
+	}
+	s.finishBackoff(keys, err, "invalid or expired OTP code")
+	return err
+}
+
+func (s *AuthService) resetPassword(ctx context.Context, user *model.User, otpCode string, newPassword string) error {
+	// 2. Check the new password, then verify the OTP code
+	if err := s.passwordPolicy.Check(ctx, newPassword, user.Email, user.Name); err != nil {
+		return err
+	}
+	resetKey := "forgot_password:" + user.ID.String()
+	if s.verificationSvc != nil {
+		if err := s.verificationSvc.VerifyCode(resetKey, otpCode); err != nil {
+			log.Printf("invalid OTP for password reset of %s: %v", user.Email, err)
+			return errors.New("invalid or expired OTP code")
+		}
+	} else {
+		return errors.New("verification service not configured")
+	}
+
+	return s.applyPasswordReset(ctx, user, newPassword)
+}
+
+// ResetPasswordWithToken sets a new password with the token of a password reset link
+// Like a reset with the code, it revokes all of the user's sessions; the token can only be used once.
+func (s *AuthService) ResetPasswordWithToken(ctx context.Context, token string, newPassword string) error {
+	invalid := errors.New("invalid or expired link")
+
+	action, err := util.ParseActionToken(token, util.ActionResetPassword)
+	if err != nil {
+		return invalid
+	}
+	user, err := s.userRepo.GetByID(ctx, action.UserID)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		return invalid
+	}
+	// Check the password first, so a rejected password doesn't use up the link
+	if err := s.passwordPolicy.Check(ctx, newPassword, user.Email, user.Name); err != nil {
+		return err
+	}
+	if err := action.Consume(ctx); err != nil {
+		return invalid
+	}
+
+	return s.applyPasswordReset(ctx, user, newPassword)
+}
+
+// applyPasswordReset stores the new password of a verified reset and signs the user out everywhere
+func (s *AuthService) applyPasswordReset(ctx context.Context, user *model.User, newPassword string) error {
+	// 3. Find password credential
+	pwCred := passwordCredential(user)
+	if pwCred == nil {
+		return errors.New("password credential not found")
+	}
+
+	// 4. Hash the new password
+	hashedPassword, err := util.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	// 5. Update credential, revoke every refresh token and API key and unlock the account in one transaction,
+	// so a stolen session cannot outlive the reset
+	pwCred.SetPassword(hashedPassword)
+	if err := s.saveWithEvent(ctx, model.EventUserPasswordReset, user, func(repos *repository.Repositories) error {
+		if err := repos.Credentials.Update(ctx, pwCred); err != nil {
+			return err
+		}
+		if err := repos.Users.SetLoginLock(ctx, user.ID, nil); err != nil {
+			return err
+		}
+		// Access tokens issued so far are rejected as well, API keys are revoked with the sessions
+		if _, err := repos.Users.BumpTokenVersion(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := repos.RefreshTokens.RevokeAllForUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return repos.APIKeys.RevokeAllForUser(ctx, user.ID)
+	}); err != nil {
+		return err
+	}
+
+	if err := s.opaqueTokens.RevokeAllForUser(ctx, user.ID); err != nil {
+		log.Printf("failed to revoke access tokens of user %s: %v", user.Email, err)
+	}
+
+	// 6. Clean up the OTP from verification storage (the code and the link of one request are redeemed together)
+	if s.verificationSvc != nil {
+		_ = s.verificationSvc.DeleteCode("forgot_password:" + user.ID.String()) // Ignore error if key doesn't exist
+	}
+
+	log.Printf("password reset completed for user %s", user.Email)
+	return nil
+}
+
+// SendTemporaryPassword replaces the password of a user with a random one and emails it to them
+// The user has to choose a new password at the next login (must_change_password). Like a password reset,
+// it signs the user out everywhere and unlocks the account. Users without a password (social or passwordless
+// login only) get a password credential.
+func (s *AuthService) SendTemporaryPassword(ctx context.Context, adminID string, userID string, emailSvc *EmailService) error {
+	password, err := util.GenerateSecureToken(12)
+	if err != nil {
+		return err
+	}
+	user, err := s.setTemporaryPassword(ctx, adminID, userID, password, false)
+	if err != nil {
+		return err
+	}
+
+	if err := emailSvc.SendTemporaryPassword(user.Email, password); err != nil {
+		log.Printf("failed to send temporary password to %s: %v", user.Email, err)
+		return errors.New("failed to send email")
+	}
+	return nil
+}
+
+// AdminResetPassword sets a password chosen by an admin, who passes it to the user out of band
+// The password has to meet the password policy and must be changed at the next login, see SendTemporaryPassword.
+func (s *AuthService) AdminResetPassword(ctx context.Context, adminID string, userID string, password string) error {
+	_, err := s.setTemporaryPassword(ctx, adminID, userID, password, true)
+	return err
+}
+
+// setTemporaryPassword stores a password the user must change at the next login; checkPolicy is false for generated passwords
+func (s *AuthService) setTemporaryPassword(ctx context.Context, adminID string, userID string, password string, checkPolicy bool) (*model.User, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		return nil, errors.New("user not found")
+	}
+	if user.IsAnonymous {
+		return nil, errors.New("guest accounts have no password")
+	}
+	if checkPolicy {
+		if err := s.passwordPolicy.Check(ctx, password, user.Email, user.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	hashed, err := util.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	pwCred := passwordCredential(user)
+	created := pwCred == nil
+	if created {
+		pwCred = &model.Credential{UserID: user.ID, Type: model.CredTypePassword}
+	}
+	pwCred.SetTemporaryPassword(hashed)
+
+	if err := s.saveWithEvent(ctx, model.EventUserPasswordReset, user, func(repos *repository.Repositories) error {
+		save := repos.Credentials.Update
+		if created {
+			save = repos.Credentials.Create
+		}
+		if err := save(ctx, pwCred); err != nil {
+			return err
+		}
+		if err := repos.Users.SetLoginLock(ctx, user.ID, nil); err != nil {
+			return err
+		}
+		// Access tokens issued so far are rejected as well, API keys are revoked with the sessions
+		if _, err := repos.Users.BumpTokenVersion(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := repos.RefreshTokens.RevokeAllForUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return repos.APIKeys.RevokeAllForUser(ctx, user.ID)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := s.opaqueTokens.RevokeAllForUser(ctx, user.ID); err != nil {
+		log.Printf("failed to revoke access tokens of user %s: %v", user.Email, err)
+	}
+
+	log.Printf("temporary password set for user %s by %s", user.Email, adminID)
+	return user, nil
+}
+
+// RequestMFAReset emails a code to start the self-service MFA reset (lost authenticator, no recovery codes)
+// If there is no such account with MFA, silently returns no error (prevents enumeration)
+func (s *AuthService) RequestMFAReset(ctx context.Context, email string, emailSvc *EmailService) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil || !inCurrentTenant(ctx, user) || !user.IsEmailVerified || !mfaRequired(user) {
+		log.Printf("MFA reset request for unknown account: %s", email)
+		return nil
+	}
+
+	if s.verificationSvc == nil {
+		return errors.New("verification service not configured")
+	}
+
+	otpCode := util.GenerateRandomDigits(6)
+	if err := s.verificationSvc.StoreCode("mfa_reset:"+user.ID.String(), otpCode, 5*time.Minute); err != nil {
+		return err
+	}
+
+	if err := emailSvc.SendMFAResetOTP(user.Email, otpCode); err != nil {
+		log.Printf("failed to send MFA reset OTP to %s: %v", user.Email, err)
+		return errors.New("failed to send email")
+	}
+	return nil
+}
+
+// ConfirmMFAReset checks the emailed code and schedules the MFA reset after the cooldown
+// Until then the user is notified and can cancel it by signing in with a second factor.
+// Returns when the reset takes effect; confirming again doesn't postpone a pending reset.
+func (s *AuthService) ConfirmMFAReset(ctx context.Context, email string, otpCode string, clientIP string) (time.Time, error) {
+	keys := backoffKeys(email, clientIP)
+	if err := s.checkBackoff(keys); err != nil {
+		return time.Time{}, err
+	}
+	invalid := errors.New("invalid or expired OTP code")
+
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		s.finishBackoff(keys, invalid, invalid.Error())
+		return time.Time{}, invalid
+	}
+
+	if s.verificationSvc == nil {
+		return time.Time{}, errors.New("verification service not configured")
+	}
+	if err := s.verificationSvc.VerifyCode("mfa_reset:"+user.ID.String(), otpCode); err != nil {
+		s.finishBackoff(keys, invalid, invalid.Error())
+		return time.Time{}, invalid
+	}
+	s.finishBackoff(keys, nil)
+
+	if user.MFAResetAt != nil {
+		return *user.MFAResetAt, nil
+	}
+
+	effectiveAt := time.Now().Add(s.mfaResetCooldown)
+	event, err := NewOutboxEvent(model.EventUserMFAResetRequested, dto.MFAResetEvent{
+		UserID:      user.ID.String(),
+		Email:       user.Email,
+		Actor:       mfaResetActorSelf,
+		EffectiveAt: &effectiveAt,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	user.MFAResetAt = &effectiveAt
+	if err := s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		if err := repos.Users.Update(ctx, user); err != nil {
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	}); err != nil {
+		return time.Time{}, err
+	}
+
+	log.Printf("MFA reset requested for user %s, effective at %s", user.Email, effectiveAt.Format(time.RFC3339))
+	return effectiveAt, nil
+}
+
+// AdminResetMFA removes every second factor of a user at once (lost device, verified out of band)
+// The admin and the reason are recorded in the user.mfa_reset event.
+func (s *AuthService) AdminResetMFA(ctx context.Context, adminID string, userID string, reason string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		return errors.New("user not found")
+	}
+	if !mfaRequired(user) && user.MFAResetAt == nil {
+		return errors.New("MFA not enabled")
+	}
+
+	return s.resetMFA(ctx, user, adminID, reason)
+}
+
+// resetMFA removes the TOTP secret, the SMS and email factors, the recovery codes and the remembered devices, and writes the audit event
+func (s *AuthService) resetMFA(ctx context.Context, user *model.User, actor string, reason string) error {
+	event, err := NewOutboxEvent(model.EventUserMFAReset, dto.MFAResetEvent{
+		UserID: user.ID.String(),
+		Email:  user.Email,
+		Actor:  actor,
+		Reason: reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	user.IsMFAEnabled = false
+	user.MFASecret = ""
+	user.IsSMSMFAEnabled = false
+	user.IsEmailMFAEnabled = false
+	user.MFAResetAt = nil
+
+	if err := s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		if err := repos.Users.Update(ctx, user); err != nil {
+			return err
+		}
+		if err := repos.RecoveryCodes.Replace(ctx, user.ID, nil); err != nil {
+			return err
+		}
+		// Remembered devices would otherwise keep skipping the factors enrolled after the reset
+		if err := repos.Devices.DeleteAllForUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	}); err != nil {
+		return err
+	}
+
+	log.Printf("MFA reset for user %s by %s: %s", user.Email, actor, reason)
+	return nil
+}
+
+// cancelMFAReset drops a pending self-service reset once the user proved they still have a factor
+func (s *AuthService) cancelMFAReset(ctx context.Context, user *model.User) {
+	if user.MFAResetAt == nil {
+		return
+	}
+	user.MFAResetAt = nil
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		log.Printf("failed to cancel MFA reset of user %s: %v", user.Email, err)
+		return
+	}
+	log.Printf("pending MFA reset of user %s cancelled by a successful MFA login", user.Email)
+}
+
+// EnableEmailMFA makes codes sent to the verified email address the user's second factor
+// Returns new recovery codes when this is the user's first factor, nil otherwise.
+func (s *AuthService) EnableEmailMFA(ctx context.Context, userID string) ([]string, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if !user.IsEmailVerified {
+		return nil, errors.New("email not verified")
+	}
+	if user.IsEmailMFAEnabled {
+		return nil, nil
+	}
+
+	var codes, hashes []string
+	if !mfaRequired(user) {
+		if codes, hashes, err = generateRecoveryCodes(); err != nil {
+			return nil, err
+		}
+	}
+
+	user.IsEmailMFAEnabled = true
+	if err := s.saveWithEvent(ctx, model.EventUserMFAEnabled, user, func(repos *repository.Repositories) error {
+		if err := repos.Users.Update(ctx, user); err != nil {
+			return err
+		}
+		if hashes == nil {
+			return nil
+		}
+		return repos.RecoveryCodes.Replace(ctx, user.ID, hashes)
+	}); err != nil {
+		return nil, err
+	}
+
+	log.Printf("email MFA enabled for user %s", user.Email)
+	return codes, nil
+}
+
+// DisableEmailMFA stops sending MFA codes by email; other factors stay active
+func (s *AuthService) DisableEmailMFA(ctx context.Context, userID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if !user.IsEmailMFAEnabled {
+		return nil
+	}
+
+	user.IsEmailMFAEnabled = false
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return err
+	}
+
+	log.Printf("email MFA disabled for user %s", user.Email)
+	return nil
+}
+
+// InitiateMFA generates a TOTP secret for the user and returns the secret and a QR code URL
+// Requires user email to be verified first
+func (s *AuthService) InitiateMFA(ctx context.Context, userID string) (string, string, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return "", "", errors.New("invalid user ID format")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Check if email is verified before allowing MFA setup
+	if !user.IsEmailVerified {
+		return "", "", errors.New("email not verified")
+	}
+
+	secret, err := util.GenerateTOTPSecret(user.Email)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Build a local QR code endpoint (client can call /auth/mfa/qrcode with email & secret)
+	qrURL := "/auth/mfa/qrcode?email=" + url.QueryEscape(user.Email) + "&secret=" + url.QueryEscape(secret)
+
+	return secret, qrURL, nil
+}
+
+// ConfirmMFA verifies the provided TOTP token against the secret and enables MFA for the user
+// Returns the recovery codes (shown once) that replace the authenticator if it is lost
+func (s *AuthService) ConfirmMFA(ctx context.Context, userID string, secret string, token string) ([]string, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	// Verify TOTP; the code is used up, so it can't pass the first MFA login as well
+	ok, err := util.UseTOTP(ctx, userID, secret, token)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("invalid MFA token")
+	}
+
+	// Persist secret and enable MFA
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	user.MFASecret = secret
+	user.IsMFAEnabled = true
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.saveWithEvent(ctx, model.EventUserMFAEnabled, user, func(repos *repository.Repositories) error {
+		if err := repos.Users.Update(ctx, user); err != nil {
+			return err
+		}
+		return repos.RecoveryCodes.Replace(ctx, user.ID, hashes)
+	}); err != nil {
+		log.Printf("failed to save MFA settings for user %s: %v", user.Email, err)
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the user's MFA recovery codes; the old ones stop working
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if !mfaRequired(user) {
+		return nil, errors.New("MFA not enabled")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		return repos.RecoveryCodes.Replace(ctx, user.ID, hashes)
+	}); err != nil {
+		return nil, err
+	}
+
+	log.Printf("regenerated MFA recovery codes for user %s", user.Email)
+	return codes, nil
+}
+
+// generateRecoveryCodes returns recoveryCodeCount new codes and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := util.GenerateRecoveryCode()
+		if err != nil {
+			return nil, nil, err
 		}
-		log.Printf("password change OTP sent successfully to %s", user.Email)
-		return user.Email, nil
+		codes[i] = code
+		hashes[i] = util.HashToken(util.NormalizeRecoveryCode(code))
 	}
-	return "", errors.New("verification service not configured")
+	return codes, hashes, nil
 }
 
-// ChangePassword changes the user's password after OTP verification
-func (s *AuthService) ChangePassword(userID string, oldPassword string, newPassword string, otpCode string) error {
-	// 1. Parse userID
+// useRecoveryCode consumes one of the user's recovery codes; false when it is unknown or already used
+func (s *AuthService) useRecoveryCode(ctx context.Context, user *model.User, code string) (bool, error) {
+	var ok bool
+	var remaining int64
+	err := s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		var err error
+		if ok, err = repos.RecoveryCodes.Consume(ctx, user.ID, util.HashToken(util.NormalizeRecoveryCode(code))); err != nil || !ok {
+			return err
+		}
+		remaining, err = repos.RecoveryCodes.CountUnused(ctx, user.ID)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		log.Printf("user %s signed in with an MFA recovery code, %d left", user.Email, remaining)
+	}
+	return ok, nil
+}
+
+// StartPhoneVerification sends an SMS OTP to the phone number the user wants to attach
+// The code is keyed by user and number, so only the number it was sent to can be confirmed
+func (s *AuthService) StartPhoneVerification(ctx context.Context, userID string, phone string, smsSvc SMSService) error {
 	uid, err := uuid.Parse(userID)
 	if err != nil {
 		return errors.New("invalid user ID format")
 	}
 
-	// 2. Verify OTP
-	if s.verificationSvc != nil {
-		if err := s.verificationSvc.VerifyCode(userID, otpCode); err != nil {
-			return err
-		}
-	} else {
+	if _, err := s.userRepo.GetByPhone(ctx, phone); err == nil {
+		return errors.New("phone already in use")
+	}
+
+	if s.verificationSvc == nil {
 		return errors.New("verification service not configured")
 	}
 
-	// 3. Get user with credentials
-	user, err := s.userRepo.GetByID(uid)
-	if err != nil {
+	otpCode := util.GenerateRandomDigits(6)
+	if err := s.verificationSvc.StoreCode("phone_verify:"+uid.String()+":"+phone, otpCode, 5*time.Minute); err != nil {
 		return err
 	}
 
-	// 4. Find existing password credential
-	var pwCred *model.Credential
-	for i, c := range user.Credentials {
-		if c.Type == model.CredTypePassword {
-			pwCred = &user.Credentials[i]
-			break
-		}
+	if err := smsSvc.SendOTP(phone, otpCode); err != nil {
+		log.Printf("failed to send phone verification OTP to %s: %v", phone, err)
+		return errors.New("failed to send sms")
 	}
-	if pwCred == nil {
-		return errors.New("password credential not found")
+	return nil
+}
+
+// ConfirmPhone verifies the SMS OTP and stores the phone number as verified
+func (s *AuthService) ConfirmPhone(ctx context.Context, userID string, phone string, otpCode string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
 	}
 
-	// 5. Verify old password
-	if err := util.ComparePassword(pwCred.Value, oldPassword); err != nil {
-		return errors.New("invalid old password")
+	if s.verificationSvc == nil {
+		return errors.New("verification service not configured")
+	}
+	if err := s.verificationSvc.VerifyCode("phone_verify:"+uid.String()+":"+phone, otpCode); err != nil {
+		return errors.New("invalid or expired OTP code")
 	}
 
-	// 6. Hash new password
-	hashedNewPassword, err := util.HashPassword(newPassword)
+	user, err := s.userRepo.GetByID(ctx, uid)
 	if err != nil {
-		return err
+		return errors.New("user not found")
 	}
 
-	// 7. Update credential
-	pwCred.Value = hashedNewPassword
-	if err := s.credentialRepo.Update(pwCred); err != nil {
+	user.Phone = &phone
+	user.IsPhoneVerified = true
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		if util.IsDuplicateKeyError(err) {
+			return errors.New("phone already in use")
+		}
 		return err
 	}
 
-	log.Printf("password changed successfully for user %s", user.Email)
+	log.Printf("phone verified for user %s", user.Email)
 	return nil
 }
 
-// SendForgotPasswordOTP sends a 6-digit OTP code to the user's email for password reset
-// If email doesn't exist, silently logs and returns no error (for security)
-func (s *AuthService) SendForgotPasswordOTP(email string, emailSvc *EmailService) error {
-	user, err := s.userRepo.GetByEmail(email)
+// CheckUsername reports whether a username is valid and not taken yet
+func (s *AuthService) CheckUsername(ctx context.Context, username string) *dto.UsernameAvailabilityResponse {
+	username = util.NormalizeUsername(username)
+	res := &dto.UsernameAvailabilityResponse{Username: username, Available: true}
+	if !util.IsValidUsername(username) {
+		res.Available, res.Reason = false, "invalid"
+	} else if _, err := s.userRepo.GetByUsername(ctx, username); err == nil {
+		res.Available, res.Reason = false, "taken"
+	}
+	return res
+}
+
+// ChangeUsername sets or changes the username of a user; the old one becomes available to others right away
+func (s *AuthService) ChangeUsername(ctx context.Context, userID string, username string) error {
+	uid, err := uuid.Parse(userID)
 	if err != nil {
-		// Silently log that email was not found - security best practice
-		log.Printf("password reset request for non-existent email: %s", email)
-		return nil // Return success to prevent email enumeration
+		return errors.New("invalid user ID format")
+	}
+	username = util.NormalizeUsername(username)
+	if !util.IsValidUsername(username) {
+		return errors.New("invalid username")
 	}
 
-	// Generate 6-digit OTP code
-	otpCode := util.GenerateRandomDigits(6)
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if user.Username != nil && *user.Username == username {
+		return nil
+	}
+	if _, err := s.userRepo.GetByUsername(ctx, username); err == nil {
+		return errors.New("username already in use")
+	}
 
-	// Send OTP via email
-	if err := emailSvc.SendForgotPasswordOTP(user.Email, otpCode); err != nil {
-		log.Printf("failed to send password reset OTP to %s: %v", user.Email, err)
+	old := user.Username
+	user.Username = &username
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		// Taken by a concurrent request since the check above
+		if util.IsDuplicateKeyError(err) {
+			return errors.New("username already in use")
+		}
 		return err
 	}
 
-	// Store OTP with 5-minute TTL using verification service
-	if s.verificationSvc != nil {
-		resetKey := "forgot_password:" + user.ID.String()
-		if err := s.verificationSvc.StoreCode(resetKey, otpCode, 5*time.Minute); err != nil {
-			log.Printf("failed to store password reset OTP for %s: %v", user.Email, err)
-			return err
-		}
+	if old != nil {
+		log.Printf("username of user %s changed from %s to %s", user.Email, *old, username)
+	} else {
+		log.Printf("username %s set for user %s", username, user.Email)
 	}
+	return nil
+}
 
-	log.Printf("password reset OTP sent successfully to %s", user.Email)
+// SendPhoneLoginOTP sends a passwordless login code to a verified phone number
+// If no account has this verified number, silently returns no error (prevents enumeration)
+func (s *AuthService) SendPhoneLoginOTP(ctx context.Context, phone string, smsSvc SMSService) error {
+	user, err := s.userRepo.GetByPhone(ctx, phone)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		log.Printf("phone login request for unknown number: %s", phone)
+		return nil
+	}
+
+	if s.verificationSvc == nil {
+		return errors.New("verification service not configured")
+	}
+
+	otpCode := util.GenerateRandomDigits(6)
+	if err := s.verificationSvc.StoreCode("phone_login:"+user.ID.String(), otpCode, 5*time.Minute); err != nil {
+		return err
+	}
+
+	if err := smsSvc.SendOTP(phone, otpCode); err != nil {
+		log.Printf("failed to send phone login OTP to %s: %v", phone, err)
+		return errors.New("failed to send sms")
+	}
 	return nil
 }
 
-// ResetPasswordWithOTP validates the OTP and resets the password with a temporary password
-func (s *AuthService) ResetPasswordWithOTP(email string, otpCode string, emailSvc *EmailService) error {
-	// 1. Get user by email
-	user, err := s.userRepo.GetByEmail(email)
-	if err != nil {
-		return errors.New("user not found")
+// LoginWithPhone exchanges a valid SMS OTP for a token pair
+func (s *AuthService) LoginWithPhone(ctx context.Context, phone string, otpCode string, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	keys := backoffKeys(phone, clientIP)
+	if err := s.checkBackoff(keys); err != nil {
+		return nil, err
 	}
+	invalid := errors.New("invalid or expired OTP code")
 
-	// 2. Verify OTP code
-	if s.verificationSvc != nil {
-		resetKey := "forgot_password:" + user.ID.String()
-		if err := s.verificationSvc.VerifyCode(resetKey, otpCode); err != nil {
-			log.Printf("invalid OTP for password reset on email %s: %v", email, err)
-			return errors.New("invalid or expired OTP code")
-		}
-	} else {
+	user, err := s.userRepo.GetByPhone(ctx, phone)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		s.finishBackoff(keys, invalid, invalid.Error())
+		return nil, invalid
+	}
+
+	if s.verificationSvc == nil {
+		return nil, errors.New("verification service not configured")
+	}
+	if err := s.verificationSvc.VerifyCode("phone_login:"+user.ID.String(), otpCode); err != nil {
+		s.finishBackoff(keys, invalid, invalid.Error())
+		return nil, invalid
+	}
+	s.finishBackoff(keys, nil)
+
+	return s.completeLogin(ctx, user, dto.AMRSMS, "", false, clientIP, userAgent)
+}
+
+// SendMagicLink emails a single-use passwordless login link to a verified address
+// callbackURL is the callback endpoint; the token is appended as ?token=. If there is no such
+// verified account, silently returns no error (prevents enumeration).
+func (s *AuthService) SendMagicLink(ctx context.Context, email string, callbackURL string, emailSvc *EmailService) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil || !inCurrentTenant(ctx, user) || !user.IsEmailVerified {
+		log.Printf("magic link request for unknown account: %s", email)
+		return nil
+	}
+
+	if s.verificationSvc == nil {
 		return errors.New("verification service not configured")
 	}
 
-	// 3. Generate random 8-character password
-	tempPassword, err := util.GenerateRandomPassword(8)
+	token, err := util.GenerateSecureToken(32)
 	if err != nil {
-		log.Printf("failed to generate temporary password for %s: %v", email, err)
-		return errors.New("failed to generate temporary password")
+		return err
+	}
+	// Only the hash is stored, so the storage never holds a usable link
+	if err := s.verificationSvc.StoreCode(magicLinkKey(token), user.ID.String(), s.magicLinkTTL); err != nil {
+		return err
+	}
+
+	link := callbackURL + "?token=" + url.QueryEscape(token)
+	if err := emailSvc.SendMagicLink(user.Email, link, s.magicLinkTTL); err != nil {
+		log.Printf("failed to send magic link to %s: %v", user.Email, err)
+		return errors.New("failed to send email")
+	}
+	return nil
+}
+
+func magicLinkKey(token string) string {
+	return "magic_link:" + util.HashToken(token)
+}
+
+// LoginWithMagicLink redeems an emailed login link for a token pair (or an MFA challenge)
+// The link works once; a second click fails even before it expires.
+func (s *AuthService) LoginWithMagicLink(ctx context.Context, token string, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	if s.verificationSvc == nil {
+		return nil, errors.New("verification service not configured")
 	}
 
-	// 4. Hash the temporary password
-	hashedPassword, err := util.HashPassword(tempPassword)
+	key := magicLinkKey(token)
+	userID, err := s.verificationSvc.GetCode(key)
 	if err != nil {
-		return err
+		return nil, errors.New("invalid or expired login link")
 	}
+	_ = s.verificationSvc.DeleteCode(key)
 
-	// 5. Find and update password credential
-	var pwCred *model.Credential
-	for i, c := range user.Credentials {
-		if c.Type == model.CredTypePassword {
-			pwCred = &user.Credentials[i]
-			break
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid or expired login link")
+	}
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		return nil, errors.New("invalid or expired login link")
+	}
+
+	return s.completeLogin(ctx, user, dto.AMROTP, "", false, clientIP, userAgent)
+}
+
+// EnableSMSMFA makes SMS codes to the verified phone the user's second factor
+// Returns new recovery codes when this is the user's first factor, nil otherwise.
+func (s *AuthService) EnableSMSMFA(ctx context.Context, userID string) ([]string, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if !user.IsPhoneVerified || user.Phone == nil {
+		return nil, errors.New("phone not verified")
+	}
+	if user.IsSMSMFAEnabled {
+		return nil, nil
+	}
+
+	var codes, hashes []string
+	if !mfaRequired(user) {
+		if codes, hashes, err = generateRecoveryCodes(); err != nil {
+			return nil, err
 		}
 	}
-	if pwCred == nil {
-		return errors.New("password credential not found")
+
+	user.IsSMSMFAEnabled = true
+	if err := s.saveWithEvent(ctx, model.EventUserMFAEnabled, user, func(repos *repository.Repositories) error {
+		if err := repos.Users.Update(ctx, user); err != nil {
+			return err
+		}
+		if hashes == nil {
+			return nil
+		}
+		return repos.RecoveryCodes.Replace(ctx, user.ID, hashes)
+	}); err != nil {
+		return nil, err
 	}
 
-	pwCred.Value = hashedPassword
-	if err := s.credentialRepo.Update(pwCred); err != nil {
-		return err
+	log.Printf("SMS MFA enabled for user %s", user.Email)
+	return codes, nil
+}
+
+// DisableSMSMFA stops sending MFA codes by SMS; a TOTP authenticator stays active
+func (s *AuthService) DisableSMSMFA(ctx context.Context, userID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
 	}
 
-	// 6. Send the temporary password to user's email
-	if err := emailSvc.SendTemporaryPassword(user.Email, tempPassword); err != nil {
-		log.Printf("failed to send temporary password to %s: %v", user.Email, err)
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if !user.IsSMSMFAEnabled {
+		return nil
+	}
+
+	user.IsSMSMFAEnabled = false
+	if err := s.userRepo.Update(ctx, user); err != nil {
 		return err
 	}
 
-	// 7. Clean up the OTP from verification storage
-	if s.verificationSvc != nil {
-		resetKey := "forgot_password:" + user.ID.String()
-		_ = s.verificationSvc.DeleteCode(resetKey) // Ignore error if key doesn't exist
+	log.Printf("SMS MFA disabled for user %s", user.Email)
+	return nil
+}
+
+// SendForgotPasswordSMS sends the password reset code to a verified phone number (account recovery)
+// If no account has this verified number, silently returns no error (prevents enumeration)
+func (s *AuthService) SendForgotPasswordSMS(ctx context.Context, phone string, smsSvc SMSService) error {
+	user, err := s.userRepo.GetByPhone(ctx, phone)
+	if err != nil || !inCurrentTenant(ctx, user) {
+		log.Printf("password reset request for unknown number: %s", phone)
+		return nil
 	}
 
-	log.Printf("password reset completed for user %s", user.Email)
+	if s.verificationSvc == nil {
+		return errors.New("verification service not configured")
+	}
+
+	otpCode := util.GenerateRandomDigits(6)
+	if err := s.verificationSvc.StoreCode("forgot_password:"+user.ID.String(), otpCode, 5*time.Minute); err != nil {
+		return err
+	}
+
+	if err := smsSvc.SendOTP(phone, otpCode); err != nil {
+		log.Printf("failed to send password reset OTP to %s: %v", phone, err)
+		return errors.New("failed to send sms")
+	}
 	return nil
 }
 
-// InitiateMFA generates a TOTP secret for the user and returns the secret and a QR code URL
-// Requires user email to be verified first
-func (s *AuthService) InitiateMFA(userID string) (string, string, error) {
-	uid, err := uuid.Parse(userID)
-	if err != nil {
-		return "", "", errors.New("invalid user ID format")
+// CreateGuest creates an anonymous device-bound account with the 'guest' role and logs it in
+// The device secret is returned once; only its hash is stored
+func (s *AuthService) CreateGuest(ctx context.Context, clientIP, userAgent string) (*dto.GuestResponse, error) {
+	if s.registrationSvc != nil {
+		if err := s.registrationSvc.CheckOpen(ctx); err != nil {
+			return nil, err
+		}
 	}
 
-	user, err := s.userRepo.GetByID(uid)
+	deviceSecret, err := util.GenerateSecureToken(32)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	// Check if email is verified before allowing MFA setup
-	if !user.IsEmailVerified {
-		return "", "", errors.New("email not verified")
+	id := uuid.New()
+	user := &model.User{
+		ID:          id,
+		Name:        "Guest",
+		Email:       "guest-" + id.String() + "@guest.invalid", // Placeholder until the account is claimed
+		IsAnonymous: true,
+		Tenant:      util.TenantFromContext(ctx),
 	}
 
-	secret, err := util.GenerateTOTPSecret(user.Email)
+	err = s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		guestRole, err := repos.Roles.GetByCode(ctx, "guest")
+		if err != nil {
+			return errors.New("system error: guest role not found")
+		}
+		user.Roles = append(user.Roles, *guestRole)
+
+		if err := repos.Users.Create(ctx, user); err != nil {
+			return err
+		}
+
+		return repos.Credentials.Create(ctx, &model.Credential{
+			UserID: user.ID,
+			Type:   model.CredTypeDevice,
+			Value:  util.HashToken(deviceSecret),
+		})
+	})
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	// Build a local QR code endpoint (client can call /auth/mfa/qrcode with email & secret)
-	qrURL := "/auth/mfa/qrcode?email=" + url.QueryEscape(user.Email) + "&secret=" + url.QueryEscape(secret)
+	pair, err := s.issueTokenPair(ctx, user, dto.NewAuthentication(dto.AMRDevice), clientIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
 
-	return secret, qrURL, nil
+	return &dto.GuestResponse{
+		UserID:       user.ID.String(),
+		DeviceSecret: deviceSecret,
+		AccessToken:  pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    pair.ExpiresIn,
+	}, nil
 }
 
-// ConfirmMFA verifies the provided TOTP token against the secret and enables MFA for the user
-func (s *AuthService) ConfirmMFA(userID string, secret string, token string) error {
+// LoginGuest logs back into a guest account with its device secret
+func (s *AuthService) LoginGuest(ctx context.Context, deviceSecret string, clientIP, userAgent string) (*dto.LoginResponse, error) {
+	cred, err := s.credentialRepo.GetByTypeAndValue(ctx, model.CredTypeDevice, util.HashToken(deviceSecret))
+	if err != nil || !cred.Active {
+		return nil, errors.New("invalid device secret")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, cred.UserID)
+	if err != nil || !user.IsAnonymous || !inCurrentTenant(ctx, user) {
+		return nil, errors.New("invalid device secret")
+	}
+
+	return s.issueTokenPair(ctx, user, dto.NewAuthentication(dto.AMRDevice), clientIP, userAgent)
+}
+
+// ClaimGuest upgrades a guest account to a regular one with email and password, keeping its user ID
+// The device credential is dropped, the guest role is replaced by 'user' and the verification email is sent
+func (s *AuthService) ClaimGuest(ctx context.Context, userID string, req *dto.ClaimAccountRequest) (*dto.RegisterResponse, error) {
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		return errors.New("invalid user ID format")
+		return nil, errors.New("invalid user ID format")
 	}
 
-	// Verify TOTP
-	if !util.VerifyTOTP(secret, token) {
-		return errors.New("invalid MFA token")
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if !user.IsAnonymous {
+		return nil, errors.New("account is not a guest account")
+	}
+	if err := s.passwordPolicy.Check(ctx, req.Password, req.Email, req.Name); err != nil {
+		return nil, err
 	}
 
-	// Persist secret and enable MFA
-	user, err := s.userRepo.GetByID(uid)
+	// Hash Password (outside the transaction: Argon2 is slow)
+	hashed, err := util.HashPassword(req.Password)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	user.MFASecret = secret
-	user.IsMFAEnabled = true
+	deviceCreds := make([]model.Credential, 0, 1)
+	for _, c := range user.Credentials {
+		if c.Type == model.CredTypeDevice {
+			deviceCreds = append(deviceCreds, c)
+		}
+	}
 
-	if err := s.userRepo.Update(user); err != nil {
-		log.Printf("failed to save MFA settings for user %s: %v", user.Email, err)
-		return err
+	user.Name = req.Name
+	user.Email = req.Email
+	user.IsAnonymous = false
+	user.IsEmailVerified = false
+	user.Credentials = nil
+
+	err = s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		// Claiming is a registration: the same policy applies
+		if s.registrationSvc != nil {
+			if err := s.registrationSvc.Authorize(ctx, repos, user.Email, req.InviteToken, user.ID); err != nil {
+				return err
+			}
+		}
+
+		userRole, err := repos.Roles.GetByCode(ctx, "user")
+		if err != nil {
+			return errors.New("system error: default role not found")
+		}
+
+		if err := repos.Users.Update(ctx, user); err != nil {
+			if util.IsDuplicateKeyError(err) {
+				return errors.New("email already in use")
+			}
+			return err
+		}
+		if err := repos.Users.ReplaceRoles(ctx, user, []model.Role{*userRole}); err != nil {
+			return err
+		}
+
+		for _, c := range deviceCreds {
+			if err := repos.Credentials.Delete(ctx, c.ID); err != nil {
+				return err
+			}
+		}
+		if err := repos.Credentials.Create(ctx, &model.Credential{
+			UserID: user.ID,
+			Type:   model.CredTypePassword,
+			Value:  hashed,
+		}); err != nil {
+			return errors.New("failed to create credentials")
+		}
+
+		// Same event as a fresh registration: sends the verification email and notifies webhooks
+		event, err := NewOutboxEvent(model.EventUserRegistered, dto.UserRegisteredEvent{
+			UserID: user.ID.String(),
+			Email:  user.Email,
+			Name:   user.Name,
+			Tenant: user.Tenant,
+		})
+		if err != nil {
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	s.InvalidateUserRoles(user.ID)
+	log.Printf("guest account %s claimed by %s", user.ID, user.Email)
+	return &dto.RegisterResponse{ID: user.ID.String(), Name: user.Name, Email: user.Email}, nil
 }
@@ -2,9 +2,16 @@ package service
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"html"
 	"os"
 	"strconv"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/util"
 
 	"gopkg.in/gomail.v2"
 )
@@ -42,8 +49,8 @@ func NewEmailService() *EmailService {
 	}
 }
 
-// SendOTP sends the 6-digit code to the user
-func (s *EmailService) SendOTP(toEmail string, code string) error {
+// SendOTP sends the 6-digit code to the user, and a one-click verification link when link is set
+func (s *EmailService) SendOTP(toEmail string, code string, link string) error {
 	m := gomail.NewMessage()
 
 	// Set Headers
@@ -59,9 +66,10 @@ func (s *EmailService) SendOTP(toEmail string, code string) error {
 			<p>Your verification code is:</p>
 			<h1 style="color: #2d89ef; letter-spacing: 5px;">%s</h1>
 			<p>This code will expire in 5 minutes.</p>
+			%s
 			<p>If you did not request this, please ignore this email.</p>
 		</div>
-	`, code)
+	`, code, actionLinkHTML(link, "Verify email"))
 	m.SetBody("text/html", body)
 
 	// Send
@@ -100,8 +108,8 @@ func (s *EmailService) SendPasswordOTP(toEmail string, code string) error {
 	return nil
 }
 
-// SendForgotPasswordOTP sends the 6-digit OTP code for password reset
-func (s *EmailService) SendForgotPasswordOTP(toEmail string, code string) error {
+// SendForgotPasswordOTP sends the 6-digit OTP code for password reset, and a one-click reset link when link is set
+func (s *EmailService) SendForgotPasswordOTP(toEmail string, code string, link string) error {
 	m := gomail.NewMessage()
 
 	m.SetHeader("From", fmt.Sprintf("%s <%s>", s.sender, s.dialer.Username))
@@ -114,8 +122,44 @@ func (s *EmailService) SendForgotPasswordOTP(toEmail string, code string) error
 			<p>You requested to reset your password. Use the code below:</p>
 			<h1 style="color: #2d89ef; letter-spacing: 5px;">%s</h1>
 			<p>This code will expire in 5 minutes.</p>
+			%s
 			<p>If you did not request this, please ignore this email and your password will remain unchanged.</p>
 		</div>
+	`, code, actionLinkHTML(link, "Reset password"))
+	m.SetBody("text/html", body)
+
+	if err := s.dialer.DialAndSend(m); err != nil {
+		return err
+	}
+	return nil
+}
+
+// actionLinkHTML is the button of a one-time action link next to a code ("" without a link)
+func actionLinkHTML(link string, label string) string {
+	if link == "" {
+		return ""
+	}
+	return fmt.Sprintf(`<p>Or click the button below. The link works once and expires in %s.</p>
+			<p><a href="%s" style="display: inline-block; padding: 10px 20px; background: #2d89ef; color: #fff; text-decoration: none; border-radius: 4px;">%s</a></p>`,
+		util.GetActionTokenTTL(), html.EscapeString(link), label)
+}
+
+// SendMFACode sends the 6-digit login code of the email second factor
+func (s *EmailService) SendMFACode(toEmail string, code string) error {
+	m := gomail.NewMessage()
+
+	m.SetHeader("From", fmt.Sprintf("%s <%s>", s.sender, s.dialer.Username))
+	m.SetHeader("To", toEmail)
+	m.SetHeader("Subject", "Your Sign-in Code")
+
+	body := fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; padding: 20px;">
+			<h2>Sign-in Verification</h2>
+			<p>Someone signed in to your account with your password. Enter this code to complete the sign-in:</p>
+			<h1 style="color: #2d89ef; letter-spacing: 5px;">%s</h1>
+			<p>This code will expire in 5 minutes.</p>
+			<p>If this wasn't you, change your password immediately.</p>
+		</div>
 	`, code)
 	m.SetBody("text/html", body)
 
@@ -125,8 +169,58 @@ func (s *EmailService) SendForgotPasswordOTP(toEmail string, code string) error
 	return nil
 }
 
-// SendTemporaryPassword sends the temporary password to the user
-func (s *EmailService) SendTemporaryPassword(toEmail string, tempPassword string) error {
+// SendMagicLink sends the passwordless login link
+func (s *EmailService) SendMagicLink(toEmail string, link string, ttl time.Duration) error {
+	m := gomail.NewMessage()
+
+	m.SetHeader("From", fmt.Sprintf("%s <%s>", s.sender, s.dialer.Username))
+	m.SetHeader("To", toEmail)
+	m.SetHeader("Subject", "Your Sign-in Link")
+
+	body := fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; padding: 20px;">
+			<h2>Sign in to your account</h2>
+			<p>Click the button below to sign in. The link works once.</p>
+			<p><a href="%s" style="display: inline-block; padding: 10px 20px; background: #2d89ef; color: #fff; text-decoration: none; border-radius: 4px;">Sign in</a></p>
+			<p>This link will expire in %s.</p>
+			<p>If you did not request this, please ignore this email.</p>
+		</div>
+	`, html.EscapeString(link), ttl)
+	m.SetBody("text/html", body)
+
+	if err := s.dialer.DialAndSend(m); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SendMFAResetOTP sends the 6-digit code that confirms a self-service MFA reset
+func (s *EmailService) SendMFAResetOTP(toEmail string, code string) error {
+	m := gomail.NewMessage()
+
+	m.SetHeader("From", fmt.Sprintf("%s <%s>", s.sender, s.dialer.Username))
+	m.SetHeader("To", toEmail)
+	m.SetHeader("Subject", "Two-Factor Authentication Reset Code")
+
+	body := fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; padding: 20px;">
+			<h2>Two-Factor Authentication Reset</h2>
+			<p>You asked to reset two-factor authentication because you lost your device. Use the code below:</p>
+			<h1 style="color: #2d89ef; letter-spacing: 5px;">%s</h1>
+			<p>This code will expire in 5 minutes.</p>
+			<p>If you did not request this, someone may know your email address. Your account stays protected unless the code is used.</p>
+		</div>
+	`, code)
+	m.SetBody("text/html", body)
+
+	if err := s.dialer.DialAndSend(m); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SendTemporaryPassword sends a password set by an administrator, to be replaced at the next sign-in
+func (s *EmailService) SendTemporaryPassword(toEmail string, password string) error {
 	m := gomail.NewMessage()
 
 	m.SetHeader("From", fmt.Sprintf("%s <%s>", s.sender, s.dialer.Username))
@@ -135,14 +229,13 @@ func (s *EmailService) SendTemporaryPassword(toEmail string, tempPassword string
 
 	body := fmt.Sprintf(`
 		<div style="font-family: Arial, sans-serif; padding: 20px;">
-			<h2>Password Reset Successful</h2>
-			<p>Your password has been successfully reset.</p>
-			<p>Your temporary password is:</p>
-			<h1 style="color: #2d89ef; letter-spacing: 5px; font-family: monospace;">%s</h1>
-			<p style="color: #d32f2f; font-weight: bold;">Please change this password after login for security.</p>
-			<p>If you did not request this, please contact support immediately.</p>
+			<h2>Temporary Password</h2>
+			<p>An administrator reset your password. Sign in with the temporary password below:</p>
+			<h1 style="color: #2d89ef; letter-spacing: 2px; font-family: monospace;">%s</h1>
+			<p>You will be asked to choose a new password right after signing in. You have been signed out of all devices.</p>
+			<p style="color: #d32f2f; font-weight: bold;">If you did not ask for this, contact support immediately.</p>
 		</div>
-	`, tempPassword)
+	`, html.EscapeString(password))
 	m.SetBody("text/html", body)
 
 	if err := s.dialer.DialAndSend(m); err != nil {
@@ -150,3 +243,94 @@ func (s *EmailService) SendTemporaryPassword(toEmail string, tempPassword string
 	}
 	return nil
 }
+
+// SendSecurityNotification informs the user about a security-relevant change on their account
+func (s *EmailService) SendSecurityNotification(toEmail string, subject string, message string) error {
+	m := gomail.NewMessage()
+
+	m.SetHeader("From", fmt.Sprintf("%s <%s>", s.sender, s.dialer.Username))
+	m.SetHeader("To", toEmail)
+	m.SetHeader("Subject", subject)
+
+	body := fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; padding: 20px;">
+			<h2>Security Notice</h2>
+			<p>%s</p>
+			<p style="color: #d32f2f; font-weight: bold;">If this wasn't you, change your password and contact support immediately.</p>
+		</div>
+	`, message)
+	m.SetBody("text/html", body)
+
+	if err := s.dialer.DialAndSend(m); err != nil {
+		return err
+	}
+	return nil
+}
+
+// HandleIdentityEvent is the outbox handler notifying the user when a social identity is linked or unlinked
+func (s *EmailService) HandleIdentityEvent(event *model.OutboxEvent) error {
+	var payload dto.IdentityEvent
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		return err
+	}
+
+	action := "linked to"
+	if event.Type == model.EventUserIdentityUnlinked {
+		action = "removed from"
+	}
+	message := fmt.Sprintf("A %s login was %s your account.", payload.Provider, action)
+	return s.SendSecurityNotification(payload.Email, "Security alert: sign-in method changed", message)
+}
+
+// HandleAccountLockedEvent is the outbox handler notifying the user when repeated failed logins locked the account
+func (s *EmailService) HandleAccountLockedEvent(event *model.OutboxEvent) error {
+	var payload dto.AccountLockedEvent
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Your account was locked after %d failed sign-in attempts. You can sign in again after %s (UTC), "+
+		"or reset your password to unlock it now.", payload.FailedAttempts, payload.LockedUntil.UTC().Format("2006-01-02 15:04"))
+	return s.SendSecurityNotification(payload.Email, "Security alert: account locked", message)
+}
+
+// HandleTokenReuseEvent is the outbox handler notifying the user when a replayed refresh token,
+// or one presented by another client, ended a session
+func (s *EmailService) HandleTokenReuseEvent(event *model.OutboxEvent) error {
+	var payload dto.TokenReuseEvent
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		return err
+	}
+
+	if event.Type == model.EventUserSessionBindingMismatch {
+		message := fmt.Sprintf("A sign-in session of your account was used from another device or network (IP %s), "+
+			"which can mean that it was stolen. The session was signed out. "+
+			"If you don't recognize this, change your password.", payload.ClientIP)
+		return s.SendSecurityNotification(payload.Email, "Security alert: session signed out", message)
+	}
+
+	message := fmt.Sprintf("A sign-in session of your account was used again after it had been renewed (IP %s), "+
+		"which can mean that it was stolen. The session was signed out on all devices that used it. "+
+		"If you don't recognize this, change your password.", payload.ClientIP)
+	return s.SendSecurityNotification(payload.Email, "Security alert: session signed out", message)
+}
+
+// HandleMFAResetEvent is the outbox handler notifying the user when an MFA reset is requested or done
+func (s *EmailService) HandleMFAResetEvent(event *model.OutboxEvent) error {
+	var payload dto.MFAResetEvent
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		return err
+	}
+
+	if event.Type == model.EventUserMFAResetRequested && payload.EffectiveAt != nil {
+		message := fmt.Sprintf("A reset of your two-factor authentication was requested. It takes effect on %s (UTC). "+
+			"To cancel it, sign in with your second factor before then.", payload.EffectiveAt.UTC().Format("2006-01-02 15:04"))
+		return s.SendSecurityNotification(payload.Email, "Security alert: two-factor reset requested", message)
+	}
+
+	message := "Two-factor authentication was removed from your account. Please set it up again."
+	if payload.Actor != "self" {
+		message = "An administrator removed two-factor authentication from your account. Please set it up again."
+	}
+	return s.SendSecurityNotification(payload.Email, "Security alert: two-factor authentication reset", message)
+}
@@ -0,0 +1,366 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/util"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Upstream discovery documents and keys are cached; unknown kids refetch the JWKS at most once a minute
+const (
+	federationDiscoveryTTL = time.Hour
+	federationJWKSCooldown = time.Minute
+)
+
+// providerNamePattern keeps provider names usable in URLs, env var names and credential types
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+
+// FederationProvider is an upstream OpenID Connect provider users can sign in with (broker mode)
+type FederationProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       string
+	TrustEmail   bool // Treat the email as verified when the provider sends no email_verified claim
+
+	mu              sync.Mutex
+	discovery       *upstreamDiscovery
+	discoveredAt    time.Time
+	keys            map[string]crypto.PublicKey
+	keysRefreshedAt time.Time
+}
+
+// upstreamDiscovery is the part of the upstream discovery document the broker needs
+type upstreamDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// FederationFlow is the per-login state kept in the browser between start and callback
+type FederationFlow struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// FederationService lets users sign in with upstream OIDC providers (Okta, Azure AD, Keycloak, ...)
+// Users are created on first sign-in (JIT) or linked to the verified account with the same email.
+// Environment variables:
+// - OIDC_PROVIDERS: comma-separated provider names (e.g. "okta,azure")
+// - OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET: required per provider
+// - OIDC_<NAME>_SCOPES: requested scopes (default: "openid email profile")
+// - OIDC_<NAME>_TRUST_EMAIL: "true" for providers that omit email_verified (e.g. Azure AD)
+type FederationService struct {
+	providers []*FederationProvider
+	byName    map[string]*FederationProvider
+	client    *http.Client
+}
+
+func NewFederationService() *FederationService {
+	s := &FederationService{
+		byName: make(map[string]*FederationProvider),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !providerNamePattern.MatchString(name) {
+			log.Printf("warning: invalid OIDC provider name '%s', skipping", name)
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		p := &FederationProvider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       os.Getenv(prefix + "SCOPES"),
+			TrustEmail:   os.Getenv(prefix+"TRUST_EMAIL") == "true",
+		}
+		if p.Issuer == "" || p.ClientID == "" || p.ClientSecret == "" {
+			log.Printf("warning: OIDC provider '%s' needs %sISSUER, %sCLIENT_ID and %sCLIENT_SECRET, skipping", name, prefix, prefix, prefix)
+			continue
+		}
+		if p.Scopes == "" {
+			p.Scopes = "openid email profile"
+		}
+
+		s.providers = append(s.providers, p)
+		s.byName[name] = p
+	}
+	return s
+}
+
+// Providers returns the configured providers in OIDC_PROVIDERS order
+func (s *FederationService) Providers() []dto.FederationProviderResponse {
+	res := make([]dto.FederationProviderResponse, 0, len(s.providers))
+	for _, p := range s.providers {
+		res = append(res, dto.FederationProviderResponse{Name: p.Name, Issuer: p.Issuer})
+	}
+	return res
+}
+
+// Start begins a login at the provider: it returns the authorization URL and the flow to keep for the callback
+// The request uses PKCE (S256) and a nonce, so a stolen code or ID token can't be replayed.
+func (s *FederationService) Start(ctx context.Context, name string, redirectURI string) (string, *FederationFlow, error) {
+	p, ok := s.byName[name]
+	if !ok {
+		return "", nil, errors.New("unknown identity provider")
+	}
+	discovery, err := s.discover(ctx, p)
+	if err != nil {
+		log.Printf("oidc provider %s discovery failed: %v", p.Name, err)
+		return "", nil, errors.New("identity provider unavailable")
+	}
+
+	flow := &FederationFlow{}
+	for _, v := range []*string{&flow.State, &flow.Nonce, &flow.CodeVerifier} {
+		if *v, err = util.GenerateSecureToken(32); err != nil {
+			return "", nil, err
+		}
+	}
+	challenge := sha256.Sum256([]byte(flow.CodeVerifier))
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {p.Scopes},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return discovery.AuthorizationEndpoint + sep + q.Encode(), flow, nil
+}
+
+// Finish redeems the authorization code and verifies the provider's ID token
+// The identity's credential type is oidc:<provider>, its subject the provider's sub claim.
+func (s *FederationService) Finish(ctx context.Context, name string, code string, redirectURI string, flow *FederationFlow) (*ExternalIdentity, error) {
+	p, ok := s.byName[name]
+	if !ok {
+		return nil, errors.New("unknown identity provider")
+	}
+	discovery, err := s.discover(ctx, p)
+	if err != nil {
+		log.Printf("oidc provider %s discovery failed: %v", p.Name, err)
+		return nil, errors.New("identity provider unavailable")
+	}
+
+	idToken, err := s.exchangeCode(ctx, p, discovery, code, redirectURI, flow.CodeVerifier)
+	if err != nil {
+		log.Printf("oidc provider %s code exchange failed: %v", p.Name, err)
+		return nil, errors.New("invalid identity token")
+	}
+
+	identity, err := s.verifyIDToken(ctx, p, discovery, idToken, flow.Nonce)
+	if err != nil {
+		log.Printf("oidc provider %s returned an invalid id_token: %v", p.Name, err)
+		return nil, errors.New("invalid identity token")
+	}
+	return identity, nil
+}
+
+// discover returns the provider's (cached) discovery document
+func (s *FederationService) discover(ctx context.Context, p *FederationProvider) (*upstreamDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < federationDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	var d upstreamDiscovery
+	if err := s.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	// The document must belong to the configured issuer (OpenID Connect Discovery 4.3)
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer || d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("invalid discovery document for issuer %s", p.Issuer)
+	}
+
+	p.discovery = &d
+	p.discoveredAt = time.Now()
+	return p.discovery, nil
+}
+
+// exchangeCode redeems the code at the provider's token endpoint (client_secret_post) and returns the ID token
+func (s *FederationService) exchangeCode(ctx context.Context, p *FederationProvider, d *upstreamDiscovery, code string, redirectURI string, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return token.IDToken, nil
+}
+
+// upstreamClaims are the ID token claims used for sign-in
+type upstreamClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+	Name          string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// verifyIDToken checks signature, issuer, audience, expiry and nonce of the upstream ID token
+func (s *FederationService) verifyIDToken(ctx context.Context, p *FederationProvider, d *upstreamDiscovery, idToken string, nonce string) (*ExternalIdentity, error) {
+	var claims upstreamClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.providerKey(ctx, p, d, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "PS256", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+
+	verified := p.TrustEmail
+	if claims.EmailVerified != nil {
+		verified = *claims.EmailVerified
+	}
+	return &ExternalIdentity{
+		Type:          model.OIDCCredentialType(p.Name),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: verified,
+		Name:          claims.Name,
+		Attributes:    claimAttributes(idToken),
+	}, nil
+}
+
+// claimAttributes returns the scalar claims of an already verified ID token; arrays such as groups are skipped
+func claimAttributes(idToken string) map[string]string {
+	attrs := make(map[string]string)
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, claims); err != nil {
+		return attrs
+	}
+	for k, v := range claims {
+		switch val := v.(type) {
+		case string:
+			attrs[k] = val
+		case bool, float64:
+			attrs[k] = fmt.Sprint(val)
+		}
+	}
+	return attrs
+}
+
+// providerKey returns the provider's signing key for kid, refetching the JWKS when it is unknown (key rotation)
+func (s *FederationService) providerKey(ctx context.Context, p *FederationProvider, d *upstreamDiscovery, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysRefreshedAt) < federationJWKSCooldown {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var jwks dto.JWKS
+	if err := s.getJSON(ctx, d.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := util.ParsePublicJWK(jwk); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	p.keys = keys
+	p.keysRefreshedAt = time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (s *FederationService) getJSON(ctx context.Context, endpoint string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GroupService manages groups of users and the roles they grant (admin)
+// Members get the group's roles in addition to their own, so access can be granted per team.
+type GroupService struct {
+	groupRepo repository.GroupRepository
+	roleRepo  repository.RoleRepository
+	roleCache repository.RoleCache
+}
+
+func NewGroupService(groups repository.GroupRepository, roles repository.RoleRepository, cache repository.RoleCache) *GroupService {
+	return &GroupService{groupRepo: groups, roleRepo: roles, roleCache: cache}
+}
+
+// ListGroups returns all groups
+func (s *GroupService) ListGroups(ctx context.Context) ([]dto.GroupResponse, error) {
+	groups, err := s.groupRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.GroupResponse, 0, len(groups))
+	for i := range groups {
+		res = append(res, *toGroupResponse(&groups[i]))
+	}
+	return res, nil
+}
+
+// GetGroup returns the group with the given code
+func (s *GroupService) GetGroup(ctx context.Context, code string) (*dto.GroupResponse, error) {
+	group, err := s.findGroup(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	return toGroupResponse(group), nil
+}
+
+// CreateGroup adds a group without members or roles
+func (s *GroupService) CreateGroup(ctx context.Context, req *dto.CreateGroupRequest) (*dto.GroupResponse, error) {
+	if !roleCodePattern.MatchString(req.Code) {
+		return nil, errors.New("invalid group code")
+	}
+
+	group := &model.Group{Code: req.Code, Name: req.Name, Description: req.Description}
+	if err := s.groupRepo.Create(ctx, group); err != nil {
+		if util.IsDuplicateKeyError(err) {
+			return nil, errors.New("group already exists")
+		}
+		return nil, err
+	}
+
+	log.Printf("[GROUPS] created group %s", group.Code)
+	return toGroupResponse(group), nil
+}
+
+// UpdateGroup changes the name and description of a group
+func (s *GroupService) UpdateGroup(ctx context.Context, code string, req *dto.UpdateGroupRequest) (*dto.GroupResponse, error) {
+	group, err := s.findGroup(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	group.Name, group.Description = req.Name, req.Description
+	if err := s.groupRepo.Update(ctx, group); err != nil {
+		if util.IsDuplicateKeyError(err) {
+			return nil, errors.New("group name already in use")
+		}
+		return nil, err
+	}
+	return toGroupResponse(group), nil
+}
+
+// DeleteGroup removes a group; its members lose the roles they had through it
+// Access tokens already issued keep those roles until they expire.
+func (s *GroupService) DeleteGroup(ctx context.Context, code string) error {
+	group, err := s.findGroup(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	userIDs, err := s.groupRepo.Delete(ctx, group)
+	if err != nil {
+		return err
+	}
+	s.invalidate(userIDs)
+
+	log.Printf("[GROUPS] deleted group %s (%d members)", group.Code, len(userIDs))
+	return nil
+}
+
+// SetGroupRoles replaces the roles a group grants its members
+func (s *GroupService) SetGroupRoles(ctx context.Context, code string, req *dto.SetGroupRolesRequest) (*dto.GroupResponse, error) {
+	group, err := s.findGroup(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]model.Role, 0, len(req.Roles))
+	for _, roleCode := range req.Roles {
+		role, err := s.roleRepo.GetByCode(ctx, roleCode)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("unknown role: " + roleCode)
+		}
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *role)
+	}
+
+	if err := s.groupRepo.SetRoles(ctx, group, roles); err != nil {
+		return nil, err
+	}
+	userIDs, err := s.groupRepo.MemberIDs(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate(userIDs)
+
+	group.Roles = roles
+	res := toGroupResponse(group)
+	log.Printf("[GROUPS] group %s now grants %v", group.Code, res.Roles)
+	return res, nil
+}
+
+// ListMembers returns one page of the group's members
+func (s *GroupService) ListMembers(ctx context.Context, code string, req *dto.ListGroupMembersRequest) (*dto.UserListResponse, error) {
+	group, err := s.findGroup(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	page, size := userPage(req.Page, req.Size)
+	users, total, err := s.groupRepo.ListMembers(ctx, group, (page-1)*size, size)
+	if err != nil {
+		return nil, err
+	}
+	return userListResponse(users, total, page, size), nil
+}
+
+// AddMembers adds users to a group; users already in it are skipped
+// Nothing is added when one of the users doesn't exist.
+func (s *GroupService) AddMembers(ctx context.Context, code string, req *dto.AddGroupMembersRequest) error {
+	group, err := s.findGroup(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[uuid.UUID]bool, len(req.UserIDs))
+	userIDs := make([]uuid.UUID, 0, len(req.UserIDs))
+	for _, raw := range req.UserIDs {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return errors.New("invalid user ID format")
+		}
+		if !seen[id] {
+			seen[id] = true
+			userIDs = append(userIDs, id)
+		}
+	}
+
+	missing, err := s.groupRepo.AddMembers(ctx, group, userIDs)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return errors.New("unknown user: " + missing[0].String())
+	}
+	s.invalidate(userIDs)
+
+	log.Printf("[GROUPS] added %d users to group %s", len(userIDs), group.Code)
+	return nil
+}
+
+// RemoveMember takes a user out of a group
+func (s *GroupService) RemoveMember(ctx context.Context, code string, userID string) error {
+	group, err := s.findGroup(ctx, code)
+	if err != nil {
+		return err
+	}
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+
+	removed, err := s.groupRepo.RemoveMember(ctx, group, id)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return errors.New("user is not a member of the group")
+	}
+	s.roleCache.Invalidate(id)
+
+	log.Printf("[GROUPS] removed user %s from group %s", id, group.Code)
+	return nil
+}
+
+// invalidate drops the cached role codes of users whose group roles changed
+func (s *GroupService) invalidate(userIDs []uuid.UUID) {
+	for _, id := range userIDs {
+		s.roleCache.Invalidate(id)
+	}
+}
+
+func (s *GroupService) findGroup(ctx context.Context, code string) (*model.Group, error) {
+	group, err := s.groupRepo.GetByCode(ctx, code)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("group not found")
+	}
+	return group, err
+}
+
+func toGroupResponse(group *model.Group) *dto.GroupResponse {
+	roles := make([]string, 0, len(group.Roles))
+	for _, r := range group.Roles {
+		roles = append(roles, r.Code)
+	}
+	sort.Strings(roles)
+
+	return &dto.GroupResponse{
+		ID:          group.ID.String(),
+		Code:        group.Code,
+		Name:        group.Name,
+		Description: group.Description,
+		Roles:       roles,
+		CreatedAt:   group.CreatedAt,
+		UpdatedAt:   group.UpdatedAt,
+	}
+}
@@ -0,0 +1,382 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+)
+
+// KeyRotationService rotates the JWT signing key and keeps every replica's key ring in sync
+// Rotated-out keys keep verifying tokens (and stay published) until their overlap window ends.
+// With multi-tenancy an organization can get signing keys of its own (generated or added for the
+// tenant); it is rotated the same way, and tenants without keys of their own use the global key.
+// Environment variables:
+// - SIGNING_KEY_SECRET: encrypts generated private keys at rest (rotation disabled when empty or with a KMS signer)
+// - KEY_ROTATION_OVERLAP: how long a rotated-out key stays valid (default: JWT_REFRESH_TTL)
+// - KEY_ROTATION_INTERVAL: rotate automatically once the active key is this old (default: 0 = manual only)
+// - KEY_RELOAD_INTERVAL: how often each replica reloads the key ring (default: 1m)
+type KeyRotationService struct {
+	repo     repository.SigningKeyRepository
+	secret   string
+	overlap  time.Duration
+	interval time.Duration
+	reload   time.Duration
+}
+
+func NewKeyRotationService(repo repository.SigningKeyRepository) *KeyRotationService {
+	s := &KeyRotationService{
+		repo:     repo,
+		secret:   os.Getenv("SIGNING_KEY_SECRET"),
+		overlap:  envDuration("KEY_ROTATION_OVERLAP", util.GetRefreshTTL()),
+		interval: envDuration("KEY_ROTATION_INTERVAL", 0),
+		reload:   envDuration("KEY_RELOAD_INTERVAL", time.Minute),
+	}
+
+	// KMS keys are rotated in the KMS; generated keys would put a private key back in the database
+	if util.RemoteSigning() && s.secret != "" {
+		log.Println("warning: SIGNING_KEY_SECRET is ignored with a KMS signer (JWT_SIGNER), key rotation disabled")
+		s.secret = ""
+	}
+
+	// Refresh tokens are signed too: a shorter overlap logs everyone out on rotation
+	if s.overlap < util.GetRefreshTTL() {
+		log.Printf("warning: KEY_ROTATION_OVERLAP (%v) is shorter than JWT_REFRESH_TTL (%v); older sessions will end on rotation", s.overlap, util.GetRefreshTTL())
+	}
+	return s
+}
+
+// envDuration parses a Go duration from env, returning fallback when unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("warning: invalid %s value '%s', using default %v", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+// Load rebuilds the key ring from the database
+// Until the first rotation the env key (RSA_*/EC_*) stays active; afterwards the newest stored key signs.
+// The newest key of a tenant signs that tenant's tokens.
+// With a KMS signer (JWT_SIGNER) the KMS key always signs and stored keys only verify.
+func (s *KeyRotationService) Load(ctx context.Context) error {
+	rows, err := s.repo.ListUsable(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	envKey := util.EnvSigningKey()
+	active := envKey
+	var keys []*util.SigningKey
+	for _, row := range rows {
+		if row.Algorithm != util.GetSigningAlg() {
+			log.Printf("warning: skipping signing key %s (%s, configured %s)", row.Kid, row.Algorithm, util.GetSigningAlg())
+			continue
+		}
+
+		pub, err := util.DecodePublicKey(row.PublicKey)
+		if err != nil {
+			return err
+		}
+		var priv crypto.Signer
+		if row.RetiresAt == nil && row.PrivateKey != "" && !util.RemoteSigning() {
+			if priv, err = util.DecryptPrivateKey(row.PrivateKey, s.secret); err != nil {
+				return err
+			}
+		}
+
+		key, err := util.NewSigningKey(priv, pub, row.CreatedAt, row.RetiresAt)
+		if err != nil {
+			return err
+		}
+		key.Tenant = row.Tenant
+		// Rows are newest first, so the first active key wins (util.SetKeyRing does the same per tenant)
+		if priv != nil && row.Tenant == "" && active == envKey {
+			active = key
+		}
+		keys = append(keys, key)
+	}
+
+	util.SetKeyRing(active, keys)
+	return nil
+}
+
+// Rotate generates a new signing key, starts signing with it and retires the current key
+// after the overlap window. tenant ("" for the global keys) gets a key of its own on its first rotation.
+// Used by POST /admin/keys/rotate.
+func (s *KeyRotationService) Rotate(ctx context.Context, tenant string) (*dto.KeyRotationResponse, error) {
+	if err := checkKeyTenant(tenant); err != nil {
+		return nil, err
+	}
+	return s.rotate(ctx, tenant, time.Now())
+}
+
+// AddKey imports an externally generated private key (PEM) and starts signing tenant's tokens with it.
+// The current key is retired after the overlap window, exactly like a rotation. Used by POST /admin/keys.
+func (s *KeyRotationService) AddKey(ctx context.Context, tenant string, privateKeyPEM string, passphrase string) (*dto.KeyRotationResponse, error) {
+	if s.secret == "" {
+		return nil, errors.New("key rotation not configured")
+	}
+	if err := checkKeyTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	priv, err := util.ParseSigningKey(privateKeyPEM, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := util.KeyThumbprint(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	// Re-adding a retired key would resurrect tokens that were meant to be dead
+	rows, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if kid == util.EnvSigningKey().Kid {
+		return nil, errors.New("signing key already exists")
+	}
+	for _, row := range rows {
+		if row.Kid == kid {
+			return nil, errors.New("signing key already exists")
+		}
+	}
+
+	return s.activate(ctx, tenant, priv, time.Now())
+}
+
+// ListKeys returns every known signing key of tenant ("" for the global keys) with its status
+// (active, verifying or retired), newest first. Tenants without keys of their own have none.
+func (s *KeyRotationService) ListKeys(ctx context.Context, tenant string) ([]dto.SigningKeyInfo, error) {
+	if err := checkKeyTenant(tenant); err != nil {
+		return nil, err
+	}
+	rows, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	activeKid := util.TenantSigningKey(tenant).Kid
+	envKey := util.EnvSigningKey()
+	envStored := false
+	keys := make([]dto.SigningKeyInfo, 0, len(rows)+1)
+	for _, row := range rows {
+		if row.Tenant != tenant {
+			continue
+		}
+		status := "verifying"
+		switch {
+		case row.Kid == activeKid:
+			status = "active"
+		case row.RetiresAt != nil && !row.RetiresAt.After(now):
+			status = "retired"
+		}
+		createdAt := row.CreatedAt
+		keys = append(keys, dto.SigningKeyInfo{
+			Kid:       row.Kid,
+			Tenant:    row.Tenant,
+			Algorithm: row.Algorithm,
+			Status:    status,
+			CreatedAt: &createdAt,
+			RetiresAt: row.RetiresAt,
+		})
+		if row.Kid == envKey.Kid {
+			envStored = true
+		}
+	}
+
+	// Until the first rotation the env key is the only key and isn't stored
+	if tenant == "" && !envStored && envKey.Kid == activeKid {
+		keys = append(keys, dto.SigningKeyInfo{Kid: envKey.Kid, Algorithm: util.GetSigningAlg(), Status: "active"})
+	}
+	return keys, nil
+}
+
+// RetireKey stops a rotated-out key from verifying tokens right away, without waiting for the
+// overlap window (e.g. a leaked key). Tokens signed with it are rejected once each replica reloads
+// the key ring. Active keys (global or of a tenant) can't be retired: rotate or add a key first.
+// Used by DELETE /admin/keys/:kid.
+func (s *KeyRotationService) RetireKey(ctx context.Context, kid string) error {
+	if err := s.Load(ctx); err != nil {
+		return err
+	}
+	if kid == util.ActiveSigningKey().Kid {
+		return errors.New("cannot retire the active signing key")
+	}
+	for _, org := range util.Tenants() {
+		if kid == util.TenantSigningKey(org).Kid {
+			return errors.New("cannot retire the active signing key")
+		}
+	}
+
+	retired, err := s.repo.Retire(ctx, kid, time.Now())
+	if err != nil {
+		return err
+	}
+	if !retired {
+		return errors.New("signing key not found")
+	}
+
+	if err := s.Load(ctx); err != nil {
+		return err
+	}
+	log.Printf("[KEYS] retired signing key %s", kid)
+	return nil
+}
+
+// rotate rotates tenant's key unless its active key was created after notBefore (returns nil, nil then)
+func (s *KeyRotationService) rotate(ctx context.Context, tenant string, notBefore time.Time) (*dto.KeyRotationResponse, error) {
+	if s.secret == "" {
+		return nil, errors.New("key rotation not configured")
+	}
+
+	priv, err := util.GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	return s.activate(ctx, tenant, priv, notBefore)
+}
+
+// activate stores priv as the new active key of tenant and retires its current one after the overlap window,
+// unless the active key was created after notBefore (returns nil, nil then). The first key of a tenant
+// retires nothing: the global key keeps signing the other tenants' tokens.
+func (s *KeyRotationService) activate(ctx context.Context, tenant string, priv crypto.Signer, notBefore time.Time) (*dto.KeyRotationResponse, error) {
+	// Start from the current ring, another replica may have rotated since the last reload
+	if err := s.Load(ctx); err != nil {
+		return nil, err
+	}
+	previous := util.TenantSigningKey(tenant)
+	if previous.Tenant != tenant {
+		previous = nil
+	}
+
+	key, err := util.NewSigningKey(priv, nil, time.Now(), nil)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := util.EncryptPrivateKey(priv, s.secret)
+	if err != nil {
+		return nil, err
+	}
+	pubPEM, err := util.EncodePublicKey(key.Public)
+	if err != nil {
+		return nil, err
+	}
+
+	retiresAt := time.Now().Add(s.overlap)
+
+	// The env key isn't stored yet: record its public half so it keeps verifying during the overlap
+	var imported *model.SigningKey
+	if envKey := util.EnvSigningKey(); previous != nil && previous.Kid == envKey.Kid {
+		envPEM, err := util.EncodePublicKey(envKey.Public)
+		if err != nil {
+			return nil, err
+		}
+		imported = &model.SigningKey{Kid: envKey.Kid, Algorithm: util.GetSigningAlg(), PublicKey: envPEM, RetiresAt: &retiresAt}
+	}
+
+	rotated, err := s.repo.Rotate(ctx, &model.SigningKey{
+		Kid:        key.Kid,
+		Tenant:     tenant,
+		Algorithm:  util.GetSigningAlg(),
+		PrivateKey: encrypted,
+		PublicKey:  pubPEM,
+	}, imported, retiresAt, notBefore)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		return nil, nil
+	}
+
+	if err := s.Load(ctx); err != nil {
+		return nil, err
+	}
+
+	res := &dto.KeyRotationResponse{Kid: key.Kid, Tenant: tenant}
+	if previous == nil {
+		log.Printf("[KEYS] tenant %s now signs with its own key %s", tenant, key.Kid)
+		return res, nil
+	}
+	log.Printf("[KEYS] rotated signing key %s -> %s (old key retires at %s)", previous.Kid, key.Kid, retiresAt.Format(time.RFC3339))
+	res.PreviousKid, res.PreviousRetiresAt = previous.Kid, &retiresAt
+	return res, nil
+}
+
+// checkKeyTenant accepts "" (the global keys) and the configured tenants
+func checkKeyTenant(tenant string) error {
+	if tenant != "" && !util.IsKnownTenant(tenant) {
+		return errors.New("unknown tenant")
+	}
+	return nil
+}
+
+// rotateExpired rotates the global key and the own keys of tenants once they are older than KEY_ROTATION_INTERVAL
+func (s *KeyRotationService) rotateExpired(ctx context.Context) {
+	if time.Since(util.ActiveSigningKey().CreatedAt) > s.interval {
+		if _, err := s.rotate(ctx, "", time.Now().Add(-s.interval)); err != nil {
+			log.Printf("[KEYS] scheduled key rotation failed: %v", err)
+		}
+	}
+	for _, org := range util.Tenants() {
+		key := util.TenantSigningKey(org)
+		if key.Tenant != org || time.Since(key.CreatedAt) <= s.interval {
+			continue
+		}
+		if _, err := s.rotate(ctx, org, time.Now().Add(-s.interval)); err != nil {
+			log.Printf("[KEYS] scheduled key rotation of tenant %s failed: %v", org, err)
+		}
+	}
+}
+
+// Start loads the key ring and keeps it in sync in the background
+// With KEY_ROTATION_INTERVAL set, it also rotates the key once the active key is older than the interval.
+func (s *KeyRotationService) Start(ctx context.Context) error {
+	if err := s.Load(ctx); err != nil {
+		return err
+	}
+
+	util.SetKeyRingReloader(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.Load(ctx); err != nil {
+			log.Printf("[KEYS] failed to reload key ring: %v", err)
+		}
+	})
+
+	go func() {
+		ticker := time.NewTicker(s.reload)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := s.Load(ctx); err != nil {
+				log.Printf("[KEYS] failed to reload key ring: %v", err)
+			}
+
+			if s.interval > 0 && s.secret != "" {
+				s.rotateExpired(ctx)
+			}
+			cancel()
+		}
+	}()
+
+	if s.interval > 0 && s.secret == "" {
+		log.Println("warning: KEY_ROTATION_INTERVAL is set but SIGNING_KEY_SECRET is not, scheduled rotation disabled")
+	}
+	return nil
+}
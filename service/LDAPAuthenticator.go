@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"mein-idaas/ldap"
+)
+
+// LDAPUser is a directory account whose password was checked with a bind
+type LDAPUser struct {
+	Subject string // Stable directory ID (LDAP_ID_ATTRIBUTE), stored as the credential value
+	Email   string
+	Name    string
+	Roles   []string          // Role codes mapped from the account's groups
+	Attrs   map[string]string // Single-valued text attributes of the entry (lowercased names)
+}
+
+// LDAPAuthenticator checks passwords against an LDAP / Active Directory server (simple bind as the user)
+// Environment variables:
+//   - LDAP_URL: ldap://host[:389] or ldaps://host[:636]; LDAP login is disabled while empty
+//   - LDAP_START_TLS: "true" to upgrade ldap:// connections with StartTLS
+//   - LDAP_CA_FILE: PEM bundle to verify the server certificate (default: system roots)
+//   - LDAP_BIND_DN_TEMPLATE: bind name, {email} and {username} (the part before @) are replaced,
+//     e.g. "uid={username},ou=people,dc=example,dc=com" or "{email}" for Active Directory UPN binds
+//   - LDAP_BASE_DN: search base for the user entry
+//   - LDAP_USER_ATTRIBUTE: attribute matched against the login email (default: mail; AD: userPrincipalName)
+//   - LDAP_ID_ATTRIBUTE: stable ID attribute (default: entryUUID; AD: objectGUID)
+//   - LDAP_GROUP_ATTRIBUTE: group membership attribute with group DNs (default: memberOf)
+//   - LDAP_GROUP_ROLES: group CN to role code, e.g. "IdaaS Admins:admin,Helpdesk:support"
+//   - LDAP_TIMEOUT: connect and operation timeout (default: 5s)
+type LDAPAuthenticator struct {
+	url            string
+	startTLS       bool
+	tlsConfig      *tls.Config
+	bindDNTemplate string
+	baseDN         string
+	userAttr       string
+	idAttr         string
+	groupAttr      string
+	groupRoles     map[string]string // lowercased group CN -> role code
+	timeout        time.Duration
+}
+
+// NewLDAPAuthenticator returns nil when LDAP_URL is not set
+func NewLDAPAuthenticator() *LDAPAuthenticator {
+	rawURL := os.Getenv("LDAP_URL")
+	if rawURL == "" {
+		return nil
+	}
+
+	a := &LDAPAuthenticator{
+		url:            rawURL,
+		startTLS:       os.Getenv("LDAP_START_TLS") == "true",
+		tlsConfig:      &tls.Config{MinVersion: tls.VersionTLS12},
+		bindDNTemplate: os.Getenv("LDAP_BIND_DN_TEMPLATE"),
+		baseDN:         os.Getenv("LDAP_BASE_DN"),
+		userAttr:       envOrDefault("LDAP_USER_ATTRIBUTE", "mail"),
+		idAttr:         envOrDefault("LDAP_ID_ATTRIBUTE", "entryUUID"),
+		groupAttr:      envOrDefault("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		groupRoles:     make(map[string]string),
+		timeout:        5 * time.Second,
+	}
+
+	if a.bindDNTemplate == "" || a.baseDN == "" {
+		log.Printf("warning: LDAP_URL is set but LDAP_BIND_DN_TEMPLATE or LDAP_BASE_DN is missing, LDAP login disabled")
+		return nil
+	}
+	if strings.HasPrefix(strings.ToLower(rawURL), "ldap://") && !a.startTLS {
+		log.Printf("warning: LDAP passwords are sent unencrypted, use ldaps:// or LDAP_START_TLS=true")
+	}
+
+	if caFile := os.Getenv("LDAP_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		pool := x509.NewCertPool()
+		if err != nil || !pool.AppendCertsFromPEM(pem) {
+			log.Printf("warning: cannot load LDAP_CA_FILE '%s', LDAP login disabled", caFile)
+			return nil
+		}
+		a.tlsConfig.RootCAs = pool
+	}
+
+	if v := os.Getenv("LDAP_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			a.timeout = d
+		} else {
+			log.Printf("warning: invalid LDAP_TIMEOUT '%s', using default %s", v, a.timeout)
+		}
+	}
+
+	for _, pair := range strings.Split(os.Getenv("LDAP_GROUP_ROLES"), ",") {
+		group, role, ok := strings.Cut(pair, ":")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" || role == "" {
+			if strings.TrimSpace(pair) != "" {
+				log.Printf("warning: invalid LDAP_GROUP_ROLES entry '%s', skipping", pair)
+			}
+			continue
+		}
+		a.groupRoles[strings.ToLower(group)] = role
+	}
+
+	return a
+}
+
+func envOrDefault(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// ManagedRoles returns the role codes that LDAP group mapping grants and revokes
+func (a *LDAPAuthenticator) ManagedRoles() map[string]bool {
+	roles := make(map[string]bool, len(a.groupRoles))
+	for _, role := range a.groupRoles {
+		roles[role] = true
+	}
+	return roles
+}
+
+// Authenticate binds as the user and reads their directory entry
+// Wrong passwords and unknown users both return "invalid credentials".
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, email string, password string) (*LDAPUser, error) {
+	conn, err := ldap.Dial(ctx, a.url, a.tlsConfig, a.startTLS, a.timeout)
+	if err != nil {
+		log.Printf("ldap connect failed: %v", err)
+		return nil, errors.New("directory unavailable")
+	}
+	defer conn.Close()
+
+	bindDN := a.bindDN(email)
+	if err := conn.Bind(ctx, bindDN, password); err != nil {
+		if !ldap.IsInvalidCredentials(err) {
+			log.Printf("ldap bind for %s failed: %v", email, err)
+		}
+		return nil, errors.New("invalid credentials")
+	}
+
+	// "*" returns all user attributes; the ID and group attributes may be operational ones (entryUUID, memberOf)
+	attrs := []string{"*", a.idAttr, a.groupAttr}
+	entries, err := conn.Search(ctx, a.baseDN, ldap.Equal(a.userAttr, email), attrs, 2)
+	if err != nil {
+		log.Printf("ldap search for %s failed: %v", email, err)
+		return nil, errors.New("directory unavailable")
+	}
+	if len(entries) != 1 {
+		log.Printf("ldap search for %s returned %d entries, expected 1", email, len(entries))
+		return nil, errors.New("invalid credentials")
+	}
+	entry := entries[0]
+
+	// With a DN template the entry must be the account that just bound
+	if a.isDNTemplate() && normalizeDN(entry.DN) != normalizeDN(bindDN) {
+		log.Printf("ldap entry %s does not match bind DN %s", entry.DN, bindDN)
+		return nil, errors.New("invalid credentials")
+	}
+
+	user := &LDAPUser{
+		Subject: directoryID(entry.RawValue(a.idAttr)),
+		Email:   strings.ToLower(firstNonEmpty(entry.Value("mail"), email)),
+		Name:    firstNonEmpty(entry.Value("displayName"), entry.Value("cn")),
+	}
+	if user.Subject == "" {
+		user.Subject = normalizeDN(entry.DN)
+	}
+
+	user.Attrs = make(map[string]string)
+	for name, values := range entry.Attributes {
+		if len(values) == 1 && utf8.Valid(values[0]) {
+			user.Attrs[name] = string(values[0])
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, groupDN := range entry.Values(a.groupAttr) {
+		if role, ok := a.groupRoles[groupCN(groupDN)]; ok && !seen[role] {
+			seen[role] = true
+			user.Roles = append(user.Roles, role)
+		}
+	}
+	return user, nil
+}
+
+// bindDN fills the template; values are DN-escaped unless the template is a bare UPN ("{email}")
+func (a *LDAPAuthenticator) bindDN(email string) string {
+	username, _, _ := strings.Cut(email, "@")
+	if a.isDNTemplate() {
+		email, username = ldap.EscapeDN(email), ldap.EscapeDN(username)
+	}
+	return strings.NewReplacer("{email}", email, "{username}", username).Replace(a.bindDNTemplate)
+}
+
+func (a *LDAPAuthenticator) isDNTemplate() bool {
+	return strings.Contains(a.bindDNTemplate, "=")
+}
+
+// groupCN returns the lowercased value of the first RDN of a group DN ("CN=Helpdesk,OU=Groups,..." -> "helpdesk")
+func groupCN(dn string) string {
+	rdn, _, _ := strings.Cut(dn, ",")
+	_, value, ok := strings.Cut(rdn, "=")
+	if !ok {
+		return strings.ToLower(strings.TrimSpace(dn))
+	}
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// normalizeDN lowercases a DN and drops the spaces around RDN separators
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return strings.ToLower(strings.Join(parts, ","))
+}
+
+// directoryID renders an ID attribute; binary IDs such as AD's objectGUID are hex-encoded
+func directoryID(raw []byte) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	if utf8.Valid(raw) && strings.IndexFunc(string(raw), func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+		return string(raw)
+	}
+	return hex.EncodeToString(raw)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -1 +1,726 @@
 package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+
+	"github.com/google/uuid"
+)
+
+// ExternalIdentity is a social identity whose token was verified with its provider
+type ExternalIdentity struct {
+	Type          model.CredentialType
+	Subject       string // Stable provider user ID, stored as the credential value
+	Email         string
+	EmailVerified bool // The provider confirmed the user owns Email
+	PrivateEmail  bool // Email is a relay address (Sign in with Apple "Hide My Email")
+	Name          string
+	Attributes    map[string]string // Further provider attributes, input of the provisioning attribute mapping
+}
+
+// IdentityVerifier checks a client-supplied provider token and returns the identity behind it
+type IdentityVerifier interface {
+	Verify(ctx context.Context, token string) (*ExternalIdentity, error)
+}
+
+// LinkCredentialService links social identities (Google, GitHub, Apple, Facebook) to existing accounts
+// Environment variables:
+// - GOOGLE_CLIENT_ID: expected audience of Google ID tokens (Google disabled when empty)
+// - GOOGLE_CLIENT_SECRET: needed to exchange Google authorization codes (code flow of /auth/social/google)
+// - GITHUB_CLIENT_ID / GITHUB_CLIENT_SECRET: OAuth app used to check GitHub tokens (GitHub disabled when empty)
+// - FACEBOOK_APP_ID / FACEBOOK_APP_SECRET: app used to check Facebook user access tokens (Facebook disabled when empty)
+// - APPLE_CLIENT_ID: Services ID or bundle ID, expected audience of Apple ID tokens (Apple disabled when empty)
+// - APPLE_TEAM_ID / APPLE_KEY_ID / APPLE_PRIVATE_KEY (PEM, or APPLE_PRIVATE_KEY_FILE): key to exchange Apple authorization codes
+type LinkCredentialService struct {
+	userRepo  repository.UserRepository
+	credRepo  repository.CredentialRepository
+	roleCache repository.RoleCache
+	uow       repository.UnitOfWork
+	verifiers map[model.CredentialType]IdentityVerifier
+}
+
+func NewLinkCredentialService(u repository.UserRepository, c repository.CredentialRepository, roleCache repository.RoleCache, uow repository.UnitOfWork) *LinkCredentialService {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	verifiers := make(map[model.CredentialType]IdentityVerifier)
+	if id := os.Getenv("GOOGLE_CLIENT_ID"); id != "" {
+		verifiers[model.CredTypeGoogle] = &googleVerifier{clientID: id, clientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"), client: client}
+	}
+	if id := os.Getenv("GITHUB_CLIENT_ID"); id != "" {
+		verifiers[model.CredTypeGithub] = &githubVerifier{clientID: id, clientSecret: os.Getenv("GITHUB_CLIENT_SECRET"), client: client}
+	}
+	if id := os.Getenv("FACEBOOK_APP_ID"); id != "" {
+		if secret := os.Getenv("FACEBOOK_APP_SECRET"); secret != "" {
+			verifiers[model.CredTypeFacebook] = &facebookVerifier{appID: id, appSecret: secret, client: client}
+		} else {
+			log.Printf("warning: FACEBOOK_APP_SECRET is required, Facebook login disabled")
+		}
+	}
+	if id := os.Getenv("APPLE_CLIENT_ID"); id != "" {
+		apple := &appleVerifier{clientID: id, teamID: os.Getenv("APPLE_TEAM_ID"), keyID: os.Getenv("APPLE_KEY_ID"), client: client}
+		keyPEM := os.Getenv("APPLE_PRIVATE_KEY")
+		if file := os.Getenv("APPLE_PRIVATE_KEY_FILE"); keyPEM == "" && file != "" {
+			if b, err := os.ReadFile(file); err == nil {
+				keyPEM = string(b)
+			} else {
+				log.Printf("warning: cannot read APPLE_PRIVATE_KEY_FILE: %v", err)
+			}
+		}
+		if keyPEM != "" {
+			if apple.teamID == "" || apple.keyID == "" {
+				log.Printf("warning: APPLE_TEAM_ID and APPLE_KEY_ID are required for the Apple code flow")
+			} else if key, err := parseApplePrivateKey(keyPEM); err != nil {
+				log.Printf("warning: invalid Apple private key, code flow disabled: %v", err)
+			} else {
+				apple.privateKey = key
+			}
+		}
+		verifiers[model.CredTypeApple] = apple
+	}
+
+	return &LinkCredentialService{
+		userRepo:  u,
+		credRepo:  c,
+		roleCache: roleCache,
+		uow:       uow,
+		verifiers: verifiers,
+	}
+}
+
+// VerifyIdentity validates a provider token with the matching verifier
+func (s *LinkCredentialService) VerifyIdentity(ctx context.Context, credType string, token string) (*ExternalIdentity, error) {
+	verifier, ok := s.verifiers[model.CredentialType(credType)]
+	if !ok {
+		return nil, errors.New("identity provider not configured")
+	}
+
+	identity, err := verifier.Verify(ctx, token)
+	if err != nil {
+		log.Printf("failed to verify %s identity token: %v", credType, err)
+		return nil, errors.New("invalid identity token")
+	}
+	return identity, nil
+}
+
+// VerifyGoogleCode exchanges a Google authorization code for an ID token and verifies it
+// redirectURI must match the one the client used; codeVerifier is the PKCE verifier, if any.
+func (s *LinkCredentialService) VerifyGoogleCode(ctx context.Context, code string, redirectURI string, codeVerifier string) (*ExternalIdentity, error) {
+	google, ok := s.verifiers[model.CredTypeGoogle].(*googleVerifier)
+	if !ok || google.clientSecret == "" {
+		return nil, errors.New("identity provider not configured")
+	}
+
+	idToken, err := google.exchangeCode(ctx, code, redirectURI, codeVerifier)
+	if err != nil {
+		log.Printf("failed to exchange google authorization code: %v", err)
+		return nil, errors.New("invalid identity token")
+	}
+	return s.VerifyIdentity(ctx, string(model.CredTypeGoogle), idToken)
+}
+
+// VerifyAppleCode exchanges a Sign in with Apple authorization code for an ID token and verifies it
+// redirectURI must match the web login's redirect URI; codes issued to native apps have none.
+func (s *LinkCredentialService) VerifyAppleCode(ctx context.Context, code string, redirectURI string) (*ExternalIdentity, error) {
+	apple, ok := s.verifiers[model.CredTypeApple].(*appleVerifier)
+	if !ok || apple.privateKey == nil {
+		return nil, errors.New("identity provider not configured")
+	}
+
+	idToken, err := apple.exchangeCode(ctx, code, redirectURI)
+	if err != nil {
+		log.Printf("failed to exchange apple authorization code: %v", err)
+		return nil, errors.New("invalid identity token")
+	}
+	return s.VerifyIdentity(ctx, string(model.CredTypeApple), idToken)
+}
+
+// VerifyFacebookToken checks a Facebook user access token and requires the email permission
+// Users can decline the email permission in the Facebook dialog; the client then has to ask again
+// (auth_type=rerequest) before the account can be linked or created.
+func (s *LinkCredentialService) VerifyFacebookToken(ctx context.Context, token string) (*ExternalIdentity, error) {
+	identity, err := s.VerifyIdentity(ctx, string(model.CredTypeFacebook), token)
+	if err != nil {
+		return nil, err
+	}
+	if identity.Email == "" {
+		return nil, errors.New("email permission not granted")
+	}
+	return identity, nil
+}
+
+// GithubAuthorizeURL returns the GitHub authorization URL of the login flow (GET /auth/social/github/start)
+func (s *LinkCredentialService) GithubAuthorizeURL(state string, redirectURI string) (string, error) {
+	github, ok := s.verifiers[model.CredTypeGithub].(*githubVerifier)
+	if !ok || github.clientSecret == "" {
+		return "", errors.New("identity provider not configured")
+	}
+
+	q := url.Values{
+		"client_id":    {github.clientID},
+		"redirect_uri": {redirectURI},
+		"scope":        {"read:user user:email"},
+		"state":        {state},
+	}
+	return "https://github.com/login/oauth/authorize?" + q.Encode(), nil
+}
+
+// VerifyGithubCode exchanges a GitHub authorization code and returns the user with their primary verified email
+func (s *LinkCredentialService) VerifyGithubCode(ctx context.Context, code string, redirectURI string) (*ExternalIdentity, error) {
+	github, ok := s.verifiers[model.CredTypeGithub].(*githubVerifier)
+	if !ok || github.clientSecret == "" {
+		return nil, errors.New("identity provider not configured")
+	}
+
+	token, err := github.exchangeCode(ctx, code, redirectURI)
+	if err != nil {
+		log.Printf("failed to exchange github authorization code: %v", err)
+		return nil, errors.New("invalid identity token")
+	}
+	identity, err := github.fetchIdentity(ctx, token)
+	if err != nil {
+		log.Printf("failed to fetch github user: %v", err)
+		return nil, errors.New("invalid identity token")
+	}
+	return identity, nil
+}
+
+// LinkIdentity connects a verified social identity to the user's account
+// Fails when the identity belongs to another account or the user already linked this provider.
+// Linking to a guest account claims it: the provider email becomes the account email and 'guest' is replaced by 'user'.
+func (s *LinkCredentialService) LinkIdentity(ctx context.Context, userID string, credType string, token string) (*ExternalIdentity, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	identity, err := s.VerifyIdentity(ctx, credType, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	// Conflict detection (the unique indexes on the credentials table back these up under concurrency)
+	if existing, err := s.credRepo.GetByTypeAndValue(ctx, identity.Type, identity.Subject); err == nil {
+		if existing.UserID == user.ID {
+			return nil, errors.New("identity already linked")
+		}
+		return nil, errors.New("identity linked to another account")
+	}
+	for _, c := range user.Credentials {
+		if c.Type == identity.Type {
+			return nil, errors.New("provider already linked")
+		}
+	}
+
+	claimGuest := user.IsAnonymous
+	if claimGuest {
+		if identity.Email == "" {
+			return nil, errors.New("provider did not share an email")
+		}
+		user.Email = identity.Email
+		user.IsAnonymous = false
+		user.Credentials = nil
+	}
+
+	event, err := NewOutboxEvent(model.EventUserIdentityLinked, dto.IdentityEvent{
+		UserID:   user.ID.String(),
+		Email:    user.Email,
+		Provider: string(identity.Type),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		if claimGuest {
+			if err := s.claimGuest(ctx, repos, user); err != nil {
+				return err
+			}
+		}
+
+		cred := &model.Credential{
+			UserID: user.ID,
+			Type:   identity.Type,
+			Value:  identity.Subject,
+		}
+		if err := repos.Credentials.Create(ctx, cred); err != nil {
+			if util.IsDuplicateKeyError(err) {
+				return errors.New("identity linked to another account")
+			}
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claimGuest && s.roleCache != nil {
+		s.roleCache.Invalidate(user.ID)
+	}
+	log.Printf("linked %s identity to user %s", identity.Type, user.Email)
+	return identity, nil
+}
+
+// claimGuest turns a guest account into a regular one inside the link transaction
+func (s *LinkCredentialService) claimGuest(ctx context.Context, repos *repository.Repositories, user *model.User) error {
+	userRole, err := repos.Roles.GetByCode(ctx, "user")
+	if err != nil {
+		return errors.New("system error: default role not found")
+	}
+
+	if err := repos.Users.Update(ctx, user); err != nil {
+		if util.IsDuplicateKeyError(err) {
+			return errors.New("email already in use")
+		}
+		return err
+	}
+	if err := repos.Users.ReplaceRoles(ctx, user, []model.Role{*userRole}); err != nil {
+		return err
+	}
+
+	// The device secret no longer grants access once the account is claimed
+	creds, err := repos.Credentials.ListByUserIDForUpdate(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, c := range creds {
+		if c.Type == model.CredTypeDevice {
+			if err := repos.Credentials.Delete(ctx, c.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UnlinkIdentity removes a linked social identity from the user's account
+// Refuses to remove the last remaining login method, so the account can't be locked out
+func (s *LinkCredentialService) UnlinkIdentity(ctx context.Context, userID string, credType string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+
+	ct := model.CredentialType(credType)
+	if !ct.IsValid() || ct == model.CredTypePassword || ct == model.CredTypeDevice {
+		return errors.New("invalid identity type")
+	}
+
+	user, err := s.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return errors.New("user not found")
+	}
+
+	event, err := NewOutboxEvent(model.EventUserIdentityUnlinked, dto.IdentityEvent{
+		UserID:   user.ID.String(),
+		Email:    user.Email,
+		Provider: credType,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.uow.WithTransaction(ctx, func(repos *repository.Repositories) error {
+		creds, err := repos.Credentials.ListByUserIDForUpdate(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		var target *model.Credential
+		remaining := 0
+		for i, c := range creds {
+			if c.Type == ct {
+				target = &creds[i]
+			} else if c.Active {
+				remaining++
+			}
+		}
+		if target == nil {
+			return errors.New("provider not linked")
+		}
+		if remaining == 0 {
+			return errors.New("cannot remove the only login method, set a password first")
+		}
+
+		if err := repos.Credentials.Delete(ctx, target.ID); err != nil {
+			return err
+		}
+		return repos.Outbox.Create(ctx, event)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("unlinked %s identity from user %s", credType, user.Email)
+	return nil
+}
+
+// googleVerifier validates Google ID tokens via the tokeninfo endpoint and checks the audience
+type googleVerifier struct {
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+func (v *googleVerifier) Verify(ctx context.Context, token string) (*ExternalIdentity, error) {
+	endpoint := "https://oauth2.googleapis.com/tokeninfo?id_token=" + url.QueryEscape(token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("google tokeninfo returned %d", resp.StatusCode)
+	}
+
+	var info struct {
+		Iss           string `json:"iss"`
+		Aud           string `json:"aud"`
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified string `json:"email_verified"` // tokeninfo returns "true"/"false"
+		Name          string `json:"name"`
+		GivenName     string `json:"given_name"`
+		FamilyName    string `json:"family_name"`
+		HostedDomain  string `json:"hd"` // Google Workspace domain
+		Locale        string `json:"locale"`
+		Picture       string `json:"picture"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+
+	// A token minted for another app must not be accepted (token substitution)
+	if info.Aud != v.clientID {
+		return nil, errors.New("google token audience mismatch")
+	}
+	if info.Iss != "accounts.google.com" && info.Iss != "https://accounts.google.com" {
+		return nil, errors.New("google token issuer mismatch")
+	}
+	if info.Sub == "" {
+		return nil, errors.New("google token has no subject")
+	}
+
+	return &ExternalIdentity{
+		Type:          model.CredTypeGoogle,
+		Subject:       info.Sub,
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified == "true",
+		Name:          info.Name,
+		Attributes: map[string]string{
+			"given_name":  info.GivenName,
+			"family_name": info.FamilyName,
+			"hd":          info.HostedDomain,
+			"locale":      info.Locale,
+			"picture":     info.Picture,
+		},
+	}, nil
+}
+
+// exchangeCode redeems an authorization code at Google's token endpoint and returns the ID token
+func (v *googleVerifier) exchangeCode(ctx context.Context, code string, redirectURI string, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {v.clientID},
+		"client_secret": {v.clientSecret},
+	}
+	if codeVerifier != "" {
+		form.Set("code_verifier", codeVerifier)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://oauth2.googleapis.com/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("google token endpoint returned %d", resp.StatusCode)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.IDToken == "" {
+		return "", errors.New("google token response has no id_token")
+	}
+	return token.IDToken, nil
+}
+
+// githubVerifier checks that an access token was issued to our OAuth app and returns its user
+type githubVerifier struct {
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+func (v *githubVerifier) Verify(ctx context.Context, token string) (*ExternalIdentity, error) {
+	body, err := json.Marshal(map[string]string{"access_token": token})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := "https://api.github.com/applications/" + url.PathEscape(v.clientID) + "/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(v.clientID, v.clientSecret)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("github token check returned %d", resp.StatusCode)
+	}
+
+	var info struct {
+		User struct {
+			ID    int64  `json:"id"`
+			Email string `json:"email"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	if info.User.ID == 0 {
+		return nil, errors.New("github token has no user")
+	}
+
+	return &ExternalIdentity{Type: model.CredTypeGithub, Subject: strconv.FormatInt(info.User.ID, 10), Email: info.User.Email}, nil
+}
+
+// exchangeCode redeems an authorization code of the GitHub login flow for a user access token
+func (v *githubVerifier) exchangeCode(ctx context.Context, code string, redirectURI string) (string, error) {
+	form := url.Values{
+		"client_id":     {v.clientID},
+		"client_secret": {v.clientSecret},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://github.com/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("github token endpoint returned %d", resp.StatusCode)
+	}
+
+	// GitHub reports errors (bad_verification_code, ...) with status 200
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("github token endpoint returned error %q", token.Error)
+	}
+	return token.AccessToken, nil
+}
+
+// fetchIdentity reads the GitHub user and their primary verified email with a user access token
+func (v *githubVerifier) fetchIdentity(ctx context.Context, token string) (*ExternalIdentity, error) {
+	var user struct {
+		ID       int64  `json:"id"`
+		Login    string `json:"login"`
+		Name     string `json:"name"`
+		Company  string `json:"company"`
+		Location string `json:"location"`
+	}
+	if err := v.getJSON(ctx, token, "https://api.github.com/user", &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, errors.New("github user has no id")
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := v.getJSON(ctx, token, "https://api.github.com/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &ExternalIdentity{
+		Type:       model.CredTypeGithub,
+		Subject:    strconv.FormatInt(user.ID, 10),
+		Name:       user.Name,
+		Attributes: map[string]string{"login": user.Login, "company": user.Company, "location": user.Location},
+	}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			identity.Email = e.Email
+			identity.EmailVerified = true
+		}
+	}
+	return identity, nil
+}
+
+// getJSON calls the GitHub API with a user access token
+func (v *githubVerifier) getJSON(ctx context.Context, token string, endpoint string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("github api %s returned %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// facebookGraphURL is the versioned Graph API base URL
+const facebookGraphURL = "https://graph.facebook.com/v19.0"
+
+// facebookVerifier checks that a user access token was issued to our app (debug_token) and reads the user
+type facebookVerifier struct {
+	appID     string
+	appSecret string
+	client    *http.Client
+}
+
+func (v *facebookVerifier) Verify(ctx context.Context, token string) (*ExternalIdentity, error) {
+	// debug_token with the app token: a token issued to another app must not be accepted (token substitution)
+	var debug struct {
+		Data struct {
+			AppID   string   `json:"app_id"`
+			Type    string   `json:"type"`
+			IsValid bool     `json:"is_valid"`
+			UserID  string   `json:"user_id"`
+			Scopes  []string `json:"scopes"`
+		} `json:"data"`
+	}
+	q := url.Values{
+		"input_token":  {token},
+		"access_token": {v.appID + "|" + v.appSecret},
+	}
+	if err := v.getJSON(ctx, facebookGraphURL+"/debug_token?"+q.Encode(), &debug); err != nil {
+		return nil, err
+	}
+	if !debug.Data.IsValid || debug.Data.Type != "USER" {
+		return nil, errors.New("facebook token is not a valid user token")
+	}
+	if debug.Data.AppID != v.appID {
+		return nil, errors.New("facebook token app mismatch")
+	}
+	if debug.Data.UserID == "" {
+		return nil, errors.New("facebook token has no user")
+	}
+
+	emailGranted := false
+	for _, scope := range debug.Data.Scopes {
+		if scope == "email" {
+			emailGranted = true
+		}
+	}
+
+	// appsecret_proof binds the call to our app secret (required when "Require App Secret" is on)
+	mac := hmac.New(sha256.New, []byte(v.appSecret))
+	mac.Write([]byte(token))
+	q = url.Values{
+		"fields":          {"id,name,email"},
+		"access_token":    {token},
+		"appsecret_proof": {hex.EncodeToString(mac.Sum(nil))},
+	}
+	var me struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := v.getJSON(ctx, facebookGraphURL+"/me?"+q.Encode(), &me); err != nil {
+		return nil, err
+	}
+	if me.ID != debug.Data.UserID {
+		return nil, errors.New("facebook user mismatch")
+	}
+
+	identity := &ExternalIdentity{Type: model.CredTypeFacebook, Subject: me.ID, Name: me.Name}
+	// The Graph API only returns a confirmed primary email, and only with the email permission
+	if emailGranted && me.Email != "" {
+		identity.Email = strings.ToLower(me.Email)
+		identity.EmailVerified = true
+	}
+	return identity, nil
+}
+
+// getJSON calls the Graph API; tokens travel in the query string, so errors never include the URL
+func (v *facebookVerifier) getJSON(ctx context.Context, endpoint string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return errors.New("facebook graph api unreachable")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("facebook graph api returned %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
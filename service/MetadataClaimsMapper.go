@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"mein-idaas/dto"
+	"mein-idaas/repository"
+
+	"github.com/google/uuid"
+)
+
+// MetadataClaimsMapper copies user metadata fields (see REGISTRATION_FIELDS) into access tokens
+// Environment variables:
+// - TOKEN_METADATA_CLAIMS: comma-separated metadata keys to copy, e.g. "plan,customer_id" (disabled when empty)
+type MetadataClaimsMapper struct {
+	userRepo repository.UserRepository
+	keys     []string
+}
+
+// NewMetadataClaimsMapper returns nil when TOKEN_METADATA_CLAIMS is empty
+func NewMetadataClaimsMapper(userRepo repository.UserRepository) *MetadataClaimsMapper {
+	var keys []string
+	for _, k := range strings.Split(os.Getenv("TOKEN_METADATA_CLAIMS"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return &MetadataClaimsMapper{userRepo: userRepo, keys: keys}
+}
+
+// MapClaims returns the configured metadata fields the user has; missing fields are left out
+func (m *MetadataClaimsMapper) MapClaims(ctx context.Context, claims *dto.AuthClaims) (map[string]interface{}, error) {
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	user, err := m.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	extra := make(map[string]interface{}, len(m.keys))
+	for _, k := range m.keys {
+		if v, ok := user.Metadata[k]; ok {
+			extra[k] = v
+		}
+	}
+	return extra, nil
+}
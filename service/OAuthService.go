@@ -0,0 +1,833 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// authorizationCodeTTL keeps codes short-lived; the client redeems them right after the redirect
+const authorizationCodeTTL = time.Minute
+
+// OAuthService implements the OAuth 2.0 authorization code flow with PKCE (RFC 6749, RFC 7636)
+// for third-party web and mobile apps. The user signs in on the hosted login pages; the code is
+// exchanged at /oauth/token for a token pair issued to the client: the access token's audience is the
+// client ID and the refresh token only refreshes at /oauth/token for that client.
+type OAuthService struct {
+	authSvc     *AuthService
+	clientRepo  repository.OAuthClientRepository
+	codeRepo    repository.AuthorizationCodeRepository
+	refreshRepo repository.RefreshTokenRepository
+	scopeRepo   repository.ScopeRepository
+	consentRepo repository.ConsentRepository
+
+	registrationOpen  bool
+	exchangeAudiences map[string]bool
+	// Clients that may exchange first-party access tokens (not issued to a client)
+	firstPartyExchangers map[string]bool
+}
+
+// ClientAuth holds the client credentials of a token endpoint request and how they were sent
+type ClientAuth struct {
+	ID     string
+	Secret string
+	Method string // client_secret_basic, client_secret_post or none
+}
+
+// Environment variables:
+// - OAUTH_REGISTRATION: who may use /oauth/register, "admin" (default) or "open"
+// - TOKEN_EXCHANGE_AUDIENCES: comma-separated audiences token exchange may mint tokens for (disabled when empty)
+// - TOKEN_EXCHANGE_FIRST_PARTY_CLIENTS: comma-separated client IDs that may exchange first-party access tokens
+func NewOAuthService(authSvc *AuthService, clients repository.OAuthClientRepository, codes repository.AuthorizationCodeRepository, refreshTokens repository.RefreshTokenRepository, scopes repository.ScopeRepository, consents repository.ConsentRepository) *OAuthService {
+	registration := strings.ToLower(os.Getenv("OAUTH_REGISTRATION"))
+	switch registration {
+	case "", "admin", "open":
+	default:
+		log.Printf("warning: invalid OAUTH_REGISTRATION value '%s', using default admin", registration)
+	}
+
+	audiences := make(map[string]bool)
+	for _, a := range strings.Split(os.Getenv("TOKEN_EXCHANGE_AUDIENCES"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			audiences[a] = true
+		}
+	}
+	firstPartyExchangers := make(map[string]bool)
+	for _, id := range strings.Split(os.Getenv("TOKEN_EXCHANGE_FIRST_PARTY_CLIENTS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			firstPartyExchangers[id] = true
+		}
+	}
+
+	return &OAuthService{
+		authSvc:              authSvc,
+		clientRepo:           clients,
+		codeRepo:             codes,
+		refreshRepo:          refreshTokens,
+		scopeRepo:            scopes,
+		consentRepo:          consents,
+		registrationOpen:     registration == "open",
+		exchangeAudiences:    audiences,
+		firstPartyExchangers: firstPartyExchangers,
+	}
+}
+
+// RegistrationOpen reports whether anyone may register clients (otherwise an admin token is required)
+func (s *OAuthService) RegistrationOpen() bool {
+	return s.registrationOpen
+}
+
+// ValidateClient checks client_id and redirect_uri of an authorization request
+// The redirect URI must match a registered one exactly. Until it does, errors must be shown
+// to the user instead of being redirected (open redirect).
+func (s *OAuthService) ValidateClient(ctx context.Context, clientID string, redirectURI string) (*model.OAuthClient, error) {
+	client, err := s.clientRepo.GetByID(ctx, clientID)
+	if err != nil || client.Tenant != util.TenantFromContext(ctx) {
+		return nil, errors.New("unknown client")
+	}
+
+	for _, uri := range client.RedirectURIs {
+		if uri == redirectURI {
+			return client, nil
+		}
+	}
+	return nil, errors.New("redirect_uri not registered")
+}
+
+// ValidateRequest checks the remaining authorization parameters and returns the requested scopes
+// Only response_type=code and the S256 challenge method are supported; public clients must use PKCE.
+// Scopes must exist in the scopes table.
+func (s *OAuthService) ValidateRequest(ctx context.Context, client *model.OAuthClient, req *dto.AuthorizeRequest) ([]model.Scope, error) {
+	if req.ResponseType != "code" {
+		return nil, errors.New("unsupported response_type")
+	}
+	if !client.AllowsGrant(model.GrantAuthorizationCode) {
+		return nil, errors.New("unauthorized client")
+	}
+
+	names := uniqueScopes(req.Scope)
+	for _, name := range names {
+		if !client.AllowsScope(name) {
+			return nil, errors.New("invalid scope")
+		}
+	}
+	scopes, err := s.scopeRepo.GetByNames(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	if len(scopes) != len(names) {
+		return nil, errors.New("invalid scope")
+	}
+
+	if req.CodeChallenge == "" {
+		if client.IsPublic() {
+			return nil, errors.New("code_challenge required")
+		}
+		return scopes, nil
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return nil, errors.New("unsupported code_challenge_method")
+	}
+	// base64url of a SHA-256 digest, no padding
+	if len(req.CodeChallenge) != 43 {
+		return nil, errors.New("invalid code_challenge")
+	}
+	return scopes, nil
+}
+
+// IssueCode creates a single-use authorization code for the approved request (only the hash is stored)
+func (s *OAuthService) IssueCode(ctx context.Context, client *model.OAuthClient, user *model.User, authTime time.Time, req *dto.AuthorizeRequest) (string, error) {
+	code, err := util.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	authCode := &model.AuthorizationCode{
+		CodeHash:            util.HashToken(code),
+		ClientID:            client.ID,
+		UserID:              user.ID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               strings.Join(uniqueScopes(req.Scope), " "),
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		AuthTime:            authTime,
+		ExpiresAt:           time.Now().Add(authorizationCodeTTL),
+	}
+	if err := s.codeRepo.Create(ctx, authCode); err != nil {
+		return "", err
+	}
+
+	log.Printf("issued authorization code for user %s to client %s", user.Email, client.ID)
+	return code, nil
+}
+
+// HasConsent reports whether the user already granted the client all requested scopes
+func (s *OAuthService) HasConsent(ctx context.Context, userID uuid.UUID, clientID string, scope string) bool {
+	consent, err := s.consentRepo.Get(ctx, userID, clientID)
+	return err == nil && consent.Covers(uniqueScopes(scope))
+}
+
+// GrantConsent stores the scopes the user approved for the client, adding to earlier grants
+func (s *OAuthService) GrantConsent(ctx context.Context, userID uuid.UUID, clientID string, scope string) error {
+	scopes := uniqueScopes(scope)
+	if consent, err := s.consentRepo.Get(ctx, userID, clientID); err == nil {
+		scopes = uniqueScopes(strings.Join(append(consent.Scopes, scopes...), " "))
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return s.consentRepo.Upsert(ctx, &model.UserConsent{UserID: userID, ClientID: clientID, Scopes: scopes})
+}
+
+// ListConsents returns the clients the user granted access to
+func (s *OAuthService) ListConsents(ctx context.Context, userID string) ([]dto.ConsentResponse, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	consents, err := s.consentRepo.ListByUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]dto.ConsentResponse, 0, len(consents))
+	for _, c := range consents {
+		item := dto.ConsentResponse{
+			ClientID:  c.ClientID,
+			Scopes:    c.Scopes,
+			GrantedAt: c.UpdatedAt,
+		}
+		if client, err := s.clientRepo.GetByID(ctx, c.ClientID); err == nil {
+			item.ClientName = client.Name
+		}
+		res = append(res, item)
+	}
+	return res, nil
+}
+
+// RevokeConsent removes the user's consent for a client; its next authorization request asks again
+func (s *OAuthService) RevokeConsent(ctx context.Context, userID string, clientID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+
+	found, err := s.consentRepo.Delete(ctx, uid, clientID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("consent not found")
+	}
+
+	log.Printf("user %s revoked consent for client %s", userID, clientID)
+	return nil
+}
+
+// uniqueScopes splits a space-separated scope string, dropping duplicates
+func uniqueScopes(scope string) []string {
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// ExchangeCode redeems an authorization code at the token endpoint (grant_type=authorization_code)
+func (s *OAuthService) ExchangeCode(ctx context.Context, req *dto.TokenRequest, auth ClientAuth, clientIP, userAgent string) (*dto.OAuthTokenResponse, error) {
+	client, err := s.authenticateClient(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(model.GrantAuthorizationCode) {
+		return nil, errors.New("unauthorized client")
+	}
+	if req.Code == "" {
+		return nil, errors.New("missing code")
+	}
+
+	code, err := s.codeRepo.Consume(ctx, util.HashToken(req.Code))
+	if err != nil {
+		return nil, errors.New("invalid authorization code")
+	}
+	if code.ClientID != client.ID || code.RedirectURI != req.RedirectURI {
+		return nil, errors.New("invalid authorization code")
+	}
+	if code.CodeChallenge != "" && !verifyCodeChallenge(req.CodeVerifier, code.CodeChallenge) {
+		return nil, errors.New("invalid code_verifier")
+	}
+
+	user, err := s.authSvc.GetUserByID(ctx, code.UserID.String())
+	if err != nil || user.Tenant != client.Tenant {
+		return nil, errors.New("invalid authorization code")
+	}
+
+	// The SSO session records when the user logged in, not with which factors
+	pair, err := s.authSvc.issueScopedTokenPair(ctx, user, code.Scope, client.ID, dto.Authentication{Time: code.AuthTime}, clientIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &dto.OAuthTokenResponse{
+		AccessToken: pair.AccessToken,
+		TokenType:   tokenType(ctx),
+		ExpiresIn:   pair.ExpiresIn,
+		Scope:       code.Scope,
+	}
+	if res.IDToken, err = s.idToken(code, user, pair.AccessToken); err != nil {
+		return nil, err
+	}
+	// Clients without the refresh_token grant couldn't use it
+	if client.AllowsGrant(model.GrantRefreshToken) {
+		res.RefreshToken = pair.RefreshToken
+	}
+	return res, nil
+}
+
+// tokenType is the token_type of issued access tokens: DPoP when bound to the request's proof key (RFC 9449)
+func tokenType(ctx context.Context) string {
+	if util.DPoPThumbprint(ctx) != "" {
+		return "DPoP"
+	}
+	return "Bearer"
+}
+
+// idToken mints the OIDC ID token for a redeemed code, or returns "" when openid wasn't granted
+// email and profile claims are only included for the matching scopes.
+func (s *OAuthService) idToken(code *model.AuthorizationCode, user *model.User, accessToken string) (string, error) {
+	claims := dto.IDTokenClaims{
+		AuthTime: jwt.NewNumericDate(code.AuthTime),
+		Nonce:    code.Nonce,
+		Tenant:   user.Tenant,
+	}
+
+	openid := false
+	for _, scope := range uniqueScopes(code.Scope) {
+		switch scope {
+		case "openid":
+			openid = true
+		case "email":
+			verified := user.IsEmailVerified
+			claims.Email = user.Email
+			claims.EmailVerified = &verified
+		case "profile":
+			claims.Name = user.Name
+		}
+	}
+	if !openid {
+		return "", nil
+	}
+
+	return util.GenerateIDToken(claims, user.ID, code.ClientID, accessToken)
+}
+
+// RefreshToken rotates a refresh token issued to the client (grant_type=refresh_token)
+// Tokens of other clients and of first-party logins are rejected like unknown ones.
+func (s *OAuthService) RefreshToken(ctx context.Context, req *dto.TokenRequest, auth ClientAuth, clientIP, userAgent string) (*dto.OAuthTokenResponse, error) {
+	client, err := s.authenticateClient(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(model.GrantRefreshToken) {
+		return nil, errors.New("unauthorized client")
+	}
+	if req.RefreshToken == "" {
+		return nil, errors.New("missing refresh_token")
+	}
+
+	res, err := s.authSvc.Refresh(ctx, &dto.RefreshRequest{RefreshToken: req.RefreshToken, Scope: req.Scope}, client.ID, clientIP, userAgent)
+	if err != nil {
+		log.Printf("oauth refresh for client %s failed: %v", client.ID, err)
+		if err.Error() == "invalid scope" || err.Error() == "invalid DPoP proof" {
+			return nil, err
+		}
+		return nil, errors.New("invalid refresh token")
+	}
+
+	return &dto.OAuthTokenResponse{
+		AccessToken:  res.AccessToken,
+		TokenType:    tokenType(ctx),
+		ExpiresIn:    res.ExpiresIn,
+		RefreshToken: res.RefreshToken,
+		Scope:        res.Scope,
+	}, nil
+}
+
+// ExchangeToken swaps a user's access token for one scoped to a downstream service (RFC 8693)
+// The audience must be listed in TOKEN_EXCHANGE_AUDIENCES, and the subject token must be meant for the client,
+// see mayExchange. Scopes can only be narrowed: a subject token with scopes limits the request to those,
+// otherwise the scopes must exist in the scopes table.
+func (s *OAuthService) ExchangeToken(ctx context.Context, req *dto.TokenRequest, auth ClientAuth) (*dto.OAuthTokenResponse, error) {
+	client, err := s.authenticateClient(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(model.GrantTokenExchange) {
+		return nil, errors.New("unauthorized client")
+	}
+	if req.SubjectToken == "" {
+		return nil, errors.New("missing subject_token")
+	}
+	if req.SubjectTokenType != model.TokenTypeAccessToken {
+		return nil, errors.New("unsupported subject_token_type")
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != model.TokenTypeAccessToken {
+		return nil, errors.New("unsupported requested_token_type")
+	}
+	if !s.exchangeAudiences[req.Audience] {
+		return nil, errors.New("invalid target")
+	}
+
+	// Refresh tokens can't be exchanged
+	subject, err := util.ResolveAccessToken(ctx, req.SubjectToken)
+	if err != nil || subject.IsRefreshToken() || subject.Tenant != util.TenantFromContext(ctx) {
+		return nil, errors.New("invalid subject token")
+	}
+	if !s.mayExchange(client, subject) {
+		log.Printf("client %s tried to exchange a token of user %s meant for %v", client.ID, subject.Subject, subject.Audience)
+		return nil, errors.New("invalid subject token")
+	}
+	// A DPoP-bound subject token is only exchanged with a proof of its key, and the new token is bound to it too
+	if err := util.CheckProofOfPossession(ctx, subject); err != nil {
+		return nil, err
+	}
+
+	scopes := uniqueScopes(req.Scope)
+	if subject.Scope != "" {
+		granted := &model.OAuthClient{Scope: subject.Scope}
+		for _, scope := range scopes {
+			if !granted.AllowsScope(scope) {
+				return nil, errors.New("invalid scope")
+			}
+		}
+		if len(scopes) == 0 {
+			scopes = uniqueScopes(subject.Scope)
+		}
+	} else {
+		known, err := s.scopeRepo.GetByNames(ctx, scopes)
+		if err != nil {
+			return nil, err
+		}
+		if len(known) != len(scopes) {
+			return nil, errors.New("invalid scope")
+		}
+	}
+	for _, scope := range scopes {
+		if !client.AllowsScope(scope) {
+			return nil, errors.New("invalid scope")
+		}
+	}
+
+	scope := strings.Join(scopes, " ")
+	token, ttl, err := util.GenerateExchangedToken(ctx, subject, req.Audience, scope, client.ID)
+	if err != nil {
+		return nil, err
+	}
+	if token, err = s.authSvc.opaqueTokens.Issue(ctx, token, nil); err != nil {
+		return nil, err
+	}
+
+	log.Printf("client %s exchanged a token of user %s for audience %s", client.ID, subject.Subject, req.Audience)
+	return &dto.OAuthTokenResponse{
+		AccessToken:     token,
+		TokenType:       tokenType(ctx),
+		ExpiresIn:       int(ttl.Seconds()),
+		Scope:           scope,
+		IssuedTokenType: model.TokenTypeAccessToken,
+	}, nil
+}
+
+// mayExchange reports whether the client may exchange the subject token: the token was issued to the client
+// or exchanged for it (aud = client ID), or it is a first-party access token and the client is listed in
+// TOKEN_EXCHANGE_FIRST_PARTY_CLIENTS. Any other client holding the token can't turn it into new ones.
+func (s *OAuthService) mayExchange(client *model.OAuthClient, subject *dto.AuthClaims) bool {
+	for _, aud := range subject.Audience {
+		if aud == client.ID {
+			return true
+		}
+	}
+	return !subject.IsThirdParty() && s.firstPartyExchangers[client.ID]
+}
+
+// Introspect reports whether a token is active and returns its claims (RFC 7662)
+// Only authenticated clients may introspect. Refresh tokens are active until they expire, are rotated
+// or revoked (logout, reuse detection); access tokens until they expire. Tokens of another
+// organization are reported as inactive.
+func (s *OAuthService) Introspect(ctx context.Context, req *dto.IntrospectionRequest, auth ClientAuth) (*dto.IntrospectionResponse, error) {
+	if _, err := s.authenticateClient(ctx, auth); err != nil {
+		return nil, err
+	}
+	if req.Token == "" {
+		return nil, errors.New("missing token")
+	}
+
+	inactive := &dto.IntrospectionResponse{Active: false}
+
+	claims, err := util.ResolveAccessToken(ctx, req.Token)
+	if err != nil || claims.Tenant != util.TenantFromContext(ctx) {
+		return inactive, nil
+	}
+
+	res := &dto.IntrospectionResponse{
+		Active:      true,
+		TokenType:   "Bearer",
+		Sub:         claims.Subject,
+		Iss:         claims.Issuer,
+		Aud:         claims.Audience,
+		Jti:         claims.ID,
+		ClientID:    claims.ClientID,
+		Roles:       claims.Roles,
+		Permissions: claims.Permissions,
+		Tenant:      claims.Tenant,
+		Scope:       claims.Scope,
+		Act:         claims.Act,
+		Cnf:         claims.Cnf,
+	}
+	if claims.Cnf != nil {
+		res.TokenType = "DPoP"
+	}
+	if claims.ExpiresAt != nil {
+		res.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		res.Iat = claims.IssuedAt.Unix()
+	}
+
+	// Refresh tokens are checked against their database row
+	if !claims.IsRefreshToken() {
+		return res, nil
+	}
+
+	userID, refreshID, _, err := util.ParseRefreshToken(req.Token)
+	if err != nil {
+		return inactive, nil
+	}
+	rt, err := s.refreshRepo.GetByID(ctx, refreshID)
+	if err != nil || rt.UserID != userID || rt.RevokedAt != nil || rt.ReplacedAt != nil || time.Now().After(rt.ExpiresAt) {
+		return inactive, nil
+	}
+
+	res.TokenType = "refresh_token"
+	res.ClientID = rt.ClientID
+	return res, nil
+}
+
+// Revoke invalidates a refresh token or access token (RFC 7009)
+// Revoking a refresh token also revokes the opaque access tokens issued with it; JWT access tokens
+// go on the denylist until they expire. Unknown tokens are ignored, and so are tokens issued to another
+// client or to the first-party app (RFC 7009 §2.1): the request succeeds without revoking them.
+func (s *OAuthService) Revoke(ctx context.Context, req *dto.RevocationRequest, auth ClientAuth) error {
+	client, err := s.authenticateClient(ctx, auth)
+	if err != nil {
+		return err
+	}
+	if req.Token == "" {
+		return errors.New("missing token")
+	}
+
+	if claims, err := util.ResolveAccessToken(ctx, req.Token); err == nil && !claims.IsRefreshToken() {
+		if claims.Tenant != util.TenantFromContext(ctx) || tokenHolder(claims) != client.ID {
+			return nil
+		}
+		if revoked, err := s.authSvc.opaqueTokens.Revoke(ctx, req.Token); err != nil || revoked {
+			return err
+		}
+		return s.authSvc.DenyAccessToken(ctx, req.Token)
+	}
+
+	userID, refreshID, tenant, err := util.ParseRefreshToken(req.Token)
+	if err != nil || tenant != util.TenantFromContext(ctx) {
+		return nil
+	}
+	rt, err := s.refreshRepo.GetByID(ctx, refreshID)
+	if err != nil || rt.UserID != userID || rt.ClientID != client.ID {
+		return nil
+	}
+	if err := s.refreshRepo.RevokeByID(ctx, rt.ID); err != nil {
+		return err
+	}
+	return s.authSvc.opaqueTokens.RevokeForRefreshToken(ctx, rt.ID)
+}
+
+// tokenHolder is the client an access token was issued to: the actor of an exchanged token, the client of an
+// OAuth token, empty for first-party tokens
+func tokenHolder(claims *dto.AuthClaims) string {
+	if claims.Act != nil {
+		return claims.Act.Sub
+	}
+	return claims.ClientID
+}
+
+// UserInfo returns the claims of the user an access token was issued to (OIDC UserInfo)
+// authHeader is the request's Authorization header: DPoP-bound tokens need the DPoP scheme and a proof of their key.
+// The claims are read from the database, so they reflect the current email, name and roles.
+func (s *OAuthService) UserInfo(ctx context.Context, authHeader string) (*dto.UserInfoResponse, error) {
+	claims, err := util.ResolveSenderConstrainedToken(ctx, authHeader)
+	if err != nil || claims.Tenant != util.TenantFromContext(ctx) {
+		return nil, errors.New("invalid token")
+	}
+
+	user, err := s.authSvc.GetUserByID(ctx, claims.Subject)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+
+	roles, err := userRoleCodes(ctx, s.authSvc.userRepo, user)
+	if err != nil {
+		return nil, err
+	}
+	if roles, err = util.ExpandRoles(ctx, roles); err != nil {
+		return nil, err
+	}
+
+	info := &dto.UserInfoResponse{
+		Sub:           user.ID.String(),
+		Email:         user.Email,
+		EmailVerified: user.IsEmailVerified,
+		Name:          user.Name,
+		Roles:         roles,
+	}
+	if user.Username != nil {
+		info.PreferredUsername = *user.Username
+	}
+	if user.Phone != nil && user.IsPhoneVerified {
+		info.PhoneNumber = *user.Phone
+		info.PhoneNumberVerified = true
+	}
+	return info, nil
+}
+
+// authenticateClient checks the client credentials of a token request
+// Confidential clients must send their secret; public clients authenticate with PKCE instead.
+// Clients registered with a token_endpoint_auth_method must use that method.
+func (s *OAuthService) authenticateClient(ctx context.Context, auth ClientAuth) (*model.OAuthClient, error) {
+	client, err := s.clientRepo.GetByID(ctx, auth.ID)
+	if err != nil || client.Tenant != util.TenantFromContext(ctx) {
+		return nil, errors.New("invalid client")
+	}
+	if client.AuthMethod != "" && client.AuthMethod != auth.Method {
+		return nil, errors.New("invalid client")
+	}
+
+	if client.IsPublic() {
+		if auth.Secret != "" {
+			return nil, errors.New("invalid client")
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(util.HashToken(auth.Secret)), []byte(client.SecretHash)) != 1 {
+		return nil, errors.New("invalid client")
+	}
+	return client, nil
+}
+
+// verifyCodeChallenge checks a PKCE verifier against its S256 challenge
+func verifyCodeChallenge(verifier, challenge string) bool {
+	// RFC 7636: 43-128 characters
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// CreateClient registers a third-party application and returns its credentials
+// The client secret is only shown once (the hash is stored).
+func (s *OAuthService) CreateClient(ctx context.Context, req *dto.CreateOAuthClientRequest) (*dto.OAuthClientResponse, error) {
+	if req.Tenant != "" && !util.IsKnownTenant(req.Tenant) {
+		return nil, errors.New("unknown tenant")
+	}
+	for _, uri := range req.RedirectURIs {
+		if !validRedirectURI(uri) {
+			return nil, errors.New("invalid redirect_uri")
+		}
+	}
+
+	client := &model.OAuthClient{
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Tenant:       req.Tenant,
+	}
+	secret, err := s.createClient(ctx, client, req.Public)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.OAuthClientResponse{
+		ClientID:     client.ID,
+		ClientSecret: secret,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		Public:       client.IsPublic(),
+		Tenant:       client.Tenant,
+		CreatedAt:    client.CreatedAt,
+	}, nil
+}
+
+// openRegistrationScopes are the scopes anonymous registrations (OAUTH_REGISTRATION=open) may request: the standard
+// OIDC scopes, which users approve on the consent page
+var openRegistrationScopes = []string{"openid", "email", model.ScopeProfile, model.ScopeOfflineAccess}
+
+// RegisterClient creates a client from RFC 7591 metadata (dynamic client registration)
+// The client belongs to the organization of the request (tenant routes). privileged is true for callers with the
+// clients:manage permission; anonymous registrations (OAUTH_REGISTRATION=open) are limited to the authorization
+// code and refresh token grants and openRegistrationScopes, which is also their scope when none is requested.
+func (s *OAuthService) RegisterClient(ctx context.Context, req *dto.ClientRegistrationRequest, privileged bool) (*dto.ClientRegistrationResponse, error) {
+	grantTypes := req.GrantTypes
+	if len(grantTypes) == 0 {
+		grantTypes = []string{model.GrantAuthorizationCode}
+	}
+	for _, g := range grantTypes {
+		if g != model.GrantAuthorizationCode && g != model.GrantRefreshToken && g != model.GrantTokenExchange {
+			return nil, errors.New("unsupported grant type")
+		}
+		if g == model.GrantTokenExchange && !privileged {
+			return nil, errors.New("grant type requires the clients:manage permission")
+		}
+	}
+
+	responseTypes := req.ResponseTypes
+	if len(responseTypes) == 0 {
+		responseTypes = []string{"code"}
+	}
+	for _, r := range responseTypes {
+		if r != "code" {
+			return nil, errors.New("unsupported response type")
+		}
+	}
+
+	authMethod := req.TokenEndpointAuthMethod
+	switch authMethod {
+	case "":
+		authMethod = model.AuthMethodSecretBasic
+	case model.AuthMethodNone, model.AuthMethodSecretBasic, model.AuthMethodSecretPost:
+	default:
+		return nil, errors.New("unsupported token_endpoint_auth_method")
+	}
+
+	client := &model.OAuthClient{
+		Name:         req.ClientName,
+		RedirectURIs: req.RedirectURIs,
+		GrantTypes:   grantTypes,
+		Scope:        strings.Join(uniqueScopes(req.Scope), " "),
+		AuthMethod:   authMethod,
+		Tenant:       util.TenantFromContext(ctx),
+	}
+	if len(client.RedirectURIs) == 0 && client.AllowsGrant(model.GrantAuthorizationCode) {
+		return nil, errors.New("redirect_uris required")
+	}
+	for _, uri := range client.RedirectURIs {
+		if !validRedirectURI(uri) {
+			return nil, errors.New("invalid redirect_uri")
+		}
+	}
+
+	if !privileged {
+		if client.Scope == "" {
+			// An empty scope would allow every scope
+			client.Scope = strings.Join(openRegistrationScopes, " ")
+		}
+		open := &model.OAuthClient{Scope: strings.Join(openRegistrationScopes, " ")}
+		for _, scope := range uniqueScopes(client.Scope) {
+			if !open.AllowsScope(scope) {
+				return nil, errors.New("scope requires the clients:manage permission")
+			}
+		}
+	}
+
+	scopes := uniqueScopes(client.Scope)
+	known, err := s.scopeRepo.GetByNames(ctx, scopes)
+	if err != nil {
+		return nil, err
+	}
+	if len(known) != len(scopes) {
+		return nil, errors.New("invalid scope")
+	}
+
+	secret, err := s.createClient(ctx, client, authMethod == model.AuthMethodNone)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.ClientRegistrationResponse{
+		ClientID:                client.ID,
+		ClientSecret:            secret,
+		ClientIDIssuedAt:        client.CreatedAt.Unix(),
+		ClientName:              client.Name,
+		RedirectURIs:            client.RedirectURIs,
+		GrantTypes:              client.GrantTypes,
+		ResponseTypes:           responseTypes,
+		TokenEndpointAuthMethod: client.AuthMethod,
+		Scope:                   client.Scope,
+	}, nil
+}
+
+// createClient assigns the client ID and, for confidential clients, a secret (only the hash is stored)
+func (s *OAuthService) createClient(ctx context.Context, client *model.OAuthClient, public bool) (string, error) {
+	clientID, err := util.GenerateSecureToken(16)
+	if err != nil {
+		return "", err
+	}
+	client.ID = clientID
+	if client.Name == "" {
+		client.Name = clientID
+	}
+
+	var secret string
+	if !public {
+		if secret, err = util.GenerateSecureToken(32); err != nil {
+			return "", err
+		}
+		client.SecretHash = util.HashToken(secret)
+	}
+
+	if err := s.clientRepo.Create(ctx, client); err != nil {
+		return "", err
+	}
+
+	log.Printf("registered oauth client %s (%s)", client.ID, client.Name)
+	return secret, nil
+}
+
+// validRedirectURI accepts absolute URIs without fragment (RFC 6749 3.1.2)
+// Plain http is only allowed for loopback redirects; custom schemes (com.example.app:/cb) are for mobile apps.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" || u.Fragment != "" || strings.Contains(uri, "#") {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	case "javascript", "data", "file":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+
+	"github.com/google/uuid"
+)
+
+// OpaqueTokenService hands out opaque access tokens instead of JWTs (ACCESS_TOKEN_FORMAT=opaque)
+// The claims of the signed JWT are stored with the hash of a random token, so resource servers must
+// validate tokens via /oauth/introspect, and revoking a token takes effect immediately.
+// Environment variables:
+// - ACCESS_TOKEN_FORMAT: "jwt" (default) or "opaque"
+type OpaqueTokenService struct {
+	repo    repository.AccessTokenRepository
+	enabled bool
+}
+
+func NewOpaqueTokenService(repo repository.AccessTokenRepository) *OpaqueTokenService {
+	format := strings.ToLower(os.Getenv("ACCESS_TOKEN_FORMAT"))
+	switch format {
+	case "", "jwt", "opaque":
+	default:
+		log.Printf("warning: invalid ACCESS_TOKEN_FORMAT value '%s', using default jwt", format)
+	}
+
+	return &OpaqueTokenService{repo: repo, enabled: format == "opaque"}
+}
+
+// Enabled reports whether access tokens are opaque
+func (s *OpaqueTokenService) Enabled() bool {
+	return s != nil && s.enabled
+}
+
+// Issue replaces a freshly signed access token with an opaque one (returned unchanged in JWT mode)
+// refreshID links the token to its session, so revoking the refresh token revokes it too.
+func (s *OpaqueTokenService) Issue(ctx context.Context, accessToken string, refreshID *uuid.UUID) (string, error) {
+	if !s.Enabled() {
+		return accessToken, nil
+	}
+
+	claims, err := util.ParseAccessToken(accessToken)
+	if err != nil {
+		return "", err
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return "", errors.New("invalid user ID format")
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := util.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+	if err := s.repo.Create(ctx, &model.AccessToken{
+		TokenHash:      util.HashToken(token),
+		UserID:         userID,
+		RefreshTokenID: refreshID,
+		Claims:         string(data),
+		ExpiresAt:      claims.ExpiresAt.Time,
+	}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Lookup returns the claims of an active opaque token (registered with util.SetOpaqueTokenLookup)
+func (s *OpaqueTokenService) Lookup(ctx context.Context, token string) (*dto.AuthClaims, error) {
+	at, err := s.repo.GetActive(ctx, util.HashToken(token))
+	if err != nil {
+		return nil, errors.New("invalid or revoked token")
+	}
+
+	var claims dto.AuthClaims
+	if err := json.Unmarshal([]byte(at.Claims), &claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// Revoke revokes a single opaque token and reports whether it was active
+func (s *OpaqueTokenService) Revoke(ctx context.Context, token string) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+	return s.repo.RevokeByHash(ctx, util.HashToken(token))
+}
+
+// RevokeForRefreshToken revokes the access tokens issued with a refresh token
+func (s *OpaqueTokenService) RevokeForRefreshToken(ctx context.Context, refreshID uuid.UUID) error {
+	if !s.Enabled() {
+		return nil
+	}
+	return s.repo.RevokeByRefreshToken(ctx, refreshID)
+}
+
+// RevokeAllForUser revokes every access token of the user (password reset)
+func (s *OpaqueTokenService) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	if !s.Enabled() {
+		return nil
+	}
+	return s.repo.RevokeAllForUser(ctx, userID)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"mein-idaas/model"
+	"mein-idaas/repository"
+)
+
+// OutboxHandler delivers a single event. Returning an error schedules a retry.
+// Handlers must be idempotent: delivery is at-least-once (event.ID is the dedup key).
+type OutboxHandler func(event *model.OutboxEvent) error
+
+// OutboxDispatcher polls the outbox table and delivers due events with exponential backoff
+type OutboxDispatcher struct {
+	repo         repository.OutboxRepository
+	handlers     map[string][]OutboxHandler
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+}
+
+func NewOutboxDispatcher(repo repository.OutboxRepository) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		repo:         repo,
+		handlers:     make(map[string][]OutboxHandler),
+		pollInterval: 2 * time.Second,
+		batchSize:    50,
+		maxAttempts:  10,
+	}
+}
+
+// Register adds a handler for an event type. Multiple handlers per type are allowed.
+func (d *OutboxDispatcher) Register(eventType string, h OutboxHandler) {
+	d.handlers[eventType] = append(d.handlers[eventType], h)
+}
+
+// Start runs the dispatcher loop in the background
+func (d *OutboxDispatcher) Start() {
+	go func() {
+		for {
+			n, err := d.dispatchOnce()
+			if err != nil {
+				log.Printf("[OUTBOX] failed to claim events: %v", err)
+			}
+			// Drain quickly while there is a backlog, otherwise wait for the next poll
+			if n < d.batchSize {
+				time.Sleep(d.pollInterval)
+			}
+		}
+	}()
+}
+
+// dispatchOnce claims and delivers one batch, returning the number of claimed events
+func (d *OutboxDispatcher) dispatchOnce() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Lease must outlive delivery of a whole batch (SMTP can be slow)
+	events, err := d.repo.ClaimBatch(ctx, d.batchSize, 5*time.Minute)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range events {
+		d.deliver(&events[i])
+	}
+	return len(events), nil
+}
+
+func (d *OutboxDispatcher) deliver(event *model.OutboxEvent) {
+	var deliverErr error
+	for _, h := range d.handlers[event.Type] {
+		if err := h(event); err != nil {
+			deliverErr = err
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if deliverErr == nil {
+		if err := d.repo.MarkDelivered(ctx, event.ID); err != nil {
+			log.Printf("[OUTBOX] failed to mark event %s delivered: %v", event.ID, err)
+		}
+		return
+	}
+
+	attempts := event.Attempts + 1
+	dead := attempts >= d.maxAttempts
+	// Exponential backoff: 2s, 4s, 8s ... capped at 1h
+	backoff := time.Duration(1<<uint(attempts)) * time.Second
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+
+	if dead {
+		log.Printf("[OUTBOX] event %s (%s) failed permanently after %d attempts: %v", event.ID, event.Type, attempts, deliverErr)
+	} else {
+		log.Printf("[OUTBOX] event %s (%s) failed (attempt %d), retrying in %v: %v", event.ID, event.Type, attempts, backoff, deliverErr)
+	}
+
+	if err := d.repo.MarkFailed(ctx, event.ID, attempts, deliverErr.Error(), time.Now().Add(backoff), dead); err != nil {
+		log.Printf("[OUTBOX] failed to record failure for event %s: %v", event.ID, err)
+	}
+}
+
+// NewOutboxEvent builds an event with a JSON-encoded payload
+func NewOutboxEvent(eventType string, payload interface{}) (*model.OutboxEvent, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s payload: %w", eventType, err)
+	}
+	return &model.OutboxEvent{Type: eventType, Payload: string(b)}, nil
+}
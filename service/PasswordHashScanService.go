@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+
+	"github.com/google/uuid"
+)
+
+// passwordScanBatchSize is the number of credentials checked per query
+const passwordScanBatchSize = 1000
+
+// PasswordHashScanService finds password hashes that use a legacy scheme or outdated Argon2 parameters
+// The scan is started by an admin and runs in the background. It sets Credential.NeedsRehash and exports
+// how many users still need a rehash; the hashes themselves are upgraded at the user's next login.
+type PasswordHashScanService struct {
+	credentialRepo repository.CredentialRepository
+
+	mu     sync.Mutex
+	report dto.PasswordHashScanReport
+
+	// Exported on /metrics, values of the last finished scan
+	outdated atomic.Int64
+	scanned  atomic.Int64
+}
+
+func NewPasswordHashScanService(credentials repository.CredentialRepository) *PasswordHashScanService {
+	s := &PasswordHashScanService{credentialRepo: credentials}
+	util.RegisterGaugeFunc("idaas_password_hashes_outdated", "Password hashes flagged for rehash by the last scan.", func() float64 {
+		return float64(s.outdated.Load())
+	})
+	util.RegisterGaugeFunc("idaas_password_hashes_scanned", "Password hashes checked by the last scan.", func() float64 {
+		return float64(s.scanned.Load())
+	})
+	return s
+}
+
+// Start runs a scan in the background; only one scan runs at a time
+func (s *PasswordHashScanService) Start() (dto.PasswordHashScanReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.report.Running {
+		return s.report, errors.New("scan already running")
+	}
+
+	now := time.Now()
+	s.report = dto.PasswordHashScanReport{Running: true, StartedAt: &now, ByScheme: map[string]int64{}}
+	go s.run()
+	return s.report, nil
+}
+
+// Report returns the progress of the running scan or the result of the last one
+func (s *PasswordHashScanService) Report() dto.PasswordHashScanReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := s.report
+	report.ByScheme = make(map[string]int64, len(s.report.ByScheme))
+	for scheme, n := range s.report.ByScheme {
+		report.ByScheme[scheme] = n
+	}
+	return report
+}
+
+func (s *PasswordHashScanService) run() {
+	// Detached from the admin's request, which ends right after starting the scan
+	ctx := context.Background()
+
+	err := s.credentialRepo.StreamPasswords(ctx, passwordScanBatchSize, func(creds []model.Credential) error {
+		var outdated, current []uuid.UUID
+		var flagged int64
+		schemes := make(map[string]int64)
+		for _, c := range creds {
+			schemes[util.PasswordHashScheme(c.Value)]++
+			needsRehash := util.PasswordNeedsRehash(c.Value)
+			if needsRehash && !c.NeedsRehash {
+				outdated = append(outdated, c.ID)
+			} else if !needsRehash && c.NeedsRehash {
+				current = append(current, c.ID)
+			}
+			if needsRehash {
+				flagged++
+			}
+		}
+
+		if err := s.credentialRepo.SetNeedsRehash(ctx, outdated, true); err != nil {
+			return err
+		}
+		if err := s.credentialRepo.SetNeedsRehash(ctx, current, false); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.report.Scanned += int64(len(creds))
+		s.report.Outdated += flagged
+		for scheme, n := range schemes {
+			s.report.ByScheme[scheme] += n
+		}
+		s.mu.Unlock()
+		return nil
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.report.Running = false
+	s.report.FinishedAt = &now
+	if err != nil {
+		s.report.Error = err.Error()
+		log.Printf("password hash scan failed after %d credentials: %v", s.report.Scanned, err)
+		return
+	}
+	s.outdated.Store(s.report.Outdated)
+	s.scanned.Store(s.report.Scanned)
+	log.Printf("password hash scan finished: %d of %d hashes need a rehash", s.report.Outdated, s.report.Scanned)
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mein-idaas/util"
+)
+
+// Password policy violations, returned to the client as 400
+var (
+	errPasswordTooShort      = errors.New("password too short")
+	errPasswordTooLong       = errors.New("password too long")
+	errPasswordTooFewClasses = errors.New("password must mix more character classes")
+	errPasswordCommon        = errors.New("password is too common")
+	errPasswordTooWeak       = errors.New("password is too weak")
+	errPasswordBreached      = errors.New("password has appeared in a data breach")
+)
+
+// bcrypt ignores everything after 72 bytes
+const maxPasswordBytes = 72
+
+// PasswordPolicyService checks new passwords on register, change and reset
+// PASSWORD_MIN_LENGTH (default 8), PASSWORD_MIN_CLASSES (lowercase, uppercase, digits, other; default 1),
+// PASSWORD_MIN_SCORE (zxcvbn-style 0-4, default 2) and PASSWORD_DENY_COMMON (default true) configure it.
+// PASSWORD_DENYLIST_FILE adds a file of denied passwords (one per line) to the built-in list.
+// PASSWORD_BREACH_CHECK=true also rejects passwords found in the Pwned Passwords database
+// (k-anonymity: only the first 5 characters of the SHA-1 hash leave the server). Lookups time out after
+// PASSWORD_BREACH_TIMEOUT (default 3s); PASSWORD_BREACH_FAIL_OPEN (default true) accepts the password when
+// the API can't be reached, "false" rejects it instead.
+type PasswordPolicyService struct {
+	minLength  int
+	minClasses int
+	minScore   int
+	denyCommon bool
+
+	breachCheck    bool
+	breachFailOpen bool
+	breachAPI      string
+	client         *http.Client
+}
+
+func NewPasswordPolicyService() *PasswordPolicyService {
+	s := &PasswordPolicyService{
+		minLength:  envInt("PASSWORD_MIN_LENGTH", 8, 1, maxPasswordBytes),
+		minClasses: envInt("PASSWORD_MIN_CLASSES", 1, 1, 4),
+		minScore:   envInt("PASSWORD_MIN_SCORE", 2, 0, 4),
+		denyCommon: !strings.EqualFold(os.Getenv("PASSWORD_DENY_COMMON"), "false"),
+
+		breachCheck:    os.Getenv("PASSWORD_BREACH_CHECK") == "true",
+		breachFailOpen: os.Getenv("PASSWORD_BREACH_FAIL_OPEN") != "false",
+		breachAPI:      "https://api.pwnedpasswords.com/range/",
+		client:         &http.Client{Timeout: envDuration("PASSWORD_BREACH_TIMEOUT", 3*time.Second)},
+	}
+	if url := os.Getenv("PASSWORD_BREACH_API_URL"); url != "" {
+		s.breachAPI = strings.TrimRight(url, "/") + "/"
+	}
+
+	if path := os.Getenv("PASSWORD_DENYLIST_FILE"); path != "" {
+		added, err := util.LoadPasswordDenylist(path)
+		if err != nil {
+			log.Printf("warning: failed to load PASSWORD_DENYLIST_FILE '%s': %v", path, err)
+		} else {
+			log.Printf("Loaded %d denied passwords from %s", added, path)
+		}
+	}
+	return s
+}
+
+// envInt reads an integer setting within [min, max]
+func envInt(key string, fallback, min, max int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		log.Printf("warning: invalid %s value '%s', using default %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+// Check validates a new password
+// userInputs (email, username, name) are treated as dictionary words, so "alice2024" is weak for alice@example.com.
+func (s *PasswordPolicyService) Check(ctx context.Context, password string, userInputs ...string) error {
+	if len([]rune(password)) < s.minLength {
+		return errPasswordTooShort
+	}
+	if len(password) > maxPasswordBytes {
+		return errPasswordTooLong
+	}
+	if util.CharacterClasses(password) < s.minClasses {
+		return errPasswordTooFewClasses
+	}
+	if s.denyCommon && util.IsCommonPassword(password) {
+		return errPasswordCommon
+	}
+	if util.PasswordScore(password, userInputs...) < s.minScore {
+		return errPasswordTooWeak
+	}
+
+	if s.breachCheck {
+		breached, err := s.isBreached(ctx, password)
+		if err != nil {
+			log.Printf("warning: breached password check failed: %v", err)
+			if !s.breachFailOpen {
+				return errors.New("password breach check unavailable")
+			}
+		} else if breached {
+			return errPasswordBreached
+		}
+	}
+	return nil
+}
+
+// isBreached looks the password up in the Pwned Passwords range API
+// The API returns all hash suffixes for the 5-character prefix, padded with zero-count entries.
+func (s *PasswordPolicyService) isBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.breachAPI+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", "mein-idaas")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("pwned passwords API returned %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// Lines are "SUFFIX:COUNT"
+		hashSuffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok && hashSuffix == suffix {
+			return count != "0", nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// IsPasswordPolicyError reports whether err is a policy violation that should be shown to the user
+func IsPasswordPolicyError(err error) bool {
+	switch err {
+	case errPasswordTooShort, errPasswordTooLong, errPasswordTooFewClasses, errPasswordCommon, errPasswordTooWeak:
+		return true
+	}
+	return false
+}
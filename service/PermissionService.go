@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+)
+
+// manageRolesPermission guards the role and permission endpoints: the admin role always keeps it,
+// so admins can't lock themselves out of role management
+const manageRolesPermission = "roles:manage"
+
+// permissionCodePattern is the format of permission codes, e.g. "users:read" or "billing.invoices:write"
+var permissionCodePattern = regexp.MustCompile(`^[a-z][a-z0-9_.-]*(:[a-z0-9_.-]+)*$`)
+
+// PermissionService manages permissions and resolves what roles grant: the roles they inherit and the
+// permissions of all of them. The role model (role -> permissions, role -> inherited roles) is small; it is
+// loaded as a whole and cached for ROLE_CACHE_TTL (changes made on another replica show up after that).
+type PermissionService struct {
+	repo     repository.PermissionRepository
+	roleRepo repository.RoleRepository
+	ttl      time.Duration
+
+	mu       sync.RWMutex
+	model    *roleModel
+	loadedAt time.Time
+}
+
+// roleModel is the cached role -> permissions matrix and role inheritance
+type roleModel struct {
+	permissions map[string][]string // role code -> permission codes
+	inherits    map[string][]string // role code -> codes of the roles it directly inherits
+}
+
+func NewPermissionService(repo repository.PermissionRepository, roles repository.RoleRepository) *PermissionService {
+	return &PermissionService{repo: repo, roleRepo: roles, ttl: util.GetRoleCacheTTL()}
+}
+
+// ExpandRoles returns the role codes followed by every role they inherit, directly or transitively
+// (registered with util.SetRoleExpansion)
+func (s *PermissionService) ExpandRoles(ctx context.Context, roles []string) ([]string, error) {
+	m, err := s.roleModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return expandRoles(m.inherits, roles), nil
+}
+
+// PermissionsForRoles returns the sorted union of the permissions granted by the role codes and the roles
+// they inherit (registered with util.SetPermissionLookup)
+func (s *PermissionService) PermissionsForRoles(ctx context.Context, roles []string) ([]string, error) {
+	m, err := s.roleModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, role := range expandRoles(m.inherits, roles) {
+		for _, p := range m.permissions[role] {
+			if !seen[p] {
+				seen[p] = true
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// Invalidate drops the cached role model (call whenever permissions, role permissions or inheritance change)
+func (s *PermissionService) Invalidate() {
+	s.mu.Lock()
+	s.model = nil
+	s.mu.Unlock()
+}
+
+func (s *PermissionService) roleModel(ctx context.Context) (*roleModel, error) {
+	s.mu.RLock()
+	m, loadedAt := s.model, s.loadedAt
+	s.mu.RUnlock()
+	if m != nil && time.Since(loadedAt) < s.ttl {
+		return m, nil
+	}
+
+	permissions, err := s.repo.RoleMatrix(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inherits, err := s.roleRepo.Inheritance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m = &roleModel{permissions: permissions, inherits: inherits}
+	s.mu.Lock()
+	s.model, s.loadedAt = m, time.Now()
+	s.mu.Unlock()
+	return m, nil
+}
+
+// expandRoles walks the inheritance breadth-first; each role appears once, so a cycle can't loop
+func expandRoles(inherits map[string][]string, roles []string) []string {
+	seen := make(map[string]bool, len(roles))
+	expanded := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !seen[role] {
+			seen[role] = true
+			expanded = append(expanded, role)
+		}
+	}
+	for i := 0; i < len(expanded); i++ {
+		for _, inherited := range inherits[expanded[i]] {
+			if !seen[inherited] {
+				seen[inherited] = true
+				expanded = append(expanded, inherited)
+			}
+		}
+	}
+	return expanded
+}
+
+// ListPermissions returns all permissions
+func (s *PermissionService) ListPermissions(ctx context.Context) ([]dto.PermissionResponse, error) {
+	permissions, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.PermissionResponse, 0, len(permissions))
+	for i := range permissions {
+		res = append(res, toPermissionResponse(&permissions[i]))
+	}
+	return res, nil
+}
+
+// CreatePermission adds a permission; it takes effect once it is granted to a role
+func (s *PermissionService) CreatePermission(ctx context.Context, req *dto.CreatePermissionRequest) (*dto.PermissionResponse, error) {
+	if !permissionCodePattern.MatchString(req.Code) {
+		return nil, errors.New("invalid permission code")
+	}
+
+	permission := &model.Permission{Code: req.Code, Description: req.Description}
+	if err := s.repo.Create(ctx, permission); err != nil {
+		if util.IsDuplicateKeyError(err) {
+			return nil, errors.New("permission already exists")
+		}
+		return nil, err
+	}
+
+	log.Printf("[ROLES] created permission %s", permission.Code)
+	res := toPermissionResponse(permission)
+	return &res, nil
+}
+
+// DeletePermission removes a permission from every role
+func (s *PermissionService) DeletePermission(ctx context.Context, code string) error {
+	if code == manageRolesPermission {
+		return errors.New("permission " + manageRolesPermission + " cannot be deleted")
+	}
+
+	deleted, err := s.repo.Delete(ctx, code)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.New("permission not found")
+	}
+	s.Invalidate()
+
+	log.Printf("[ROLES] deleted permission %s", code)
+	return nil
+}
+
+// resolve returns the permissions with the given codes, failing if one doesn't exist
+func (s *PermissionService) resolve(ctx context.Context, codes []string) ([]model.Permission, error) {
+	permissions, err := s.repo.GetByCodes(ctx, codes)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		known[p.Code] = true
+	}
+	for _, code := range codes {
+		if !known[code] {
+			return nil, errors.New("unknown permission: " + code)
+		}
+	}
+	return permissions, nil
+}
+
+func toPermissionResponse(p *model.Permission) dto.PermissionResponse {
+	return dto.PermissionResponse{
+		Code:        p.Code,
+		Description: p.Description,
+		CreatedAt:   p.CreatedAt,
+	}
+}
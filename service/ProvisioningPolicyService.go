@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+
+	"gorm.io/gorm"
+)
+
+// Attribute mapping targets
+const (
+	provisioningTargetName     = "name"
+	provisioningTargetMetadata = "metadata."
+)
+
+// ProvisioningPolicyService decides how accounts are created on the first login of a federated identity (JIT)
+// Like the registration policy it is stored in the settings table. Until it is set, the env defaults apply:
+// - JIT_AUTO_CREATE: "false" to only let existing accounts sign in (default "true")
+// - JIT_DEFAULT_ROLES: roles of new accounts (default "user")
+// - JIT_ALLOWED_DOMAINS: email domains that may be provisioned (default: any)
+// - JIT_ATTRIBUTE_MAPPING: provider attribute to target, e.g. "given_name:name,department:metadata.department"
+type ProvisioningPolicyService struct {
+	settingRepo repository.SettingRepository
+	defaults    dto.ProvisioningSettings
+}
+
+func NewProvisioningPolicyService(settings repository.SettingRepository) *ProvisioningPolicyService {
+	defaults := dto.ProvisioningSettings{
+		AutoCreate:       true,
+		DefaultRoles:     []string{"user"},
+		AttributeMapping: make(map[string]string),
+	}
+
+	switch v := strings.ToLower(os.Getenv("JIT_AUTO_CREATE")); v {
+	case "", "true":
+	case "false":
+		defaults.AutoCreate = false
+	default:
+		log.Printf("warning: invalid JIT_AUTO_CREATE value '%s', using default true", v)
+	}
+
+	if v := os.Getenv("JIT_DEFAULT_ROLES"); v != "" {
+		var roles []string
+		for _, r := range strings.Split(v, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				roles = append(roles, r)
+			}
+		}
+		if len(roles) > 0 {
+			defaults.DefaultRoles = roles
+		}
+	}
+
+	for _, d := range strings.Split(os.Getenv("JIT_ALLOWED_DOMAINS"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			defaults.AllowedDomains = append(defaults.AllowedDomains, d)
+		}
+	}
+
+	for _, pair := range strings.Split(os.Getenv("JIT_ATTRIBUTE_MAPPING"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		attr, target, _ := strings.Cut(pair, ":")
+		attr, target = strings.TrimSpace(attr), strings.TrimSpace(target)
+		if attr == "" || !validProvisioningTarget(target) {
+			log.Printf("warning: invalid JIT_ATTRIBUTE_MAPPING entry '%s', skipping", pair)
+			continue
+		}
+		defaults.AttributeMapping[attr] = target
+	}
+
+	return &ProvisioningPolicyService{settingRepo: settings, defaults: defaults}
+}
+
+func validProvisioningTarget(target string) bool {
+	return target == provisioningTargetName ||
+		(strings.HasPrefix(target, provisioningTargetMetadata) && len(target) > len(provisioningTargetMetadata))
+}
+
+// GetSettings returns the current provisioning policy (stored setting or env defaults)
+func (s *ProvisioningPolicyService) GetSettings(ctx context.Context) (*dto.ProvisioningSettings, error) {
+	setting, err := s.settingRepo.Get(ctx, model.SettingKeyProvisioning)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		defaults := s.defaults
+		return &defaults, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var settings dto.ProvisioningSettings
+	if err := json.Unmarshal([]byte(setting.Value), &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateSettings stores a new provisioning policy (takes effect immediately on every replica)
+func (s *ProvisioningPolicyService) UpdateSettings(ctx context.Context, settings *dto.ProvisioningSettings) error {
+	for attr, target := range settings.AttributeMapping {
+		if attr == "" || !validProvisioningTarget(target) {
+			return errors.New("invalid attribute mapping target")
+		}
+	}
+	for i, d := range settings.AllowedDomains {
+		settings.AllowedDomains[i] = strings.ToLower(strings.TrimSpace(d))
+	}
+
+	value, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if err := s.settingRepo.Upsert(ctx, &model.Setting{Key: model.SettingKeyProvisioning, Value: string(value)}); err != nil {
+		return err
+	}
+
+	log.Printf("provisioning policy changed: auto_create=%t default_roles=%v allowed_domains=%v", settings.AutoCreate, settings.DefaultRoles, settings.AllowedDomains)
+	return nil
+}
+
+// Authorize checks whether a new account may be created for the identity and returns the policy to apply
+func (s *ProvisioningPolicyService) Authorize(ctx context.Context, identity *ExternalIdentity) (*dto.ProvisioningSettings, error) {
+	settings, err := s.GetSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.AutoCreate {
+		return nil, errors.New("automatic sign-up is disabled")
+	}
+
+	if len(settings.AllowedDomains) > 0 {
+		_, domain, _ := strings.Cut(identity.Email, "@")
+		allowed := false
+		for _, d := range settings.AllowedDomains {
+			if strings.EqualFold(domain, d) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, errors.New("email domain not allowed for sign-up")
+		}
+	}
+	return settings, nil
+}
+
+// applyProvisioningAttributes copies the mapped provider attributes onto the new account
+func applyProvisioningAttributes(settings *dto.ProvisioningSettings, identity *ExternalIdentity, user *model.User) {
+	for attr, target := range settings.AttributeMapping {
+		value, ok := identity.Attributes[attr]
+		if !ok {
+			// LDAP attribute names are case-insensitive and stored lowercased
+			value = identity.Attributes[strings.ToLower(attr)]
+		}
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		if target == provisioningTargetName {
+			if runes := []rune(value); len(runes) > 50 {
+				value = string(runes[:50])
+			}
+			user.Name = value
+			continue
+		}
+		if user.Metadata == nil {
+			user.Metadata = make(model.JSONB)
+		}
+		user.Metadata[strings.TrimPrefix(target, provisioningTargetMetadata)] = value
+	}
+}
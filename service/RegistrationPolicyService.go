@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Registration modes
+const (
+	RegistrationOpen       = "open"
+	RegistrationRestricted = "restricted" // invite token or allowed email domain
+	RegistrationClosed     = "closed"
+)
+
+// defaultInviteTTL is used when an invite is created without expires_in
+const defaultInviteTTL = 7 * 24 * time.Hour
+
+// RegistrationPolicyService decides who may register
+// The policy is stored in the settings table, so admins can change it at runtime for all replicas.
+// Until it is set, REGISTRATION_MODE (default "open") and REGISTRATION_ALLOWED_DOMAINS apply.
+type RegistrationPolicyService struct {
+	settingRepo repository.SettingRepository
+	inviteRepo  repository.InviteRepository
+	defaults    dto.RegistrationSettings
+}
+
+func NewRegistrationPolicyService(settings repository.SettingRepository, invites repository.InviteRepository) *RegistrationPolicyService {
+	mode := strings.ToLower(os.Getenv("REGISTRATION_MODE"))
+	switch mode {
+	case RegistrationOpen, RegistrationRestricted, RegistrationClosed:
+	case "":
+		mode = RegistrationOpen
+	default:
+		log.Printf("warning: invalid REGISTRATION_MODE value '%s', using default %s", mode, RegistrationOpen)
+		mode = RegistrationOpen
+	}
+
+	var domains []string
+	for _, d := range strings.Split(os.Getenv("REGISTRATION_ALLOWED_DOMAINS"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			domains = append(domains, d)
+		}
+	}
+
+	return &RegistrationPolicyService{
+		settingRepo: settings,
+		inviteRepo:  invites,
+		defaults:    dto.RegistrationSettings{Mode: mode, AllowedDomains: domains},
+	}
+}
+
+// GetSettings returns the current registration policy (stored setting or env defaults)
+func (s *RegistrationPolicyService) GetSettings(ctx context.Context) (*dto.RegistrationSettings, error) {
+	setting, err := s.settingRepo.Get(ctx, model.SettingKeyRegistration)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		defaults := s.defaults
+		return &defaults, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var settings dto.RegistrationSettings
+	if err := json.Unmarshal([]byte(setting.Value), &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateSettings stores a new registration policy (takes effect immediately on every replica)
+func (s *RegistrationPolicyService) UpdateSettings(ctx context.Context, settings *dto.RegistrationSettings) error {
+	for i, d := range settings.AllowedDomains {
+		settings.AllowedDomains[i] = strings.ToLower(strings.TrimSpace(d))
+	}
+
+	value, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if err := s.settingRepo.Upsert(ctx, &model.Setting{Key: model.SettingKeyRegistration, Value: string(value)}); err != nil {
+		return err
+	}
+
+	log.Printf("registration policy changed: mode=%s allowed_domains=%v", settings.Mode, settings.AllowedDomains)
+	return nil
+}
+
+// CheckOpen fails when registration is closed entirely (used before creating guest accounts)
+func (s *RegistrationPolicyService) CheckOpen(ctx context.Context) error {
+	settings, err := s.GetSettings(ctx)
+	if err != nil {
+		return err
+	}
+	if settings.Mode == RegistrationClosed {
+		return errors.New("registration is closed")
+	}
+	return nil
+}
+
+// Authorize checks whether email may register and redeems the invite token, if any
+// Must run inside the registration transaction so a redeemed invite rolls back with a failed registration
+func (s *RegistrationPolicyService) Authorize(ctx context.Context, repos *repository.Repositories, email string, inviteToken string, userID uuid.UUID) error {
+	settings, err := s.GetSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	if settings.Mode == RegistrationClosed {
+		return errors.New("registration is closed")
+	}
+
+	if inviteToken != "" {
+		ok, err := repos.Invites.Consume(ctx, util.HashToken(inviteToken), email, userID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("invalid or expired invite")
+		}
+		return nil
+	}
+
+	if settings.Mode == RegistrationOpen {
+		return nil
+	}
+
+	// Restricted: only allowed email domains may register without an invite
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		domain := strings.ToLower(email[at+1:])
+		for _, d := range settings.AllowedDomains {
+			if domain == d {
+				return nil
+			}
+		}
+	}
+	return errors.New("registration requires an invite")
+}
+
+// CreateInvite creates a single-use invite and returns its token (only the hash is stored)
+func (s *RegistrationPolicyService) CreateInvite(ctx context.Context, createdBy string, req *dto.CreateInviteRequest) (*dto.InviteResponse, error) {
+	ttl := defaultInviteTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return nil, errors.New("invalid expires_in")
+		}
+		ttl = d
+	}
+
+	token, err := util.GenerateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	creator, _ := uuid.Parse(createdBy)
+	invite := &model.Invite{
+		TokenHash: util.HashToken(token),
+		Email:     req.Email,
+		CreatedBy: creator,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.inviteRepo.Create(ctx, invite); err != nil {
+		return nil, err
+	}
+
+	return &dto.InviteResponse{
+		ID:        invite.ID.String(),
+		Token:     token,
+		Email:     invite.Email,
+		ExpiresAt: invite.ExpiresAt,
+	}, nil
+}
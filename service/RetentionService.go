@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"mein-idaas/repository"
+	"mein-idaas/util"
+)
+
+// retentionBatchSize is the number of rows deleted per statement
+const retentionBatchSize = 1000
+
+// RetentionStats holds the result of the last run of a policy
+type RetentionStats struct {
+	Policy      string        `json:"policy"`
+	LastRun     time.Time     `json:"last_run"`
+	LastPurged  int64         `json:"last_purged"`
+	TotalPurged int64         `json:"total_purged"`
+	LastError   string        `json:"last_error,omitempty"`
+	Duration    time.Duration `json:"duration_ns"`
+}
+
+// RetentionService purges old rows according to per-table retention policies
+// Each policy's window can be overridden with RETENTION_<NAME> (Go duration, "0" disables)
+type RetentionService struct {
+	repo     repository.RetentionRepository
+	policies []repository.RetentionPolicy
+
+	mu    sync.Mutex
+	stats map[string]*RetentionStats
+}
+
+func NewRetentionService(repo repository.RetentionRepository) *RetentionService {
+	s := &RetentionService{
+		repo:  repo,
+		stats: make(map[string]*RetentionStats),
+	}
+
+	// Built-in policies
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "outbox_delivered",
+		Table:      "outbox_events",
+		TimeColumn: "delivered_at",
+		Condition:  "status = 'delivered'",
+		Retention:  7 * 24 * time.Hour,
+	})
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "outbox_dead",
+		Table:      "outbox_events",
+		TimeColumn: "created_at",
+		Condition:  "status = 'dead'",
+		Retention:  30 * 24 * time.Hour,
+	})
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "refresh_tokens_revoked",
+		Table:      "refresh_tokens",
+		TimeColumn: "revoked_at",
+		Condition:  "revoked_at IS NOT NULL",
+		Retention:  30 * 24 * time.Hour,
+	})
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "users_unverified",
+		Table:      "users",
+		TimeColumn: "created_at",
+		Condition:  "is_email_verified = false AND is_anonymous = false", // Guests never verify an email
+		Retention:  util.GetUnverifiedAccountTTL(),
+	})
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "user_activity",
+		Table:      "user_activities",
+		TimeColumn: "day",
+		Retention:  400 * 24 * time.Hour, // Keeps a full year of DAU/MAU history
+	})
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "signing_keys_retired",
+		Table:      "signing_keys",
+		TimeColumn: "retires_at",
+		Retention:  24 * time.Hour, // Retired keys are no longer loaded; the row is only kept for auditing
+	})
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "sso_sessions_expired",
+		Table:      "sso_sessions",
+		TimeColumn: "expires_at",
+		Retention:  7 * 24 * time.Hour,
+	})
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "remembered_devices_expired",
+		Table:      "remembered_devices",
+		TimeColumn: "expires_at",
+		Retention:  24 * time.Hour,
+	})
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "api_keys_revoked",
+		Table:      "api_keys",
+		TimeColumn: "revoked_at",
+		Condition:  "revoked_at IS NOT NULL",
+		Retention:  30 * 24 * time.Hour,
+	})
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "access_tokens_expired",
+		Table:      "access_tokens",
+		TimeColumn: "expires_at",
+		Retention:  24 * time.Hour, // Opaque access tokens (ACCESS_TOKEN_FORMAT=opaque)
+	})
+	s.AddPolicy(repository.RetentionPolicy{
+		Name:       "authorization_codes_expired",
+		Table:      "authorization_codes",
+		TimeColumn: "expires_at",
+		Retention:  24 * time.Hour, // Codes live for a minute; used and expired ones are only kept briefly
+	})
+
+	return s
+}
+
+// AddPolicy registers a policy, applying the RETENTION_<NAME> override if set
+func (s *RetentionService) AddPolicy(p repository.RetentionPolicy) {
+	envKey := "RETENTION_" + strings.ToUpper(p.Name)
+	if v := os.Getenv(envKey); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("warning: invalid %s value '%s', using default %v", envKey, v, p.Retention)
+		} else {
+			p.Retention = d
+		}
+	}
+	s.policies = append(s.policies, p)
+}
+
+// RunOnce executes every enabled policy
+func (s *RetentionService) RunOnce() {
+	for _, p := range s.policies {
+		if p.Retention == 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+		start := time.Now()
+		purged, err := s.repo.Purge(ctx, p, start.Add(-p.Retention), retentionBatchSize)
+		cancel()
+
+		s.record(p.Name, start, purged, err)
+		util.ObserveJanitorRun("retention_"+p.Name, time.Since(start), purged, err)
+		if err != nil {
+			log.Printf("[RETENTION] policy %s failed after purging %d rows: %v", p.Name, purged, err)
+			continue
+		}
+		log.Printf("[RETENTION] policy %s purged %d rows older than %v in %v", p.Name, purged, p.Retention, time.Since(start))
+	}
+}
+
+func (s *RetentionService) record(name string, start time.Time, purged int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.stats[name]
+	if !ok {
+		st = &RetentionStats{Policy: name}
+		s.stats[name] = st
+	}
+	st.LastRun = start
+	st.LastPurged = purged
+	st.TotalPurged += purged
+	st.Duration = time.Since(start)
+	st.LastError = ""
+	if err != nil {
+		st.LastError = err.Error()
+	}
+}
+
+// Stats returns a snapshot of per-policy purge metrics
+func (s *RetentionService) Stats() []RetentionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]RetentionStats, 0, len(s.stats))
+	for _, p := range s.policies {
+		if st, ok := s.stats[p.Name]; ok {
+			out = append(out, *st)
+		}
+	}
+	return out
+}
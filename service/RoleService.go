@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+
+	"gorm.io/gorm"
+)
+
+// roleCodePattern is the format of role codes: they end up in the roles claim of tokens
+var roleCodePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// RoleService manages the roles users can be assigned and the permissions they grant (admin)
+// System roles (IsSystem, seeded at startup) can be renamed but not deleted.
+type RoleService struct {
+	roleRepo  repository.RoleRepository
+	roleCache repository.RoleCache
+	permSvc   *PermissionService
+}
+
+func NewRoleService(roles repository.RoleRepository, cache repository.RoleCache, permissions *PermissionService) *RoleService {
+	return &RoleService{roleRepo: roles, roleCache: cache, permSvc: permissions}
+}
+
+// ListRoles returns all roles, system roles first
+func (s *RoleService) ListRoles(ctx context.Context) ([]dto.RoleResponse, error) {
+	roles, err := s.roleRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.RoleResponse, 0, len(roles))
+	for i := range roles {
+		res = append(res, *toRoleResponse(&roles[i]))
+	}
+	return res, nil
+}
+
+// GetRole returns the role with the given code
+func (s *RoleService) GetRole(ctx context.Context, code string) (*dto.RoleResponse, error) {
+	role, err := s.findRole(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	return toRoleResponse(role), nil
+}
+
+// CreateRole adds a (non-system) role
+func (s *RoleService) CreateRole(ctx context.Context, req *dto.CreateRoleRequest) (*dto.RoleResponse, error) {
+	if !roleCodePattern.MatchString(req.Code) {
+		return nil, errors.New("invalid role code")
+	}
+
+	role := &model.Role{Code: req.Code, Name: req.Name, Description: req.Description}
+	if err := s.roleRepo.Create(ctx, role); err != nil {
+		if util.IsDuplicateKeyError(err) {
+			return nil, errors.New("role already exists")
+		}
+		return nil, err
+	}
+
+	log.Printf("[ROLES] created role %s", role.Code)
+	return toRoleResponse(role), nil
+}
+
+// UpdateRole changes the name and description of a role
+func (s *RoleService) UpdateRole(ctx context.Context, code string, req *dto.UpdateRoleRequest) (*dto.RoleResponse, error) {
+	role, err := s.findRole(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	role.Name, role.Description = req.Name, req.Description
+	if err := s.roleRepo.Update(ctx, role); err != nil {
+		if util.IsDuplicateKeyError(err) {
+			return nil, errors.New("role name already in use")
+		}
+		return nil, err
+	}
+	return toRoleResponse(role), nil
+}
+
+// DeleteRole removes a non-system role and takes it away from every user that had it
+// Access tokens already issued keep the role in their roles claim until they expire.
+func (s *RoleService) DeleteRole(ctx context.Context, code string) error {
+	role, err := s.findRole(ctx, code)
+	if err != nil {
+		return err
+	}
+	if role.IsSystem {
+		return errors.New("system roles cannot be deleted")
+	}
+
+	userIDs, err := s.roleRepo.Delete(ctx, role)
+	if err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		s.roleCache.Invalidate(id)
+	}
+	s.permSvc.Invalidate()
+
+	log.Printf("[ROLES] deleted role %s (removed from %d users)", role.Code, len(userIDs))
+	return nil
+}
+
+// SetRolePermissions replaces the permissions a role grants, system roles included
+// Tokens issued from now on carry the new permissions; tokens already issued keep the old ones until they expire.
+func (s *RoleService) SetRolePermissions(ctx context.Context, code string, req *dto.SetRolePermissionsRequest) (*dto.RoleResponse, error) {
+	role, err := s.findRole(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := s.permSvc.resolve(ctx, req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	if role.Code == "admin" && !grantsPermission(permissions, manageRolesPermission) {
+		return nil, errors.New("the admin role must keep " + manageRolesPermission)
+	}
+
+	if err := s.roleRepo.SetPermissions(ctx, role, permissions); err != nil {
+		return nil, err
+	}
+	s.permSvc.Invalidate()
+
+	log.Printf("[ROLES] role %s now grants %d permissions", role.Code, len(permissions))
+	role.Permissions = permissions
+	return toRoleResponse(role), nil
+}
+
+// SetInheritedRoles replaces the roles a role inherits: users with the role also get the codes and
+// permissions of those roles (and of the roles they inherit). Changes creating a cycle are rejected.
+func (s *RoleService) SetInheritedRoles(ctx context.Context, code string, req *dto.SetInheritedRolesRequest) (*dto.RoleResponse, error) {
+	role, err := s.findRole(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	inherits := make([]*model.Role, 0, len(req.Roles))
+	codes := make([]string, 0, len(req.Roles))
+	for _, inheritedCode := range req.Roles {
+		if inheritedCode == role.Code {
+			return nil, errors.New("a role cannot inherit itself")
+		}
+		inherited, err := s.roleRepo.GetByCode(ctx, inheritedCode)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("unknown role: " + inheritedCode)
+		}
+		if err != nil {
+			return nil, err
+		}
+		inherits = append(inherits, inherited)
+		codes = append(codes, inherited.Code)
+	}
+
+	// Checked against the stored hierarchy, not the cache
+	inheritance, err := s.roleRepo.Inheritance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inheritance[role.Code] = codes
+	if cycle := findInheritanceCycle(inheritance, role.Code); cycle != nil {
+		return nil, errors.New("role inheritance cycle: " + strings.Join(cycle, " -> "))
+	}
+
+	if err := s.roleRepo.SetInherits(ctx, role, inherits); err != nil {
+		return nil, err
+	}
+	s.permSvc.Invalidate()
+
+	log.Printf("[ROLES] role %s now inherits %v", role.Code, codes)
+	role.Inherits = inherits
+	return toRoleResponse(role), nil
+}
+
+// findInheritanceCycle returns a path from role back to itself (role -> ... -> role), nil without a cycle
+// Only cycles through role matter: the stored hierarchy is acyclic.
+func findInheritanceCycle(inherits map[string][]string, role string) []string {
+	visited := make(map[string]bool)
+	var path []string
+	var walk func(code string) bool
+	walk = func(code string) bool {
+		path = append(path, code)
+		for _, next := range inherits[code] {
+			if next == role {
+				path = append(path, next)
+				return true
+			}
+			if !visited[next] {
+				visited[next] = true
+				if walk(next) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if walk(role) {
+		return path
+	}
+	return nil
+}
+
+func (s *RoleService) findRole(ctx context.Context, code string) (*model.Role, error) {
+	role, err := s.roleRepo.GetWithPermissions(ctx, code)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("role not found")
+	}
+	return role, err
+}
+
+func toRoleResponse(role *model.Role) *dto.RoleResponse {
+	return &dto.RoleResponse{
+		ID:          role.ID.String(),
+		Code:        role.Code,
+		Name:        role.Name,
+		Description: role.Description,
+		IsSystem:    role.IsSystem,
+		Permissions: permissionCodes(role.Permissions),
+		Inherits:    inheritedRoleCodes(role.Inherits),
+		CreatedAt:   role.CreatedAt,
+		UpdatedAt:   role.UpdatedAt,
+	}
+}
+
+// permissionCodes returns the sorted codes of the permissions
+func permissionCodes(permissions []model.Permission) []string {
+	codes := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		codes = append(codes, p.Code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func grantsPermission(permissions []model.Permission, code string) bool {
+	for _, p := range permissions {
+		if p.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// inheritedRoleCodes returns the sorted codes of the inherited roles
+func inheritedRoleCodes(roles []*model.Role) []string {
+	codes := make([]string, 0, len(roles))
+	for _, r := range roles {
+		codes = append(codes, r.Code)
+	}
+	sort.Strings(codes)
+	return codes
+}
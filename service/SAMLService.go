@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+
+	"mein-idaas/dto"
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/saml"
+	"mein-idaas/util"
+)
+
+// SAMLService lets legacy enterprise apps sign users in via SAML 2.0 (Mein IDaaS as identity provider)
+// Assertions are signed with the active JWT signing key, which must be an RSA key (JWT_SIGNING_ALG=RS256).
+type SAMLService struct {
+	spRepo   repository.SAMLServiceProviderRepository
+	userRepo repository.UserRepository
+}
+
+func NewSAMLService(sps repository.SAMLServiceProviderRepository, users repository.UserRepository) *SAMLService {
+	return &SAMLService{spRepo: sps, userRepo: users}
+}
+
+// Metadata returns the IdP metadata document for entityID with the current signing certificate of the tenant
+// SPs that don't refresh metadata must re-import it after a key rotation.
+func (s *SAMLService) Metadata(tenant string, entityID string, ssoURL string) ([]byte, error) {
+	key, err := s.signingKey(tenant, entityID)
+	if err != nil {
+		return nil, err
+	}
+	return saml.Metadata(entityID, ssoURL, key.Certificate), nil
+}
+
+// ValidateRequest looks up the service provider of an AuthnRequest in the current organization
+// The response is only sent to the registered ACS URL with the HTTP-POST binding.
+func (s *SAMLService) ValidateRequest(ctx context.Context, req *saml.AuthnRequest) (*model.SAMLServiceProvider, error) {
+	sp, err := s.spRepo.GetByEntityID(ctx, util.TenantFromContext(ctx), req.Issuer)
+	if err != nil {
+		return nil, errors.New("unknown service provider")
+	}
+	if req.AssertionConsumerServiceURL != "" && req.AssertionConsumerServiceURL != sp.ACSURL {
+		return nil, errors.New("acs url not registered")
+	}
+	if req.ProtocolBinding != "" && req.ProtocolBinding != saml.BindingHTTPPost {
+		return nil, errors.New("unsupported protocol binding")
+	}
+	return sp, nil
+}
+
+// IssueResponse builds the signed SAMLResponse for the signed-in user
+// email, name and the user's roles including group and inherited ones (sp.RoleAttribute, multi-valued) are sent as attributes.
+func (s *SAMLService) IssueResponse(ctx context.Context, sp *model.SAMLServiceProvider, user *model.User, session *model.SSOSession, entityID string, inResponseTo string) (string, error) {
+	key, err := s.signingKey(sp.Tenant, entityID)
+	if err != nil {
+		return "", err
+	}
+
+	nameID, format := user.Email, saml.NameIDFormatEmail
+	if sp.NameIDFormat == model.NameIDPersistent {
+		nameID, format = user.ID.String(), saml.NameIDFormatPersistent
+	}
+
+	roles, err := userRoleCodes(ctx, s.userRepo, user)
+	if err != nil {
+		return "", err
+	}
+	if roles, err = util.ExpandRoles(ctx, roles); err != nil {
+		return "", err
+	}
+	attributes := []saml.Attribute{{Name: "email", Values: []string{user.Email}}}
+	if user.Name != "" {
+		attributes = append(attributes, saml.Attribute{Name: "name", Values: []string{user.Name}})
+	}
+	if len(roles) > 0 {
+		attributes = append(attributes, saml.Attribute{Name: sp.RoleAttribute, Values: roles})
+	}
+
+	res, err := saml.BuildResponse(saml.ResponseParams{
+		Issuer:       entityID,
+		Audience:     sp.EntityID,
+		ACSURL:       sp.ACSURL,
+		InResponseTo: inResponseTo,
+		NameID:       nameID,
+		NameIDFormat: format,
+		SessionIndex: session.ID.String(),
+		AuthnInstant: session.AuthTime,
+		Attributes:   attributes,
+	}, key)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("issued SAML assertion for user %s to service provider %s", user.Email, sp.EntityID)
+	return res, nil
+}
+
+// signingKey returns the active signing key of the tenant with its self-signed certificate
+func (s *SAMLService) signingKey(tenant string, entityID string) (saml.SigningKey, error) {
+	key := util.TenantSigningKey(tenant)
+	if key == nil || key.Private == nil {
+		return saml.SigningKey{}, errors.New("signing keys not initialized")
+	}
+
+	cert, err := saml.SigningCertificate(key.Private, key.Kid, key.CreatedAt, entityID)
+	if err != nil {
+		return saml.SigningKey{}, err
+	}
+	return saml.SigningKey{Signer: key.Private, Certificate: cert}, nil
+}
+
+// CreateServiceProvider registers a service provider (admin)
+func (s *SAMLService) CreateServiceProvider(ctx context.Context, req *dto.CreateSAMLServiceProviderRequest) (*dto.SAMLServiceProviderResponse, error) {
+	if req.Tenant != "" && !util.IsKnownTenant(req.Tenant) {
+		return nil, errors.New("unknown tenant")
+	}
+	if !validACSURL(req.ACSURL) {
+		return nil, errors.New("invalid acs_url")
+	}
+
+	sp := &model.SAMLServiceProvider{
+		EntityID:      req.EntityID,
+		Name:          req.Name,
+		ACSURL:        req.ACSURL,
+		NameIDFormat:  req.NameIDFormat,
+		RoleAttribute: req.RoleAttribute,
+		Tenant:        req.Tenant,
+	}
+	if sp.NameIDFormat == "" {
+		sp.NameIDFormat = model.NameIDEmail
+	}
+	if sp.RoleAttribute == "" {
+		sp.RoleAttribute = "roles"
+	}
+
+	if err := s.spRepo.Create(ctx, sp); err != nil {
+		if util.IsDuplicateKeyError(err) {
+			return nil, errors.New("service provider already exists")
+		}
+		return nil, err
+	}
+
+	log.Printf("registered SAML service provider %s (%s)", sp.EntityID, sp.Name)
+	return toSAMLServiceProviderResponse(sp), nil
+}
+
+// ListServiceProviders returns all registered service providers (admin)
+func (s *SAMLService) ListServiceProviders(ctx context.Context) ([]dto.SAMLServiceProviderResponse, error) {
+	sps, err := s.spRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]dto.SAMLServiceProviderResponse, 0, len(sps))
+	for i := range sps {
+		res = append(res, *toSAMLServiceProviderResponse(&sps[i]))
+	}
+	return res, nil
+}
+
+func toSAMLServiceProviderResponse(sp *model.SAMLServiceProvider) *dto.SAMLServiceProviderResponse {
+	return &dto.SAMLServiceProviderResponse{
+		ID:            sp.ID.String(),
+		EntityID:      sp.EntityID,
+		Name:          sp.Name,
+		ACSURL:        sp.ACSURL,
+		NameIDFormat:  sp.NameIDFormat,
+		RoleAttribute: sp.RoleAttribute,
+		Tenant:        sp.Tenant,
+		CreatedAt:     sp.CreatedAt,
+	}
+}
+
+// validACSURL accepts https URLs, and http only on loopback hosts (development)
+func validACSURL(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
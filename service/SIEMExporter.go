@@ -0,0 +1,305 @@
+package service
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mein-idaas/model"
+)
+
+// SIEM message formats
+const (
+	SIEMFormatRFC5424 = "rfc5424" // JSON payload in an RFC 5424 syslog message with structured data
+	SIEMFormatCEF     = "cef"     // ArcSight Common Event Format (Splunk, ArcSight)
+	SIEMFormatLEEF    = "leef"    // Log Event Extended Format 1.0 (QRadar)
+)
+
+const (
+	siemVendor  = "mein-idaas"
+	siemProduct = "mein-idaas"
+	siemVersion = "1.0"
+
+	// syslog facility authpriv (10)
+	siemFacility = 10
+
+	siemMaxBackoff = 30 * time.Second
+)
+
+// siemSecurityEvents are logged with a higher severity (account security changes)
+var siemSecurityEvents = map[string]bool{
+	model.EventUserPasswordChanged:        true,
+	model.EventUserPasswordReset:          true,
+	model.EventUserMFAEnabled:             true,
+	model.EventUserMFAResetRequested:      true,
+	model.EventUserMFAReset:               true,
+	model.EventUserIdentityLinked:         true,
+	model.EventUserIdentityUnlinked:       true,
+	model.EventUserLocked:                 true,
+	model.EventUserSessionsRevoked:        true,
+	model.EventUserTokenReuse:             true,
+	model.EventUserSessionBindingMismatch: true,
+}
+
+// SIEMExporter streams identity/security events to a SIEM over syslog
+// Events are buffered in memory and written by a single background connection that
+// reconnects with exponential backoff. When the buffer is full, HandleEvent fails so
+// the outbox dispatcher retries the event later instead of dropping it.
+// Environment variables:
+// - SIEM_ADDR: host:port of the syslog receiver (export disabled when empty)
+// - SIEM_PROTOCOL: tcp (default), tls or udp
+// - SIEM_FORMAT: rfc5424 (default), cef or leef
+// - SIEM_BUFFER_SIZE: max buffered messages (default: 10000)
+type SIEMExporter struct {
+	addr     string
+	protocol string
+	format   string
+	hostname string
+	queue    chan []byte
+}
+
+func NewSIEMExporter() *SIEMExporter {
+	protocol := strings.ToLower(os.Getenv("SIEM_PROTOCOL"))
+	switch protocol {
+	case "tcp", "tls", "udp":
+	case "":
+		protocol = "tcp"
+	default:
+		log.Printf("warning: invalid SIEM_PROTOCOL value '%s', using default tcp", protocol)
+		protocol = "tcp"
+	}
+
+	format := strings.ToLower(os.Getenv("SIEM_FORMAT"))
+	switch format {
+	case SIEMFormatRFC5424, SIEMFormatCEF, SIEMFormatLEEF:
+	case "":
+		format = SIEMFormatRFC5424
+	default:
+		log.Printf("warning: invalid SIEM_FORMAT value '%s', using default %s", format, SIEMFormatRFC5424)
+		format = SIEMFormatRFC5424
+	}
+
+	bufferSize, err := strconv.Atoi(os.Getenv("SIEM_BUFFER_SIZE"))
+	if err != nil || bufferSize <= 0 {
+		bufferSize = 10000
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &SIEMExporter{
+		addr:     os.Getenv("SIEM_ADDR"),
+		protocol: protocol,
+		format:   format,
+		hostname: hostname,
+		queue:    make(chan []byte, bufferSize),
+	}
+}
+
+// Enabled reports whether a SIEM receiver is configured
+func (s *SIEMExporter) Enabled() bool {
+	return s.addr != ""
+}
+
+// Buffered returns the number of messages waiting to be written
+func (s *SIEMExporter) Buffered() int {
+	return len(s.queue)
+}
+
+// HandleEvent is the outbox handler formatting an event and queueing it for the SIEM
+func (s *SIEMExporter) HandleEvent(event *model.OutboxEvent) error {
+	msg, err := s.formatEvent(event)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.queue <- msg:
+		return nil
+	default:
+		return errors.New("siem buffer full")
+	}
+}
+
+// Start runs the writer loop in the background
+func (s *SIEMExporter) Start() {
+	go func() {
+		var conn net.Conn
+		backoff := time.Second
+
+		for msg := range s.queue {
+			// Keep the message until it is written: reconnect and retry on failure
+			for {
+				if conn == nil {
+					c, err := s.dial()
+					if err != nil {
+						log.Printf("[SIEM] failed to connect to %s (retry in %v): %v", s.addr, backoff, err)
+						time.Sleep(backoff)
+						backoff = min(backoff*2, siemMaxBackoff)
+						continue
+					}
+					conn = c
+					backoff = time.Second
+					log.Printf("[SIEM] connected to %s (%s, %s)", s.addr, s.protocol, s.format)
+				}
+
+				if err := s.write(conn, msg); err != nil {
+					log.Printf("[SIEM] write to %s failed, reconnecting: %v", s.addr, err)
+					conn.Close()
+					conn = nil
+					continue
+				}
+				break
+			}
+		}
+	}()
+}
+
+func (s *SIEMExporter) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	switch s.protocol {
+	case "tls":
+		return tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{MinVersion: tls.VersionTLS12})
+	case "udp":
+		return dialer.Dial("udp", s.addr)
+	default:
+		return dialer.Dial("tcp", s.addr)
+	}
+}
+
+// write sends one message: a datagram over UDP, newline-delimited over TCP/TLS
+func (s *SIEMExporter) write(conn net.Conn, msg []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	if s.protocol != "udp" {
+		msg = append(msg, '\n')
+	}
+	_, err := conn.Write(msg)
+	return err
+}
+
+// formatEvent renders the event as an RFC 5424 syslog message with a format-specific body
+func (s *SIEMExporter) formatEvent(event *model.OutboxEvent) ([]byte, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+		return nil, err
+	}
+
+	// Flatten the payload to strings, sorted for a stable field order
+	fields := make(map[string]string, len(data))
+	var keys []string
+	for k, v := range data {
+		if str, ok := v.(string); ok {
+			fields[k] = str
+		} else {
+			raw, _ := json.Marshal(v)
+			fields[k] = string(raw)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	severity, cefSeverity := 6, 3 // informational
+	if siemSecurityEvents[event.Type] {
+		severity, cefSeverity = 5, 6 // notice
+	}
+
+	var body, structured string
+	switch s.format {
+	case SIEMFormatCEF:
+		ext := []string{
+			"rt=" + strconv.FormatInt(event.CreatedAt.UnixMilli(), 10),
+			"externalId=" + cefExtensionEscape(event.ID.String()),
+		}
+		for _, k := range keys {
+			ext = append(ext, cefKey(k)+"="+cefExtensionEscape(fields[k]))
+		}
+		body = fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+			cefHeaderEscape(siemVendor), cefHeaderEscape(siemProduct), siemVersion,
+			cefHeaderEscape(event.Type), cefHeaderEscape(event.Type), cefSeverity, strings.Join(ext, " "))
+		structured = "-"
+	case SIEMFormatLEEF:
+		attrs := []string{
+			"cat=" + leefEscape(event.Type),
+			"devTime=" + event.CreatedAt.UTC().Format("Jan 02 2006 15:04:05"),
+			"eventId=" + event.ID.String(),
+		}
+		for _, k := range keys {
+			attrs = append(attrs, leefKey(k)+"="+leefEscape(fields[k]))
+		}
+		body = fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s", siemVendor, siemProduct, siemVersion, leefEscape(event.Type), strings.Join(attrs, "\t"))
+		structured = "-"
+	default:
+		params := []string{`event_id="` + sdEscape(event.ID.String()) + `"`}
+		for _, k := range keys {
+			params = append(params, sdName(k)+`="`+sdEscape(fields[k])+`"`)
+		}
+		structured = "[idaas@32473 " + strings.Join(params, " ") + "]"
+		body = event.Payload
+	}
+
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	msg := fmt.Sprintf("<%d>1 %s %s %s - %s %s %s",
+		siemFacility*8+severity,
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		s.hostname, siemProduct, event.Type, structured, body)
+	return []byte(msg), nil
+}
+
+// cefKeys maps payload fields to CEF dictionary keys
+var cefKeys = map[string]string{"user_id": "duid", "email": "suser", "name": "duser"}
+
+func cefKey(k string) string {
+	if mapped, ok := cefKeys[k]; ok {
+		return mapped
+	}
+	return sdName(k)
+}
+
+// leefKeys maps payload fields to LEEF predefined attributes
+var leefKeys = map[string]string{"email": "usrName", "user_id": "userId"}
+
+func leefKey(k string) string {
+	if mapped, ok := leefKeys[k]; ok {
+		return mapped
+	}
+	return sdName(k)
+}
+
+func cefHeaderEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(v)
+}
+
+func cefExtensionEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(v)
+}
+
+func leefEscape(v string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ", "|", " ").Replace(v)
+}
+
+// sdEscape escapes an RFC 5424 structured data parameter value
+func sdEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+// sdName keeps only characters allowed in RFC 5424 parameter names
+func sdName(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= 32 || r >= 127 || r == '=' || r == ']' || r == '"' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, k)
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMSService sends one-time codes by text message
+type SMSService interface {
+	SendOTP(toPhone string, code string) error
+}
+
+// NewSMSService returns the provider selected by SMS_PROVIDER
+// Environment variables:
+// - SMS_PROVIDER: "twilio" (default) or "vonage"
+// - TWILIO_ACCOUNT_SID / TWILIO_AUTH_TOKEN: Twilio API credentials
+// - VONAGE_API_KEY / VONAGE_API_SECRET: Vonage (Nexmo) API credentials
+// - SMS_FROM: sender number (E.164) or alphanumeric sender ID (Vonage)
+// Without credentials, messages are only logged outside production (ENV != production)
+func NewSMSService() SMSService {
+	client := &http.Client{Timeout: 10 * time.Second}
+	from := os.Getenv("SMS_FROM")
+
+	switch provider := strings.ToLower(os.Getenv("SMS_PROVIDER")); provider {
+	case "", "twilio":
+	case "vonage":
+		return &vonageSMS{
+			apiKey:    os.Getenv("VONAGE_API_KEY"),
+			apiSecret: os.Getenv("VONAGE_API_SECRET"),
+			from:      from,
+			client:    client,
+		}
+	default:
+		log.Printf("warning: unknown SMS_PROVIDER '%s', using twilio", provider)
+	}
+
+	return &twilioSMS{
+		accountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+		authToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+		from:       from,
+		client:     client,
+	}
+}
+
+func otpMessage(code string) string {
+	return fmt.Sprintf("Your verification code is %s. It expires in 5 minutes.", code)
+}
+
+// logUnconfiguredSMS stands in for the provider in development; production refuses to run without one
+func logUnconfiguredSMS(toPhone string, message string) error {
+	if os.Getenv("ENV") == "production" {
+		return fmt.Errorf("sms provider not configured")
+	}
+	log.Printf("[SMS] (not configured, dev mode) to %s: %s", toPhone, message)
+	return nil
+}
+
+// twilioSMS sends text messages through the Twilio REST API
+type twilioSMS struct {
+	accountSID string
+	authToken  string
+	from       string
+	client     *http.Client
+}
+
+// SendOTP sends the 6-digit code to the phone number
+func (s *twilioSMS) SendOTP(toPhone string, code string) error {
+	return s.send(toPhone, otpMessage(code))
+}
+
+func (s *twilioSMS) send(toPhone string, message string) error {
+	if s.accountSID == "" || s.authToken == "" {
+		return logUnconfiguredSMS(toPhone, message)
+	}
+
+	form := url.Values{}
+	form.Set("To", toPhone)
+	form.Set("From", s.from)
+	form.Set("Body", message)
+
+	endpoint := "https://api.twilio.com/2010-04-01/Accounts/" + url.PathEscape(s.accountSID) + "/Messages.json"
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.accountSID, s.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sms provider returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// vonageSMS sends text messages through the Vonage SMS API
+type vonageSMS struct {
+	apiKey    string
+	apiSecret string
+	from      string
+	client    *http.Client
+}
+
+// SendOTP sends the 6-digit code to the phone number
+func (s *vonageSMS) SendOTP(toPhone string, code string) error {
+	return s.send(toPhone, otpMessage(code))
+}
+
+func (s *vonageSMS) send(toPhone string, message string) error {
+	if s.apiKey == "" || s.apiSecret == "" {
+		return logUnconfiguredSMS(toPhone, message)
+	}
+
+	// Vonage expects numbers in international format without the leading +
+	form := url.Values{}
+	form.Set("api_key", s.apiKey)
+	form.Set("api_secret", s.apiSecret)
+	form.Set("to", strings.TrimPrefix(toPhone, "+"))
+	form.Set("from", strings.TrimPrefix(s.from, "+"))
+	form.Set("text", message)
+
+	req, err := http.NewRequest(http.MethodPost, "https://rest.nexmo.com/sms/json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sms provider returned %d", resp.StatusCode)
+	}
+
+	// The API answers 200 and reports failures per message part ("0" is success)
+	var result struct {
+		Messages []struct {
+			Status    string `json:"status"`
+			ErrorText string `json:"error-text"`
+		} `json:"messages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	for _, m := range result.Messages {
+		if m.Status != "0" {
+			return fmt.Errorf("sms provider rejected message: status %s (%s)", m.Status, m.ErrorText)
+		}
+	}
+	return nil
+}
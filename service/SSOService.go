@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"mein-idaas/model"
+	"mein-idaas/repository"
+	"mein-idaas/util"
+)
+
+const (
+	// ssoMFATimeout is how long a password-checked session may wait for its second factor
+	ssoMFATimeout = 5 * time.Minute
+	// ssoMaxMFAFailures revokes a pending session after this many wrong codes
+	ssoMaxMFAFailures = 5
+)
+
+// SSOService manages browser sessions of the hosted login pages
+// Environment variables:
+// - SSO_SESSION_TTL: lifetime of a signed-in browser session (default: 12h)
+type SSOService struct {
+	authSvc     *AuthService
+	sessionRepo repository.SSOSessionRepository
+	sms         SMSService
+	email       *EmailService
+	ttl         time.Duration
+}
+
+func NewSSOService(authSvc *AuthService, sessions repository.SSOSessionRepository) *SSOService {
+	return &SSOService{
+		authSvc:     authSvc,
+		sessionRepo: sessions,
+		sms:         NewSMSService(),
+		email:       NewEmailService(),
+		ttl:         envDuration("SSO_SESSION_TTL", 12*time.Hour),
+	}
+}
+
+// SessionTTL returns the lifetime of a signed-in session (used for the cookie expiry)
+func (s *SSOService) SessionTTL() time.Duration {
+	return s.ttl
+}
+
+// Login checks the password and starts a browser session
+// Returns the session token for the cookie; for users with MFA the session is pending until CompleteMFA
+func (s *SSOService) Login(ctx context.Context, email, password, clientIP, userAgent string) (string, *model.SSOSession, error) {
+	user, err := s.authSvc.AuthenticatePassword(ctx, email, password, clientIP)
+	if err != nil {
+		return "", nil, err
+	}
+	// The hosted pages can't change passwords; the user has to go through the app's login first
+	if s.authSvc.PasswordExpired(user) {
+		return "", nil, errors.New("password expired")
+	}
+	if mustChangePassword(user) {
+		return "", nil, errors.New("password change required")
+	}
+
+	token, err := util.GenerateSecureToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	session := &model.SSOSession{
+		UserID:     user.ID,
+		TokenHash:  util.HashToken(token),
+		MFAPending: mfaRequired(user),
+		AuthTime:   now,
+		ClientIP:   clientIP,
+		UserAgent:  userAgent,
+		ExpiresAt:  now.Add(s.ttl),
+	}
+	if session.MFAPending {
+		session.ExpiresAt = now.Add(ssoMFATimeout)
+	}
+
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return "", nil, err
+	}
+
+	// Users without an authenticator get their code by SMS (or email) right away
+	if session.MFAPending && !user.IsMFAEnabled && s.authSvc.verificationSvc != nil {
+		var err error
+		if usesSMSFactor(user) {
+			err = s.authSvc.sendMFASMS(user, ssoMFAKey(session), s.sms)
+		} else {
+			err = s.authSvc.sendMFAEmail(user, ssoMFAKey(session), s.email)
+		}
+		if err != nil {
+			log.Printf("failed to send SSO MFA code to %s: %v", user.Email, err)
+		}
+	}
+	return token, session, nil
+}
+
+// ssoMFAKey is the verification storage key of the SMS code of a pending session
+func ssoMFAKey(session *model.SSOSession) string {
+	return "sso_mfa:" + session.ID.String()
+}
+
+// CompleteMFA checks the TOTP, SMS or email code of a pending session and signs it in
+func (s *SSOService) CompleteMFA(ctx context.Context, token string, code string) error {
+	session, err := s.sessionRepo.GetActive(ctx, util.HashToken(token))
+	if err != nil {
+		return errors.New("session expired")
+	}
+	if !session.MFAPending {
+		return nil
+	}
+
+	user, err := s.authSvc.GetUserByID(ctx, session.UserID.String())
+	if err != nil {
+		return errors.New("session expired")
+	}
+	// Shares the account's backoff with /auth/mfa/verify
+	keys := backoffKeys(user.ID.String(), session.ClientIP)
+	if err := s.authSvc.checkBackoff(keys); err != nil {
+		return err
+	}
+
+	factor, err := s.authSvc.checkSecondFactor(ctx, user, ssoMFAKey(session), code)
+	if err != nil {
+		return err
+	}
+	if factor == "" {
+		s.authSvc.finishBackoff(keys, errors.New("invalid MFA code"), "invalid MFA code")
+		failures, err := s.sessionRepo.RecordMFAFailure(ctx, session.ID)
+		if err != nil {
+			return err
+		}
+		if failures >= ssoMaxMFAFailures {
+			log.Printf("too many MFA failures for %s, ending SSO session", user.Email)
+			if err := s.sessionRepo.Revoke(ctx, session.ID); err != nil {
+				return err
+			}
+			return errors.New("session expired")
+		}
+		return errors.New("invalid MFA code")
+	}
+
+	s.authSvc.finishBackoff(keys, nil)
+	s.authSvc.cancelMFAReset(ctx, user)
+	return s.sessionRepo.CompleteMFA(ctx, session.ID, time.Now().Add(s.ttl))
+}
+
+// PendingMFA reports whether token belongs to a session waiting for its second factor
+func (s *SSOService) PendingMFA(ctx context.Context, token string) bool {
+	session, err := s.sessionRepo.GetActive(ctx, util.HashToken(token))
+	return err == nil && session.MFAPending
+}
+
+// GetSession returns the signed-in session for token and its user
+func (s *SSOService) GetSession(ctx context.Context, token string) (*model.SSOSession, *model.User, error) {
+	if token == "" {
+		return nil, nil, errors.New("no session")
+	}
+
+	session, err := s.sessionRepo.GetActive(ctx, util.HashToken(token))
+	if err != nil || session.MFAPending {
+		return nil, nil, errors.New("no session")
+	}
+
+	// A session of another organization doesn't sign the user in here
+	user, err := s.authSvc.GetUserByID(ctx, session.UserID.String())
+	if err != nil || !inCurrentTenant(ctx, user) {
+		return nil, nil, errors.New("no session")
+	}
+	return session, user, nil
+}
+
+// Logout ends the session for token (no-op when it doesn't exist)
+func (s *SSOService) Logout(ctx context.Context, token string) error {
+	if token == "" {
+		return nil
+	}
+	session, err := s.sessionRepo.GetActive(ctx, util.HashToken(token))
+	if err != nil {
+		return nil
+	}
+	return s.sessionRepo.Revoke(ctx, session.ID)
+}
@@ -1,12 +1,18 @@
 package service
 
 import (
+	"encoding/json"
 	"errors"
 	"log"
+	"net/url"
 
+	"mein-idaas/dto"
+	"mein-idaas/model"
 	"mein-idaas/repository"
 	"mein-idaas/util"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type VerificationService struct {
@@ -23,7 +29,8 @@ func NewVerificationService(repo repository.VerificationRepository, emailService
 }
 
 // SendVerificationCode orchestrates the entire flow
-func (s *VerificationService) SendVerificationCode(userID string, email string) error {
+// tenant is the user's organization, for the one-click verification link
+func (s *VerificationService) SendVerificationCode(userID string, email string, tenant string) error {
 	// 1. Generate 6-digit Code
 	code := util.GenerateRandomDigits(6)
 
@@ -35,8 +42,9 @@ func (s *VerificationService) SendVerificationCode(userID string, email string)
 	}
 
 	// 3. Send Email (Run in background so API is fast)
+	link := verificationLink(userID, tenant)
 	go func() {
-		if err := s.emailService.SendOTP(email, code); err != nil {
+		if err := s.emailService.SendOTP(email, code, link); err != nil {
 			log.Printf("Failed to send OTP to %s: %v", email, err)
 			return
 		}
@@ -46,6 +54,54 @@ func (s *VerificationService) SendVerificationCode(userID string, email string)
 	return nil
 }
 
+// DeliverVerificationCode generates, stores and emails a code synchronously
+// Used by the outbox dispatcher, which needs to know whether delivery succeeded to retry
+func (s *VerificationService) DeliverVerificationCode(userID string, email string, tenant string) error {
+	code := util.GenerateRandomDigits(6)
+
+	if err := s.repo.Save(userID, code, 5*time.Minute); err != nil {
+		return err
+	}
+
+	if err := s.emailService.SendOTP(email, code, verificationLink(userID, tenant)); err != nil {
+		return err
+	}
+	log.Printf("OTP sent successfully to %s", email)
+	return nil
+}
+
+// HandleUserRegistered is the outbox handler sending the initial verification email
+func (s *VerificationService) HandleUserRegistered(event *model.OutboxEvent) error {
+	var payload dto.UserRegisteredEvent
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		return err
+	}
+	// Social sign-ups arrive with an email the provider verified
+	if payload.Provider != "" {
+		return nil
+	}
+	return s.DeliverVerificationCode(payload.UserID, payload.Email, payload.Tenant)
+}
+
+// verificationLink returns the one-click link verifying the user's email (GET /auth/verify/link),
+// "" when ISSUER_BASE_URL is not set: the API's public URL isn't known then and only the code is sent
+func verificationLink(userID string, tenant string) string {
+	base := util.GetIssuerBaseURL()
+	uid, err := uuid.Parse(userID)
+	if base == "" || err != nil {
+		return ""
+	}
+	token, err := util.GenerateActionToken(uid, tenant, util.ActionVerifyEmail)
+	if err != nil {
+		log.Printf("failed to create verification link for user %s: %v", userID, err)
+		return ""
+	}
+	if tenant != "" {
+		base += "/t/" + tenant
+	}
+	return base + "/api/v1/auth/verify/link?token=" + url.QueryEscape(token)
+}
+
 func (s *VerificationService) SendPasswordChangeCode(userID string, email string) error {
 	// 1. Generate 6-digit Code
 	code := util.GenerateRandomDigits(6)
@@ -89,6 +145,17 @@ func (s *VerificationService) VerifyCode(userID string, inputCode string) error
 	return nil
 }
 
+// HasPendingCode reports whether an unexpired code is stored under the key
+func (s *VerificationService) HasPendingCode(key string) bool {
+	_, err := s.repo.Get(key)
+	return err == nil
+}
+
+// GetCode returns the code stored under the key, or an error when it is missing or expired
+func (s *VerificationService) GetCode(key string) (string, error) {
+	return s.repo.Get(key)
+}
+
 // StoreCode stores a verification code with a custom TTL
 func (s *VerificationService) StoreCode(key string, code string, ttl time.Duration) error {
 	return s.repo.Save(key, code, ttl)
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"mein-idaas/model"
+)
+
+// WebhookService posts identity events to the configured endpoints
+// Environment variables:
+// - WEBHOOK_URLS: comma-separated list of receiver URLs (webhooks disabled when empty)
+// - WEBHOOK_SECRET: HMAC-SHA256 key used to sign the request body (X-Webhook-Signature)
+type WebhookService struct {
+	urls   []string
+	secret string
+	client *http.Client
+}
+
+// webhookEnvelope is the JSON body delivered to receivers
+type webhookEnvelope struct {
+	ID        string          `json:"id"` // Stable across retries: receivers dedupe on it
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func NewWebhookService() *WebhookService {
+	var urls []string
+	for _, u := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	return &WebhookService{
+		urls:   urls,
+		secret: os.Getenv("WEBHOOK_SECRET"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Enabled reports whether any webhook receiver is configured
+func (s *WebhookService) Enabled() bool {
+	return len(s.urls) > 0
+}
+
+// HandleEvent is the outbox handler delivering an event to every receiver
+// Any non-2xx response fails the delivery so the dispatcher retries it
+func (s *WebhookService) HandleEvent(event *model.OutboxEvent) error {
+	body, err := json.Marshal(webhookEnvelope{
+		ID:        event.ID.String(),
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		Data:      json.RawMessage(event.Payload),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, url := range s.urls {
+		if err := s.post(url, event, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WebhookService) post(url string, event *model.OutboxEvent, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", event.ID.String())
+	req.Header.Set("X-Webhook-Event", event.Type)
+
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s: unexpected status %d", url, resp.StatusCode)
+	}
+	return nil
+}
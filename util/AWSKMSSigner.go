@@ -0,0 +1,189 @@
+package util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// awsKMSSigner signs with an asymmetric AWS KMS key (JWT_SIGNER=awskms)
+// Supported key specs: RSA_2048/3072/4096 (RS256) and ECC_NIST_P256 (ES256).
+// Environment variables:
+// - AWS_KMS_KEY_ID: key ID, ARN or alias ARN
+// - AWS_REGION (or AWS_DEFAULT_REGION): region of the key
+// - AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN: credentials allowed kms:Sign and kms:GetPublicKey
+// - AWS_KMS_ENDPOINT: custom endpoint, e.g. for LocalStack (optional)
+type awsKMSSigner struct {
+	keyID        string
+	region       string
+	endpoint     string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	algorithm    string // KMS SigningAlgorithm
+	public       crypto.PublicKey
+}
+
+func newAWSKMSSigner() (crypto.Signer, error) {
+	s := &awsKMSSigner{
+		keyID:        getEnv("AWS_KMS_KEY_ID", ""),
+		region:       getEnv("AWS_REGION", getEnv("AWS_DEFAULT_REGION", "")),
+		endpoint:     strings.TrimRight(getEnv("AWS_KMS_ENDPOINT", ""), "/"),
+		accessKey:    getEnv("AWS_ACCESS_KEY_ID", ""),
+		secretKey:    getEnv("AWS_SECRET_ACCESS_KEY", ""),
+		sessionToken: getEnv("AWS_SESSION_TOKEN", ""),
+	}
+	if s.keyID == "" || s.region == "" || s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("JWT_SIGNER=awskms requires AWS_KMS_KEY_ID, AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://kms." + s.region + ".amazonaws.com"
+	}
+
+	switch signingMethod.Alg() {
+	case "RS256":
+		s.algorithm = "RSASSA_PKCS1_V1_5_SHA_256"
+	case "ES256":
+		s.algorithm = "ECDSA_SHA_256"
+	default:
+		return nil, fmt.Errorf("AWS KMS does not support %s signing, use RS256 or ES256", signingMethod.Alg())
+	}
+
+	var res struct {
+		PublicKey         string   `json:"PublicKey"` // base64 PKIX DER
+		SigningAlgorithms []string `json:"SigningAlgorithms"`
+	}
+	if err := s.call("GetPublicKey", map[string]string{"KeyId": s.keyID}, &res); err != nil {
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(res.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if s.public, err = x509.ParsePKIXPublicKey(der); err != nil {
+		return nil, err
+	}
+
+	supported := false
+	for _, alg := range res.SigningAlgorithms {
+		supported = supported || alg == s.algorithm
+	}
+	if !supported {
+		return nil, fmt.Errorf("AWS KMS key %s does not support %s", s.keyID, s.algorithm)
+	}
+	return s, nil
+}
+
+func (s *awsKMSSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *awsKMSSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if err := checkDigest(digest, opts); err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Signature string `json:"Signature"` // base64; DER for ECDSA
+	}
+	if err := s.call("Sign", map[string]string{
+		"KeyId":            s.keyID,
+		"Message":          b64std(digest),
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": s.algorithm,
+	}, &res); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(res.Signature)
+}
+
+// call invokes a KMS JSON API action, signed with AWS Signature Version 4
+func (s *awsKMSSigner) call(action string, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	s.signRequest(req, payload, time.Now().UTC())
+
+	resp, err := remoteSignerClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("AWS KMS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("AWS KMS %s: status %d: %s", action, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// signRequest adds the SigV4 Authorization header (service "kms")
+func (s *awsKMSSigner) signRequest(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	u, _ := url.Parse(s.endpoint)
+	headers := map[string]string{
+		"content-type": req.Header.Get("Content-Type"),
+		"host":         u.Host,
+		"x-amz-date":   amzDate,
+		"x-amz-target": req.Header.Get("X-Amz-Target"),
+	}
+	names := []string{"content-type", "host", "x-amz-date"}
+	if s.sessionToken != "" {
+		headers["x-amz-security-token"] = s.sessionToken
+		names = append(names, "x-amz-security-token")
+	}
+	names = append(names, "x-amz-target") // names stay sorted
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		http.MethodPost, "/", "", canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.region + "/kms/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "kms")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Purposes of action tokens; a token is only accepted for the purpose it was minted for
+const (
+	ActionVerifyEmail   = "verify_email"
+	ActionResetPassword = "reset_password"
+)
+
+// How long emailed action links stay valid (ACTION_LINK_TTL, default 30m)
+var actionTokenTTL = parseTokenTTL("ACTION_LINK_TTL", 30*time.Minute)
+
+// GetActionTokenTTL returns the lifetime of action tokens (ACTION_LINK_TTL)
+func GetActionTokenTTL() time.Duration {
+	return actionTokenTTL
+}
+
+// actionClaims are the claims of a single-purpose action token (email verification and password reset links)
+// They have no audience and a purpose claim, so they are never accepted as access or refresh tokens.
+type actionClaims struct {
+	Purpose string `json:"purpose"`
+	Tenant  string `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateActionToken mints a short-lived signed token that lets the holder perform one action for the user
+// It is valid for ACTION_LINK_TTL and can be redeemed once (ActionToken.Consume).
+func GenerateActionToken(userID uuid.UUID, tenant string, purpose string) (string, error) {
+	now := time.Now()
+	claims := actionClaims{
+		Purpose: purpose,
+		Tenant:  tenant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(actionTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    TenantIssuer(tenant),
+			ID:        uuid.NewString(),
+		},
+	}
+	return signClaims(tenant, claims)
+}
+
+// ActionToken is a validated action token
+type ActionToken struct {
+	ID        string
+	UserID    uuid.UUID
+	Tenant    string // Tenant the token was minted for ("" for the global tenant)
+	ExpiresAt time.Time
+}
+
+// ParseActionToken validates an action token for purpose without redeeming it
+func ParseActionToken(tokenString string, purpose string) (*ActionToken, error) {
+	invalid := errors.New("invalid or expired link")
+
+	claims := &actionClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
+	if err != nil || !token.Valid || claims.Purpose != purpose || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil, invalid
+	}
+	if err := checkTenantClaims(claims.Tenant, claims.Issuer); err != nil {
+		return nil, invalid
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, invalid
+	}
+	return &ActionToken{ID: claims.ID, UserID: userID, Tenant: claims.Tenant, ExpiresAt: claims.ExpiresAt.Time}, nil
+}
+
+// Consume redeems the token; it fails if the token was used before
+// Without a replay check (SetReplayCheck) a token can be redeemed until it expires.
+func (t *ActionToken) Consume(ctx context.Context) error {
+	if replayCheck == nil {
+		return nil
+	}
+	fresh, err := replayCheck(ctx, "action:"+t.ID, t.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return errors.New("invalid or expired link")
+	}
+	return nil
+}
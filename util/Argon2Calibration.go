@@ -0,0 +1,84 @@
+package util
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2CalibrationMaxTime = 10 // upper bound for iterations picked by calibration
+	argon2CalibrationMinMem  = 16 * 1024
+)
+
+// calibrateArgon2Params benchmarks the host and raises argon2Memory first, then argon2Time,
+// until a single hash takes at least ARGON2_TARGET_DURATION (default 250ms).
+// Parameters explicitly set via ARGON2_TIME / ARGON2_MEMORY are kept as-is.
+func calibrateArgon2Params() {
+	target := getEnvDuration("ARGON2_TARGET_DURATION", 250*time.Millisecond)
+	maxMemory := uint32(getEnvInt("ARGON2_MAX_MEMORY", 1024*1024)) // KB (default 1GB)
+	timeFixed := os.Getenv("ARGON2_TIME") != ""
+	memoryFixed := os.Getenv("ARGON2_MEMORY") != ""
+
+	if timeFixed && memoryFixed {
+		fmt.Println("[ARGON2] Calibration skipped: ARGON2_TIME and ARGON2_MEMORY are both set explicitly")
+		return
+	}
+
+	t, m := argon2Time, argon2Memory
+	if !timeFixed {
+		t = 1
+	}
+	if !memoryFixed && m < argon2CalibrationMinMem {
+		m = argon2CalibrationMinMem
+	}
+
+	elapsed := measureArgon2(t, m)
+
+	// Memory is the preferred cost factor (GPU/ASIC resistance), so grow it first
+	if !memoryFixed {
+		for elapsed < target && m*2 <= maxMemory {
+			next := measureArgon2(t, m*2)
+			if next > target*2 {
+				break // doubling would overshoot the target by too much
+			}
+			m *= 2
+			elapsed = next
+		}
+	}
+
+	// Then add iterations until the target is reached
+	if !timeFixed {
+		for elapsed < target && t < argon2CalibrationMaxTime {
+			t++
+			elapsed = measureArgon2(t, m)
+		}
+	}
+
+	argon2Time, argon2Memory = t, m
+	fmt.Printf("[ARGON2] Calibrated for target %v: time=%d, memory=%dKB, threads=%d (measured %v)\n",
+		target, argon2Time, argon2Memory, argon2Threads, elapsed.Round(time.Millisecond))
+}
+
+// measureArgon2 returns the duration of a single hash with the given cost parameters
+func measureArgon2(t, m uint32) time.Duration {
+	salt := make([]byte, argon2SaltLen)
+	_, _ = rand.Read(salt)
+
+	start := time.Now()
+	argon2.IDKey([]byte("argon2-calibration"), salt, t, m, argon2Threads, argon2KeyLength)
+	return time.Since(start)
+}
+
+// argon2CalibrationEnabled reports whether ARGON2_CALIBRATE is turned on
+func argon2CalibrationEnabled() bool {
+	switch strings.ToLower(os.Getenv("ARGON2_CALIBRATE")) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
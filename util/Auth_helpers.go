@@ -9,8 +9,6 @@ import (
 	"os"
 	"strconv"
 	"strings"
-
-	"golang.org/x/crypto/argon2"
 )
 
 // Argon2 parameters for password hashing - with environment variable support
@@ -92,50 +90,16 @@ func InitArgon2Params() {
 		}
 	}
 
+	// Optionally tune time/memory to this host (explicit env values win)
+	if argon2CalibrationEnabled() {
+		calibrateArgon2Params()
+	}
+
 	// Log the initialized parameters
 	fmt.Printf("[ARGON2] Initialized with: time=%d, memory=%dKB, threads=%d, keylen=%d, saltlen=%d\n",
 		argon2Time, argon2Memory, argon2Threads, argon2KeyLength, argon2SaltLen)
 }
 
-// HashPassword hashes a plaintext password using argon2 with current global parameters
-func HashPassword(password string) (string, error) {
-	if password == "" {
-		return "", errors.New("empty password")
-	}
-
-	// Generate random salt
-	salt, err := generateSalt(argon2SaltLen)
-	if err != nil {
-		return "", err
-	}
-
-	// Hash password with current global argon2 parameters
-	hash := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLength)
-
-	// Combine salt and hash for storage with current parameters
-	return encodeArgon2Hash(salt, hash), nil
-}
-
-// ComparePassword compares argon2 hashed password with plaintext
-// CRITICAL: Uses parameters extracted from the stored hash, NOT global variables
-func ComparePassword(hashed, plain string) error {
-	salt, hash, params, err := decodeArgon2Hash(hashed)
-	if err != nil {
-		return err
-	}
-
-	// Hash the provided password with the STORED parameters from the hash
-	// This ensures users can always login even if global parameters change
-	computedHash := argon2.IDKey([]byte(plain), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
-
-	// Compare hashes
-	if !constantTimeCompare(hash, computedHash) {
-		return errors.New("invalid password")
-	}
-
-	return nil
-}
-
 // HashToken returns a SHA256 hex of the token string for safe DB storage
 func HashToken(token string) string {
 	h := sha256.Sum256([]byte(token))
@@ -189,6 +153,8 @@ func decodeArgon2Hash(encoded string) ([]byte, []byte, *Argon2Params, error) {
 	if err != nil {
 		return nil, nil, nil, errors.New("invalid hash encoding")
 	}
+	// The key length isn't encoded in the parameters, it is the length of the stored hash
+	params.KeyLength = uint32(len(hash))
 
 	return salt, hash, params, nil
 }
@@ -196,9 +162,7 @@ func decodeArgon2Hash(encoded string) ([]byte, []byte, *Argon2Params, error) {
 // parseArgon2ParamString parses the parameter string from hash format
 // Format: m=65536,t=3,p=4
 func parseArgon2ParamString(paramStr string) (*Argon2Params, error) {
-	params := &Argon2Params{
-		KeyLength: 32, // Default keylen
-	}
+	params := &Argon2Params{}
 
 	pairs := strings.Split(paramStr, ",")
 	for _, pair := range pairs {
@@ -0,0 +1,58 @@
+package util
+
+import (
+	"context"
+	"log"
+
+	"mein-idaas/dto"
+)
+
+// ClaimsMapper adds deployment-specific claims to access tokens (plan level, customer ID, user attributes, ...)
+// Mappers run for every access token issued by a login or refresh, in registration order; claims is the
+// token being built (subject, roles, tenant, scope are set). An error fails the token issuance.
+type ClaimsMapper interface {
+	MapClaims(ctx context.Context, claims *dto.AuthClaims) (map[string]interface{}, error)
+}
+
+// ClaimsMapperFunc adapts a function to ClaimsMapper
+type ClaimsMapperFunc func(ctx context.Context, claims *dto.AuthClaims) (map[string]interface{}, error)
+
+func (f ClaimsMapperFunc) MapClaims(ctx context.Context, claims *dto.AuthClaims) (map[string]interface{}, error) {
+	return f(ctx, claims)
+}
+
+// reservedClaims can't be set by mappers: they carry the token's identity and security decisions
+var reservedClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+	"roles": true, "permissions": true, "tenant": true, "scope": true, "act": true, "auth_time": true, "amr": true,
+	"acr": true, "token_version": true, "at_hash": true, "azp": true, "nonce": true,
+	"cnf": true,
+}
+
+var claimsMappers []ClaimsMapper
+
+// RegisterClaimsMapper adds a mapper to every access token issued from now on (call at startup)
+func RegisterClaimsMapper(m ClaimsMapper) {
+	claimsMappers = append(claimsMappers, m)
+}
+
+// applyClaimsMappers runs the registered mappers and stores their claims in claims.Extra
+func applyClaimsMappers(ctx context.Context, claims *dto.AuthClaims) error {
+	for _, m := range claimsMappers {
+		extra, err := m.MapClaims(ctx, claims)
+		if err != nil {
+			return err
+		}
+		for name, value := range extra {
+			if reservedClaims[name] {
+				log.Printf("warning: claims mapper tried to set reserved claim %q, ignored", name)
+				continue
+			}
+			if claims.Extra == nil {
+				claims.Extra = make(map[string]interface{})
+			}
+			claims.Extra[name] = value
+		}
+	}
+	return nil
+}
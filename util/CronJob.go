@@ -1,42 +1,55 @@
 package util
 
 import (
+	"context"
 	"log"
 	"mein-idaas/repository"
 	"time"
 )
 
 func StartDailyCleanup(repo repository.RefreshTokenRepository) {
+	StartDailyJob("refresh token record cleanup", 12, func() {
+		log.Println("Deleting expired tokens...")
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+		start := time.Now()
+		deleted, err := repo.DeleteExpired(ctx)
+		cancel()
+		ObserveJanitorRun("refresh_tokens_expired", time.Since(start), deleted, err)
+		if err != nil {
+			log.Printf("Clean up failed succesfully: %v\n", err)
+		} else {
+			log.Printf("Clean up completed (%d tokens deleted).\n", deleted)
+		}
+	})
+}
+
+// StartDailyJob runs job every day at the given hour (server local time) in the background
+func StartDailyJob(name string, hour int, job func()) {
 	go func() {
 		for {
 			now := time.Now()
 
-			// 1. Calculate target time: Today at 12:00 PM
-			nextRun := time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, now.Location())
+			// 1. Calculate target time: Today at <hour>:00
+			nextRun := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
 
-			// 2. If 12:00 PM has already passed today, schedule for tomorrow
+			// 2. If that time has already passed today, schedule for tomorrow
 			if nextRun.Before(now) {
 				nextRun = nextRun.Add(24 * time.Hour)
 			}
 
 			// 3. Calculate exact duration to wait
 			duration := nextRun.Sub(now)
-			log.Printf("Next refresh token record cleanup scheduled in %v (at %v)\n", duration, nextRun.Format(time.Kitchen))
+			log.Printf("Next %s scheduled in %v (at %v)\n", name, duration, nextRun.Format(time.Kitchen))
 
 			// 4. Sleep until that time
 			time.Sleep(duration)
 
-			// 5. Run the cleanup task
-			log.Println("Deleting expired tokens...")
-			if err := repo.DeleteExpired(); err != nil {
-				log.Printf("Clean up failed succesfully: %v\n", err)
-			} else {
-				log.Println("Clean up completed.")
-			}
+			// 5. Run the task
+			job()
 
 			// 6. Loop restarts immediately.
-			// Since we just finished (approx 12:00 PM), the next loop calculation
-			// will see that "Today 12:00 PM" is just passed or is now,
+			// Since we just finished (approx <hour>:00), the next loop calculation
+			// will see that "Today <hour>:00" is just passed or is now,
 			// so it will correctly add 24h for the next run.
 			// (Adding a tiny buffer sleep here is good practice to ensure we don't double-trigger)
 			time.Sleep(1 * time.Second)
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DBQueryStats is a snapshot of query counters collected by the GORM logger
+type DBQueryStats struct {
+	Queries       uint64 `json:"queries"`
+	SlowQueries   uint64 `json:"slow_queries"`
+	FailedQueries uint64 `json:"failed_queries"`
+	TotalTimeMs   uint64 `json:"total_time_ms"`
+}
+
+var (
+	dbQueryCount  atomic.Uint64
+	dbSlowCount   atomic.Uint64
+	dbFailedCount atomic.Uint64
+	dbTotalTimeMs atomic.Uint64
+)
+
+// GetDBQueryStats returns the query counters since startup
+func GetDBQueryStats() DBQueryStats {
+	return DBQueryStats{
+		Queries:       dbQueryCount.Load(),
+		SlowQueries:   dbSlowCount.Load(),
+		FailedQueries: dbFailedCount.Load(),
+		TotalTimeMs:   dbTotalTimeMs.Load(),
+	}
+}
+
+// metricsLogger wraps the default GORM logger and records query metrics for every statement,
+// independent of the configured log level
+type metricsLogger struct {
+	logger.Interface
+	slowThreshold time.Duration
+}
+
+// newDBLogger builds the GORM logger from environment variables:
+// - DB_LOG_LEVEL: silent | error | warn | info (default: warn)
+// - DB_SLOW_QUERY_THRESHOLD: queries slower than this are logged as [DB-SLOW] (default: 200ms)
+func newDBLogger() logger.Interface {
+	level := parseDBLogLevel(getEnv("DB_LOG_LEVEL", "warn"))
+	slowThreshold := getEnvDuration("DB_SLOW_QUERY_THRESHOLD", 200*time.Millisecond)
+
+	base := logger.New(
+		log.New(os.Stdout, "\r\n", log.LstdFlags),
+		logger.Config{
+			SlowThreshold:             slowThreshold,
+			LogLevel:                  level,
+			IgnoreRecordNotFoundError: true,
+		},
+	)
+
+	log.Printf("[DB] Logger initialized with: level=%s, slow_query=%v", getEnv("DB_LOG_LEVEL", "warn"), slowThreshold)
+	return &metricsLogger{Interface: base, slowThreshold: slowThreshold}
+}
+
+// parseDBLogLevel maps the env value to a GORM log level
+func parseDBLogLevel(level string) logger.LogLevel {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "silent":
+		return logger.Silent
+	case "error":
+		return logger.Error
+	case "info", "debug":
+		return logger.Info
+	case "warn", "warning":
+		return logger.Warn
+	default:
+		log.Printf("warning: invalid DB_LOG_LEVEL value '%s', using default warn\n", level)
+		return logger.Warn
+	}
+}
+
+// LogMode keeps the metrics wrapper when GORM derives a new logger (e.g. db.Debug())
+func (l *metricsLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &metricsLogger{Interface: l.Interface.LogMode(level), slowThreshold: l.slowThreshold}
+}
+
+// Trace records metrics for the statement, then delegates logging to the wrapped logger
+func (l *metricsLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+
+	dbQueryCount.Add(1)
+	dbTotalTimeMs.Add(uint64(elapsed.Milliseconds()))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		dbFailedCount.Add(1)
+	}
+	if l.slowThreshold > 0 && elapsed > l.slowThreshold {
+		dbSlowCount.Add(1)
+	}
+
+	l.Interface.Trace(ctx, begin, fc, err)
+}
@@ -9,6 +9,7 @@ import (
 	"gorm.io/gorm"
 
 	"mein-idaas/model"
+	"mein-idaas/repository"
 )
 
 func InitDB() *gorm.DB {
@@ -55,7 +56,12 @@ func InitDB() *gorm.DB {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
 		host, user, password, dbName, port, sslmode)
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// PrepareStmt caches prepared statements per connection (auth queries are highly repetitive)
+	// Logger level and slow-query threshold are configured via DB_LOG_LEVEL / DB_SLOW_QUERY_THRESHOLD
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		PrepareStmt: true,
+		Logger:      newDBLogger(),
+	})
 	if err != nil {
 		log.Fatalf("Failed to connect to application database: %v", err)
 	}
@@ -66,11 +72,29 @@ func InitDB() *gorm.DB {
 		&model.User{},
 		&model.Credential{},
 		&model.RefreshToken{},
+		&model.Permission{},
 		&model.Role{},
+		&model.Group{},
+		&model.OutboxEvent{},
+		&model.Setting{},
+		&model.Invite{},
+		&model.UserActivity{},
+		&model.SigningKey{},
+		&model.SSOSession{},
+		&model.OAuthClient{},
+		&model.AuthorizationCode{},
+		&model.Scope{},
+		&model.UserConsent{},
+		&model.AccessToken{},
+		&model.SAMLServiceProvider{},
+		&model.RecoveryCode{},
+		&model.RememberedDevice{},
+		&model.APIKey{},
 	)
 	if err != nil {
 		log.Fatalf("Migration failed: %v", err)
 	}
+	createUserSearchIndex(db)
 
 	// 5. CONFIGURE CONNECTION POOL
 	// We get the underlying sql.DB object to set pool params
@@ -79,16 +103,24 @@ func InitDB() *gorm.DB {
 		log.Fatalf("Failed to get underlying DB object: %v", err)
 	}
 
+	maxOpen := getEnvInt("DB_MAX_OPEN_CONNS", 1000)
+	maxIdle := getEnvInt("DB_MAX_IDLE_CONNS", 30)
+	maxLifetime := getEnvDuration("DB_CONN_MAX_LIFETIME", 15*time.Minute)
+
 	// SetMaxOpenConns: Limit max concurrent queries to prevent DB overload
-	postgresDB.SetMaxOpenConns(1000)
+	postgresDB.SetMaxOpenConns(maxOpen)
 
 	// SetMaxIdleConns: Keep these open for fast response (essential for auth)
-	postgresDB.SetMaxIdleConns(30)
+	postgresDB.SetMaxIdleConns(maxIdle)
+
+	// SetConnMaxLifetime: Recycle connections to avoid stale connection errors
+	postgresDB.SetConnMaxLifetime(maxLifetime)
 
-	// SetConnMaxLifetime: Recycle connections every 15 mins to avoid stale connection errors
-	postgresDB.SetConnMaxLifetime(15 * time.Minute)
+	// Export pool stats (open, in-use, idle, wait count/duration) on /metrics
+	RegisterDBPoolMetrics(postgresDB, dbName)
 
-	log.Println("Database connected, migrated, and pool configured!")
+	log.Printf("Database connected, migrated, and pool configured! (max_open=%d, max_idle=%d, max_lifetime=%v)",
+		maxOpen, maxIdle, maxLifetime)
 	return db
 }
 
@@ -99,3 +131,16 @@ func InitDB() *gorm.DB {
 //	}
 //	return fallback
 //}
+
+// createUserSearchIndex adds the trigram (pg_trgm) GIN index behind the admin user search, which makes
+// substring matches (ILIKE '%term%') on name and email fast on large user bases. Without the privilege
+// to create the extension the search still works, by a sequential scan.
+func createUserSearchIndex(db *gorm.DB) {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("warning: pg_trgm extension not available, user search is not indexed: %v", err)
+		return
+	}
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_users_search_trgm ON users USING gin (" + repository.UserSearchExpression + " gin_trgm_ops)").Error; err != nil {
+		log.Printf("warning: failed to create the user search index: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"mein-idaas/dto"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DPoP (RFC 9449): the client signs a proof JWT per request with its own key pair (DPoP header).
+// Tokens issued with a proof are bound to that key (cnf.jkt): they are only accepted together with
+// a fresh proof of the same key, so a stolen token can't be replayed from another machine.
+var (
+	// How old a proof may be (iat); DPOP_PROOF_MAX_AGE, default 5m
+	dpopProofMaxAge = parseTokenTTL("DPOP_PROOF_MAX_AGE", 5*time.Minute)
+	// Tolerated clock difference between client and server
+	dpopClockSkew = 30 * time.Second
+)
+
+// DPoPSigningAlgs are the proof algorithms accepted (dpop_signing_alg_values_supported)
+var DPoPSigningAlgs = []string{"RS256", "PS256", "ES256", "EdDSA"}
+
+// DPoPProof is a verified DPoP proof of the current request
+type DPoPProof struct {
+	JKT string // RFC 7638 thumbprint of the client's public key
+	ATH string // Hash of the access token sent with the proof (requests to protected endpoints)
+}
+
+type dpopClaims struct {
+	HTM string `json:"htm"` // HTTP method of the request
+	HTU string `json:"htu"` // URL of the request without query and fragment
+	ATH string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// VerifyDPoPProof checks the DPoP header of a request: a proof signed with the embedded public key
+// (typ dpop+jwt), made for this method and URL, recent and not seen before
+func VerifyDPoPProof(ctx context.Context, proof, method, requestURL string) (*DPoPProof, error) {
+	claims := &dpopClaims{}
+	var jkt string
+	_, err := jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); !strings.EqualFold(typ, "dpop+jwt") {
+			return nil, errors.New("typ must be dpop+jwt")
+		}
+		raw, ok := token.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("missing jwk header")
+		}
+		if _, private := raw["d"]; private {
+			return nil, errors.New("jwk must be a public key")
+		}
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		var jwk dto.JWK
+		if err := json.Unmarshal(data, &jwk); err != nil {
+			return nil, err
+		}
+		pub, err := ParsePublicJWK(jwk)
+		if err != nil {
+			return nil, err
+		}
+		if jkt, err = KeyThumbprint(pub); err != nil {
+			return nil, err
+		}
+		return pub, nil
+	}, jwt.WithValidMethods(DPoPSigningAlgs), jwt.WithIssuedAt(), jwt.WithLeeway(dpopClockSkew))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, errors.New("missing jti or iat")
+	}
+	if time.Since(claims.IssuedAt.Time) > dpopProofMaxAge+dpopClockSkew {
+		return nil, errors.New("proof expired")
+	}
+	if !strings.EqualFold(claims.HTM, method) {
+		return nil, errors.New("htm does not match the request")
+	}
+	if !sameHTU(claims.HTU, requestURL) {
+		return nil, errors.New("htu does not match the request")
+	}
+
+	// Without a replay check (SetReplayCheck) proofs can be replayed within DPOP_PROOF_MAX_AGE
+	if replayCheck != nil {
+		fresh, err := replayCheck(ctx, "dpop:"+jkt+":"+claims.ID, claims.IssuedAt.Add(dpopProofMaxAge+2*dpopClockSkew))
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			return nil, errors.New("proof was already used")
+		}
+	}
+	return &DPoPProof{JKT: jkt, ATH: claims.ATH}, nil
+}
+
+// sameHTU compares the htu of a proof with the request URL, ignoring query, fragment
+// and the case of scheme and host (RFC 9449 section 4.3)
+func sameHTU(htu, requestURL string) bool {
+	a, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(requestURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) &&
+		strings.TrimSuffix(a.EscapedPath(), "/") == strings.TrimSuffix(b.EscapedPath(), "/")
+}
+
+// AccessTokenHash returns the ath value of a proof sent with accessToken
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return b64url(sum[:])
+}
+
+type dpopContextKey struct{}
+
+// WithDPoPProof stores the verified proof of the request in ctx
+func WithDPoPProof(ctx context.Context, proof *DPoPProof) context.Context {
+	return context.WithValue(ctx, dpopContextKey{}, proof)
+}
+
+// DPoPProofFromContext returns the verified proof of the request, nil if it came without one
+func DPoPProofFromContext(ctx context.Context) *DPoPProof {
+	proof, _ := ctx.Value(dpopContextKey{}).(*DPoPProof)
+	return proof
+}
+
+// DPoPThumbprint returns the key thumbprint tokens issued for the request are bound to ("" without a proof)
+func DPoPThumbprint(ctx context.Context) string {
+	if proof := DPoPProofFromContext(ctx); proof != nil {
+		return proof.JKT
+	}
+	return ""
+}
+
+// dpopConfirmation is the cnf claim binding an access token to the request's proof key (nil without a proof)
+func dpopConfirmation(ctx context.Context) *dto.Confirmation {
+	if jkt := DPoPThumbprint(ctx); jkt != "" {
+		return &dto.Confirmation{JKT: jkt}
+	}
+	return nil
+}
+
+// checkConfirmation only accepts a DPoP-bound access token (cnf.jkt) with the DPoP authorization scheme
+// and a proof of the bound key made for this token (ath); unbound tokens pass
+func checkConfirmation(ctx context.Context, claims *dto.AuthClaims, authHeader, tokenString string) error {
+	if claims.Cnf == nil || claims.Cnf.JKT == "" {
+		return nil
+	}
+	if !strings.HasPrefix(authHeader, "DPoP ") {
+		return errors.New("DPoP-bound token requires the DPoP authorization scheme")
+	}
+	proof := DPoPProofFromContext(ctx)
+	if proof == nil || proof.JKT != claims.Cnf.JKT || proof.ATH != AccessTokenHash(tokenString) {
+		return errors.New("invalid DPoP proof")
+	}
+	return nil
+}
+
+// CheckProofOfPossession only accepts a DPoP-bound token (cnf.jkt) sent outside the Authorization header, such as
+// the subject_token of a token exchange, with a request proof of the bound key; unbound tokens pass
+func CheckProofOfPossession(ctx context.Context, claims *dto.AuthClaims) error {
+	if claims.Cnf == nil || claims.Cnf.JKT == "" {
+		return nil
+	}
+	if DPoPThumbprint(ctx) != claims.Cnf.JKT {
+		return errors.New("invalid DPoP proof")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log"
+)
+
+var (
+	ecPrivateKey *ecdsa.PrivateKey
+	ecPublicKey  *ecdsa.PublicKey
+)
+
+// InitECKeys loads P-256 ECDSA keys (for ES256) from environment variables or key files
+// Supports SEC1 ("EC PRIVATE KEY"), PKCS8 and encrypted PKCS8 private key formats
+// Environment variables:
+// - EC_PRIVATE_KEY: Private key in PEM format (or EC_PRIVATE_KEY_FILE: path to it)
+// - EC_PUBLIC_KEY: Public key in PEM format (or EC_PUBLIC_KEY_FILE: path to it)
+// - EC_PRIVATE_KEY_PASSPHRASE: Passphrase of an encrypted private key
+func InitECKeys() error {
+	privPEM, err := loadKeyPEM("EC_PRIVATE_KEY")
+	if err != nil {
+		return err
+	}
+	pubPEM, err := loadKeyPEM("EC_PUBLIC_KEY")
+	if err != nil {
+		return err
+	}
+
+	// Parse private key
+	privBlock, err := decodePrivateKeyPEM(privPEM, getEnv("EC_PRIVATE_KEY_PASSPHRASE", ""))
+	if err != nil {
+		return errors.New("EC_PRIVATE_KEY: " + err.Error())
+	}
+
+	// Try parsing as SEC1 first, then PKCS8 if that fails
+	priv, err := x509.ParseECPrivateKey(privBlock.Bytes)
+	if err != nil {
+		privInterface, pkcs8Err := x509.ParsePKCS8PrivateKey(privBlock.Bytes)
+		if pkcs8Err != nil {
+			return errors.New("failed to parse EC private key (tried both SEC1 and PKCS8): " + err.Error())
+		}
+
+		var ok bool
+		priv, ok = privInterface.(*ecdsa.PrivateKey)
+		if !ok {
+			return errors.New("private key is not an ECDSA key")
+		}
+	}
+
+	// Parse public key
+	pubBlock, _ := pem.Decode([]byte(pubPEM))
+	if pubBlock == nil {
+		return errors.New("failed to decode public key PEM from EC_PUBLIC_KEY - ensure it's properly formatted with BEGIN/END markers")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(pubBlock.Bytes)
+	if err != nil {
+		return errors.New("failed to parse EC public key: " + err.Error())
+	}
+
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("public key is not an ECDSA key")
+	}
+
+	// ES256 requires the P-256 curve
+	if priv.Curve != elliptic.P256() || ecPub.Curve != elliptic.P256() {
+		return errors.New("ES256 requires P-256 (prime256v1) keys")
+	}
+
+	ecPrivateKey = priv
+	ecPublicKey = ecPub
+
+	log.Println("ECDSA keys loaded successfully")
+	return nil
+}
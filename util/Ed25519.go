@@ -0,0 +1,68 @@
+package util
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log"
+)
+
+var (
+	edPrivateKey ed25519.PrivateKey
+	edPublicKey  ed25519.PublicKey
+)
+
+// InitEdKeys loads Ed25519 keys (for EdDSA) from environment variables or key files
+// Ed25519 private keys only exist in PKCS8 format (plain or encrypted), e.g. from `openssl genpkey -algorithm ed25519`
+// Environment variables:
+// - ED25519_PRIVATE_KEY: Private key in PEM format (or ED25519_PRIVATE_KEY_FILE: path to it)
+// - ED25519_PUBLIC_KEY: Public key in PEM format (or ED25519_PUBLIC_KEY_FILE: path to it)
+// - ED25519_PRIVATE_KEY_PASSPHRASE: Passphrase of an encrypted private key
+func InitEdKeys() error {
+	privPEM, err := loadKeyPEM("ED25519_PRIVATE_KEY")
+	if err != nil {
+		return err
+	}
+	pubPEM, err := loadKeyPEM("ED25519_PUBLIC_KEY")
+	if err != nil {
+		return err
+	}
+
+	// Parse private key
+	privBlock, err := decodePrivateKeyPEM(privPEM, getEnv("ED25519_PRIVATE_KEY_PASSPHRASE", ""))
+	if err != nil {
+		return errors.New("ED25519_PRIVATE_KEY: " + err.Error())
+	}
+
+	privInterface, err := x509.ParsePKCS8PrivateKey(privBlock.Bytes)
+	if err != nil {
+		return errors.New("failed to parse Ed25519 private key (expected PKCS8): " + err.Error())
+	}
+	priv, ok := privInterface.(ed25519.PrivateKey)
+	if !ok {
+		return errors.New("private key is not an Ed25519 key")
+	}
+
+	// Parse public key
+	pubBlock, _ := pem.Decode([]byte(pubPEM))
+	if pubBlock == nil {
+		return errors.New("failed to decode public key PEM from ED25519_PUBLIC_KEY - ensure it's properly formatted with BEGIN/END markers")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(pubBlock.Bytes)
+	if err != nil {
+		return errors.New("failed to parse Ed25519 public key: " + err.Error())
+	}
+
+	edPub, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("public key is not an Ed25519 key")
+	}
+
+	edPrivateKey = priv
+	edPublicKey = edPub
+
+	log.Println("Ed25519 keys loaded successfully")
+	return nil
+}
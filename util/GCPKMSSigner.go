@@ -0,0 +1,163 @@
+package util
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcpMetadataTokenURL returns an access token of the instance's service account (GCE, GKE, Cloud Run)
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gcpKMSSigner signs with a Google Cloud KMS asymmetric key version (JWT_SIGNER=gcpkms)
+// Supported algorithms: RSA_SIGN_PKCS1_*_SHA256 (RS256) and EC_SIGN_P256_SHA256 (ES256).
+// Environment variables:
+// - GCP_KMS_KEY: key version resource name (projects/.../locations/.../keyRings/.../cryptoKeys/.../cryptoKeyVersions/N)
+// - GCP_ACCESS_TOKEN: OAuth access token (optional; default: the metadata server's service account token)
+type gcpKMSSigner struct {
+	name        string
+	staticToken string
+	public      crypto.PublicKey
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newGCPKMSSigner() (crypto.Signer, error) {
+	s := &gcpKMSSigner{
+		name:        strings.Trim(getEnv("GCP_KMS_KEY", ""), "/"),
+		staticToken: getEnv("GCP_ACCESS_TOKEN", ""),
+	}
+	if s.name == "" {
+		return nil, errors.New("JWT_SIGNER=gcpkms requires GCP_KMS_KEY")
+	}
+
+	var want string
+	switch signingMethod.Alg() {
+	case "RS256":
+		want = "RSA_SIGN_PKCS1_"
+	case "ES256":
+		want = "EC_SIGN_P256_SHA256"
+	default:
+		return nil, fmt.Errorf("Google Cloud KMS does not support %s signing, use RS256 or ES256", signingMethod.Alg())
+	}
+
+	var res struct {
+		Pem       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := s.call(http.MethodGet, "/publicKey", nil, &res); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(res.Algorithm, want) || !strings.HasSuffix(res.Algorithm, "SHA256") {
+		return nil, fmt.Errorf("Google Cloud KMS key algorithm %s does not match %s", res.Algorithm, signingMethod.Alg())
+	}
+	pub, err := DecodePublicKey(res.Pem)
+	if err != nil {
+		return nil, err
+	}
+	s.public = pub
+	return s, nil
+}
+
+func (s *gcpKMSSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *gcpKMSSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if err := checkDigest(digest, opts); err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Signature string `json:"signature"` // base64; DER for ECDSA
+	}
+	body := map[string]interface{}{"digest": map[string]string{"sha256": b64std(digest)}}
+	if err := s.call(http.MethodPost, ":asymmetricSign", body, &res); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(res.Signature)
+}
+
+// call sends a request for the key version (suffix "/publicKey" or ":asymmetricSign")
+func (s *gcpKMSSigner) call(method, suffix string, body interface{}, out interface{}) error {
+	token, err := s.accessToken()
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, "https://cloudkms.googleapis.com/v1/"+s.name+suffix, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := remoteSignerClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Google Cloud KMS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Google Cloud KMS %s: status %d: %s", suffix, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// accessToken returns GCP_ACCESS_TOKEN or a cached metadata server token, refreshed a minute before it expires
+func (s *gcpKMSSigner) accessToken() (string, error) {
+	if s.staticToken != "" {
+		return s.staticToken, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, gcpMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := remoteSignerClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("metadata server token request failed (set GCP_ACCESS_TOKEN outside Google Cloud): %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server token request: status %d", resp.StatusCode)
+	}
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	s.token = res.AccessToken
+	s.tokenExpiry = time.Now().Add(time.Duration(res.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
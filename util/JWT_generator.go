@@ -1,8 +1,14 @@
 package util
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"log"
 	"mein-idaas/dto"
+	"mein-idaas/model"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -19,9 +25,41 @@ type TokenPair struct {
 var (
 	accessTTL  = parseTokenTTL("JWT_ACCESS_TTL", 15*time.Minute)
 	refreshTTL = parseTokenTTL("JWT_REFRESH_TTL", 168*time.Hour)
-	issuer     = getEnv("JWT_ISSUER", "mein-idaas")
+	offlineTTL = parseTokenTTL("JWT_OFFLINE_REFRESH_TTL", 720*time.Hour)
+	// Logins with remember me get a long-lived refresh token and a persistent cookie
+	rememberMeTTL = parseTokenTTL("JWT_REMEMBER_ME_TTL", 720*time.Hour)
+	// Sliding sessions: each refresh renews the refresh TTL, but never past login + JWT_REFRESH_ABSOLUTE_TTL (0 = no cap)
+	absoluteTTL = parseTokenTTL("JWT_REFRESH_ABSOLUTE_TTL", 0)
+	// Idle timeout: a refresh after this much inactivity is rejected, whatever the expiry (0 = disabled)
+	idleTimeout = parseTokenTTL("JWT_REFRESH_IDLE_TIMEOUT", 0)
+	issuer      = getEnv("JWT_ISSUER", "mein-idaas")
+
+	// JWT_AUDIENCE: comma-separated aud of access tokens (tenant tokens use the tenant issuer instead)
+	// JWT_ACCEPTED_AUDIENCES: extra audiences this server accepts, e.g. the previous JWT_AUDIENCE while changing it
+	audiences         = tokenAudiences()
+	acceptedAudiences = parseAudiences(getEnv("JWT_ACCEPTED_AUDIENCES", ""))
 )
 
+// tokenAudiences reads JWT_AUDIENCE; access tokens always need an audience (tokens without one are refresh tokens)
+func tokenAudiences() jwt.ClaimStrings {
+	list := parseAudiences(getEnv("JWT_AUDIENCE", ""))
+	if len(list) == 0 {
+		return jwt.ClaimStrings{"self-hosted-idaas"}
+	}
+	return list
+}
+
+// parseAudiences splits a comma-separated audience list, dropping empty entries
+func parseAudiences(value string) jwt.ClaimStrings {
+	var list jwt.ClaimStrings
+	for _, a := range strings.Split(value, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
 // parseTokenTTL parses a duration from env variable or returns default
 func parseTokenTTL(envKey string, defaultDuration time.Duration) time.Duration {
 	ttlStr := getEnv(envKey, "")
@@ -36,24 +74,44 @@ func parseTokenTTL(envKey string, defaultDuration time.Duration) time.Duration {
 	return duration
 }
 
-// GenerateTokens creates both Access and Refresh tokens using RS256
-func GenerateTokens(userID uuid.UUID, roles []string) (*TokenPair, error) {
+// GenerateTokens creates both Access and Refresh tokens using the configured algorithm (RS256/ES256/EdDSA)
+// tenant is the user's organization ("" without multi-tenancy); it selects the issuer and audience
+// scope goes into the access token ("" for full access); refreshExpiresAt is the refresh token's exp
+// The access token is bound to the request's DPoP key (cnf.jkt) when ctx carries a proof.
+// authn (the user's login) becomes the auth_time, amr and acr claims of the access token
+// tokenVersion is the user's current token version (token_version claim, see logout-all)
+// clientID is the OAuth client the tokens are issued to ("" for first-party logins), see forClient
+func GenerateTokens(ctx context.Context, userID uuid.UUID, roles []string, tenant string, scope string, clientID string, refreshExpiresAt time.Time, authn dto.Authentication, tokenVersion int) (*TokenPair, error) {
 	now := time.Now()
 
 	// 1. Create Access Token
 	accessClaims := dto.AuthClaims{
-		Roles: roles,
+		Roles:        roles,
+		Tenant:       tenant,
+		Scope:        scope,
+		TokenVersion: tokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   userID.String(),
 			ExpiresAt: jwt.NewNumericDate(now.Add(accessTTL)),
 			IssuedAt:  jwt.NewNumericDate(now),
-			Issuer:    issuer,
-			Audience:  jwt.ClaimStrings{"self-hosted-idaas"},
+			Issuer:    TenantIssuer(tenant),
+			ID:        uuid.NewString(), // Key of the denylist (logout)
+			Audience:  tenantAudience(tenant, audiences),
 		},
 	}
+	setAuthentication(&accessClaims, authn)
+	forClient(&accessClaims, clientID)
+	accessClaims.Cnf = dpopConfirmation(ctx)
+	permissions, err := PermissionsForRoles(ctx, accessClaims.Roles)
+	if err != nil {
+		return nil, err
+	}
+	accessClaims.Permissions = permissions
+	if err := applyClaimsMappers(ctx, &accessClaims); err != nil {
+		return nil, err
+	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodRS256, accessClaims)
-	signedAccess, err := accessToken.SignedString(GetPrivateKey())
+	signedAccess, err := signClaims(tenant, accessClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -61,17 +119,17 @@ func GenerateTokens(userID uuid.UUID, roles []string) (*TokenPair, error) {
 	// 2. Create Refresh Token
 	refreshID := uuid.New()
 	refreshClaims := dto.AuthClaims{
+		Tenant: tenant,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   userID.String(),
-			ExpiresAt: jwt.NewNumericDate(now.Add(refreshTTL)),
+			ExpiresAt: jwt.NewNumericDate(refreshExpiresAt),
 			IssuedAt:  jwt.NewNumericDate(now),
-			Issuer:    issuer,
+			Issuer:    TenantIssuer(tenant),
 			ID:        refreshID.String(),
 		},
 	}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodRS256, refreshClaims)
-	signedRefresh, err := refreshToken.SignedString(GetPrivateKey())
+	signedRefresh, err := signClaims(tenant, refreshClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -84,40 +142,186 @@ func GenerateTokens(userID uuid.UUID, roles []string) (*TokenPair, error) {
 }
 
 // SignRefreshToken creates a JWT string for an EXISTING refresh token ID
-func SignRefreshToken(refreshID uuid.UUID, userID uuid.UUID) (string, error) {
+// expiresAt is the stored expiry, so re-signing never extends the token
+func SignRefreshToken(refreshID uuid.UUID, userID uuid.UUID, tenant string, expiresAt time.Time) (string, error) {
 	now := time.Now()
 
 	claims := dto.AuthClaims{
+		Tenant: tenant,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   userID.String(),
-			ExpiresAt: jwt.NewNumericDate(now.Add(refreshTTL)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(now),
-			Issuer:    issuer,
+			Issuer:    TenantIssuer(tenant),
 			ID:        refreshID.String(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(GetPrivateKey())
+	return signClaims(tenant, claims)
 }
 
 // GenerateAccessTokenOnly creates a short-lived JWT for the user.
 // Used specifically in Refresh Token Rotation (Grace Period).
-func GenerateAccessTokenOnly(userID uuid.UUID, roles []string) (string, error) {
+func GenerateAccessTokenOnly(ctx context.Context, userID uuid.UUID, roles []string, tenant string, scope string, clientID string, authn dto.Authentication, tokenVersion int) (string, error) {
 	now := time.Now()
 
 	// Use dto.AuthClaims to ensure this token looks EXACTLY like a normal login token
 	claims := dto.AuthClaims{
-		Roles: roles,
+		Roles:        roles,
+		Tenant:       tenant,
+		Scope:        scope,
+		TokenVersion: tokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   userID.String(),
 			ExpiresAt: jwt.NewNumericDate(now.Add(accessTTL)),
 			IssuedAt:  jwt.NewNumericDate(now),
-			Issuer:    issuer,
-			Audience:  jwt.ClaimStrings{"my-game-server", "smoking-app"},
+			Issuer:    TenantIssuer(tenant),
+			ID:        uuid.NewString(), // Key of the denylist (logout)
+			Audience:  tenantAudience(tenant, audiences),
 		},
 	}
+	setAuthentication(&claims, authn)
+	forClient(&claims, clientID)
+	claims.Cnf = dpopConfirmation(ctx)
+	permissions, err := PermissionsForRoles(ctx, claims.Roles)
+	if err != nil {
+		return "", err
+	}
+	claims.Permissions = permissions
+	if err := applyClaimsMappers(ctx, &claims); err != nil {
+		return "", err
+	}
+
+	return signClaims(tenant, claims)
+}
+
+// setAuthentication puts the user's login into the claims; a zero authn (login unknown) sets nothing,
+// amr and acr are left out when the methods are unknown
+func setAuthentication(claims *dto.AuthClaims, authn dto.Authentication) {
+	if authn.Time.IsZero() {
+		return
+	}
+	claims.AuthTime = jwt.NewNumericDate(authn.Time)
+	if len(authn.Methods) > 0 {
+		claims.AMR = authn.Methods
+		claims.ACR = authn.ACR()
+	}
+}
+
+// forClient turns access claims into those of a token for an OAuth client: the audience is the client ID,
+// so this server's own API rejects it (checkAudience), and the roles (with the permissions they grant) are
+// only included when the profile scope was granted. First-party tokens (clientID "") are left unchanged.
+func forClient(claims *dto.AuthClaims, clientID string) {
+	if clientID == "" {
+		return
+	}
+	claims.ClientID = clientID
+	claims.Audience = jwt.ClaimStrings{clientID}
+
+	for _, s := range strings.Fields(claims.Scope) {
+		if s == model.ScopeProfile {
+			return
+		}
+	}
+	claims.Roles = nil
+}
+
+// GenerateExchangedToken mints a delegated access token for another audience (RFC 8693 token exchange)
+// It keeps the subject's roles, never outlives the subject token and records the actor in the act claim.
+// Like the other access tokens it is bound to the request's DPoP key (cnf.jkt) when ctx carries a proof.
+func GenerateExchangedToken(ctx context.Context, subject *dto.AuthClaims, audience string, scope string, actor string) (string, time.Duration, error) {
+	now := time.Now()
+	expires := now.Add(accessTTL)
+	if subject.ExpiresAt != nil && subject.ExpiresAt.Time.Before(expires) {
+		expires = subject.ExpiresAt.Time
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(GetPrivateKey())
+	claims := dto.AuthClaims{
+		Roles:       subject.Roles,
+		Permissions: subject.Permissions,
+		Tenant:      subject.Tenant,
+		Scope:       scope,
+		Act:         &dto.Actor{Sub: actor, Act: subject.Act},
+		// The delegated token is as fresh as the user's login, not as the exchange
+		AuthTime: subject.AuthTime,
+		AMR:      subject.AMR,
+		ACR:      subject.ACR,
+		// Logging the user out everywhere also ends the delegation
+		TokenVersion: subject.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject.Subject,
+			ExpiresAt: jwt.NewNumericDate(expires),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    TenantIssuer(subject.Tenant),
+			ID:        uuid.NewString(),
+			Audience:  jwt.ClaimStrings{audience},
+		},
+	}
+	claims.Cnf = dpopConfirmation(ctx)
+
+	token, err := signClaims(subject.Tenant, claims)
+	if err != nil {
+		return "", 0, err
+	}
+	return token, expires.Sub(now), nil
+}
+
+// GenerateIDToken signs an OIDC ID token for the client (aud = client ID)
+// at_hash binds it to the access token issued in the same response: SHA-256 for RS256 and ES256,
+// SHA-512 for EdDSA (Ed25519 hashes with SHA-512 internally).
+func GenerateIDToken(claims dto.IDTokenClaims, userID uuid.UUID, clientID string, accessToken string) (string, error) {
+	now := time.Now()
+	var sum []byte
+	if signingMethod.Alg() == "EdDSA" {
+		h := sha512.Sum512([]byte(accessToken))
+		sum = h[:]
+	} else {
+		h := sha256.Sum256([]byte(accessToken))
+		sum = h[:]
+	}
+
+	claims.AtHash = base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+	claims.Azp = clientID
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Subject:   userID.String(),
+		ExpiresAt: jwt.NewNumericDate(now.Add(accessTTL)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		Issuer:    TenantIssuer(claims.Tenant),
+		Audience:  jwt.ClaimStrings{clientID},
+	}
+
+	return signClaims(claims.Tenant, claims)
+}
+
+// GetAccessTTL returns the configured access token lifetime (JWT_ACCESS_TTL)
+func GetAccessTTL() time.Duration {
+	return accessTTL
+}
+
+// GetRefreshTTL returns the configured refresh token lifetime (JWT_REFRESH_TTL)
+func GetRefreshTTL() time.Duration {
+	return refreshTTL
+}
+
+// GetRememberMeTTL returns the refresh token TTL of remember me logins (JWT_REMEMBER_ME_TTL)
+func GetRememberMeTTL() time.Duration {
+	return rememberMeTTL
+}
+
+// GetRefreshAbsoluteTTL returns the maximum session lifetime across refreshes (JWT_REFRESH_ABSOLUTE_TTL, 0 = no cap)
+func GetRefreshAbsoluteTTL() time.Duration {
+	return absoluteTTL
+}
+
+// GetRefreshIdleTimeout returns the inactivity window after which refreshes are rejected (JWT_REFRESH_IDLE_TIMEOUT, 0 = disabled)
+func GetRefreshIdleTimeout() time.Duration {
+	return idleTimeout
+}
+
+// GetRefreshTTLFor returns the refresh token lifetime, JWT_OFFLINE_REFRESH_TTL for offline_access tokens
+func GetRefreshTTLFor(offline bool) time.Duration {
+	if offline {
+		return offlineTTL
+	}
+	return refreshTTL
 }
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"encoding/pem"
+	"errors"
+	"os"
+	"strings"
+)
+
+// loadKeyPEM reads a PEM key from the env var name, or from the file named by name_FILE when
+// name is empty (mounted secrets). Literal "\n" sequences are turned into newlines.
+func loadKeyPEM(name string) (string, error) {
+	value := getEnv(name, "")
+	if file := getEnv(name+"_FILE", ""); value == "" && file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", errors.New("cannot read " + name + "_FILE: " + err.Error())
+		}
+		value = string(b)
+	}
+	if value == "" {
+		return "", errors.New(name + " or " + name + "_FILE must be set")
+	}
+	return strings.ReplaceAll(value, "\\n", "\n"), nil
+}
+
+// decodePrivateKeyPEM decodes a private key PEM, decrypting "ENCRYPTED PRIVATE KEY" blocks
+// (PKCS8 with PBES2) with passphrase. Decrypted keys come back as a plain "PRIVATE KEY" block.
+func decodePrivateKeyPEM(pemStr string, passphrase string) (*pem.Block, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, errors.New("failed to decode private key PEM - ensure it's properly formatted with BEGIN/END markers")
+	}
+
+	// Legacy OpenSSL encryption ("Proc-Type: 4,ENCRYPTED") uses MD5 key derivation and is not supported
+	if strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+		return nil, errors.New("legacy encrypted PEM keys are not supported, convert with `openssl pkcs8 -topk8 -v2 aes256`")
+	}
+
+	if block.Type != "ENCRYPTED PRIVATE KEY" {
+		return block, nil
+	}
+	if passphrase == "" {
+		return nil, errors.New("private key is encrypted but no passphrase is set")
+	}
+	der, err := decryptPKCS8(block.Bytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+}
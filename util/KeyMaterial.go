@@ -0,0 +1,160 @@
+package util
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+)
+
+// GenerateSigningKey creates a fresh private key for the configured algorithm (RSA-2048, P-256 or Ed25519)
+func GenerateSigningKey() (crypto.Signer, error) {
+	switch signingMethod.Alg() {
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+}
+
+// ParseSigningKey parses a PEM private key supplied by an admin (PKCS1, SEC1, PKCS8 or encrypted PKCS8 with passphrase)
+// The key must match the configured algorithm: RSA of at least 2048 bits for RS256, P-256 for ES256,
+// Ed25519 (PKCS8 only) for EdDSA.
+func ParseSigningKey(pemStr string, passphrase string) (crypto.Signer, error) {
+	block, err := decodePrivateKeyPEM(strings.ReplaceAll(pemStr, "\\n", "\n"), passphrase)
+	if err != nil {
+		return nil, errors.New("invalid private key: " + err.Error())
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, errors.New("invalid private key: " + err.Error())
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if signingMethod.Alg() != "RS256" {
+			return nil, errors.New("invalid private key: RSA key but JWT_SIGNING_ALG is " + signingMethod.Alg())
+		}
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("invalid private key: RSA keys must be at least 2048 bits")
+		}
+		return k, nil
+	case *ecdsa.PrivateKey:
+		if signingMethod.Alg() != "ES256" {
+			return nil, errors.New("invalid private key: ECDSA key but JWT_SIGNING_ALG is " + signingMethod.Alg())
+		}
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("invalid private key: ES256 requires a P-256 key")
+		}
+		return k, nil
+	case ed25519.PrivateKey:
+		if signingMethod.Alg() != "EdDSA" {
+			return nil, errors.New("invalid private key: Ed25519 key but JWT_SIGNING_ALG is " + signingMethod.Alg())
+		}
+		return k, nil
+	}
+	return nil, errors.New("invalid private key: unsupported key type")
+}
+
+// EncryptPrivateKey serializes priv (PKCS8) and seals it with AES-256-GCM under sha256(secret)
+func EncryptPrivateKey(priv crypto.Signer, secret string) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := keyEncryptionCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, der, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptPrivateKey reverses EncryptPrivateKey
+func DecryptPrivateKey(encrypted string, secret string) (crypto.Signer, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := keyEncryptionCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted key too short")
+	}
+
+	der, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt signing key (wrong SIGNING_KEY_SECRET?)")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("stored key is not a signing key")
+	}
+	return signer, nil
+}
+
+func keyEncryptionCipher(secret string) (cipher.AEAD, error) {
+	if secret == "" {
+		return nil, errors.New("key encryption secret not set")
+	}
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncodePublicKey returns the PKIX PEM encoding of pub
+func EncodePublicKey(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// DecodePublicKey parses a PKIX PEM public key
+func DecodePublicKey(pemStr string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, errors.New("failed to decode public key PEM")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
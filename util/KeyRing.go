@@ -0,0 +1,281 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"mein-idaas/dto"
+)
+
+// SigningKey is one key of the JWT key ring, identified by its RFC 7638 thumbprint (kid)
+type SigningKey struct {
+	Kid       string
+	Private   crypto.Signer // nil for keys that only verify (rotated out)
+	Public    crypto.PublicKey
+	CreatedAt time.Time
+	RetiresAt *time.Time // end of the overlap window, nil for the active key
+	Tenant    string     // organization whose tokens the key signs, "" for the global keys
+}
+
+// Key ring shared by all token signers and parsers
+// Starts with the key from RSA_*/EC_* env vars; key rotation replaces it via SetKeyRing.
+// Tenants with keys of their own sign with their newest one, the others with the global active key.
+var (
+	keyRingMu        sync.RWMutex
+	activeKey        *SigningKey
+	tenantActiveKeys map[string]*SigningKey
+	ringKeys         map[string]*SigningKey
+	envKey           *SigningKey
+
+	// keyRingReloader refreshes the ring when a token carries an unknown kid
+	// (another replica rotated the key); calls are rate limited to one per keyReloadCooldown
+	keyRingReloader func()
+	lastKeyReload   time.Time
+	keyReloadMu     sync.Mutex
+)
+
+const keyReloadCooldown = 10 * time.Second
+
+// NewSigningKey wraps a key for the ring; priv may be nil for verify-only keys
+func NewSigningKey(priv crypto.Signer, pub crypto.PublicKey, createdAt time.Time, retiresAt *time.Time) (*SigningKey, error) {
+	if priv != nil {
+		pub = priv.Public()
+	}
+	kid, err := KeyThumbprint(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{Kid: kid, Private: priv, Public: pub, CreatedAt: createdAt, RetiresAt: retiresAt}, nil
+}
+
+// EnvSigningKey returns the key loaded from the environment at startup
+func EnvSigningKey() *SigningKey {
+	return envKey
+}
+
+// SetKeyRing replaces the key ring: active signs new tokens, active and keys verify them
+// The first key of a tenant in keys that can sign and isn't rotated out signs that tenant's tokens
+// (keys are passed newest first).
+func SetKeyRing(active *SigningKey, keys []*SigningKey) {
+	ring := map[string]*SigningKey{active.Kid: active}
+	tenantActive := make(map[string]*SigningKey)
+	for _, k := range keys {
+		if _, exists := ring[k.Kid]; !exists {
+			ring[k.Kid] = k
+		}
+		if k.Tenant != "" && k.Private != nil && k.RetiresAt == nil && tenantActive[k.Tenant] == nil {
+			tenantActive[k.Tenant] = k
+		}
+	}
+
+	keyRingMu.Lock()
+	activeKey = active
+	tenantActiveKeys = tenantActive
+	ringKeys = ring
+	keyRingMu.Unlock()
+
+	// Tokens of a retired key must not be served from the cache
+	PurgeAccessTokenCache()
+}
+
+// SetKeyRingReloader registers the function that reloads the ring on an unknown kid
+func SetKeyRingReloader(reload func()) {
+	keyReloadMu.Lock()
+	keyRingReloader = reload
+	keyReloadMu.Unlock()
+}
+
+// ActiveSigningKey returns the key new tokens are signed with
+func ActiveSigningKey() *SigningKey {
+	keyRingMu.RLock()
+	defer keyRingMu.RUnlock()
+	return activeKey
+}
+
+// TenantSigningKey returns the key new tokens of org are signed with: the tenant's own active key,
+// or the global active key for tenants without keys of their own (and for org "")
+func TenantSigningKey(org string) *SigningKey {
+	keyRingMu.RLock()
+	defer keyRingMu.RUnlock()
+	if key, ok := tenantActiveKeys[org]; ok {
+		return key
+	}
+	return activeKey
+}
+
+// GetVerificationKeys returns every key that still verifies tokens of org (the key signing them first):
+// the global keys and, for a tenant, its own keys. Keys of other tenants are left out.
+func GetVerificationKeys(org string) []SigningKey {
+	signing := TenantSigningKey(org)
+
+	keyRingMu.RLock()
+	defer keyRingMu.RUnlock()
+
+	now := time.Now()
+	keys := []SigningKey{*signing}
+	for kid, k := range ringKeys {
+		if kid != signing.Kid && (k.Tenant == "" || k.Tenant == org) && (k.RetiresAt == nil || k.RetiresAt.After(now)) {
+			keys = append(keys, *k)
+		}
+	}
+	return keys
+}
+
+// lookupVerificationKey finds the key for kid, reloading the ring once if it is unknown
+func lookupVerificationKey(kid string) (*SigningKey, error) {
+	if key, ok := findRingKey(kid); ok {
+		return key, nil
+	}
+
+	keyReloadMu.Lock()
+	reload := keyRingReloader != nil && time.Since(lastKeyReload) > keyReloadCooldown
+	if reload {
+		lastKeyReload = time.Now()
+	}
+	reloader := keyRingReloader
+	keyReloadMu.Unlock()
+
+	if reload {
+		reloader()
+		if key, ok := findRingKey(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown or retired signing key %q", kid)
+}
+
+func findRingKey(kid string) (*SigningKey, bool) {
+	keyRingMu.RLock()
+	defer keyRingMu.RUnlock()
+
+	k, ok := ringKeys[kid]
+	if !ok || (k.RetiresAt != nil && time.Now().After(*k.RetiresAt)) {
+		return nil, false
+	}
+	return k, true
+}
+
+// KeyThumbprint computes the RFC 7638 JWK thumbprint (base64url SHA-256), used as kid
+func KeyThumbprint(pub crypto.PublicKey) (string, error) {
+	var canonical string
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			b64url(big.NewInt(int64(k.E)).Bytes()), b64url(k.N.Bytes()))
+	case *ecdsa.PublicKey:
+		x, y, err := ecCoordinates(k)
+		if err != nil {
+			return "", err
+		}
+		canonical = fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, b64url(x), b64url(y))
+	case ed25519.PublicKey:
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, b64url(k))
+	default:
+		return "", errors.New("unsupported public key type")
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return b64url(sum[:]), nil
+}
+
+// PublicJWK converts the public half of a ring key to a JWK (signature use)
+func PublicJWK(key SigningKey) (dto.JWK, error) {
+	jwk := dto.JWK{Use: "sig", Alg: signingMethod.Alg(), Kid: key.Kid}
+	switch k := key.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64url(k.N.Bytes())
+		jwk.E = b64url(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		x, y, err := ecCoordinates(k)
+		if err != nil {
+			return dto.JWK{}, err
+		}
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = b64url(x)
+		jwk.Y = b64url(y)
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64url(k)
+	default:
+		return dto.JWK{}, errors.New("unsupported public key type")
+	}
+	return jwk, nil
+}
+
+// ParsePublicJWK converts an RSA, P-256 or Ed25519 JWK of a foreign JWKS into a public key
+func ParsePublicJWK(jwk dto.JWK) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid EC coordinates")
+		}
+		raw := append(append([]byte{0x04}, x...), y...)
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), raw)
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+// ecCoordinates returns the fixed-size X and Y coordinates of a P-256 public key
+func ecCoordinates(k *ecdsa.PublicKey) ([]byte, []byte, error) {
+	ecdhKey, err := k.ECDH()
+	if err != nil {
+		return nil, nil, err
+	}
+	raw := ecdhKey.Bytes() // 0x04 || X || Y
+	size := (len(raw) - 1) / 2
+	return raw[1 : 1+size], raw[1+size:], nil
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// metricsRegistry holds every Prometheus metric exported on /metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	janitorRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "idaas_janitor_run_duration_seconds",
+		Help:    "Duration of background cleanup (janitor) runs.",
+		Buckets: []float64{0.01, 0.1, 0.5, 1, 5, 15, 60, 300, 900},
+	}, []string{"job"})
+
+	janitorRowsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "idaas_janitor_rows_deleted_total",
+		Help: "Rows deleted by background cleanup (janitor) jobs.",
+	}, []string{"job"})
+
+	janitorFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "idaas_janitor_failures_total",
+		Help: "Failed background cleanup (janitor) runs.",
+	}, []string{"job"})
+
+	passwordRehashes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "idaas_password_rehashes_total",
+		Help: "Password hashes upgraded to the current scheme/parameters at login.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		janitorRunDuration,
+		janitorRowsDeleted,
+		janitorFailures,
+		passwordRehashes,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "idaas_access_token_cache_hits_total",
+			Help: "JWT access tokens served from the validated token cache (signature not verified again).",
+		}, func() float64 { return float64(tokenCacheHits.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "idaas_access_token_cache_misses_total",
+			Help: "JWT access tokens not found in the validated token cache.",
+		}, func() float64 { return float64(tokenCacheMisses.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "idaas_access_token_cache_size",
+			Help: "JWT access tokens currently held in the validated token cache.",
+		}, func() float64 { return float64(AccessTokenCacheLen()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "idaas_db_queries_total",
+			Help: "SQL statements executed through GORM.",
+		}, func() float64 { return float64(dbQueryCount.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "idaas_db_slow_queries_total",
+			Help: "SQL statements slower than DB_SLOW_QUERY_THRESHOLD.",
+		}, func() float64 { return float64(dbSlowCount.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "idaas_db_failed_queries_total",
+			Help: "SQL statements that returned an error (record-not-found excluded).",
+		}, func() float64 { return float64(dbFailedCount.Load()) }),
+	)
+}
+
+// GetMetricsRegistry returns the registry served on /metrics
+func GetMetricsRegistry() *prometheus.Registry {
+	return metricsRegistry
+}
+
+// RegisterDBPoolMetrics exports sql.DB pool stats (open, in-use, idle, wait count/duration, ...)
+func RegisterDBPoolMetrics(db *sql.DB, dbName string) {
+	metricsRegistry.MustRegister(collectors.NewDBStatsCollector(db, dbName))
+}
+
+// RegisterGaugeFunc exports a gauge whose value is read on every scrape (e.g. in-memory store sizes)
+func RegisterGaugeFunc(name, help string, fn func() float64) {
+	metricsRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, fn))
+}
+
+// ObservePasswordRehash counts a password hash upgraded at login
+func ObservePasswordRehash() {
+	passwordRehashes.Inc()
+}
+
+// ObserveJanitorRun records the duration and outcome of a cleanup job run
+func ObserveJanitorRun(job string, duration time.Duration, rowsDeleted int64, err error) {
+	janitorRunDuration.WithLabelValues(job).Observe(duration.Seconds())
+	janitorRowsDeleted.WithLabelValues(job).Add(float64(rowsDeleted))
+	if err != nil {
+		janitorFailures.WithLabelValues(job).Inc()
+	}
+}
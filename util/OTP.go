@@ -2,7 +2,10 @@ package util
 
 import (
 	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
 	"math/big"
+	"strings"
 )
 
 func GenerateRandomDigits(length int) string {
@@ -15,16 +18,34 @@ func GenerateRandomDigits(length int) string {
 	return string(b)
 }
 
-// GenerateRandomPassword generates a random 8-character password with alphanumeric characters
-func GenerateRandomPassword(length int) (string, error) {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*"
-	b := make([]byte, length)
-	_, err := rand.Read(b)
-	if err != nil {
+// GenerateSecureToken returns n random bytes encoded as URL-safe base64 (no padding)
+func GenerateSecureToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
-	for i := range b {
-		b[i] = charset[b[i]%byte(len(charset))]
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// recoveryCodeEncoding is lowercase base32 without padding, easy to read and type
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCode returns an MFA recovery code like "k7d2x-q4m9z" (50 random bits)
+func GenerateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return string(b), nil
+	code := recoveryCodeEncoding.EncodeToString(b)[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// NormalizeRecoveryCode drops separators and case, so "K7D2X Q4M9Z" matches "k7d2x-q4m9z"
+func NormalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
 }
@@ -0,0 +1,149 @@
+package util
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher is one password hashing scheme
+// HashPassword always uses the current scheme (Argon2id); ComparePassword picks the scheme by the hash prefix,
+// so hashes of legacy schemes keep working and are upgraded at the next login (PasswordNeedsRehash).
+type PasswordHasher interface {
+	// Name identifies the scheme in reports and metrics ("argon2id", "bcrypt")
+	Name() string
+	// Recognizes reports whether the stored hash was produced by this scheme
+	Recognizes(hashed string) bool
+	Hash(password string) (string, error)
+	Compare(hashed, plain string) error
+	// NeedsRehash reports whether the hash is weaker than what Hash produces today
+	NeedsRehash(hashed string) bool
+}
+
+// currentHasher hashes every new password
+var currentHasher PasswordHasher = argon2Hasher{}
+
+// passwordHashers are all schemes a stored hash may use, current first
+var passwordHashers = []PasswordHasher{currentHasher, bcryptHasher{}}
+
+// hasherFor returns the scheme of a stored hash
+func hasherFor(hashed string) (PasswordHasher, error) {
+	for _, h := range passwordHashers {
+		if h.Recognizes(hashed) {
+			return h, nil
+		}
+	}
+	return nil, errors.New("unknown password hash format")
+}
+
+// PasswordHashScheme returns the name of the scheme of a stored hash, "unknown" if no scheme recognizes it
+func PasswordHashScheme(hashed string) string {
+	h, err := hasherFor(hashed)
+	if err != nil {
+		return "unknown"
+	}
+	return h.Name()
+}
+
+// HashPassword hashes a plaintext password with the current scheme and parameters
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("empty password")
+	}
+	return currentHasher.Hash(password)
+}
+
+// ComparePassword checks a plaintext password against a stored hash of any supported scheme
+func ComparePassword(hashed, plain string) error {
+	h, err := hasherFor(hashed)
+	if err != nil {
+		return err
+	}
+	return h.Compare(hashed, plain)
+}
+
+// PasswordNeedsRehash reports whether a stored hash uses a legacy scheme or weaker parameters than the current ones
+// Call it after a successful ComparePassword and store HashPassword(plain) when it returns true.
+func PasswordNeedsRehash(hashed string) bool {
+	h, err := hasherFor(hashed)
+	if err != nil {
+		return false
+	}
+	return h != currentHasher || h.NeedsRehash(hashed)
+}
+
+// argon2Hasher is the current scheme: Argon2id with the parameters of InitArgon2Params
+type argon2Hasher struct{}
+
+func (argon2Hasher) Name() string { return "argon2id" }
+
+func (argon2Hasher) Recognizes(hashed string) bool {
+	return strings.HasPrefix(hashed, "$argon2id$")
+}
+
+func (argon2Hasher) Hash(password string) (string, error) {
+	// Generate random salt
+	salt, err := generateSalt(argon2SaltLen)
+	if err != nil {
+		return "", err
+	}
+
+	// Hash password with current global argon2 parameters
+	hash := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLength)
+
+	// Combine salt and hash for storage with current parameters
+	return encodeArgon2Hash(salt, hash), nil
+}
+
+// Compare uses the parameters extracted from the stored hash, NOT the global variables,
+// so users can always login even if global parameters change
+func (argon2Hasher) Compare(hashed, plain string) error {
+	salt, hash, params, err := decodeArgon2Hash(hashed)
+	if err != nil {
+		return err
+	}
+
+	computedHash := argon2.IDKey([]byte(plain), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+	if !constantTimeCompare(hash, computedHash) {
+		return errors.New("invalid password")
+	}
+	return nil
+}
+
+// NeedsRehash is true when memory, iterations, key or salt length are below the current settings
+func (argon2Hasher) NeedsRehash(hashed string) bool {
+	salt, _, params, err := decodeArgon2Hash(hashed)
+	if err != nil {
+		return false
+	}
+	return params.Memory < argon2Memory || params.Time < argon2Time ||
+		params.KeyLength < argon2KeyLength || len(salt) < argon2SaltLen
+}
+
+// bcryptHasher verifies legacy bcrypt hashes ($2a$, $2b$, $2y$)
+type bcryptHasher struct{}
+
+func (bcryptHasher) Name() string { return "bcrypt" }
+
+func (bcryptHasher) Recognizes(hashed string) bool {
+	return strings.HasPrefix(hashed, "$2a$") || strings.HasPrefix(hashed, "$2b$") || strings.HasPrefix(hashed, "$2y$")
+}
+
+func (bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func (bcryptHasher) Compare(hashed, plain string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(plain)); err != nil {
+		return errors.New("invalid password")
+	}
+	return nil
+}
+
+func (bcryptHasher) NeedsRehash(hashed string) bool {
+	cost, err := bcrypt.Cost([]byte(hashed))
+	return err == nil && cost < bcrypt.DefaultCost
+}
@@ -0,0 +1,188 @@
+package util
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords are the most used passwords of public breach corpora (lowercase)
+// Extended at startup with PASSWORD_DENYLIST_FILE, see LoadPasswordDenylist.
+var commonPasswords = map[string]bool{}
+
+func init() {
+	for _, p := range strings.Fields(`
+		123456 123456789 12345678 12345 1234567 1234567890 123123 111111 000000 654321
+		666666 121212 112233 123321 987654321 1q2w3e4r 1q2w3e 1qaz2wsx qwerty qwerty123
+		qwertyuiop asdfgh asdfghjkl zxcvbnm azerty password password1 password12 password123
+		passw0rd p@ssw0rd p@ssword pass1234 letmein welcome welcome1 welcome123 admin admin123
+		administrator root toor login abc123 abcd1234 iloveyou monkey dragon master sunshine
+		princess football baseball soccer hockey superman batman trustno1 starwars shadow
+		michael jennifer jordan hunter ranger buster thomas charlie freedom whatever qazwsx
+		secret secret123 changeme default guest test test123 testing hello hello123 computer
+		internet mustang access flower cheese summer winter spring autumn 11111111 22222222
+		88888888 99999999 00000000 12341234 123qwe qwe123 zaq12wsx google samsung apple
+		linkedin facebook instagram pokemon naruto lovely loveme love123 killer ninja
+	`) {
+		commonPasswords[p] = true
+	}
+}
+
+// LoadPasswordDenylist adds the passwords of a file (one per line) to the common password list
+func LoadPasswordDenylist(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	added := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p := strings.ToLower(strings.TrimSpace(scanner.Text())); p != "" && !commonPasswords[p] {
+			commonPasswords[p] = true
+			added++
+		}
+	}
+	return added, scanner.Err()
+}
+
+// leetReplacer undoes common character substitutions (p@ssw0rd -> password)
+var leetReplacer = strings.NewReplacer("@", "a", "4", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t")
+
+// IsCommonPassword reports whether the password, ignoring case, leetspeak and a trailing digit/symbol run,
+// is on the common password list
+func IsCommonPassword(password string) bool {
+	p := strings.ToLower(password)
+	if commonPasswords[p] || commonPasswords[leetReplacer.Replace(p)] {
+		return true
+	}
+	// "Password2024!" is as weak as "password"
+	base := strings.TrimRightFunc(p, func(r rune) bool { return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) })
+	return len(base) >= 4 && (commonPasswords[base] || commonPasswords[leetReplacer.Replace(base)])
+}
+
+// CharacterClasses counts the classes (lowercase, uppercase, digits, other) used in the password
+func CharacterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, used := range []bool{lower, upper, digit, other} {
+		if used {
+			classes++
+		}
+	}
+	return classes
+}
+
+// PasswordScore estimates the strength of a password on the zxcvbn scale from 0 (too guessable) to 4 (very unguessable)
+// Like zxcvbn it estimates the number of guesses: dictionary words (common passwords and userInputs such as
+// the email or name) count as one guess from the list, repeated characters and sequences (aaa, abc, 321)
+// add little; the rest counts by character set. Score thresholds are 10^3, 10^6, 10^8 and 10^10 guesses.
+func PasswordScore(password string, userInputs ...string) int {
+	if password == "" || IsCommonPassword(password) {
+		return 0
+	}
+
+	rest := password
+	guessesLog10 := 0.0
+
+	// Personal information is among the first guesses of a targeted attack
+	for _, input := range userInputs {
+		for _, word := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			lower := strings.ToLower(rest)
+			if i := strings.Index(lower, word); len(word) >= 3 && i >= 0 && len(lower) == len(rest) {
+				rest = rest[:i] + rest[i+len(word):]
+				guessesLog10 += 1 // About ten variations of each piece of personal information
+			}
+		}
+	}
+	if rest == "" {
+		return 0
+	}
+
+	// The remainder is brute-forced over its own alphabet, case-insensitively for sequences
+	charset := float64(charsetSize(rest))
+	runes := []rune(strings.ToLower(rest))
+	for i := 0; i < len(runes); {
+		j := i + 1
+		// A run of repeated characters or a sequence costs about as much as its first character
+		for j < len(runes) && isSequenceStep(runes[j-1], runes[j], runes[i], runes[i+1]) {
+			j++
+		}
+		guessesLog10 += math.Log10(charset)
+		if j-i > 2 {
+			guessesLog10 += math.Log10(float64(j - i))
+		} else {
+			for k := i + 1; k < j; k++ {
+				guessesLog10 += math.Log10(charset)
+			}
+		}
+		i = j
+	}
+
+	switch {
+	case guessesLog10 < 3:
+		return 0
+	case guessesLog10 < 6:
+		return 1
+	case guessesLog10 < 8:
+		return 2
+	case guessesLog10 < 10:
+		return 3
+	}
+	return 4
+}
+
+// isSequenceStep reports whether prev->next continues the repetition (aaa) or the ascending or descending
+// sequence (abc, 321) started by first->second
+func isSequenceStep(prev, next, first, second rune) bool {
+	step := second - first
+	return step >= -1 && step <= 1 && next-prev == step
+}
+
+// charsetSize is the size of the alphabet an attacker brute-forcing the password has to try
+func charsetSize(password string) int {
+	size := 0
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		default:
+			other = true
+		}
+	}
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if other {
+		size += 33
+	}
+	return size
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"context"
+
+	"mein-idaas/dto"
+)
+
+// Permissions claim: access tokens carry the permissions granted by the user's roles (role_permissions).
+// TOKEN_PERMISSIONS_CLAIM=false leaves the claim out (smaller tokens); resource servers then have to look
+// the permissions up by introspection or through the admin role API.
+var permissionsClaim = getEnv("TOKEN_PERMISSIONS_CLAIM", "true") != "false"
+
+// roleExpansion adds the roles that roles inherit, see SetRoleExpansion
+var roleExpansion func(ctx context.Context, roles []string) ([]string, error)
+
+// SetRoleExpansion registers the role hierarchy lookup (role_inherits)
+func SetRoleExpansion(expand func(ctx context.Context, roles []string) ([]string, error)) {
+	roleExpansion = expand
+}
+
+// ExpandRoles returns the role codes followed by every role they inherit (unchanged without a hierarchy)
+func ExpandRoles(ctx context.Context, roles []string) ([]string, error) {
+	if roleExpansion == nil || len(roles) == 0 {
+		return roles, nil
+	}
+	return roleExpansion(ctx, roles)
+}
+
+// permissionLookup returns the permission codes granted by a set of role codes, see SetPermissionLookup
+var permissionLookup func(ctx context.Context, roles []string) ([]string, error)
+
+// SetPermissionLookup registers the lookup of the permissions granted by roles
+func SetPermissionLookup(lookup func(ctx context.Context, roles []string) ([]string, error)) {
+	permissionLookup = lookup
+}
+
+// PermissionsForRoles returns the permissions granted by the role codes (nil without a lookup or when
+// the permissions claim is disabled)
+func PermissionsForRoles(ctx context.Context, roles []string) ([]string, error) {
+	if permissionLookup == nil || !permissionsClaim || len(roles) == 0 {
+		return nil, nil
+	}
+	return permissionLookup(ctx, roles)
+}
+
+// PermissionChecker decides whether a caller holds permissions
+// With a Lookup, the permissions are those the caller's roles grant now (role changes apply to tokens already
+// issued); without one, only the permissions claim of the token counts. Resource servers verifying tokens of
+// this server can use a PermissionChecker{} without a Lookup.
+type PermissionChecker struct {
+	Lookup func(ctx context.Context, roles []string) ([]string, error)
+}
+
+// DefaultPermissionChecker returns the checker of this server: the lookup registered with SetPermissionLookup
+// (independent of TOKEN_PERMISSIONS_CLAIM), or the permissions claim when none is registered
+func DefaultPermissionChecker() *PermissionChecker {
+	return &PermissionChecker{Lookup: permissionLookup}
+}
+
+// Permissions returns the permissions the caller holds
+func (pc *PermissionChecker) Permissions(ctx context.Context, claims *dto.AuthClaims) ([]string, error) {
+	if pc.Lookup == nil {
+		return claims.Permissions, nil
+	}
+	if len(claims.Roles) == 0 {
+		return nil, nil
+	}
+	return pc.Lookup(ctx, claims.Roles)
+}
+
+// Missing returns the first of permissions the caller doesn't hold, "" when it holds all of them
+func (pc *PermissionChecker) Missing(ctx context.Context, claims *dto.AuthClaims, permissions ...string) (string, error) {
+	held, err := pc.Permissions(ctx, claims)
+	if err != nil {
+		return "", err
+	}
+	granted := make(map[string]bool, len(held))
+	for _, p := range held {
+		granted[p] = true
+	}
+	for _, p := range permissions {
+		if !granted[p] {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+// HasPermission reports whether the claims contain the given permission code
+func HasPermission(claims *dto.AuthClaims, permission string) bool {
+	for _, p := range claims.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
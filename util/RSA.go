@@ -6,7 +6,6 @@ import (
 	"encoding/pem"
 	"errors"
 	"log"
-	"strings"
 )
 
 var (
@@ -14,31 +13,27 @@ var (
 	publicKey  *rsa.PublicKey
 )
 
-// InitRSAKeys loads RSA keys from environment variables
+// InitRSAKeys loads RSA keys from environment variables or key files
 // Expects PEM-encoded keys (with proper BEGIN/END markers and newlines)
-// Supports both PKCS1 and PKCS8 private key formats
+// Supports PKCS1, PKCS8 and encrypted PKCS8 ("ENCRYPTED PRIVATE KEY") private key formats
 // Environment variables:
-// - RSA_PRIVATE_KEY: Private key in PEM format
-// - RSA_PUBLIC_KEY: Public key in PEM format
+// - RSA_PRIVATE_KEY: Private key in PEM format (or RSA_PRIVATE_KEY_FILE: path to it)
+// - RSA_PUBLIC_KEY: Public key in PEM format (or RSA_PUBLIC_KEY_FILE: path to it)
+// - RSA_PRIVATE_KEY_PASSPHRASE: Passphrase of an encrypted private key
 func InitRSAKeys() error {
-	privPEM := getEnv("RSA_PRIVATE_KEY", "")
-	pubPEM := getEnv("RSA_PUBLIC_KEY", "")
-
-	if privPEM == "" {
-		return errors.New("RSA_PRIVATE_KEY environment variable not set")
+	privPEM, err := loadKeyPEM("RSA_PRIVATE_KEY")
+	if err != nil {
+		return err
 	}
-	if pubPEM == "" {
-		return errors.New("RSA_PUBLIC_KEY environment variable not set")
+	pubPEM, err := loadKeyPEM("RSA_PUBLIC_KEY")
+	if err != nil {
+		return err
 	}
 
-	// Clean up the PEM strings: handle both \n literals and actual newlines
-	privPEM = strings.ReplaceAll(privPEM, "\\n", "\n")
-	pubPEM = strings.ReplaceAll(pubPEM, "\\n", "\n")
-
 	// Parse private key
-	privBlock, _ := pem.Decode([]byte(privPEM))
-	if privBlock == nil {
-		return errors.New("failed to decode private key PEM from RSA_PRIVATE_KEY - ensure it's properly formatted with BEGIN/END markers")
+	privBlock, err := decodePrivateKeyPEM(privPEM, getEnv("RSA_PRIVATE_KEY_PASSPHRASE", ""))
+	if err != nil {
+		return errors.New("RSA_PRIVATE_KEY: " + err.Error())
 	}
 
 	// Try parsing as PKCS1 first, then PKCS8 if that fails
@@ -72,7 +67,7 @@ func InitRSAKeys() error {
 	publicKey = pub.(*rsa.PublicKey)
 	privateKey = priv
 
-	log.Println("RSA keys loaded from environment variables successfully")
+	log.Println("RSA keys loaded successfully")
 	return nil
 }
 
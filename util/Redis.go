@@ -0,0 +1,43 @@
+package util
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisClient *redis.Client
+
+// InitRedis connects to Redis when REDIS_URL is set (e.g. redis://:password@localhost:6379/0)
+// Redis is optional: when REDIS_URL is empty, features fall back to per-process in-memory storage
+func InitRedis() error {
+	redisURL := getEnv("REDIS_URL", "")
+	if redisURL == "" {
+		log.Println("REDIS_URL not set, using in-memory storage (state is not shared across replicas)")
+		return nil
+	}
+
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return err
+	}
+
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return err
+	}
+
+	redisClient = client
+	log.Println("Redis connected successfully")
+	return nil
+}
+
+// GetRedisClient returns the shared Redis client, or nil if Redis is not configured
+func GetRedisClient() *redis.Client {
+	return redisClient
+}
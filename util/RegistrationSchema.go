@@ -0,0 +1,109 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// RegistrationField declares an extra registration field stored in the user's metadata
+// Rule is a go-playground/validator tag (e.g. "max=100", "oneof=en de vi")
+type RegistrationField struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Rule     string `json:"rule"`
+}
+
+var registrationFields []RegistrationField
+
+// reservedRegistrationFields are the built-in RegisterRequest fields
+var reservedRegistrationFields = map[string]bool{"name": true, "email": true, "password": true}
+
+// InitRegistrationSchema loads the extra registration fields from REGISTRATION_FIELDS (JSON array)
+// Example: [{"name":"company","required":true,"rule":"max=100"},{"name":"locale","rule":"oneof=en de vi"}]
+func InitRegistrationSchema() error {
+	raw := os.Getenv("REGISTRATION_FIELDS")
+	if raw == "" {
+		registrationFields = nil
+		return nil
+	}
+
+	var fields []RegistrationField
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return fmt.Errorf("invalid REGISTRATION_FIELDS: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		if f.Name == "" || reservedRegistrationFields[f.Name] || seen[f.Name] {
+			return fmt.Errorf("invalid REGISTRATION_FIELDS: bad or duplicate field name %q", f.Name)
+		}
+		seen[f.Name] = true
+
+		if err := checkValidationRule(f.Rule); err != nil {
+			return fmt.Errorf("invalid REGISTRATION_FIELDS rule for %q: %w", f.Name, err)
+		}
+	}
+
+	registrationFields = fields
+	log.Printf("[REGISTRATION] %d extra registration field(s) configured", len(fields))
+	return nil
+}
+
+// checkValidationRule makes sure the validator understands the tag (it panics on unknown tags)
+func checkValidationRule(rule string) (err error) {
+	if rule == "" {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	_ = validate.Var("", rule)
+	return nil
+}
+
+// ParseRegistrationFields extracts the configured extra fields from a raw register request body
+// Fields are top-level string properties; undeclared properties are ignored
+func ParseRegistrationFields(body []byte) (map[string]interface{}, error) {
+	if len(registrationFields) == 0 {
+		return nil, nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("invalid request payload")
+	}
+
+	metadata := make(map[string]interface{})
+	for _, f := range registrationFields {
+		value, ok := raw[f.Name]
+		if !ok || string(value) == "null" {
+			if f.Required {
+				return nil, fmt.Errorf("%s is required", f.Name)
+			}
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, fmt.Errorf("%s must be a string", f.Name)
+		}
+		if s == "" && f.Required {
+			return nil, fmt.Errorf("%s is required", f.Name)
+		}
+		if f.Rule != "" {
+			if err := validate.Var(s, f.Rule); err != nil {
+				return nil, fmt.Errorf("%s is invalid (%s)", f.Name, f.Rule)
+			}
+		}
+		metadata[f.Name] = s
+	}
+
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	return metadata, nil
+}
@@ -2,9 +2,11 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image/png"
 	"os"
+	"time"
 
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
@@ -58,3 +60,34 @@ func GetTOTPQRCode(secret, email string) ([]byte, error) {
 func VerifyTOTP(secret, token string) bool {
 	return totp.Validate(token, secret)
 }
+
+// TOTP parameters of totp.Validate: 30-second steps, one step of clock drift either way
+const (
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+// UseTOTP validates a TOTP token like VerifyTOTP and marks the time step it matched as used for owner
+// (e.g. the user ID), so a code accepted once can't be replayed while it is still valid.
+// Without a replay check (SetReplayCheck) it behaves like VerifyTOTP.
+func UseTOTP(ctx context.Context, owner, secret, token string) (bool, error) {
+	now := time.Now()
+	for i := -totpSkew; i <= totpSkew; i++ {
+		at := now.Add(time.Duration(i*totpPeriod) * time.Second)
+		ok, err := totp.ValidateCustom(token, secret, at, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil || !ok {
+			continue
+		}
+		if replayCheck == nil {
+			return true, nil
+		}
+		// The step stays marked until no clock drift can make its code valid again
+		step := at.Unix() / totpPeriod
+		return replayCheck(ctx, fmt.Sprintf("totp:%s:%d", owner, step), time.Unix((step+totpSkew+1)*totpPeriod, 0))
+	}
+	return false, nil
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Multi-tenancy configuration, initialized once at startup by InitTenants
+// Each organization is served under /t/{org} and gets its own issuer URL ({ISSUER_BASE_URL}/t/{org}
+// unless TENANT_ISSUERS sets another one)
+var (
+	tenants       map[string]bool
+	tenantIssuers map[string]string
+	issuerBaseURL string
+)
+
+var tenantSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type tenantContextKey struct{}
+
+// InitTenants loads the configured organizations
+// Environment variables:
+// - TENANTS: comma-separated organization slugs (multi-tenancy disabled when empty)
+// - ISSUER_BASE_URL: public base URL of the IdP, e.g. https://idp.example.com (required with TENANTS)
+// - TENANT_ISSUERS: comma-separated org=URL pairs giving organizations their own issuer URL, e.g. acme=https://login.acme.com
+func InitTenants() error {
+	tenants = make(map[string]bool)
+	tenantIssuers = make(map[string]string)
+	issuerBaseURL = strings.TrimRight(getEnv("ISSUER_BASE_URL", ""), "/")
+
+	for _, org := range strings.Split(getEnv("TENANTS", ""), ",") {
+		org = strings.ToLower(strings.TrimSpace(org))
+		if org == "" {
+			continue
+		}
+		if !tenantSlugPattern.MatchString(org) {
+			return fmt.Errorf("invalid TENANTS entry %q (expected lowercase letters, digits and dashes)", org)
+		}
+		tenants[org] = true
+	}
+
+	if len(tenants) == 0 {
+		return nil
+	}
+	if issuerBaseURL == "" {
+		return fmt.Errorf("ISSUER_BASE_URL is required when TENANTS is set")
+	}
+	if err := loadTenantIssuers(getEnv("TENANT_ISSUERS", "")); err != nil {
+		return err
+	}
+
+	log.Printf("[TENANT] %d tenant(s) configured under %s/t/{org}", len(tenants), issuerBaseURL)
+	return nil
+}
+
+// loadTenantIssuers parses TENANT_ISSUERS; every issuer must be an absolute URL used by one tenant only
+func loadTenantIssuers(value string) error {
+	used := map[string]string{issuer: "JWT_ISSUER"}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		org, rawURL, ok := strings.Cut(entry, "=")
+		org = strings.ToLower(strings.TrimSpace(org))
+		rawURL = strings.TrimRight(strings.TrimSpace(rawURL), "/")
+		if !ok || !tenants[org] {
+			return fmt.Errorf("invalid TENANT_ISSUERS entry %q (expected org=URL for an org in TENANTS)", entry)
+		}
+		if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid TENANT_ISSUERS URL %q for %s", rawURL, org)
+		}
+		if other, taken := used[rawURL]; taken {
+			return fmt.Errorf("TENANT_ISSUERS: issuer %s of %s is already used by %s", rawURL, org, other)
+		}
+		used[rawURL] = org
+		tenantIssuers[org] = rawURL
+	}
+	return nil
+}
+
+// MultiTenancyEnabled reports whether any tenant is configured
+func MultiTenancyEnabled() bool {
+	return len(tenants) > 0
+}
+
+// IsKnownTenant reports whether org is a configured tenant
+func IsKnownTenant(org string) bool {
+	return tenants[org]
+}
+
+// Tenants returns the configured organizations, sorted
+func Tenants() []string {
+	orgs := make([]string, 0, len(tenants))
+	for org := range tenants {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	return orgs
+}
+
+// TenantIssuer returns the issuer URL for org (TENANT_ISSUERS, or {ISSUER_BASE_URL}/t/{org}),
+// or the global JWT_ISSUER when org is empty
+func TenantIssuer(org string) string {
+	if org == "" {
+		return issuer
+	}
+	if custom, ok := tenantIssuers[org]; ok {
+		return custom
+	}
+	return issuerBaseURL + "/t/" + org
+}
+
+// tenantAudience scopes access tokens of a tenant to that tenant's issuer URL,
+// so a resource server of one organization rejects tokens issued for another
+func tenantAudience(org string, defaults jwt.ClaimStrings) jwt.ClaimStrings {
+	if org == "" {
+		return defaults
+	}
+	return jwt.ClaimStrings{TenantIssuer(org)}
+}
+
+// checkTenantClaims makes sure a tenant token was issued by that tenant and the tenant still exists
+func checkTenantClaims(org string, iss string) error {
+	if org == "" {
+		return nil
+	}
+	if !IsKnownTenant(org) || iss != TenantIssuer(org) {
+		return fmt.Errorf("token issuer does not match tenant %q", org)
+	}
+	return nil
+}
+
+// WithTenant returns a context scoped to org (set by the tenant route middleware)
+func WithTenant(ctx context.Context, org string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, org)
+}
+
+// TenantFromContext returns the tenant the request is scoped to ("" for the global routes)
+func TenantFromContext(ctx context.Context) string {
+	org, _ := ctx.Value(tenantContextKey{}).(string)
+	return org
+}
+
+// GetIssuerBaseURL returns ISSUER_BASE_URL without a trailing slash ("" when not configured)
+func GetIssuerBaseURL() string {
+	return issuerBaseURL
+}
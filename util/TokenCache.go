@@ -0,0 +1,140 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mein-idaas/dto"
+)
+
+// Access token cache: validated JWT access tokens are kept by jti with their claims until they expire,
+// so hot endpoints don't verify the signature (RSA) again on every request with the same token.
+// Revocation (denylist, token_version) and the DPoP binding are still checked on every request.
+// ACCESS_TOKEN_CACHE_SIZE: maximum number of cached tokens (default 10000, 0 disables)
+var (
+	tokenCacheMu   sync.RWMutex
+	tokenCache     = map[string]cachedToken{}
+	tokenCacheSize = getEnvInt("ACCESS_TOKEN_CACHE_SIZE", 10000)
+
+	tokenCacheHits   atomic.Int64
+	tokenCacheMisses atomic.Int64
+)
+
+type cachedToken struct {
+	hash      [32]byte // SHA-256 of the whole token: another token with the same jti is not served from the cache
+	claims    dto.AuthClaims
+	expiresAt time.Time
+}
+
+// SetAccessTokenCacheSize changes the maximum number of cached access tokens (0 disables the cache) and empties it
+func SetAccessTokenCacheSize(size int) {
+	tokenCacheMu.Lock()
+	tokenCacheSize = size
+	tokenCache = map[string]cachedToken{}
+	tokenCacheMu.Unlock()
+}
+
+// GetAccessTokenCacheSize returns the maximum number of cached access tokens (ACCESS_TOKEN_CACHE_SIZE)
+func GetAccessTokenCacheSize() int {
+	tokenCacheMu.RLock()
+	defer tokenCacheMu.RUnlock()
+	return tokenCacheSize
+}
+
+// PurgeAccessTokenCache drops every cached token, e.g. when the key ring changed and a key may have been retired
+func PurgeAccessTokenCache() {
+	tokenCacheMu.Lock()
+	tokenCache = map[string]cachedToken{}
+	tokenCacheMu.Unlock()
+}
+
+// cachedAccessToken returns the claims of a token validated before, if it is cached and not expired
+func cachedAccessToken(tokenString string) (*dto.AuthClaims, bool) {
+	jti := unverifiedTokenID(tokenString)
+	if jti == "" {
+		return nil, false
+	}
+
+	tokenCacheMu.RLock()
+	entry, ok := tokenCache[jti]
+	tokenCacheMu.RUnlock()
+	if !ok || entry.hash != sha256.Sum256([]byte(tokenString)) {
+		tokenCacheMisses.Add(1)
+		return nil, false
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		tokenCacheMu.Lock()
+		delete(tokenCache, jti)
+		tokenCacheMu.Unlock()
+		tokenCacheMisses.Add(1)
+		return nil, false
+	}
+
+	tokenCacheHits.Add(1)
+	claims := entry.claims // Callers get their own copy
+	return &claims, true
+}
+
+// cacheAccessToken stores the claims of a validated access token until it expires
+// When the cache is full, expired entries are dropped first, then an arbitrary one.
+func cacheAccessToken(tokenString string, claims *dto.AuthClaims) {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return
+	}
+
+	tokenCacheMu.Lock()
+	defer tokenCacheMu.Unlock()
+	if tokenCacheSize <= 0 {
+		return
+	}
+	if len(tokenCache) >= tokenCacheSize {
+		now := time.Now()
+		for jti, entry := range tokenCache {
+			if !now.Before(entry.expiresAt) {
+				delete(tokenCache, jti)
+			}
+		}
+		for jti := range tokenCache {
+			if len(tokenCache) < tokenCacheSize {
+				break
+			}
+			delete(tokenCache, jti)
+		}
+	}
+	tokenCache[claims.ID] = cachedToken{
+		hash:      sha256.Sum256([]byte(tokenString)),
+		claims:    *claims,
+		expiresAt: claims.ExpiresAt.Time,
+	}
+}
+
+// unverifiedTokenID reads the jti of a JWT without verifying it (only used as the cache key)
+func unverifiedTokenID(tokenString string) string {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		ID string `json:"jti"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.ID
+}
+
+// AccessTokenCacheLen returns the number of cached access tokens
+func AccessTokenCacheLen() int {
+	tokenCacheMu.RLock()
+	defer tokenCacheMu.RUnlock()
+	return len(tokenCache)
+}
@@ -0,0 +1,65 @@
+package util
+
+import "strings"
+
+// uaBrowsers maps User-Agent product tokens to browser names; order matters because
+// Chromium-based browsers also send "Chrome/" and "Safari/", and Chrome sends "Safari/"
+var uaBrowsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+}
+
+// uaSystems maps User-Agent fragments to operating systems; iOS and Android come before macOS and Linux
+var uaSystems = []struct{ token, name string }{
+	{"iPhone", "iPhone"},
+	{"iPad", "iPad"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+}
+
+// DeviceLabel turns a User-Agent into a short label for session lists, e.g. "Chrome on Windows"
+// Non-browser clients get their product name ("curl", "okhttp"); an empty User-Agent gives "Unknown device".
+func DeviceLabel(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser, system := "", ""
+	for _, b := range uaBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range uaSystems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+
+	// Not a browser: use the first product token ("curl/8.4.0" -> "curl")
+	product := strings.Fields(userAgent)[0]
+	if i := strings.Index(product, "/"); i > 0 {
+		product = product[:i]
+	}
+	return product
+}
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+// usernamePattern allows 3-32 lowercase letters, digits, dots, dashes and underscores, starting with a letter or digit
+// "@" is never allowed, so a login identifier is an email exactly when it contains one.
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,31}$`)
+
+// reservedUsernames can't be taken, they would impersonate the service or its operators
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "root": true, "system": true, "support": true, "security": true,
+	"help": true, "info": true, "me": true, "null": true, "undefined": true, "anonymous": true, "guest": true,
+}
+
+// NormalizeUsername lowercases and trims a username; usernames are unique case-insensitively
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// IsValidUsername reports whether a normalized username matches the allowed format and is not reserved
+func IsValidUsername(username string) bool {
+	return usernamePattern.MatchString(username) && !reservedUsernames[username]
+}
@@ -6,6 +6,13 @@ import (
 
 var validate = validator.New()
 
+func init() {
+	// username: see IsValidUsername, checked after NormalizeUsername so "Alice" is accepted as "alice"
+	_ = validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return IsValidUsername(NormalizeUsername(fl.Field().String()))
+	})
+}
+
 // ValidateStruct checks for tag-based validation errors
 func ValidateStruct(payload interface{}) error {
 	err := validate.Struct(payload)
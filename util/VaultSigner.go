@@ -0,0 +1,154 @@
+package util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// vaultSigner signs with a HashiCorp Vault Transit key (JWT_SIGNER=vault)
+// The key version is pinned at startup so the kid stays stable when the key is rotated in Vault.
+// Environment variables:
+// - VAULT_ADDR: Vault address, e.g. https://vault.example.com:8200
+// - VAULT_TOKEN: token allowed to read the key and sign (transit/keys/<key>, transit/sign/<key>)
+// - VAULT_NAMESPACE: Vault Enterprise namespace (optional)
+// - VAULT_TRANSIT_MOUNT: mount path of the transit engine (default: transit)
+// - VAULT_TRANSIT_KEY: key name (rsa-2048/3072/4096, ecdsa-p256 or ed25519)
+// - VAULT_TRANSIT_KEY_VERSION: key version to sign with (default: latest version at startup)
+type vaultSigner struct {
+	addr      string
+	token     string
+	namespace string
+	mount     string
+	key       string
+	version   int
+	public    crypto.PublicKey
+}
+
+func newVaultSigner() (crypto.Signer, error) {
+	s := &vaultSigner{
+		addr:      strings.TrimRight(getEnv("VAULT_ADDR", ""), "/"),
+		token:     getEnv("VAULT_TOKEN", ""),
+		namespace: getEnv("VAULT_NAMESPACE", ""),
+		mount:     strings.Trim(getEnv("VAULT_TRANSIT_MOUNT", "transit"), "/"),
+		key:       getEnv("VAULT_TRANSIT_KEY", ""),
+		version:   getEnvInt("VAULT_TRANSIT_KEY_VERSION", 0),
+	}
+	if s.addr == "" || s.token == "" || s.key == "" {
+		return nil, errors.New("JWT_SIGNER=vault requires VAULT_ADDR, VAULT_TOKEN and VAULT_TRANSIT_KEY")
+	}
+
+	var res struct {
+		Data struct {
+			Type          string `json:"type"`
+			LatestVersion int    `json:"latest_version"`
+			Keys          map[string]struct {
+				PublicKey string `json:"public_key"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+	if err := s.call(http.MethodGet, "/keys/"+s.key, nil, &res); err != nil {
+		return nil, err
+	}
+	if s.version == 0 {
+		s.version = res.Data.LatestVersion
+	}
+	version, ok := res.Data.Keys[strconv.Itoa(s.version)]
+	if !ok || version.PublicKey == "" {
+		return nil, fmt.Errorf("vault transit key %s has no version %d with a public key", s.key, s.version)
+	}
+
+	// ed25519 public keys come as raw base64, RSA and ECDSA keys as PKIX PEM
+	if res.Data.Type == "ed25519" {
+		raw, err := base64.StdEncoding.DecodeString(version.PublicKey)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key from vault")
+		}
+		s.public = ed25519.PublicKey(raw)
+	} else {
+		pub, err := DecodePublicKey(version.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		s.public = pub
+	}
+	return s, nil
+}
+
+func (s *vaultSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *vaultSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	path := "/sign/" + s.key
+	body := map[string]interface{}{"input": b64std(digest), "key_version": s.version}
+	if _, ed := s.public.(ed25519.PublicKey); !ed {
+		if err := checkDigest(digest, opts); err != nil {
+			return nil, err
+		}
+		path += "/sha2-256"
+		body["prehashed"] = true
+		body["marshaling_algorithm"] = "asn1"
+		if _, isRSA := s.public.(*rsa.PublicKey); isRSA {
+			body["signature_algorithm"] = "pkcs1v15"
+		}
+	}
+
+	var res struct {
+		Data struct {
+			Signature string `json:"signature"` // vault:v<version>:<base64>
+		} `json:"data"`
+	}
+	if err := s.call(http.MethodPost, path, body, &res); err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(res.Data.Signature, ":", 3)
+	if len(parts) != 3 {
+		return nil, errors.New("unexpected signature format from vault")
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+// call sends a request to the transit engine and decodes the JSON response into out
+func (s *vaultSigner) call(method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, s.addr+"/v1/"+s.mount+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", s.token)
+	if s.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := remoteSignerClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("vault %s %s: status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
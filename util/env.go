@@ -1,6 +1,11 @@
 package util
 
-import "os"
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
 
 // getEnv retrieves an environment variable or returns a fallback
 // It is available to ALL files in package 'util'
@@ -10,3 +15,42 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getEnvInt parses an integer environment variable or returns a fallback
+func getEnvInt(key string, fallback int) int {
+	valStr := getEnv(key, "")
+	if valStr == "" {
+		return fallback
+	}
+	val, err := strconv.Atoi(valStr)
+	if err != nil {
+		log.Printf("warning: invalid %s value '%s', using default %d\n", key, valStr, fallback)
+		return fallback
+	}
+	return val
+}
+
+// getEnvDuration parses a duration environment variable (e.g. "15m") or returns a fallback
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	valStr := getEnv(key, "")
+	if valStr == "" {
+		return fallback
+	}
+	val, err := time.ParseDuration(valStr)
+	if err != nil {
+		log.Printf("warning: invalid %s value '%s', using default %v\n", key, valStr, fallback)
+		return fallback
+	}
+	return val
+}
+
+// GetRoleCacheTTL returns how long a user's role codes may be served from cache (default 1m)
+func GetRoleCacheTTL() time.Duration {
+	return getEnvDuration("ROLE_CACHE_TTL", time.Minute)
+}
+
+// GetUnverifiedAccountTTL returns how long an unverified account may hold its email
+// before it can be re-registered or purged (default 72h, "0" disables)
+func GetUnverifiedAccountTTL() time.Duration {
+	return getEnvDuration("UNVERIFIED_ACCOUNT_TTL", 72*time.Hour)
+}
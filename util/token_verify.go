@@ -1,10 +1,12 @@
 package util
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"mein-idaas/dto"
 
@@ -12,17 +14,21 @@ import (
 	"github.com/google/uuid"
 )
 
-// ParseAccessToken validates and returns the access token claims using RS256
+// ParseAccessToken validates and returns the access token claims using the configured algorithm
+// Tokens validated before are served from the access token cache (ACCESS_TOKEN_CACHE_SIZE).
 func ParseAccessToken(tokenString string) (*dto.AuthClaims, error) {
-	claims := &dto.AuthClaims{}
+	if claims, ok := cachedAccessToken(tokenString); ok {
+		return claims, nil
+	}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Verify RS256 signing method
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, errors.New("invalid signing method, expected RS256")
-		}
-		return GetPublicKey(), nil
-	})
+	parsed := &struct {
+		dto.AuthClaims
+		AtHash  string `json:"at_hash"`
+		Purpose string `json:"purpose"`
+	}{}
+	claims := &parsed.AuthClaims
+
+	token, err := jwt.ParseWithClaims(tokenString, parsed, verificationKey)
 
 	if err != nil {
 		log.Printf("Token parsing error: %v", err)
@@ -34,73 +40,258 @@ func ParseAccessToken(tokenString string) (*dto.AuthClaims, error) {
 		return nil, errors.New("token signature verification failed")
 	}
 
+	// ID tokens are signed with the same key but must not be accepted as bearer tokens
+	// (access tokens carry auth_time too, only ID tokens carry at_hash)
+	if parsed.AtHash != "" {
+		return nil, errors.New("id token is not an access token")
+	}
+	// Action tokens (email links) are signed with the same key too
+	if parsed.Purpose != "" {
+		return nil, errors.New("action token is not an access token")
+	}
+
+	if err := checkTenantClaims(claims.Tenant, claims.Issuer); err != nil {
+		return nil, err
+	}
+
 	log.Printf("Token parsed successfully. Subject: %s, Roles: %v", claims.Subject, claims.Roles)
+	if !claims.IsRefreshToken() {
+		cacheAccessToken(tokenString, claims)
+	}
 	return claims, nil
 }
 
-// ParseRefreshToken decodes and validates a refresh token using RS256
-func ParseRefreshToken(tokenString string) (uuid.UUID, uuid.UUID, error) {
-	claims := &dto.AuthClaims{}
+// opaqueTokenLookup resolves opaque access tokens (ACCESS_TOKEN_FORMAT=opaque), see SetOpaqueTokenLookup
+var opaqueTokenLookup func(ctx context.Context, token string) (*dto.AuthClaims, error)
+
+// SetOpaqueTokenLookup registers the database lookup for opaque access tokens
+func SetOpaqueTokenLookup(lookup func(ctx context.Context, token string) (*dto.AuthClaims, error)) {
+	opaqueTokenLookup = lookup
+}
+
+// apiKeyLookup resolves API keys sent in the X-API-Key header, see SetAPIKeyLookup
+var apiKeyLookup func(ctx context.Context, key string) (*dto.AuthClaims, error)
+
+// SetAPIKeyLookup registers the database lookup for API keys
+func SetAPIKeyLookup(lookup func(ctx context.Context, key string) (*dto.AuthClaims, error)) {
+	apiKeyLookup = lookup
+}
+
+// ResolveAPIKey validates an API key and returns the claims of its owner
+func ResolveAPIKey(ctx context.Context, key string) (*dto.AuthClaims, error) {
+	if apiKeyLookup == nil {
+		return nil, errors.New("API keys not enabled")
+	}
+	return apiKeyLookup(ctx, key)
+}
+
+// tokenVersionLookup returns the current token version of a user, see SetTokenVersionLookup
+var tokenVersionLookup func(ctx context.Context, userID string) (int, error)
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Verify RS256 signing method
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, errors.New("invalid signing method, expected RS256")
+// SetTokenVersionLookup registers the database lookup of the users' token versions (logout-all)
+func SetTokenVersionLookup(lookup func(ctx context.Context, userID string) (int, error)) {
+	tokenVersionLookup = lookup
+}
+
+// mfaLookup reports whether a user has a second factor, see SetMFALookup
+var mfaLookup func(ctx context.Context, userID string) (bool, error)
+
+// SetMFALookup registers the database lookup of whether users have a second factor (step-up acr check)
+func SetMFALookup(lookup func(ctx context.Context, userID string) (bool, error)) {
+	mfaLookup = lookup
+}
+
+// UsesMFA reports whether the user has a second factor (false when no lookup is registered)
+func UsesMFA(ctx context.Context, userID string) (bool, error) {
+	if mfaLookup == nil {
+		return false, nil
+	}
+	return mfaLookup(ctx, userID)
+}
+
+// denylistLookup reports whether the jti of an access token was revoked, see SetDenylistLookup
+var denylistLookup func(ctx context.Context, jti string) (bool, error)
+
+// SetDenylistLookup registers the access token denylist (logout, OAuth revocation)
+func SetDenylistLookup(lookup func(ctx context.Context, jti string) (bool, error)) {
+	denylistLookup = lookup
+}
+
+// replayCheck marks single-use values (DPoP proof and action token IDs) as used, see SetReplayCheck
+var replayCheck func(ctx context.Context, key string, expiresAt time.Time) (bool, error)
+
+// SetReplayCheck registers the store of used single-use values; it reports whether key was unused
+// and marks it used until expiresAt
+func SetReplayCheck(check func(ctx context.Context, key string, expiresAt time.Time) (bool, error)) {
+	replayCheck = check
+}
+
+// ResolveAccessToken validates an access token: opaque tokens are looked up, JWTs are verified
+// JWTs issued before switching to opaque tokens stay valid until they expire.
+// JWT access tokens on the denylist or with a token_version older than the user's are rejected
+// (opaque tokens are revoked in the database instead).
+func ResolveAccessToken(ctx context.Context, tokenString string) (*dto.AuthClaims, error) {
+	if opaqueTokenLookup != nil && !strings.Contains(tokenString, ".") {
+		return opaqueTokenLookup(ctx, tokenString)
+	}
+
+	claims, err := ParseAccessToken(tokenString)
+	if err != nil || claims.IsRefreshToken() {
+		return claims, err
+	}
+	if err := checkRevoked(ctx, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkRevoked rejects JWT access tokens revoked before they expire
+func checkRevoked(ctx context.Context, claims *dto.AuthClaims) error {
+	if denylistLookup != nil && claims.ID != "" {
+		denied, err := denylistLookup(ctx, claims.ID)
+		if err != nil {
+			return err
+		}
+		if denied {
+			return errors.New("token revoked")
+		}
+	}
+
+	if tokenVersionLookup != nil {
+		version, err := tokenVersionLookup(ctx, claims.Subject)
+		if err != nil {
+			return err
+		}
+		if claims.TokenVersion < version {
+			return errors.New("token revoked")
 		}
-		return GetPublicKey(), nil
-	})
+	}
+	return nil
+}
+
+// ParseRefreshToken decodes and validates a refresh token using the configured algorithm
+// Returns the user ID, the token ID (jti) and the tenant the token was issued for
+func ParseRefreshToken(tokenString string) (uuid.UUID, uuid.UUID, string, error) {
+	claims := &dto.AuthClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
 
 	if err != nil || !token.Valid {
-		return uuid.Nil, uuid.Nil, errors.New("invalid or expired refresh token")
+		return uuid.Nil, uuid.Nil, "", errors.New("invalid or expired refresh token")
+	}
+
+	if err := checkTenantClaims(claims.Tenant, claims.Issuer); err != nil {
+		return uuid.Nil, uuid.Nil, "", err
 	}
 
 	// Use standard 'Subject' claim
 	if claims.Subject == "" {
-		return uuid.Nil, uuid.Nil, errors.New("missing subject (user_id) in refresh token")
+		return uuid.Nil, uuid.Nil, "", errors.New("missing subject (user_id) in refresh token")
 	}
 
 	if claims.ID == "" {
-		return uuid.Nil, uuid.Nil, errors.New("missing jti in refresh token")
+		return uuid.Nil, uuid.Nil, "", errors.New("missing jti in refresh token")
 	}
 
 	// Parse userID from string to UUID
 	userID, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.Nil, uuid.Nil, errors.New("invalid user_id format in token")
+		return uuid.Nil, uuid.Nil, "", errors.New("invalid user_id format in token")
 	}
 
 	// Parse jti (refreshID) from string to UUID
 	refreshID, err := uuid.Parse(claims.ID)
 	if err != nil {
-		return uuid.Nil, uuid.Nil, errors.New("invalid jti format in token")
+		return uuid.Nil, uuid.Nil, "", errors.New("invalid jti format in token")
 	}
 
-	return userID, refreshID, nil
+	return userID, refreshID, claims.Tenant, nil
 }
 
-// ExtractUserIDFromToken extracts the user ID from an access token in the Authorization header
-// Accepts both "Bearer <token>" and raw token formats
-func ExtractUserIDFromToken(authHeader string) (string, error) {
-	tokenString := authHeader
-
-	// If the header contains "Bearer " prefix, remove it
-	if strings.HasPrefix(authHeader, "Bearer ") {
-		tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+// checkAudience makes sure a token is meant for this server's own API: its aud must name JWT_AUDIENCE,
+// JWT_ACCEPTED_AUDIENCES or, for tenant tokens, the tenant issuer. Tokens minted for other services
+// (token exchange) and refresh tokens have no such audience and are rejected as bearer tokens here;
+// introspection still accepts them.
+func checkAudience(claims *dto.AuthClaims) error {
+	accepted := tenantAudience(claims.Tenant, audiences)
+	for _, aud := range claims.Audience {
+		for _, want := range accepted {
+			if aud == want {
+				return nil
+			}
+		}
+		for _, want := range acceptedAudiences {
+			if aud == want {
+				return nil
+			}
+		}
 	}
+	return errors.New("token audience not accepted")
+}
 
+// ExtractClaimsFromToken parses the access token in the Authorization header and returns its claims
+// Accepts "Bearer <token>", "DPoP <token>" and raw token formats
+func ExtractClaimsFromToken(ctx context.Context, authHeader string) (*dto.AuthClaims, error) {
+	tokenString := BearerToken(authHeader)
 	if tokenString == "" {
-		return "", fmt.Errorf("empty token")
+		return nil, fmt.Errorf("empty token")
 	}
 
-	// Parse the access token
-	claims, err := ParseAccessToken(tokenString)
+	claims, err := ResolveAccessToken(ctx, tokenString)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if err := checkAudience(claims); err != nil {
+		return nil, err
+	}
+	if err := checkConfirmation(ctx, claims, authHeader, tokenString); err != nil {
+		return nil, err
 	}
 
 	if claims.Subject == "" {
-		return "", errors.New("missing user ID in token")
+		return nil, errors.New("missing user ID in token")
+	}
+
+	return claims, nil
+}
+
+// ResolveSenderConstrainedToken validates the access token in the Authorization header for endpoints that serve any
+// of the user's access tokens (OIDC UserInfo): unlike ExtractClaimsFromToken the audience isn't checked, so tokens
+// issued to OAuth clients are accepted, but DPoP-bound tokens still need the DPoP scheme and a proof of their key.
+// Refresh tokens are rejected.
+func ResolveSenderConstrainedToken(ctx context.Context, authHeader string) (*dto.AuthClaims, error) {
+	tokenString := BearerToken(authHeader)
+	if tokenString == "" {
+		return nil, fmt.Errorf("empty token")
 	}
 
-	return claims.Subject, nil
+	claims, err := ResolveAccessToken(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.IsRefreshToken() {
+		return nil, errors.New("refresh token is not an access token")
+	}
+	if err := checkConfirmation(ctx, claims, authHeader, tokenString); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// BearerToken strips the Bearer or DPoP scheme from an Authorization header
+func BearerToken(authHeader string) string {
+	if token, ok := strings.CutPrefix(authHeader, "DPoP "); ok {
+		return token
+	}
+	return strings.TrimPrefix(authHeader, "Bearer ")
+}
+
+// HasRole reports whether the claims contain the given role code
+func HasRole(claims *dto.AuthClaims, role string) bool {
+	for _, r := range claims.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
 }